@@ -1,11 +1,25 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"go-image-builder/pkg/builder"
+	"go-image-builder/pkg/events"
+	"go-image-builder/pkg/image"
 	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/policy"
+	"go-image-builder/pkg/preflight"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -15,18 +29,22 @@ var buildCmd = &cobra.Command{
 	Use:   "build",
 	Short: "Build an image from a configuration file",
 	Long: `Build an image from a configuration file. The configuration file specifies
-the package manager, packages to install, and other customization options.`,
+the package manager, packages to install, and other customization options.
+
+Several configurations can be built in one invocation by repeating --config or passing a
+directory. Each image is then built in a subdirectory of the output directory named after
+its configuration file, and a summary is printed once all builds have finished. A
+configuration with variants builds every variant, each in a subdirectory named after it.`,
+	Annotations: map[string]string{storageAnnotation: ""},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Check for the avialability of user namespaces if invoked as non-root
-		if os.Getuid() != 0 {
-			if _, err := os.Stat("/proc/sys/kernel/unprivileged_userns_clone"); os.IsNotExist(err) {
-				log.Warn("Unprivileged user namespaces are not supported by this kernel. Please enable them in your kernel configuration.")
-				return fmt.Errorf("unprivileged user namespaces are not supported by this kernel")
-			}
+		// Get the skip-preflight flag
+		skipPreflight, err := cmd.Flags().GetBool("skip-preflight")
+		if err != nil {
+			return fmt.Errorf("failed to get skip-preflight flag: %w", err)
 		}
 
-		// Get the config file path
-		configFile, err := cmd.Flags().GetString("config")
+		// Get the config file paths
+		configPaths, err := cmd.Flags().GetStringSlice("config")
 		if err != nil {
 			return fmt.Errorf("failed to get config file path: %w", err)
 		}
@@ -49,40 +67,460 @@ the package manager, packages to install, and other customization options.`,
 			return fmt.Errorf("failed to get initrd flag: %w", err)
 		}
 
-		// Load and validate the configuration
-		config, err := imageconfig.LoadConfig(configFile)
+		// Get the parallel flag
+		parallel, err := cmd.Flags().GetInt("parallel")
+		if err != nil {
+			return fmt.Errorf("failed to get parallel flag: %w", err)
+		}
+		if parallel < 1 {
+			return fmt.Errorf("parallel must be at least 1")
+		}
+
+		// Get the cleanup flags
+		keepRootfs, err := cmd.Flags().GetBool("keep-rootfs")
+		if err != nil {
+			return fmt.Errorf("failed to get keep-rootfs flag: %w", err)
+		}
+		noCleanup, err := cmd.Flags().GetBool("no-cleanup")
+		if err != nil {
+			return fmt.Errorf("failed to get no-cleanup flag: %w", err)
+		}
+		// Interrupted builds are cancelled, so they clean up as failed builds do
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		opts := buildOptions{
+			ctx:      ctx,
+			squashfs: createSquashfs,
+			initrd:   createInitrd,
+			cleanup:  builder.CleanupAlways,
+		}
+		if noCleanup {
+			opts.cleanup = builder.KeepAlways
+		} else if keepRootfs {
+			opts.cleanup = builder.KeepOnFailure
+		}
+
+		// Get the timeout flag
+		timeout, err := cmd.Flags().GetDuration("timeout")
+		if err != nil {
+			return fmt.Errorf("failed to get timeout flag: %w", err)
+		}
+
+		// Get the events-file flag
+		eventsFile, err := cmd.Flags().GetString("events-file")
+		if err != nil {
+			return fmt.Errorf("failed to get events-file flag: %w", err)
+		}
+		// Keep stdout clean for the event stream
+		opts.out = os.Stdout
+		if eventsFile == "-" {
+			opts.out = os.Stderr
+		}
+		if eventsFile != "" {
+			opts.events, err = openEvents(eventsFile)
+			if err != nil {
+				return err
+			}
+			defer opts.events.Close()
+		}
+
+		// Get the layer-cache flag
+		layerCache, err := cmd.Flags().GetString("layer-cache")
+		if err != nil {
+			return fmt.Errorf("failed to get layer-cache flag: %w", err)
+		}
+		if layerCache != "" {
+			opts.layerCache, err = image.NewLayerCache(layerCache)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Get the blob-cache flag
+		blobCache, err := cmd.Flags().GetString("blob-cache")
 		if err != nil {
-			return fmt.Errorf("failed to load config: %w", err)
+			return fmt.Errorf("failed to get blob-cache flag: %w", err)
+		}
+		if blobCache != "" {
+			opts.blobCache, err = image.NewBlobCache(blobCache)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Get the force flag
+		if opts.force, err = cmd.Flags().GetBool("force"); err != nil {
+			return fmt.Errorf("failed to get force flag: %w", err)
+		}
+
+		// Get the sign-key flag
+		if opts.signKey, err = cmd.Flags().GetString("sign-key"); err != nil {
+			return fmt.Errorf("failed to get sign-key flag: %w", err)
 		}
 
-		// Create output directory if it doesn't exist
-		if err := os.MkdirAll(outputDir, 0755); err != nil {
-			return fmt.Errorf("failed to create output directory: %w", err)
+		// Get the size-report flag
+		if opts.sizeReport, err = cmd.Flags().GetInt("size-report"); err != nil {
+			return fmt.Errorf("failed to get size-report flag: %w", err)
 		}
 
-		// Create builder
-		builder, err := builder.NewBuilder(config, outputDir, createSquashfs, createInitrd)
+		// Get the policy flag
+		policies, err := cmd.Flags().GetStringSlice("policy")
 		if err != nil {
-			return fmt.Errorf("failed to create builder: %w", err)
+			return fmt.Errorf("failed to get policy flag: %w", err)
 		}
 
-		// Build image
-		if err := builder.Build(); err != nil {
-			return fmt.Errorf("failed to build image: %w", err)
+		// Get the backend flag
+		backend, err := cmd.Flags().GetString("backend")
+		if err != nil {
+			return fmt.Errorf("failed to get backend flag: %w", err)
+		}
+
+		configFiles, err := expandConfigPaths(configPaths)
+		if err != nil {
+			return err
 		}
 
-		return nil
+		// Load, expand and validate every configuration before starting any build
+		var jobs []buildJob
+		for _, path := range configFiles {
+			config, err := imageconfig.LoadConfig(path)
+			if err != nil {
+				return fmt.Errorf("failed to load config %s: %w", path, err)
+			}
+			variants, err := config.Expand()
+			if err != nil {
+				return fmt.Errorf("failed to expand variants of %s: %w", path, err)
+			}
+			for _, config := range variants {
+				if backend != "" {
+					config.Options.Backend = backend
+				}
+				if tmpDir != "" {
+					config.Options.TempDir = tmpDir
+				}
+				if timeout > 0 {
+					config.Options.Timeout = timeout.String()
+				}
+				job := buildJob{path: path, config: config, outputDir: outputDir}
+				// Several configurations or variants each build into their own directory
+				if len(configFiles) > 1 {
+					job.outputDir = filepath.Join(job.outputDir, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+				}
+				if config.Variant != nil {
+					job.path = fmt.Sprintf("%s[%s]", path, config.Variant.Name)
+					job.outputDir = filepath.Join(job.outputDir, config.Variant.Name)
+				}
+				jobs = append(jobs, job)
+			}
+		}
+
+		// Enforce the policies before starting any build, even without preflight checks
+		if len(policies) > 0 {
+			if err := checkPolicies(jobs, policies); err != nil {
+				return err
+			}
+		}
+
+		// A single build goes straight into the output directory
+		if len(jobs) == 1 {
+			if !skipPreflight {
+				if err := preflightChecks([]*imageconfig.Config{jobs[0].config}, outputDir, createSquashfs); err != nil {
+					return err
+				}
+			}
+			return buildImage(jobs[0].config, jobs[0].outputDir, opts)
+		}
+
+		if !skipPreflight {
+			configs := make([]*imageconfig.Config, 0, len(jobs))
+			for _, job := range jobs {
+				configs = append(configs, job.config)
+			}
+			if err := preflightChecks(configs, outputDir, createSquashfs); err != nil {
+				return err
+			}
+		}
+
+		// Build failures are recorded in the results, so no errors are returned
+		results, _ := runPool(jobs, parallel, func(job buildJob) ([]buildResult, error) {
+			start := time.Now()
+			err := buildImage(job.config, job.outputDir, opts)
+			if err != nil {
+				log.Errorf("Build of %s failed: %v", job.path, err)
+			}
+			return []buildResult{{job: job, duration: time.Since(start), err: err}}, nil
+		})
+
+		return printBuildSummary(opts.out, results)
 	},
 }
 
+// checkPolicies evaluates every configuration against the rego policies and fails listing
+// all violations if any configuration breaks them
+func checkPolicies(jobs []buildJob, policies []string) error {
+	var violations int
+	for _, job := range jobs {
+		denials, err := policy.Evaluate(job.config, policies)
+		if err != nil {
+			return fmt.Errorf("failed to check %s against policies: %w", job.path, err)
+		}
+		for _, denial := range denials {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", job.path, denial)
+		}
+		violations += len(denials)
+	}
+	if violations > 0 {
+		return fmt.Errorf("configurations violate policies in %d places", violations)
+	}
+	return nil
+}
+
+// openEvents opens the events file. When events go to stdout, the log moves to stderr so
+// stdout only holds the event stream.
+func openEvents(path string) (*events.Writer, error) {
+	if path == "-" {
+		log.SetOutput(os.Stderr)
+	}
+	return events.Open(path)
+}
+
+// buildJob is a single configuration built by a multi-config build
+type buildJob struct {
+	path      string
+	config    *imageconfig.Config
+	outputDir string
+}
+
+// buildResult records the outcome of a buildJob
+type buildResult struct {
+	job      buildJob
+	duration time.Duration
+	err      error
+}
+
+// buildOptions holds the command line settings shared by every build of an invocation
+type buildOptions struct {
+	ctx        context.Context
+	squashfs   bool
+	initrd     bool
+	cleanup    builder.CleanupPolicy
+	events     *events.Writer
+	layerCache *image.LayerCache
+	blobCache  *image.BlobCache
+	force      bool
+	signKey    string
+	sizeReport int
+	out        io.Writer
+}
+
+// buildImage builds a single configuration into outputDir
+func buildImage(config *imageconfig.Config, outputDir string, opts buildOptions) error {
+	// Create output directory if it doesn't exist
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// Create builder
+	b, err := builder.New(config,
+		builder.WithWorkDir(outputDir),
+		builder.WithArtifacts(opts.squashfs, opts.initrd),
+		builder.WithCleanupPolicy(opts.cleanup),
+		builder.WithEvents(opts.events),
+		builder.WithLayerCache(opts.layerCache),
+		builder.WithBlobCache(opts.blobCache),
+		builder.WithForce(opts.force),
+		builder.WithSignKey(opts.signKey),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create builder: %w", err)
+	}
+
+	// Build image
+	result, err := b.Build(opts.ctx)
+	printBuildResult(opts.out, result)
+	if err != nil {
+		return fmt.Errorf("failed to build image: %w", err)
+	}
+
+	if opts.sizeReport > 0 {
+		printSizeReport(opts.out, config.Options.Name, b.SizeReport(), opts.sizeReport)
+	}
+	return nil
+}
+
+// printBuildResult prints what a build produced and how long its stages took as tables
+func printBuildResult(out io.Writer, result *builder.BuildResult) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "\nBuild summary for %s\n", result.Image)
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	for _, field := range []struct{ name, value string }{
+		{"Digest", result.Digest},
+		{"Tags", strings.Join(result.Tags, ", ")},
+		{"Kernel", result.KernelVersion},
+		{"Duration", result.Duration.Round(time.Second).String()},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(w, "%s:\t%s\n", field.name, field.value)
+		}
+	}
+
+	if len(result.Artifacts) > 0 {
+		fmt.Fprintln(w, "\nARTIFACT\tSIZE")
+		for _, artifact := range result.Artifacts {
+			size := "-"
+			if info, err := os.Stat(artifact); err == nil {
+				size = formatSize(info.Size())
+			}
+			fmt.Fprintf(w, "%s\t%s\n", artifact, size)
+		}
+	}
+	if len(result.Stages) > 0 {
+		fmt.Fprintln(w, "\nSTAGE\tDURATION")
+		for _, stage := range result.Stages {
+			fmt.Fprintf(w, "%s\t%s\n", stage.Stage, stage.Duration.Round(100*time.Millisecond))
+		}
+	}
+	w.Flush()
+
+	// Summaries of parallel builds must not interleave
+	out.Write(buf.Bytes())
+}
+
+// printSizeReport prints the layer sizes and the top largest packages and directories of an
+// image as tables
+func printSizeReport(out io.Writer, name string, report *builder.SizeReport, top int) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "\nSize report for %s\n", name)
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LAYER\tSIZE")
+	for _, layer := range report.Layers {
+		fmt.Fprintf(w, "%s\t%s\n", layer.Comment, formatSize(layer.Size))
+	}
+	for _, section := range []struct {
+		title   string
+		entries []builder.SizeEntry
+	}{
+		{"PACKAGE", report.Packages},
+		{"DIRECTORY", report.Directories},
+	} {
+		if len(section.entries) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s\tSIZE\n", section.title)
+		for i, entry := range section.entries {
+			if i == top {
+				break
+			}
+			fmt.Fprintf(w, "%s\t%s\n", entry.Name, formatSize(entry.Size))
+		}
+	}
+	w.Flush()
+
+	// Reports of parallel builds must not interleave
+	out.Write(buf.Bytes())
+}
+
+// preflightChecks verifies the host tools and disk space the configurations need before
+// any build starts
+func preflightChecks(configs []*imageconfig.Config, outputDir string, createSquashfs bool) error {
+	var issues []preflight.Issue
+	seen := make(map[string]bool)
+	for _, config := range configs {
+		for _, issue := range preflight.CheckDependencies(config, createSquashfs) {
+			if !seen[issue.String()] {
+				seen[issue.String()] = true
+				issues = append(issues, issue)
+			}
+		}
+	}
+	issues = append(issues, preflight.CheckDiskSpace(outputDir, createSquashfs)...)
+	tempDirs := make(map[string]bool)
+	for _, config := range configs {
+		if dir := config.Options.TempDir; dir != "" && !tempDirs[dir] {
+			tempDirs[dir] = true
+			issues = append(issues, preflight.CheckTempDirSpace(dir)...)
+		}
+	}
+	return preflight.Report(issues)
+}
+
+// expandConfigPaths expands directories into the YAML files they contain
+func expandConfigPaths(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat config path: %w", err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config directory: %w", err)
+		}
+		for _, entry := range entries {
+			ext := filepath.Ext(entry.Name())
+			if !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no configuration files found")
+	}
+	return files, nil
+}
+
+// printBuildSummary prints the outcome of every build to out and fails if any build failed
+func printBuildSummary(out io.Writer, results []buildResult) error {
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].job.path < results[j].job.path
+	})
+
+	failed := 0
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONFIG\tIMAGE\tSTATUS\tDURATION")
+	for _, r := range results {
+		status := "ok"
+		if r.err != nil {
+			status = "failed"
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.job.path, r.job.config.Options.Name, status, r.duration.Round(time.Second))
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d builds failed", failed, len(results))
+	}
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(buildCmd)
 
 	// Add flags
-	buildCmd.Flags().StringP("config", "c", "", "Path to the configuration file (required)")
+	buildCmd.Flags().StringSliceP("config", "c", nil, "Path to a configuration file or directory of configuration files, may be repeated (required)")
 	buildCmd.Flags().StringP("output", "o", "", "Output directory")
 	buildCmd.Flags().BoolP("squashfs", "s", false, "Create a squashfs image")
 	buildCmd.Flags().BoolP("initrd", "i", true, "Create an initrd image (default: true)")
+	buildCmd.Flags().Int("parallel", 1, "Number of configurations to build at the same time")
+	buildCmd.Flags().String("backend", "", "Container backend: buildah, podman or docker (overrides options.backend)")
+	buildCmd.Flags().Bool("keep-rootfs", false, "Keep the build container, mounted rootfs and temporary layers of a failed build for inspection")
+	buildCmd.Flags().Bool("no-cleanup", false, "Keep the build container, mounted rootfs and temporary layers even when the build succeeds")
+	buildCmd.Flags().Duration("timeout", 0, "Abort each build that runs longer than this (overrides options.timeout)")
+	buildCmd.Flags().String("events-file", "", "Write build events as newline-delimited JSON to this file, or to stdout with -")
+	buildCmd.Flags().String("layer-cache", "", "Reuse kernel, initrd, config and base layers made from unchanged files, caching them in this directory")
+	buildCmd.Flags().String("blob-cache", "", "Remember the blobs each registry holds in this file, skipping their existence checks and uploads in later pushes")
+	buildCmd.Flags().Bool("force", false, "Push even if an immutable tag already points at another image")
+	buildCmd.Flags().String("sign-key", "", "GPG key to sign the SHA256SUMS of the build artifacts with")
+	buildCmd.Flags().Int("size-report", 0, "Print the layer sizes and this many of the largest packages and directories of each image after it is built")
+	buildCmd.Flags().StringSlice("policy", nil, "Rego policy file or directory every configuration must satisfy, evaluated with opa (repeatable)")
+	buildCmd.Flags().Bool("skip-preflight", false, "Skip the host, dependency and disk space checks run before building")
 
 	// Mark required flags
 	buildCmd.MarkFlagRequired("config")
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-image-builder/pkg/builder"
+	"go-image-builder/pkg/events"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestEventsToStdout(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	log.SetOutput(os.Stdout)
+	defer func() {
+		os.Stdout = stdout
+		log.SetOutput(os.Stderr)
+	}()
+
+	writer, err := openEvents("-")
+	if err != nil {
+		t.Fatalf("openEvents() error = %v", err)
+	}
+	log.Info("starting build")
+	writer.Emit(events.Event{Type: events.BuildStarted, Image: "test"})
+	log.Warn("a warning")
+	writer.Emit(events.Event{Type: events.BuildFinished, Image: "test"})
+	w.Close()
+
+	output, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("stdout has %d lines, want 2:\n%s", len(lines), output)
+	}
+	for _, line := range lines {
+		if !json.Valid([]byte(line)) {
+			t.Errorf("stdout line is not JSON: %q", line)
+		}
+	}
+}
+
+func TestPrintBuildResult(t *testing.T) {
+	artifact := filepath.Join(t.TempDir(), "kernel")
+	if err := os.WriteFile(artifact, make([]byte, 2048), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result := &builder.BuildResult{
+		Image:         "registry.local/compute",
+		Tags:          []string{"latest", "v1"},
+		Digest:        "sha256:abc",
+		KernelVersion: "6.1.0",
+		Artifacts:     []string{artifact},
+		Stages:        []builder.StageTiming{{Stage: "setup", Duration: 1500 * time.Millisecond}},
+		Duration:      90 * time.Second,
+	}
+
+	var out strings.Builder
+	printBuildResult(&out, result)
+	for _, want := range []string{
+		"Build summary for registry.local/compute",
+		"Digest:    sha256:abc",
+		"Tags:      latest, v1",
+		"Kernel:    6.1.0",
+		"Duration:  1m30s",
+		artifact + "  2.0KiB",
+		"setup  1.5s",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("summary\n%s\nwant %q", out.String(), want)
+		}
+	}
+}
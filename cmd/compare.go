@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"go-image-builder/pkg/builder"
+	"go-image-builder/pkg/image"
+	"go-image-builder/pkg/imageconfig"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// defaultSourceDateEpoch is the source date baselines are recorded with when the
+// configuration sets none, 1980-01-01 as used by zip and many reproducible builds
+const defaultSourceDateEpoch = 315532800
+
+// layerBaseline records the layer digests of reproducible builds of a configuration, by
+// image name
+type layerBaseline struct {
+	SourceDateEpoch int64                        `json:"source_date_epoch"`
+	Images          map[string][]image.LayerSize `json:"images"`
+}
+
+// layerDiff is a layer whose digest differs from the baseline. A layer missing from either
+// build has an empty digest there.
+type layerDiff struct {
+	Index    int
+	Comment  string
+	Baseline string
+	Current  string
+}
+
+var compareCmd = &cobra.Command{
+	Use:   "compare CONFIG",
+	Short: "Rebuild an image reproducibly and compare its layers with a baseline",
+	Long: `Rebuild every variant of a configuration with reproducible-build settings and
+check that the digests of the resulting layers match a recorded baseline, reporting
+exactly which layers differ. This detects unintended drift of golden images, such as
+packages changed upstream in the repositories they are built from.
+
+The rebuilds clamp file times to the source date of the baseline and publish nothing.
+Run with --record to build the baseline in the first place, or to accept the current
+layers as the new one.`,
+	Args:        cobra.ExactArgs(1),
+	Annotations: map[string]string{storageAnnotation: ""},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		baselinePath, err := cmd.Flags().GetString("baseline")
+		if err != nil {
+			return fmt.Errorf("failed to get baseline flag: %w", err)
+		}
+		record, err := cmd.Flags().GetBool("record")
+		if err != nil {
+			return fmt.Errorf("failed to get record flag: %w", err)
+		}
+		variant, err := cmd.Flags().GetString("variant")
+		if err != nil {
+			return fmt.Errorf("failed to get variant flag: %w", err)
+		}
+		workDir, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return fmt.Errorf("failed to get output flag: %w", err)
+		}
+		backend, err := cmd.Flags().GetString("backend")
+		if err != nil {
+			return fmt.Errorf("failed to get backend flag: %w", err)
+		}
+
+		config, err := imageconfig.LoadConfig(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		configs, err := config.Expand()
+		if err != nil {
+			return fmt.Errorf("failed to expand variants: %w", err)
+		}
+		if variant != "" {
+			configs = selectVariant(configs, variant)
+			if len(configs) == 0 {
+				return fmt.Errorf("the configuration has no variant named %s", variant)
+			}
+		}
+
+		baseline := &layerBaseline{SourceDateEpoch: config.Options.SourceDateEpoch, Images: make(map[string][]image.LayerSize)}
+		if baseline.SourceDateEpoch == 0 {
+			baseline.SourceDateEpoch = defaultSourceDateEpoch
+		}
+		if !record {
+			if baseline, err = loadBaseline(baselinePath); err != nil {
+				return err
+			}
+		}
+
+		if workDir == "" {
+			if workDir, err = os.MkdirTemp("", "compare-*"); err != nil {
+				return fmt.Errorf("failed to create work directory: %w", err)
+			}
+			defer os.RemoveAll(workDir)
+		}
+
+		drifted := 0
+		for n, config := range configs {
+			name := baselineName(config)
+			config.Options.SourceDateEpoch = baseline.SourceDateEpoch
+			if backend != "" {
+				config.Options.Backend = backend
+			}
+			layers, err := buildReproducibly(config, filepath.Join(workDir, fmt.Sprintf("%d", n)))
+			if err != nil {
+				return fmt.Errorf("failed to build %s: %w", name, err)
+			}
+
+			if record {
+				baseline.Images[name] = layers
+				continue
+			}
+			recorded, ok := baseline.Images[name]
+			if !ok {
+				return fmt.Errorf("baseline %s has no layers for %s", baselinePath, name)
+			}
+			diffs := compareLayers(recorded, layers)
+			printLayerDiffs(os.Stdout, name, diffs)
+			if len(diffs) > 0 {
+				drifted++
+			}
+		}
+
+		if record {
+			if err := writeBaseline(baselinePath, baseline); err != nil {
+				return err
+			}
+			log.Infof("Recorded the layers of %d image(s) in %s", len(configs), baselinePath)
+			return nil
+		}
+		if drifted > 0 {
+			return fmt.Errorf("%d of %d image(s) differ from the baseline", drifted, len(configs))
+		}
+		log.Infof("All %d image(s) match the baseline", len(configs))
+		return nil
+	},
+}
+
+// selectVariant returns the configurations expanded for the named variant
+func selectVariant(configs []*imageconfig.Config, variant string) []*imageconfig.Config {
+	var selected []*imageconfig.Config
+	for _, config := range configs {
+		if config.Variant != nil && config.Variant.Name == variant {
+			selected = append(selected, config)
+		}
+	}
+	return selected
+}
+
+// baselineName is the name the layers of a configuration are recorded under
+func baselineName(config *imageconfig.Config) string {
+	if config.Variant != nil {
+		return fmt.Sprintf("%s[%s]", config.Options.Name, config.Variant.Name)
+	}
+	return config.Options.Name
+}
+
+// buildReproducibly builds config into workDir without publishing it anywhere and returns
+// the layers of the image
+func buildReproducibly(config *imageconfig.Config, workDir string) ([]image.LayerSize, error) {
+	config.Options.PublishRegistry = ""
+	config.Notifications = nil
+	config.BSS = nil
+	config.PublishHTTP = nil
+	config.PublishTFTP = nil
+
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %w", err)
+	}
+	b, err := builder.New(config,
+		builder.WithWorkDir(workDir),
+		builder.WithArtifacts(false, false),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create builder: %w", err)
+	}
+	result, err := b.Build(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return result.Layers, nil
+}
+
+// compareLayers returns the layers of current whose digests differ from those at the same
+// position in baseline, including layers only one of them has
+func compareLayers(baseline, current []image.LayerSize) []layerDiff {
+	var diffs []layerDiff
+	for n := 0; n < len(baseline) || n < len(current); n++ {
+		var diff layerDiff
+		diff.Index = n
+		if n < len(baseline) {
+			diff.Comment, diff.Baseline = baseline[n].Comment, baseline[n].Digest
+		}
+		if n < len(current) {
+			diff.Comment, diff.Current = current[n].Comment, current[n].Digest
+		}
+		if diff.Baseline != diff.Current {
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs
+}
+
+// printLayerDiffs prints whether an image matches the baseline, and the layers that differ
+func printLayerDiffs(out io.Writer, name string, diffs []layerDiff) {
+	var buf bytes.Buffer
+	if len(diffs) == 0 {
+		fmt.Fprintf(&buf, "%s: matches the baseline\n", name)
+		out.Write(buf.Bytes())
+		return
+	}
+	fmt.Fprintf(&buf, "%s: %d layer(s) differ from the baseline\n", name, len(diffs))
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LAYER\tCOMMENT\tBASELINE\tCURRENT")
+	for _, diff := range diffs {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", diff.Index, diff.Comment, orMissing(diff.Baseline), orMissing(diff.Current))
+	}
+	w.Flush()
+	out.Write(buf.Bytes())
+}
+
+// orMissing returns digest, or a dash for a layer that is missing
+func orMissing(digest string) string {
+	if digest == "" {
+		return "-"
+	}
+	return digest
+}
+
+// loadBaseline reads a baseline written by writeBaseline
+func loadBaseline(path string) (*layerBaseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baseline: %w", err)
+	}
+	var baseline layerBaseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, fmt.Errorf("failed to parse baseline %s: %w", path, err)
+	}
+	if baseline.SourceDateEpoch <= 0 {
+		return nil, fmt.Errorf("baseline %s has no source date", path)
+	}
+	return &baseline, nil
+}
+
+// writeBaseline writes baseline to path as JSON
+func writeBaseline(path string, baseline *layerBaseline) error {
+	data, err := json.MarshalIndent(baseline, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal baseline: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write baseline: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	compareCmd.Flags().String("baseline", "", "JSON file holding the recorded layer digests")
+	compareCmd.MarkFlagRequired("baseline")
+	compareCmd.Flags().Bool("record", false, "Record the layers of the rebuild as the baseline instead of comparing them")
+	compareCmd.Flags().String("variant", "", "Only rebuild this variant")
+	compareCmd.Flags().String("output", "", "Directory to build in (defaults to a temporary directory)")
+	compareCmd.Flags().String("backend", "", "Container backend: buildah, podman or docker (overrides options.backend)")
+	rootCmd.AddCommand(compareCmd)
+}
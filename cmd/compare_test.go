@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"go-image-builder/pkg/image"
+)
+
+func TestCompareLayers(t *testing.T) {
+	baseline := []image.LayerSize{
+		{Comment: "Base Layer", Digest: "sha256:base"},
+		{Comment: "Kernel Layer", Digest: "sha256:kernel"},
+		{Comment: "Configuration Layer", Digest: "sha256:config"},
+	}
+
+	tests := []struct {
+		name    string
+		current []image.LayerSize
+		want    []layerDiff
+	}{
+		{
+			name:    "identical",
+			current: baseline,
+		},
+		{
+			name: "changed layer",
+			current: []image.LayerSize{
+				{Comment: "Base Layer", Digest: "sha256:drifted"},
+				{Comment: "Kernel Layer", Digest: "sha256:kernel"},
+				{Comment: "Configuration Layer", Digest: "sha256:config"},
+			},
+			want: []layerDiff{{Index: 0, Comment: "Base Layer", Baseline: "sha256:base", Current: "sha256:drifted"}},
+		},
+		{
+			name:    "missing layer",
+			current: baseline[:2],
+			want:    []layerDiff{{Index: 2, Comment: "Configuration Layer", Baseline: "sha256:config"}},
+		},
+		{
+			name:    "added layer",
+			current: append(append([]image.LayerSize{}, baseline...), image.LayerSize{Comment: "GPU Driver Layer", Digest: "sha256:gpu"}),
+			want:    []layerDiff{{Index: 3, Comment: "GPU Driver Layer", Current: "sha256:gpu"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareLayers(baseline, tt.current); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compareLayers() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"go-image-builder/internal/redact"
+	"go-image-builder/pkg/imageconfig"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect image configurations",
+}
+
+var configRenderCmd = &cobra.Command{
+	Use:   "render CONFIG...",
+	Short: "Print the effective configuration a build would use",
+	Long: `Print the fully resolved configuration of every variant a build of the given
+configuration files or directories would run, as YAML documents. Secrets such as
+passwords in repository URLs and registry credentials are masked, so the output can
+be shared and diffed across environments.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		variant, err := cmd.Flags().GetString("variant")
+		if err != nil {
+			return fmt.Errorf("failed to get variant flag: %w", err)
+		}
+
+		paths, err := expandConfigPaths(args)
+		if err != nil {
+			return err
+		}
+		return renderConfigs(os.Stdout, paths, variant)
+	},
+}
+
+// renderConfigs writes the effective configuration of every variant of the configuration
+// files at paths to w, or of the named variant only
+func renderConfigs(w io.Writer, paths []string, variant string) error {
+	rendered := 0
+	for _, path := range paths {
+		config, err := imageconfig.LoadConfig(path)
+		if err != nil {
+			return fmt.Errorf("failed to load config %s: %w", path, err)
+		}
+		configs, err := config.Expand()
+		if err != nil {
+			return fmt.Errorf("failed to expand variants of %s: %w", path, err)
+		}
+
+		for _, config := range configs {
+			source := path
+			if config.Variant != nil {
+				if variant != "" && config.Variant.Name != variant {
+					continue
+				}
+				source = fmt.Sprintf("%s[%s]", path, config.Variant.Name)
+			} else if variant != "" {
+				continue
+			}
+
+			data, err := yaml.Marshal(config)
+			if err != nil {
+				return fmt.Errorf("failed to marshal config %s: %w", source, err)
+			}
+			redact.Config(config)
+			if rendered > 0 {
+				fmt.Fprintln(w, "---")
+			}
+			fmt.Fprintf(w, "# %s\n%s", source, redact.String(string(data)))
+			rendered++
+		}
+	}
+	if variant != "" && rendered == 0 {
+		return fmt.Errorf("no configuration has a variant named %s", variant)
+	}
+	return nil
+}
+
+func init() {
+	configRenderCmd.Flags().String("variant", "", "Only print the configuration of this variant")
+	configCmd.AddCommand(configRenderCmd)
+	rootCmd.AddCommand(configCmd)
+}
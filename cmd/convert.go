@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go-image-builder/pkg/builder"
+	"go-image-builder/pkg/imageconfig"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// defaultConvertPackages are installed into the source image to make it bootable
+var defaultConvertPackages = []string{"kernel", "dracut-live", "NetworkManager"}
+
+var convertCmd = &cobra.Command{
+	Use:   "convert SOURCE",
+	Short: "Turn an existing container image into a bootable node image",
+	Long: `Take an ordinary container image, install a kernel and dracut into it,
+add the kernel, initrd and squashfs artifacts and OpenCHAMI labels, and push
+the result. This lets images built from Dockerfiles be booted without writing
+a full builder configuration.
+
+An optional configuration file can supply repositories, extra packages and
+commands. Flags take precedence over values in the file.`,
+	Args:        cobra.ExactArgs(1),
+	Annotations: map[string]string{storageAnnotation: ""},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		source := args[0]
+
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			return fmt.Errorf("failed to get config flag: %w", err)
+		}
+		imageName, err := cmd.Flags().GetString("name")
+		if err != nil {
+			return fmt.Errorf("failed to get name flag: %w", err)
+		}
+		registry, err := cmd.Flags().GetString("registry")
+		if err != nil {
+			return fmt.Errorf("failed to get registry flag: %w", err)
+		}
+		tags, err := cmd.Flags().GetString("tags")
+		if err != nil {
+			return fmt.Errorf("failed to get tags flag: %w", err)
+		}
+		pkgManager, err := cmd.Flags().GetString("pkg-manager")
+		if err != nil {
+			return fmt.Errorf("failed to get pkg-manager flag: %w", err)
+		}
+		packages, err := cmd.Flags().GetStringSlice("package")
+		if err != nil {
+			return fmt.Errorf("failed to get package flag: %w", err)
+		}
+		labels, err := cmd.Flags().GetStringSlice("label")
+		if err != nil {
+			return fmt.Errorf("failed to get label flag: %w", err)
+		}
+		workDir, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return fmt.Errorf("failed to get output flag: %w", err)
+		}
+		createSquashfs, err := cmd.Flags().GetBool("squashfs")
+		if err != nil {
+			return fmt.Errorf("failed to get squashfs flag: %w", err)
+		}
+
+		// Start from the optional config file, then apply the flags
+		config := &imageconfig.Config{}
+		if configPath != "" {
+			if config, err = imageconfig.LoadConfig(configPath); err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+		}
+
+		config.Options.LayerType = "base"
+		config.Options.Parent = source
+		if imageName != "" {
+			config.Options.Name = imageName
+		}
+		if config.Options.Name == "" {
+			config.Options.Name = defaultConvertName(source)
+		}
+		if registry != "" {
+			config.Options.PublishRegistry = registry
+		}
+		if tags != "" {
+			config.Options.PublishTags = tags
+		}
+		if cmd.Flags().Changed("pkg-manager") || config.Options.PkgManager == "" {
+			config.Options.PkgManager = pkgManager
+		}
+		if cmd.Flags().Changed("package") || len(config.Packages) == 0 {
+			config.Packages = append(config.Packages, packages...)
+		}
+		if config.Options.Labels == nil {
+			config.Options.Labels = make(map[string]string)
+		}
+		config.Options.Labels["com.openchami.image.converted-from"] = source
+		for _, label := range labels {
+			key, value, ok := strings.Cut(label, "=")
+			if !ok {
+				return fmt.Errorf("invalid label '%s': must be key=value", label)
+			}
+			config.Options.Labels[key] = value
+		}
+
+		if err := config.Validate(); err != nil {
+			return fmt.Errorf("invalid conversion settings: %w", err)
+		}
+
+		if workDir == "" {
+			if workDir, err = os.MkdirTemp("", "convert-*"); err != nil {
+				return fmt.Errorf("failed to create work directory: %w", err)
+			}
+			log.Infof("Using work directory %s", workDir)
+		} else if err := os.MkdirAll(workDir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+
+		b, err := builder.NewBuilder(config, workDir, createSquashfs, true)
+		if err != nil {
+			return fmt.Errorf("failed to create builder: %w", err)
+		}
+
+		log.Infof("Converting %s into bootable image %s", source, config.Options.Name)
+		if _, err := b.Build(cmd.Context()); err != nil {
+			return fmt.Errorf("failed to convert image: %w", err)
+		}
+		return nil
+	},
+}
+
+// defaultConvertName derives an image name from the repository of the source reference
+func defaultConvertName(source string) string {
+	name := source
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:]
+	}
+	if idx := strings.IndexAny(name, ":@"); idx >= 0 {
+		name = name[:idx]
+	}
+	return name + "-bootable"
+}
+
+func init() {
+	convertCmd.Flags().StringP("config", "c", "", "Optional configuration file with repositories, packages and commands")
+	convertCmd.Flags().String("name", "", "Name of the resulting image (default: <source>-bootable)")
+	convertCmd.Flags().String("registry", "", "Registry to push the resulting image to")
+	convertCmd.Flags().String("tags", "", "Comma separated list of tags to publish")
+	convertCmd.Flags().String("pkg-manager", "dnf", "Package manager used to install the kernel")
+	convertCmd.Flags().StringSlice("package", defaultConvertPackages, "Packages to install into the image (repeatable)")
+	convertCmd.Flags().StringSlice("label", nil, "Additional label as key=value (repeatable)")
+	convertCmd.Flags().StringP("output", "o", "", "Work directory for build artifacts (default: a temporary directory)")
+	convertCmd.Flags().BoolP("squashfs", "s", true, "Create a squashfs image")
+	rootCmd.AddCommand(convertCmd)
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"go-image-builder/pkg/image"
+	"go-image-builder/pkg/imageconfig"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export IMAGE",
+	Short: "Flatten an image into a rootfs tarball",
+	Long: `Flatten the layers of an image in a registry into a single rootfs tarball.
+Whiteouts are applied, so the tarball contains the filesystem as it appears
+in a container started from the image.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, err := cmd.Flags().GetString("file")
+		if err != nil {
+			return fmt.Errorf("failed to get file flag: %w", err)
+		}
+
+		ref, err := parseImageReference(args[0])
+		if err != nil {
+			return err
+		}
+
+		opts, err := remoteOptions(ref.Context())
+		if err != nil {
+			return err
+		}
+
+		remoteImg, err := remote.Image(ref, opts...)
+		if err != nil {
+			return fmt.Errorf("failed to fetch image: %w", err)
+		}
+
+		img, err := image.NewImage("", ref.Context().RepositoryStr(), &imageconfig.Config{}, remoteImg, "")
+		if err != nil {
+			return fmt.Errorf("failed to load image: %w", err)
+		}
+
+		out, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer out.Close()
+
+		log.Infof("Exporting rootfs of %s to %s", ref, output)
+		if err := img.ExportRootfs(out); err != nil {
+			return err
+		}
+		return out.Sync()
+	},
+}
+
+func init() {
+	addRegistryFlags(exportCmd)
+	exportCmd.Flags().StringP("file", "f", "rootfs.tar", "Path of the rootfs tarball to write")
+	rootCmd.AddCommand(exportCmd)
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/oci"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// tempLayerPatterns are the temporary directories and files created by builds while packaging
+// layers
+var tempLayerPatterns = []string{
+	"base-layer-*",
+	"kernel-layer-*",
+	"initrd-layer-*",
+	"config-layer-*",
+	"squash-layer-*",
+	"gpu-layers-*",
+	"parent-image-*.tar",
+}
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove residue left behind by crashed or interrupted builds",
+	Long: `Find and remove go-image-builder containers of builds that are no
+longer running, and leftover parent-image-*.tar archives and temporary
+layer directories in the system temporary directory and the given work
+directories, reporting the space reclaimed.`,
+	Args:        cobra.NoArgs,
+	Annotations: map[string]string{storageAnnotation: ""},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		workDirs, err := cmd.Flags().GetStringSlice("workdir")
+		if err != nil {
+			return fmt.Errorf("failed to get workdir flag: %w", err)
+		}
+
+		olderThan, err := cmd.Flags().GetDuration("older-than")
+		if err != nil {
+			return fmt.Errorf("failed to get older-than flag: %w", err)
+		}
+
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return fmt.Errorf("failed to get dry-run flag: %w", err)
+		}
+
+		backend, err := cmd.Flags().GetString("backend")
+		if err != nil {
+			return fmt.Errorf("failed to get backend flag: %w", err)
+		}
+
+		cutoff := time.Now().Add(-olderThan)
+
+		// Collect stale files and directories
+		var candidates []string
+		for _, dir := range append([]string{os.TempDir()}, workDirs...) {
+			for _, pattern := range tempLayerPatterns {
+				matches, err := filepath.Glob(filepath.Join(dir, pattern))
+				if err != nil {
+					return fmt.Errorf("invalid work directory %s: %w", dir, err)
+				}
+				candidates = append(candidates, matches...)
+			}
+		}
+
+		var reclaimed int64
+		var removed int
+		for _, path := range candidates {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if info.ModTime().After(cutoff) {
+				log.Debugf("Skipping %s, modified within the last %s", path, olderThan)
+				continue
+			}
+
+			size, err := diskUsage(path)
+			if err != nil {
+				log.Warnf("Failed to compute size of %s: %v", path, err)
+			}
+
+			if dryRun {
+				log.Infof("Would remove %s (%s)", path, formatSize(size))
+			} else {
+				log.Infof("Removing %s (%s)", path, formatSize(size))
+				if err := os.RemoveAll(path); err != nil {
+					log.Warnf("Failed to remove %s: %v", path, err)
+					continue
+				}
+			}
+			reclaimed += size
+			removed++
+		}
+
+		// Remove containers left behind by builds that are no longer running
+		config := &imageconfig.Config{}
+		config.Options.Backend = backend
+		o, err := oci.NewOCI(config, "")
+		if err != nil {
+			return err
+		}
+		defer o.Close()
+		containers, err := o.StaleContainers(olderThan)
+		if err != nil {
+			log.Warnf("Failed to list buildah containers: %v", err)
+		}
+		var removedContainers int
+		for _, container := range containers {
+			if created, ok := oci.ContainerCreated(container); ok && created.After(cutoff) {
+				log.Debugf("Skipping container %s, created within the last %s", container, olderThan)
+				continue
+			}
+
+			if dryRun {
+				log.Infof("Would remove container %s", container)
+			} else {
+				log.Infof("Removing container %s", container)
+				if err := o.Cleanup(container); err != nil {
+					log.Warnf("Failed to remove container %s: %v", container, err)
+					continue
+				}
+			}
+			removedContainers++
+		}
+
+		verb := "Removed"
+		if dryRun {
+			verb = "Would remove"
+		}
+		log.Infof("%s %d files/directories and %d containers, reclaiming %s", verb, removed, removedContainers, formatSize(reclaimed))
+		return nil
+	},
+}
+
+// diskUsage returns the total size of the files below path
+func diskUsage(path string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+func init() {
+	gcCmd.Flags().StringSlice("workdir", nil, "Work or temporary directories of builds to scan for leftover parent archives and layer directories (repeatable)")
+	gcCmd.Flags().Duration("older-than", time.Hour, "Only remove residue older than this")
+	gcCmd.Flags().Bool("dry-run", false, "Report what would be removed without removing anything")
+	gcCmd.Flags().String("backend", "buildah", "Container backend whose containers are cleaned up: buildah, podman or docker")
+	rootCmd.AddCommand(gcCmd)
+}
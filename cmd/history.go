@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/spf13/cobra"
+)
+
+// HistoryEntry describes a single entry in an image's history
+type HistoryEntry struct {
+	Created    time.Time `json:"created"`
+	CreatedBy  string    `json:"created_by"`
+	Comment    string    `json:"comment"`
+	EmptyLayer bool      `json:"empty_layer"`
+	Digest     string    `json:"digest,omitempty"`
+	Size       int64     `json:"size"`
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history IMAGE",
+	Short: "Show the layer history of an image",
+	Long: `Show the layer history of an image in a registry. The history comments
+(e.g. "Base OS Layer", "Kernel Layer") are used to decide which layers are
+reused from a parent image, so this command helps audit that behavior.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return fmt.Errorf("failed to get format flag: %w", err)
+		}
+		if format != "table" && format != "json" {
+			return fmt.Errorf("unsupported output format '%s': must be 'table' or 'json'", format)
+		}
+
+		ref, err := parseImageReference(args[0])
+		if err != nil {
+			return err
+		}
+
+		opts, err := remoteOptions(ref.Context())
+		if err != nil {
+			return err
+		}
+
+		img, err := remote.Image(ref, opts...)
+		if err != nil {
+			return fmt.Errorf("failed to fetch image: %w", err)
+		}
+
+		config, err := img.ConfigFile()
+		if err != nil {
+			return fmt.Errorf("failed to fetch config: %w", err)
+		}
+
+		manifest, err := img.Manifest()
+		if err != nil {
+			return fmt.Errorf("failed to fetch manifest: %w", err)
+		}
+
+		// History entries that are not empty layers map to the manifest layers in order
+		entries := make([]HistoryEntry, 0, len(config.History))
+		layerIdx := 0
+		for _, h := range config.History {
+			entry := HistoryEntry{
+				Created:    h.Created.Time,
+				CreatedBy:  h.CreatedBy,
+				Comment:    h.Comment,
+				EmptyLayer: h.EmptyLayer,
+			}
+			if !h.EmptyLayer && layerIdx < len(manifest.Layers) {
+				entry.Digest = manifest.Layers[layerIdx].Digest.String()
+				entry.Size = manifest.Layers[layerIdx].Size
+				layerIdx++
+			}
+			entries = append(entries, entry)
+		}
+
+		if format == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(entries)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CREATED\tCREATED BY\tSIZE\tCOMMENT\tDIGEST")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				e.Created.Format("2006-01-02 15:04:05"),
+				e.CreatedBy,
+				formatSize(e.Size),
+				e.Comment,
+				e.Digest,
+			)
+		}
+		return w.Flush()
+	},
+}
+
+// formatSize renders a byte count in human readable units
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	addRegistryFlags(historyCmd)
+	historyCmd.Flags().StringP("format", "f", "table", "Output format (table, json)")
+	rootCmd.AddCommand(historyCmd)
+}
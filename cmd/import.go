@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"go-image-builder/pkg/image"
+	"go-image-builder/pkg/imageconfig"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import ROOTFS",
+	Short: "Wrap a rootfs tarball into an OCI image",
+	Long: `Wrap an existing rootfs tarball (for example one produced by a legacy image
+tool) into a labeled OCI image. Kernel and initrd layers can optionally be
+added. The image is pushed to --registry and/or saved to --archive.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rootfs := args[0]
+
+		imageName, err := cmd.Flags().GetString("name")
+		if err != nil {
+			return fmt.Errorf("failed to get name flag: %w", err)
+		}
+		registry, err := cmd.Flags().GetString("registry")
+		if err != nil {
+			return fmt.Errorf("failed to get registry flag: %w", err)
+		}
+		tags, err := cmd.Flags().GetString("tags")
+		if err != nil {
+			return fmt.Errorf("failed to get tags flag: %w", err)
+		}
+		archive, err := cmd.Flags().GetString("archive")
+		if err != nil {
+			return fmt.Errorf("failed to get archive flag: %w", err)
+		}
+		kernelPath, err := cmd.Flags().GetString("kernel")
+		if err != nil {
+			return fmt.Errorf("failed to get kernel flag: %w", err)
+		}
+		kernelVersion, err := cmd.Flags().GetString("kernel-version")
+		if err != nil {
+			return fmt.Errorf("failed to get kernel-version flag: %w", err)
+		}
+		initrdPath, err := cmd.Flags().GetString("initrd")
+		if err != nil {
+			return fmt.Errorf("failed to get initrd flag: %w", err)
+		}
+
+		if registry == "" && archive == "" {
+			return fmt.Errorf("at least one of --registry or --archive is required")
+		}
+		if _, err := os.Stat(rootfs); err != nil {
+			return fmt.Errorf("rootfs tarball %s is not accessible: %w", rootfs, err)
+		}
+
+		// Describe the import as a minimal base layer configuration
+		config := &imageconfig.Config{}
+		config.Options.LayerType = "base"
+		config.Options.Name = imageName
+		config.Options.Parent = "scratch"
+		config.Options.PublishRegistry = registry
+		config.Options.PublishTags = tags
+
+		img, err := image.NewImage(registry, imageName, config, nil, "")
+		if err != nil {
+			return fmt.Errorf("failed to create image: %w", err)
+		}
+		defer img.Cleanup()
+
+		log.Infof("Importing rootfs from %s", rootfs)
+		if err := img.AddBaseLayerFromTar(rootfs); err != nil {
+			return fmt.Errorf("failed to add base layer: %w", err)
+		}
+
+		if kernelPath != "" {
+			log.Infof("Adding kernel layer from %s", kernelPath)
+			if err := img.AddKernelLayer(kernelPath, kernelVersion); err != nil {
+				return fmt.Errorf("failed to add kernel layer: %w", err)
+			}
+		}
+
+		if initrdPath != "" {
+			log.Infof("Adding initrd layer from %s", initrdPath)
+			if err := img.AddInitrdLayer(initrdPath); err != nil {
+				return fmt.Errorf("failed to add initrd layer: %w", err)
+			}
+		}
+
+		if archive != "" {
+			log.Infof("Saving image to %s", archive)
+			if err := img.Save(archive); err != nil {
+				return err
+			}
+		}
+
+		if registry != "" {
+			if err := img.Push(); err != nil {
+				return fmt.Errorf("failed to push image: %w", err)
+			}
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	importCmd.Flags().String("name", "", "Name of the image (required)")
+	importCmd.Flags().String("registry", "", "Registry to push the image to")
+	importCmd.Flags().String("tags", "latest", "Comma separated list of tags to publish")
+	importCmd.Flags().String("archive", "", "Also save the image as a Docker archive at this path")
+	importCmd.Flags().String("kernel", "", "Kernel to add as a kernel layer")
+	importCmd.Flags().String("kernel-version", "", "Version of the kernel, recorded as a label")
+	importCmd.Flags().String("initrd", "", "Initrd to add as an initrd layer")
+	importCmd.MarkFlagRequired("name")
+	rootCmd.AddCommand(importCmd)
+}
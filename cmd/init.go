@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// distroProfile describes the repositories and release details used to scaffold a config
+type distroProfile struct {
+	Name       string
+	Release    string
+	PkgManager string
+	Repos      []scaffoldRepo
+}
+
+type scaffoldRepo struct {
+	Alias string
+	Url   string
+	GPG   string
+}
+
+// typeProfile describes the package selection for a given image type
+type typeProfile struct {
+	Description   string
+	PackageGroups []string
+	Packages      []string
+}
+
+var distroProfiles = map[string]distroProfile{
+	"rocky8": {
+		Name:       "rocky",
+		Release:    "8",
+		PkgManager: "dnf",
+		Repos: []scaffoldRepo{
+			{Alias: "Rocky_8_BaseOS", Url: "https://dl.rockylinux.org/pub/rocky/8/BaseOS/x86_64/os/", GPG: "https://dl.rockylinux.org/pub/rocky/RPM-GPG-KEY-rockyofficial"},
+			{Alias: "Rocky_8_AppStream", Url: "https://dl.rockylinux.org/pub/rocky/8/AppStream/x86_64/os/", GPG: "https://dl.rockylinux.org/pub/rocky/RPM-GPG-KEY-rockyofficial"},
+		},
+	},
+	"rocky9": {
+		Name:       "rocky",
+		Release:    "9",
+		PkgManager: "dnf",
+		Repos: []scaffoldRepo{
+			{Alias: "Rocky_9_BaseOS", Url: "https://dl.rockylinux.org/pub/rocky/9/BaseOS/x86_64/os/", GPG: "https://dl.rockylinux.org/pub/rocky/RPM-GPG-KEY-Rocky-9"},
+			{Alias: "Rocky_9_AppStream", Url: "https://dl.rockylinux.org/pub/rocky/9/AppStream/x86_64/os/", GPG: "https://dl.rockylinux.org/pub/rocky/RPM-GPG-KEY-Rocky-9"},
+		},
+	},
+	"almalinux9": {
+		Name:       "almalinux",
+		Release:    "9",
+		PkgManager: "dnf",
+		Repos: []scaffoldRepo{
+			{Alias: "AlmaLinux_9_BaseOS", Url: "https://repo.almalinux.org/almalinux/9/BaseOS/x86_64/os/", GPG: "https://repo.almalinux.org/almalinux/RPM-GPG-KEY-AlmaLinux-9"},
+			{Alias: "AlmaLinux_9_AppStream", Url: "https://repo.almalinux.org/almalinux/9/AppStream/x86_64/os/", GPG: "https://repo.almalinux.org/almalinux/RPM-GPG-KEY-AlmaLinux-9"},
+		},
+	},
+}
+
+var typeProfiles = map[string]typeProfile{
+	"base": {
+		Description:   "minimal bootable base image",
+		PackageGroups: []string{"Minimal Install"},
+		Packages:      []string{"kernel", "dracut-live", "cloud-init", "chrony", "rsyslog", "sudo"},
+	},
+	"compute": {
+		Description:   "diskless compute node image",
+		PackageGroups: []string{"Minimal Install"},
+		Packages: []string{
+			"kernel", "dracut-live", "cloud-init", "chrony", "rsyslog", "sudo",
+			"nfs-utils", "rdma-core", "infiniband-diags", "numactl", "hwloc",
+			"munge", "tar", "wget", "vim-minimal",
+		},
+	},
+}
+
+var scaffoldTemplate = template.Must(template.New("config").Parse(`# Starter configuration for a {{ .Distro.Name }} {{ .Distro.Release }} {{ .Type.Description }}.
+# Generated by 'go-image-builder init'. Review every section before building.
+
+options:
+  # 'base' layers are built with a package manager, 'ansible' layers run playbooks.
+  layer_type: 'base'
+
+  # Name of the image in the registry.
+  name: '{{ .Distro.Name }}-{{ .TypeName }}'
+
+  # Comma separated list of tags to publish.
+  publish_tags: '{{ .Distro.Release }},latest'
+
+  pkg_manager: '{{ .Distro.PkgManager }}'
+
+  # Start from an empty rootfs. Set this to a registry reference to build on
+  # top of an existing image instead.
+  parent: 'scratch'
+
+  # Registry to publish the OCI image to. Leave empty to skip pushing.
+  # publish_registry: 'registry.example.com:5000/{{ .TypeName }}'
+  # registry_opts_push:
+  #   - '--tls-verify=false'
+
+repos:
+{{- range .Distro.Repos }}
+  - alias: '{{ .Alias }}'
+    url: '{{ .Url }}'
+    gpg: '{{ .GPG }}'
+{{- end }}
+
+package_groups:
+{{- range .Type.PackageGroups }}
+  - '{{ . }}'
+{{- end }}
+
+packages:
+{{- range .Type.Packages }}
+  - {{ . }}
+{{- end }}
+
+# Commands run inside the image after packages are installed.
+# cmds:
+#   - cmd: 'echo "Welcome" > /etc/motd'
+#     loglevel: INFO
+
+# Files copied from the build host into the image.
+# copyfiles:
+#   - src: './files/chrony.conf'
+#     dest: '/etc/chrony.conf'
+`))
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Write a starter configuration file",
+	Long: `Write a commented starter configuration file for a supported distribution
+and image type. The result is a reasonable starting point that should be
+reviewed and adjusted before building.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		distroName, err := cmd.Flags().GetString("distro")
+		if err != nil {
+			return fmt.Errorf("failed to get distro flag: %w", err)
+		}
+
+		typeName, err := cmd.Flags().GetString("type")
+		if err != nil {
+			return fmt.Errorf("failed to get type flag: %w", err)
+		}
+
+		file, err := cmd.Flags().GetString("file")
+		if err != nil {
+			return fmt.Errorf("failed to get file flag: %w", err)
+		}
+
+		force, err := cmd.Flags().GetBool("force")
+		if err != nil {
+			return fmt.Errorf("failed to get force flag: %w", err)
+		}
+
+		distro, ok := distroProfiles[distroName]
+		if !ok {
+			return fmt.Errorf("unsupported distro '%s' (supported: %s)", distroName, strings.Join(sortedKeys(distroProfiles), ", "))
+		}
+		imageType, ok := typeProfiles[typeName]
+		if !ok {
+			return fmt.Errorf("unsupported image type '%s' (supported: %s)", typeName, strings.Join(sortedKeys(typeProfiles), ", "))
+		}
+
+		data := struct {
+			Distro   distroProfile
+			Type     typeProfile
+			TypeName string
+		}{
+			Distro:   distro,
+			Type:     imageType,
+			TypeName: typeName,
+		}
+
+		// Write to stdout when requested
+		if file == "-" {
+			return scaffoldTemplate.Execute(cmd.OutOrStdout(), data)
+		}
+
+		if file == "" {
+			file = fmt.Sprintf("%s-%s.yaml", distroName, typeName)
+		}
+
+		if _, err := os.Stat(file); err == nil && !force {
+			return fmt.Errorf("file %s already exists, use --force to overwrite it", file)
+		}
+
+		out, err := os.Create(file)
+		if err != nil {
+			return fmt.Errorf("failed to create config file: %w", err)
+		}
+		defer out.Close()
+
+		if err := scaffoldTemplate.Execute(out, data); err != nil {
+			return fmt.Errorf("failed to write config file: %w", err)
+		}
+
+		log.Infof("Wrote starter configuration to %s", file)
+		return nil
+	},
+}
+
+// sortedKeys returns the keys of a profile map in a stable order for messages
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+
+	initCmd.Flags().String("distro", "rocky9", "Distribution to scaffold (rocky8, rocky9, almalinux9)")
+	initCmd.Flags().String("type", "base", "Image type to scaffold (base, compute)")
+	initCmd.Flags().StringP("file", "f", "", "Path of the file to write, '-' for stdout (default: <distro>-<type>.yaml)")
+	initCmd.Flags().Bool("force", false, "Overwrite the file if it already exists")
+}
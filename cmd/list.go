@@ -2,16 +2,22 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"text/tabwriter"
 	"time"
 
-	"github.com/google/go-containerregistry/pkg/authn"
+	"go-image-builder/pkg/image"
+
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -19,14 +25,59 @@ var (
 )
 
 type ImageInfo struct {
-	Repository  string
-	Tag         string
-	Created     time.Time
-	KernelVer   string
-	HasKernel   bool
-	HasInitrd   bool
-	BuildDate   string
-	Description string
+	Repository     string            `json:"repository" yaml:"repository"`
+	Tag            string            `json:"tag" yaml:"tag"`
+	Digest         string            `json:"digest" yaml:"digest"`
+	Created        time.Time         `json:"created" yaml:"created"`
+	KernelVer      string            `json:"kernel_version" yaml:"kernel_version"`
+	HasKernel      bool              `json:"has_kernel" yaml:"has_kernel"`
+	HasInitrd      bool              `json:"has_initrd" yaml:"has_initrd"`
+	BuildDate      string            `json:"build_date" yaml:"build_date"`
+	Description    string            `json:"description" yaml:"description"`
+	Size           int64             `json:"size" yaml:"size"`
+	Layers         int               `json:"layers" yaml:"layers"`
+	Cmdline        string            `json:"kernel_cmdline,omitempty" yaml:"kernel_cmdline,omitempty"`
+	DracutModules  []string          `json:"dracut_modules,omitempty" yaml:"dracut_modules,omitempty"`
+	SquashfsDigest string            `json:"squashfs_digest,omitempty" yaml:"squashfs_digest,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+
+	// blobs are the sizes of the config and layer blobs of the image, by digest
+	blobs map[string]int64
+}
+
+// byteSize is a size in bytes that renders in human readable units in tables
+type byteSize int64
+
+func (b byteSize) String() string {
+	return formatSize(int64(b))
+}
+
+// listColumn describes a column that can be selected with --columns
+type listColumn struct {
+	Name   string
+	Header string
+	Value  func(info ImageInfo) any
+}
+
+var listColumns = []listColumn{
+	{"repository", "REPOSITORY", func(i ImageInfo) any { return i.Repository }},
+	{"tag", "TAG", func(i ImageInfo) any { return i.Tag }},
+	{"created", "CREATED", func(i ImageInfo) any { return i.Created }},
+	{"kernel_version", "KERNEL VERSION", func(i ImageInfo) any { return i.KernelVer }},
+	{"has_kernel", "KERNEL", func(i ImageInfo) any { return i.HasKernel }},
+	{"has_initrd", "INITRD", func(i ImageInfo) any { return i.HasInitrd }},
+	{"build_date", "BUILD DATE", func(i ImageInfo) any { return i.BuildDate }},
+	{"size", "SIZE", func(i ImageInfo) any { return byteSize(i.Size) }},
+	{"layers", "LAYERS", func(i ImageInfo) any { return i.Layers }},
+	{"description", "DESCRIPTION", func(i ImageInfo) any { return i.Description }},
+}
+
+// bootColumns are the boot metadata of the images, which are too wide for the default table
+// and only shown when selected with --columns
+var bootColumns = []listColumn{
+	{"kernel_cmdline", "KERNEL CMDLINE", func(i ImageInfo) any { return i.Cmdline }},
+	{"dracut_modules", "DRACUT MODULES", func(i ImageInfo) any { return strings.Join(i.DracutModules, ",") }},
+	{"squashfs_digest", "SQUASHFS DIGEST", func(i ImageInfo) any { return i.SquashfsDigest }},
 }
 
 var listCmd = &cobra.Command{
@@ -36,118 +87,413 @@ var listCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		registry := args[0]
 
-		// Remove any existing protocol prefix
-		registry = strings.TrimPrefix(registry, "http://")
-		registry = strings.TrimPrefix(registry, "https://")
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return fmt.Errorf("failed to get format flag: %w", err)
+		}
+		if format != "table" && format != "json" && format != "yaml" {
+			return fmt.Errorf("unsupported output format '%s': must be 'table', 'json' or 'yaml'", format)
+		}
 
-		// Create a registry reference
-		reg, err := name.NewRegistry(registry, name.Insecure)
+		columnNames, err := cmd.Flags().GetStringSlice("columns")
+		if err != nil {
+			return fmt.Errorf("failed to get columns flag: %w", err)
+		}
+		columns, err := selectColumns(columnNames)
 		if err != nil {
-			return fmt.Errorf("invalid registry: %w", err)
+			return err
 		}
 
-		// Get authentication options
-		auth, err := authn.DefaultKeychain.Resolve(reg)
+		_, infos, err := collectRegistryImages(cmd, registry)
 		if err != nil {
-			return fmt.Errorf("failed to resolve authentication: %w", err)
+			return err
 		}
 
-		// Configure remote options
-		opts := []remote.Option{remote.WithAuth(auth)}
-		if insecure {
-			opts = append(opts, remote.WithTransport(remote.DefaultTransport))
+		return renderImageInfos(infos, columns, format, len(columnNames) > 0)
+	},
+}
+
+// addImageSelectionFlags registers the flags selecting the images of a registry that
+// collectRegistryImages fetches, shared by the commands that summarize a registry
+func addImageSelectionFlags(cmd *cobra.Command, verb string) {
+	addRegistryFlags(cmd)
+	cmd.Flags().StringSlice("repos", nil, fmt.Sprintf("Repositories to %s instead of querying the registry catalog", verb))
+	cmd.Flags().String("repos-file", "", fmt.Sprintf("File with repositories to %s, one per line", verb))
+	cmd.Flags().String("repo", "", fmt.Sprintf("Only %s repositories matching this glob", verb))
+	cmd.Flags().String("tag", "", fmt.Sprintf("Only %s tags matching this glob", verb))
+	cmd.Flags().StringSlice("label", nil, fmt.Sprintf("Only %s images with a label matching key=value, the value may be a glob (repeatable)", verb))
+	cmd.Flags().String("since", "", fmt.Sprintf("Only %s images created after this time (RFC3339, YYYY-MM-DD or a duration such as 7d)", verb))
+	cmd.Flags().String("before", "", fmt.Sprintf("Only %s images created before this time (RFC3339, YYYY-MM-DD or a duration such as 7d)", verb))
+	cmd.Flags().Int("concurrency", 8, "Number of repositories and images fetched in parallel")
+}
+
+// collectRegistryImages fetches the information of the images of registry selected by the
+// flags of addImageSelectionFlags. The registry is returned without any protocol prefix.
+func collectRegistryImages(cmd *cobra.Command, registry string) (string, []ImageInfo, error) {
+	// Remove any existing protocol prefix, an explicit http:// implies --insecure
+	if strings.HasPrefix(registry, "http://") {
+		insecure = true
+	}
+	registry = strings.TrimPrefix(registry, "http://")
+	registry = strings.TrimPrefix(registry, "https://")
+
+	// Create a registry reference
+	reg, err := name.NewRegistry(registry, nameOptions()...)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid registry: %w", err)
+	}
+
+	// Configure remote options
+	opts, err := remoteOptions(reg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	filter, err := newListFilter(cmd)
+	if err != nil {
+		return "", nil, err
+	}
+
+	repos, err := listRepositories(cmd, reg, opts, filter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	concurrency, err := cmd.Flags().GetInt("concurrency")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get concurrency flag: %w", err)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return registry, collectImageInfos(registry, repos, opts, concurrency, filter), nil
+}
+
+// listRepositories returns the repositories to list. Repositories given with --repos or
+// --repos-file are used as is; otherwise the registry catalog is queried. Registries such as
+// ECR and GCR do not implement the catalog API, in which case a literal --repo filter is used
+// as the repository to list.
+func listRepositories(cmd *cobra.Command, reg name.Registry, opts []remote.Option, filter *listFilter) ([]string, error) {
+	repos, err := cmd.Flags().GetStringSlice("repos")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repos flag: %w", err)
+	}
+
+	reposFile, err := cmd.Flags().GetString("repos-file")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repos-file flag: %w", err)
+	}
+	if reposFile != "" {
+		fileRepos, err := readReposFile(reposFile)
+		if err != nil {
+			return nil, err
 		}
+		repos = append(repos, fileRepos...)
+	}
+
+	if len(repos) > 0 {
+		return repos, nil
+	}
+
+	// List repositories with authentication
+	repos, err = remote.Catalog(context.Background(), reg, opts...)
+	if err == nil {
+		return repos, nil
+	}
+
+	if filter.repo != "" && !strings.ContainsAny(filter.repo, "*?[") {
+		fmt.Fprintf(os.Stderr, "Catalog API unavailable (%v), listing repository %s only\n", err, filter.repo)
+		return []string{filter.repo}, nil
+	}
+
+	return nil, fmt.Errorf("failed to list repositories: %w (if the registry does not support the catalog API, pass --repos or --repos-file)", err)
+}
 
-		// List repositories with authentication
-		repos, err := remote.Catalog(context.Background(), reg, opts...)
+// readReposFile reads repository names from a file, one per line. Blank lines and lines
+// starting with '#' are ignored.
+func readReposFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repos file: %w", err)
+	}
+
+	var repos []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		repos = append(repos, line)
+	}
+	return repos, nil
+}
+
+// taggedImage identifies a single tag within a repository
+type taggedImage struct {
+	repo string
+	tag  string
+}
+
+// collectImageInfos lists the tags of every repository and fetches the information of every
+// tagged image using a bounded pool of workers. Only images matching the filter are returned,
+// sorted by repository and tag.
+func collectImageInfos(registry string, repos []string, opts []remote.Option, concurrency int, filter *listFilter) []ImageInfo {
+	var matchingRepos []string
+	for _, repo := range repos {
+		if filter.matchRepo(repo) {
+			matchingRepos = append(matchingRepos, repo)
+		}
+	}
+
+	// Stage 1: list the tags of each repository
+	images, errs := runPool(matchingRepos, concurrency, func(repo string) ([]taggedImage, error) {
+		repoRef, err := name.NewRepository(fmt.Sprintf("%s/%s", registry, repo), nameOptions()...)
 		if err != nil {
-			return fmt.Errorf("failed to list repositories: %w", err)
+			return nil, fmt.Errorf("failed to parse repository reference: %w", err)
 		}
 
-		// Create tabwriter for formatted output
-		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-		fmt.Fprintln(w, "REPOSITORY\tTAG\tCREATED\tKERNEL VERSION\tKERNEL\tINITRD\tBUILD DATE\tDESCRIPTION")
+		// List tags for this repository with authentication
+		tags, err := remote.List(repoRef, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags of %s: %w", repo, err)
+		}
 
-		for _, repo := range repos {
-			repoRef, err := name.NewRepository(fmt.Sprintf("%s/%s", registry, repo), name.Insecure)
-			if err != nil {
-				fmt.Printf("  Error parsing repository reference: %v\n", err)
-				continue
+		result := make([]taggedImage, 0, len(tags))
+		for _, tag := range tags {
+			if filter.matchTag(tag) {
+				result = append(result, taggedImage{repo: repo, tag: tag})
 			}
+		}
+		return result, nil
+	})
 
-			// List tags for this repository with authentication
-			tags, err := remote.List(repoRef, opts...)
-			if err != nil {
-				fmt.Printf("  Error listing tags: %v\n", err)
-				continue
-			}
+	printErrors(errs, "  ")
 
-			for _, tag := range tags {
-				imgRef, err := name.ParseReference(fmt.Sprintf("%s/%s:%s", registry, repo, tag), name.Insecure)
-				if err != nil {
-					fmt.Printf("    Error parsing image reference: %v\n", err)
-					continue
-				}
+	// Stage 2: fetch the config and manifest of each tagged image
+	infos, errs := runPool(images, concurrency, func(t taggedImage) ([]ImageInfo, error) {
+		info, err := fetchImageInfo(registry, t.repo, t.tag, opts)
+		if err != nil {
+			return nil, err
+		}
+		if !filter.matchInfo(info) {
+			return nil, nil
+		}
+		return []ImageInfo{info}, nil
+	})
+	printErrors(errs, "    ")
 
-				img, err := remote.Image(imgRef, opts...)
-				if err != nil {
-					fmt.Printf("    Error fetching image: %v\n", err)
-					continue
-				}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Repository != infos[j].Repository {
+			return infos[i].Repository < infos[j].Repository
+		}
+		return infos[i].Tag < infos[j].Tag
+	})
+	return infos
+}
+
+// runPool applies fn to every input using at most concurrency goroutines and returns the
+// concatenated results and the errors of the inputs that failed. Errors do not stop the
+// other workers.
+func runPool[In, Out any](inputs []In, concurrency int, fn func(In) ([]Out, error)) ([]Out, []error) {
+	jobs := make(chan In)
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results []Out
+		errs    []error
+	)
 
-				config, err := img.ConfigFile()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for in := range jobs {
+				out, err := fn(in)
+				mu.Lock()
 				if err != nil {
-					fmt.Printf("    Error fetching config: %v\n", err)
-					continue
+					errs = append(errs, err)
+				} else {
+					results = append(results, out...)
 				}
+				mu.Unlock()
+			}
+		}()
+	}
 
-				// Extract image information
-				info := ImageInfo{
-					Repository: repo,
-					Tag:        tag,
-					Created:    config.Created.Time,
-				}
+	for _, in := range inputs {
+		jobs <- in
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results, errs
+}
+
+// printErrors reports errors on stderr, indented below the output they belong to
+func printErrors(errs []error, indent string) {
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s%v\n", indent, err)
+	}
+}
+
+// fetchImageInfo fetches the config and manifest of a tagged image and summarizes them
+func fetchImageInfo(registry, repo, tag string, opts []remote.Option) (ImageInfo, error) {
+	imgRef, err := name.ParseReference(fmt.Sprintf("%s/%s:%s", registry, repo, tag), nameOptions()...)
+	if err != nil {
+		return ImageInfo{}, fmt.Errorf("failed to parse image reference: %w", err)
+	}
+
+	img, err := remote.Image(imgRef, opts...)
+	if err != nil {
+		return ImageInfo{}, fmt.Errorf("failed to fetch image %s:%s: %w", repo, tag, err)
+	}
+
+	config, err := img.ConfigFile()
+	if err != nil {
+		return ImageInfo{}, fmt.Errorf("failed to fetch config of %s:%s: %w", repo, tag, err)
+	}
+
+	// Extract image information
+	info := ImageInfo{
+		Repository: repo,
+		Tag:        tag,
+		Created:    config.Created.Time,
+	}
+	if digest, err := img.Digest(); err == nil {
+		info.Digest = digest.String()
+	}
+
+	// Check for kernel and initrd layers
+	manifest, err := img.Manifest()
+	if err == nil {
+		// The compressed size is the sum of the config and layer blobs
+		info.Size = manifest.Config.Size
+		info.Layers = len(manifest.Layers)
+		info.blobs = map[string]int64{manifest.Config.Digest.String(): manifest.Config.Size}
+		for _, layer := range manifest.Layers {
+			info.Size += layer.Size
+			info.blobs[layer.Digest.String()] = layer.Size
+		}
 
-				// Check for kernel and initrd layers
-				manifest, err := img.Manifest()
-				if err == nil {
-					for _, layer := range manifest.Layers {
-						if layer.Annotations != nil {
-							if layer.Annotations["org.opencontainers.image.type"] == "kernel" {
-								info.HasKernel = true
-								info.KernelVer = layer.Annotations["org.opencontainers.image.kernel.version"]
-							} else if layer.Annotations["org.opencontainers.image.type"] == "initrd" {
-								info.HasInitrd = true
-							}
-						}
-					}
+		for _, layer := range manifest.Layers {
+			if layer.Annotations != nil {
+				if layer.Annotations["org.opencontainers.image.type"] == "kernel" {
+					info.HasKernel = true
+					info.KernelVer = layer.Annotations["org.opencontainers.image.kernel.version"]
+				} else if layer.Annotations["org.opencontainers.image.type"] == "initrd" {
+					info.HasInitrd = true
 				}
+			}
+		}
+	}
+
+	// Get build date and description from labels
+	if config.Config.Labels != nil {
+		info.Labels = config.Config.Labels
+		info.BuildDate = config.Config.Labels["org.opencontainers.image.build-date"]
+		info.Description = config.Config.Labels["org.opencontainers.image.description"]
+		if info.KernelVer == "" {
+			info.KernelVer = config.Config.Labels[image.KernelVersionLabel]
+		}
+		info.Cmdline = config.Config.Labels[image.KernelCmdlineLabel]
+		if modules := config.Config.Labels[image.DracutModulesLabel]; modules != "" {
+			info.DracutModules = strings.Split(modules, ",")
+		}
+		info.SquashfsDigest = config.Config.Labels[image.SquashfsDigestLabel]
+	}
+
+	return info, nil
+}
+
+// selectColumns resolves column names to columns, returning all columns but the boot
+// columns when none are given
+func selectColumns(names []string) ([]listColumn, error) {
+	if len(names) == 0 {
+		return listColumns, nil
+	}
 
-				// Get build date and description from labels
-				if config.Config.Labels != nil {
-					info.BuildDate = config.Config.Labels["org.opencontainers.image.build-date"]
-					info.Description = config.Config.Labels["org.opencontainers.image.description"]
+	all := append(slices.Clone(listColumns), bootColumns...)
+	var selected []listColumn
+	for _, n := range names {
+		found := false
+		for _, c := range all {
+			if c.Name == strings.TrimSpace(n) {
+				selected = append(selected, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			var valid []string
+			for _, c := range all {
+				valid = append(valid, c.Name)
+			}
+			return nil, fmt.Errorf("unknown column '%s' (valid columns: %s)", n, strings.Join(valid, ", "))
+		}
+	}
+	return selected, nil
+}
+
+// renderImageInfos writes the image information to stdout in the requested format. For
+// json and yaml the full records are written unless columns were explicitly selected.
+func renderImageInfos(infos []ImageInfo, columns []listColumn, format string, filtered bool) error {
+	if format == "table" {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		headers := make([]string, len(columns))
+		for i, c := range columns {
+			headers[i] = c.Header
+		}
+		fmt.Fprintln(w, strings.Join(headers, "\t"))
+
+		for _, info := range infos {
+			values := make([]string, len(columns))
+			for i, c := range columns {
+				switch v := c.Value(info).(type) {
+				case time.Time:
+					values[i] = v.Format("2006-01-02 15:04:05")
+				default:
+					values[i] = fmt.Sprintf("%v", v)
 				}
+			}
+			fmt.Fprintln(w, strings.Join(values, "\t"))
+		}
+		return w.Flush()
+	}
 
-				// Print in tabular format
-				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%v\t%v\t%s\t%s\n",
-					info.Repository,
-					info.Tag,
-					info.Created.Format("2006-01-02 15:04:05"),
-					info.KernelVer,
-					info.HasKernel,
-					info.HasInitrd,
-					info.BuildDate,
-					info.Description,
-				)
+	var data any = infos
+	if infos == nil {
+		data = []ImageInfo{}
+	}
+	if filtered {
+		records := make([]map[string]any, 0, len(infos))
+		for _, info := range infos {
+			record := make(map[string]any, len(columns))
+			for _, c := range columns {
+				record[c.Name] = c.Value(info)
 			}
+			records = append(records, record)
 		}
-		w.Flush()
-		return nil
-	},
+		data = records
+	}
+
+	if format == "yaml" {
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(data); err != nil {
+			return fmt.Errorf("failed to encode yaml: %w", err)
+		}
+		return enc.Close()
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(data)
 }
 
 func init() {
-	listCmd.Flags().BoolVar(&insecure, "insecure", false, "Allow insecure HTTP connections")
+	addImageSelectionFlags(listCmd, "list")
+	listCmd.Flags().StringP("format", "f", "table", "Output format (table, json, yaml)")
+	listCmd.Flags().StringSlice("columns", nil, "Comma separated list of columns to include (default: all but kernel_cmdline, dracut_modules and squashfs_digest)")
 	rootCmd.AddCommand(listCmd)
 }
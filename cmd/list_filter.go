@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// listFilter selects which repositories, tags and images of a registry are reported
+type listFilter struct {
+	repo   string
+	tag    string
+	labels map[string]string
+	since  time.Time
+	before time.Time
+}
+
+// newListFilter builds a listFilter from the flags registered by addImageSelectionFlags
+func newListFilter(cmd *cobra.Command) (*listFilter, error) {
+	f := &listFilter{labels: make(map[string]string)}
+	var err error
+
+	if f.repo, err = cmd.Flags().GetString("repo"); err != nil {
+		return nil, fmt.Errorf("failed to get repo flag: %w", err)
+	}
+	if f.tag, err = cmd.Flags().GetString("tag"); err != nil {
+		return nil, fmt.Errorf("failed to get tag flag: %w", err)
+	}
+
+	labels, err := cmd.Flags().GetStringSlice("label")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get label flag: %w", err)
+	}
+	for _, label := range labels {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label selector '%s': must be key=value", label)
+		}
+		f.labels[key] = value
+	}
+
+	since, err := cmd.Flags().GetString("since")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get since flag: %w", err)
+	}
+	if f.since, err = parseTimeFilter(since, time.Now()); err != nil {
+		return nil, fmt.Errorf("invalid --since value: %w", err)
+	}
+
+	before, err := cmd.Flags().GetString("before")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get before flag: %w", err)
+	}
+	if f.before, err = parseTimeFilter(before, time.Now()); err != nil {
+		return nil, fmt.Errorf("invalid --before value: %w", err)
+	}
+
+	// Validate the glob patterns up front
+	for _, pattern := range []string{f.repo, f.tag} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern '%s': %w", pattern, err)
+		}
+	}
+
+	return f, nil
+}
+
+// parseTimeFilter parses an absolute time (RFC3339 or YYYY-MM-DD) or a duration relative to
+// now. Durations accept a 'd' suffix for days. An empty value returns the zero time.
+func parseTimeFilter(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid number of days '%s'", days)
+		}
+		return now.AddDate(0, 0, -n), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("'%s' is not a time or duration", value)
+	}
+	return now.Add(-d), nil
+}
+
+// matchRepo reports whether the repository matches the repository glob
+func (f *listFilter) matchRepo(repo string) bool {
+	return matchGlob(f.repo, repo)
+}
+
+// matchTag reports whether the tag matches the tag glob
+func (f *listFilter) matchTag(tag string) bool {
+	return matchGlob(f.tag, tag)
+}
+
+// matchInfo reports whether a fetched image matches the label and time filters
+func (f *listFilter) matchInfo(info ImageInfo) bool {
+	for key, pattern := range f.labels {
+		value, ok := info.Labels[key]
+		if !ok || !matchGlob(pattern, value) {
+			return false
+		}
+	}
+	if !f.since.IsZero() && info.Created.Before(f.since) {
+		return false
+	}
+	if !f.before.IsZero() && !info.Created.Before(f.before) {
+		return false
+	}
+	return true
+}
+
+// matchGlob matches value against a glob pattern, where an empty pattern matches everything
+func matchGlob(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
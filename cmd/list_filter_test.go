@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeFilter(t *testing.T) {
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: time.Time{}},
+		{name: "rfc3339", value: "2025-06-01T08:00:00Z", want: time.Date(2025, 6, 1, 8, 0, 0, 0, time.UTC)},
+		{name: "date", value: "2025-06-01", want: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "days", value: "7d", want: now.AddDate(0, 0, -7)},
+		{name: "duration", value: "36h", want: now.Add(-36 * time.Hour)},
+		{name: "invalid days", value: "xd", wantErr: true},
+		{name: "invalid", value: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTimeFilter(tt.value, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseTimeFilter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseTimeFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListFilterMatchInfo(t *testing.T) {
+	created := time.Date(2025, 6, 10, 0, 0, 0, 0, time.UTC)
+	info := ImageInfo{
+		Repository: "compute/rocky",
+		Tag:        "9.5",
+		Created:    created,
+		Labels: map[string]string{
+			"com.openchami.image.kernel-version": "5.14.0-503.el9.x86_64",
+		},
+	}
+
+	tests := []struct {
+		name   string
+		filter listFilter
+		want   bool
+	}{
+		{name: "no filter", filter: listFilter{}, want: true},
+		{name: "label glob", filter: listFilter{labels: map[string]string{"com.openchami.image.kernel-version": "5.14.*"}}, want: true},
+		{name: "label mismatch", filter: listFilter{labels: map[string]string{"com.openchami.image.kernel-version": "6.*"}}, want: false},
+		{name: "missing label", filter: listFilter{labels: map[string]string{"com.openchami.image.role": "*"}}, want: false},
+		{name: "since", filter: listFilter{since: created.AddDate(0, 0, -1)}, want: true},
+		{name: "since excludes", filter: listFilter{since: created.AddDate(0, 0, 1)}, want: false},
+		{name: "before", filter: listFilter{before: created.AddDate(0, 0, 1)}, want: true},
+		{name: "before excludes", filter: listFilter{before: created}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.matchInfo(info); got != tt.want {
+				t.Errorf("matchInfo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListFilterGlobs(t *testing.T) {
+	f := listFilter{repo: "compute/*", tag: "9.*"}
+	if !f.matchRepo("compute/rocky") {
+		t.Errorf("expected compute/rocky to match %s", f.repo)
+	}
+	if f.matchRepo("base/rocky") {
+		t.Errorf("expected base/rocky not to match %s", f.repo)
+	}
+	if !f.matchTag("9.5") || f.matchTag("latest") {
+		t.Errorf("unexpected tag match result for pattern %s", f.tag)
+	}
+}
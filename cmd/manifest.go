@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/spf13/cobra"
+)
+
+var manifestCmd = &cobra.Command{
+	Use:   "manifest IMAGE",
+	Short: "Print the raw manifest and config of an image",
+	Long: `Fetch and print the raw manifest and config JSON of an image reference.
+This is useful for debugging media type and annotation issues with boot services.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		show, err := cmd.Flags().GetString("show")
+		if err != nil {
+			return fmt.Errorf("failed to get show flag: %w", err)
+		}
+		if show != "all" && show != "manifest" && show != "config" {
+			return fmt.Errorf("unsupported value '%s' for --show: must be 'all', 'manifest' or 'config'", show)
+		}
+
+		raw, err := cmd.Flags().GetBool("raw")
+		if err != nil {
+			return fmt.Errorf("failed to get raw flag: %w", err)
+		}
+
+		ref, err := parseImageReference(args[0])
+		if err != nil {
+			return err
+		}
+
+		opts, err := remoteOptions(ref.Context())
+		if err != nil {
+			return err
+		}
+
+		desc, err := remote.Get(ref, opts...)
+		if err != nil {
+			return fmt.Errorf("failed to fetch manifest: %w", err)
+		}
+
+		if show == "all" || show == "manifest" {
+			if show == "all" {
+				fmt.Printf("# Manifest (%s, %s)\n", desc.MediaType, desc.Digest)
+			}
+			if err := printJSON(desc.Manifest, raw); err != nil {
+				return fmt.Errorf("failed to print manifest: %w", err)
+			}
+		}
+
+		if show == "all" || show == "config" {
+			// Indexes do not have a config of their own
+			if desc.MediaType.IsIndex() {
+				if show == "config" {
+					return fmt.Errorf("%s is an image index and has no config", ref)
+				}
+				return nil
+			}
+
+			img, err := desc.Image()
+			if err != nil {
+				return fmt.Errorf("failed to load image: %w", err)
+			}
+			config, err := img.RawConfigFile()
+			if err != nil {
+				return fmt.Errorf("failed to fetch config: %w", err)
+			}
+
+			if show == "all" {
+				fmt.Println("# Config")
+			}
+			if err := printJSON(config, raw); err != nil {
+				return fmt.Errorf("failed to print config: %w", err)
+			}
+		}
+
+		return nil
+	},
+}
+
+// printJSON writes a JSON document to stdout, indenting it unless raw output is requested
+func printJSON(data []byte, raw bool) error {
+	if raw {
+		_, err := fmt.Println(string(data))
+		return err
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return err
+	}
+	out.WriteString("\n")
+	_, err := out.WriteTo(os.Stdout)
+	return err
+}
+
+func init() {
+	addRegistryFlags(manifestCmd)
+	manifestCmd.Flags().String("show", "all", "What to print (all, manifest, config)")
+	manifestCmd.Flags().Bool("raw", false, "Print the JSON exactly as returned by the registry")
+	rootCmd.AddCommand(manifestCmd)
+}
@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"go-image-builder/pkg/image"
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/oci"
+	"go-image-builder/pkg/utils"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// chainImage is an image of a parent chain, as its registry holds it
+type chainImage struct {
+	Image  string
+	Digest string // Hex digest of the image config, the ID it has in local storage
+	Layers int
+	Size   int64
+}
+
+// prefetchedImage is an image of a parent chain made available in local storage
+type prefetchedImage struct {
+	chainImage
+	Status   string // "reused", "pulled" or "stale"
+	Verified string
+}
+
+var prefetchCmd = &cobra.Command{
+	Use:   "prefetch CONFIG",
+	Short: "Pull the parent chain of a configuration into local storage",
+	Long: `Pull the parent image of a configuration, and the ancestors recorded in the
+parent labels of the images, into local storage ahead of a build, such as before an
+air-gapped or low-bandwidth build window. The parent is verified against
+options.parent_verify as a build would, and every image in local storage is checked
+against the config digest its registry holds. The images reused from local storage and
+those that were pulled are reported. Images whose local copy differs from the registry
+are reported as stale, and make the command fail; prefetch with --refresh to update them.`,
+	Args:        cobra.ExactArgs(1),
+	Annotations: map[string]string{storageAnnotation: ""},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backend, err := cmd.Flags().GetString("backend")
+		if err != nil {
+			return fmt.Errorf("failed to get backend flag: %w", err)
+		}
+		refresh, err := cmd.Flags().GetBool("refresh")
+		if err != nil {
+			return fmt.Errorf("failed to get refresh flag: %w", err)
+		}
+
+		config, err := imageconfig.LoadConfig(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		configs, err := config.Expand()
+		if err != nil {
+			return fmt.Errorf("failed to expand variants: %w", err)
+		}
+
+		workDir, err := os.MkdirTemp("", "prefetch-*")
+		if err != nil {
+			return fmt.Errorf("failed to create work directory: %w", err)
+		}
+		defer os.RemoveAll(workDir)
+
+		var prefetched []prefetchedImage
+		seen := make(map[string]bool)
+		for _, config := range configs {
+			parent := config.Options.Parent
+			if parent == "" || parent == "scratch" || seen[parent] {
+				continue
+			}
+			seen[parent] = true
+			if backend != "" {
+				config.Options.Backend = backend
+			}
+			if refresh {
+				config.Options.ParentPullPolicy = "always"
+			}
+			images, err := prefetchParent(config, workDir)
+			if err != nil {
+				return err
+			}
+			prefetched = append(prefetched, images...)
+		}
+		if len(prefetched) == 0 {
+			log.Info("The configuration has no parent image to prefetch")
+			return nil
+		}
+
+		stale := renderPrefetched(os.Stdout, prefetched)
+		if stale > 0 {
+			return fmt.Errorf("%d images in local storage differ from their registry, prefetch with --refresh to update them", stale)
+		}
+		return nil
+	},
+}
+
+// prefetchParent pulls the parent of config and its ancestors into local storage. Parents
+// in archives are imported, and have no chain to follow.
+func prefetchParent(config *imageconfig.Config, workDir string) ([]prefetchedImage, error) {
+	o, err := oci.NewOCI(config, workDir)
+	if err != nil {
+		return nil, err
+	}
+	defer o.Close()
+
+	parent := config.Options.Parent
+	verified := "digest"
+	if config.Options.ParentVerify.Policy != "" || config.Options.ParentVerify.Cosign() {
+		verified = "digest, signature"
+	}
+	if _, ok := config.Options.ParentArchive(); ok {
+		if err := o.PullParentImage(); err != nil {
+			return nil, err
+		}
+		return []prefetchedImage{{chainImage: chainImage{Image: parent}, Status: "imported", Verified: "-"}}, nil
+	}
+
+	chain, err := parentChain(parent)
+	if err != nil {
+		return nil, err
+	}
+	prefetched := make([]prefetchedImage, len(chain))
+	for n, img := range chain {
+		local := o.ImageExists(img.Image)
+		// The parent is verified as a build would, its ancestors only by digest
+		if n == 0 {
+			err = o.PullParentImage()
+		} else {
+			err = o.PullImage(img.Image)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		p := prefetchedImage{chainImage: img, Status: "pulled", Verified: "digest"}
+		if local {
+			p.Status = "reused"
+		}
+		if n == 0 {
+			p.Verified = verified
+		}
+		if id, err := o.ImageID(img.Image); err != nil || id != img.Digest {
+			p.Status, p.Verified = "stale", "no"
+		}
+		prefetched[n] = p
+	}
+	return prefetched, nil
+}
+
+// parentChain returns parent and its ancestors, following the parent labels recorded by
+// the builder, as their registries hold them. The chain ends at an image without a parent
+// label, or with one that cannot be read from its registry.
+func parentChain(parent string) ([]chainImage, error) {
+	var chain []chainImage
+	seen := make(map[string]bool)
+	for current := parent; current != "" && current != "scratch" && !seen[current]; {
+		seen[current] = true
+		ref, err := parseImageReference(utils.SanitizeRegistryURL(current))
+		if err != nil {
+			return nil, err
+		}
+		opts, err := remoteOptions(ref.Context())
+		if err != nil {
+			return nil, err
+		}
+		img, err := remote.Image(ref, opts...)
+		if err != nil {
+			if len(chain) == 0 {
+				return nil, fmt.Errorf("failed to fetch parent image %s: %w", current, err)
+			}
+			log.Warnf("Not following the parent chain past %s: %v", current, err)
+			break
+		}
+
+		config, err := img.ConfigFile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch config of %s: %w", current, err)
+		}
+		digest, err := img.ConfigName()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get config digest of %s: %w", current, err)
+		}
+		manifest, err := img.Manifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch manifest of %s: %w", current, err)
+		}
+		entry := chainImage{Image: current, Digest: digest.Hex, Layers: len(manifest.Layers)}
+		for _, layer := range manifest.Layers {
+			entry.Size += layer.Size
+		}
+		chain = append(chain, entry)
+		current = config.Config.Labels[image.ParentLabel]
+	}
+	return chain, nil
+}
+
+// renderPrefetched writes the prefetched images as a table followed by a summary, returning
+// the number of stale images
+func renderPrefetched(out io.Writer, prefetched []prefetchedImage) int {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "IMAGE\tDIGEST\tLAYERS\tSIZE\tSTATUS\tVERIFIED")
+	counts := make(map[string]int)
+	var reusedSize int64
+	for _, p := range prefetched {
+		digest := p.Digest
+		if len(digest) > 12 {
+			digest = digest[:12]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", p.Image, digest, p.Layers, formatSize(p.Size), p.Status, p.Verified)
+		counts[p.Status]++
+		if p.Status == "reused" {
+			reusedSize += p.Size
+		}
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\n%d of %d images reused from local storage (%s), %d pulled, %d imported, %d stale\n",
+		counts["reused"], len(prefetched), formatSize(reusedSize), counts["pulled"], counts["imported"], counts["stale"])
+	return counts["stale"]
+}
+
+func init() {
+	prefetchCmd.Flags().String("backend", "", "Container backend: buildah, podman or docker (overrides options.backend)")
+	prefetchCmd.Flags().Bool("refresh", false, "Pull images even if they are in local storage, as parent_pull_policy 'always' does")
+	addRegistryFlags(prefetchCmd)
+	rootCmd.AddCommand(prefetchCmd)
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-image-builder/pkg/image"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestParentChain(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	// compute is built on rocky-base, which was built on an image the registry does not hold
+	push := func(repo, parent string) {
+		t.Helper()
+		img, err := random.Image(64, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := img.ConfigFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Config.Labels = map[string]string{image.ParentLabel: parent}
+		if img, err = mutate.ConfigFile(img, config); err != nil {
+			t.Fatal(err)
+		}
+		if err := crane.Push(img, host+"/"+repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	push("rocky-base:9", host+"/upstream:9")
+	push("compute:9", host+"/rocky-base:9")
+
+	chain, err := parentChain(host + "/compute:9")
+	if err != nil {
+		t.Fatalf("parentChain() error = %v", err)
+	}
+	var got []string
+	for _, c := range chain {
+		got = append(got, strings.TrimPrefix(c.Image, host+"/"))
+		if len(c.Digest) != 64 || c.Layers != 1 || c.Size == 0 {
+			t.Errorf("chain image %s = %+v, want its digest, layer and size", c.Image, c)
+		}
+	}
+	if strings.Join(got, " ") != "compute:9 rocky-base:9" {
+		t.Errorf("parentChain() = %v, want [compute:9 rocky-base:9]", got)
+	}
+
+	if _, err := parentChain(host + "/missing:9"); err == nil {
+		t.Error("parentChain() of a missing parent succeeded, want error")
+	}
+}
+
+func TestRenderPrefetched(t *testing.T) {
+	prefetched := []prefetchedImage{
+		{chainImage: chainImage{Image: "compute:9", Digest: strings.Repeat("a", 64), Layers: 4, Size: 2 << 20}, Status: "pulled", Verified: "digest, signature"},
+		{chainImage: chainImage{Image: "rocky-base:9", Digest: strings.Repeat("b", 64), Layers: 1, Size: 1 << 20}, Status: "reused", Verified: "digest"},
+		{chainImage: chainImage{Image: "upstream:9", Digest: strings.Repeat("c", 64), Layers: 1, Size: 1 << 20}, Status: "stale", Verified: "no"},
+	}
+	var out bytes.Buffer
+	if stale := renderPrefetched(&out, prefetched); stale != 1 {
+		t.Errorf("renderPrefetched() = %d stale, want 1", stale)
+	}
+	if !strings.Contains(out.String(), "aaaaaaaaaaaa") || strings.Contains(out.String(), strings.Repeat("a", 13)) {
+		t.Errorf("digests are not shortened:\n%s", out.String())
+	}
+	if want := "1 of 3 images reused from local storage (1.0MiB), 1 pulled, 0 imported, 1 stale"; !strings.Contains(out.String(), want) {
+		t.Errorf("summary missing %q:\n%s", want, out.String())
+	}
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go-image-builder/pkg/image"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune REPOSITORY",
+	Short: "Delete expired images from a repository",
+	Long: `Delete the images of a repository whose com.openchami.image.expires label, set
+by builds with options.expires_after, lies in the past. Images are deleted by digest, which
+removes every tag pointing at them. Images without the label are kept.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return fmt.Errorf("failed to get dry-run flag: %w", err)
+		}
+		concurrency, err := cmd.Flags().GetInt("concurrency")
+		if err != nil {
+			return fmt.Errorf("failed to get concurrency flag: %w", err)
+		}
+		if concurrency < 1 {
+			concurrency = 1
+		}
+
+		ref, err := parseImageReference(args[0])
+		if err != nil {
+			return err
+		}
+		repo := ref.Context()
+
+		opts, err := remoteOptions(repo)
+		if err != nil {
+			return err
+		}
+
+		tags, err := remote.List(repo, opts...)
+		if err != nil {
+			return fmt.Errorf("failed to list tags of %s: %w", repo, err)
+		}
+
+		now := time.Now()
+		expired, errs := runPool(tags, concurrency, func(tag string) ([]expiredImage, error) {
+			expires, digest, err := fetchExpiry(repo.Tag(tag), opts)
+			if err != nil {
+				return nil, err
+			}
+			if expires.IsZero() || expires.After(now) {
+				return nil, nil
+			}
+			return []expiredImage{{tag: tag, digest: digest, expires: expires}}, nil
+		})
+		if len(errs) > 0 {
+			fmt.Fprintf(os.Stderr, "Errors while reading %s:\n", repo)
+			printErrors(errs, "  ")
+		}
+
+		// Tags of the same image are deleted together
+		byDigest := make(map[string][]string)
+		expiry := make(map[string]time.Time)
+		for _, img := range expired {
+			byDigest[img.digest] = append(byDigest[img.digest], img.tag)
+			expiry[img.digest] = img.expires
+		}
+		digests := make([]string, 0, len(byDigest))
+		for digest := range byDigest {
+			digests = append(digests, digest)
+		}
+		sort.Strings(digests)
+
+		var deleted int
+		for _, digest := range digests {
+			tags := byDigest[digest]
+			sort.Strings(tags)
+			ref := repo.Digest(digest)
+			if dryRun {
+				log.Infof("Would delete %s (tags %s, expired %s)", ref, strings.Join(tags, ", "), expiry[digest].Format(time.RFC3339))
+				deleted++
+				continue
+			}
+			log.Infof("Deleting %s (tags %s, expired %s)", ref, strings.Join(tags, ", "), expiry[digest].Format(time.RFC3339))
+			if err := remote.Delete(ref, opts...); err != nil {
+				log.Warnf("Failed to delete %s: %v", ref, err)
+				continue
+			}
+			deleted++
+		}
+
+		verb := "Deleted"
+		if dryRun {
+			verb = "Would delete"
+		}
+		log.Infof("%s %d expired images of %s", verb, deleted, repo)
+		if len(errs) > 0 {
+			return fmt.Errorf("failed to read %d tags of %s", len(errs), repo)
+		}
+		return nil
+	},
+}
+
+// expiredImage is a tag whose image expired
+type expiredImage struct {
+	tag     string
+	digest  string
+	expires time.Time
+}
+
+// fetchExpiry returns the expiry label and manifest digest of a tagged image. The time is zero
+// for images without the label, including indexes, which have no config of their own.
+func fetchExpiry(ref name.Tag, opts []remote.Option) (time.Time, string, error) {
+	desc, err := remote.Get(ref, opts...)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("failed to fetch %s: %w", ref, err)
+	}
+	if desc.MediaType.IsIndex() {
+		return time.Time{}, desc.Digest.String(), nil
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("failed to read image %s: %w", ref, err)
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("failed to fetch config of %s: %w", ref, err)
+	}
+	value, ok := config.Config.Labels[image.ExpiresLabel]
+	if !ok {
+		return time.Time{}, desc.Digest.String(), nil
+	}
+	expires, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("invalid %s label on %s: %w", image.ExpiresLabel, ref, err)
+	}
+	return expires, desc.Digest.String(), nil
+}
+
+func init() {
+	addRegistryFlags(pruneCmd)
+	pruneCmd.Flags().Bool("dry-run", false, "Report what would be deleted without deleting anything")
+	pruneCmd.Flags().Int("concurrency", 8, "Number of images fetched in parallel")
+	rootCmd.AddCommand(pruneCmd)
+}
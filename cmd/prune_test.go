@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-image-builder/pkg/image"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestFetchExpiry(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	repo := strings.TrimPrefix(server.URL, "http://") + "/test"
+
+	expires := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	labels := map[string]map[string]string{
+		"expiring":  {image.ExpiresLabel: expires.Format(time.RFC3339)},
+		"unlabeled": nil,
+		"invalid":   {image.ExpiresLabel: "tomorrow"},
+	}
+	for tag, l := range labels {
+		img, err := random.Image(64, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img, err = mutate.Config(img, v1.Config{Labels: l}); err != nil {
+			t.Fatal(err)
+		}
+		if err := crane.Push(img, repo+":"+tag, crane.Insecure); err != nil {
+			t.Fatalf("failed to push %s: %v", tag, err)
+		}
+	}
+
+	tests := []struct {
+		tag     string
+		want    time.Time
+		wantErr bool
+	}{
+		{tag: "expiring", want: expires},
+		{tag: "unlabeled"},
+		{tag: "invalid", wantErr: true},
+		{tag: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			ref, err := name.NewTag(repo+":"+tt.tag, name.Insecure)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, digest, err := fetchExpiry(ref, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetchExpiry(%s) error = %v, wantErr %v", tt.tag, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("fetchExpiry(%s) = %v, want %v", tt.tag, got, tt.want)
+			}
+			if !strings.HasPrefix(digest, "sha256:") {
+				t.Errorf("fetchExpiry(%s) digest = %q", tt.tag, digest)
+			}
+		})
+	}
+}
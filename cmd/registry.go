@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go-image-builder/internal/redact"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/spf13/cobra"
+)
+
+var (
+	registryUsername string
+	registryPassword string
+	registryToken    string
+	registryAuthfile string
+)
+
+// addRegistryFlags registers the flags shared by every command that talks to a registry
+func addRegistryFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&insecure, "insecure", false, "Skip TLS certificate verification")
+	cmd.Flags().StringVar(&registryUsername, "username", "", "Registry username")
+	cmd.Flags().StringVar(&registryPassword, "password", "", "Registry password (or set REGISTRY_PASSWORD)")
+	cmd.Flags().StringVar(&registryToken, "token", "", "Registry bearer token (or set REGISTRY_TOKEN)")
+	cmd.Flags().StringVar(&registryAuthfile, "authfile", "", "Path to a containers auth.json or docker config.json (or set REGISTRY_AUTH_FILE)")
+}
+
+// nameOptions returns the options used to parse references. Registries are always allowed to
+// fall back to plain HTTP when HTTPS is unavailable, as they always have been; --insecure only
+// controls TLS certificate verification.
+func nameOptions() []name.Option {
+	return []name.Option{name.Insecure}
+}
+
+// parseImageReference parses an image reference, stripping any protocol prefix. An explicit
+// http:// prefix implies --insecure.
+func parseImageReference(ref string) (name.Reference, error) {
+	if strings.HasPrefix(ref, "http://") {
+		insecure = true
+	}
+	ref = strings.TrimPrefix(ref, "http://")
+	ref = strings.TrimPrefix(ref, "https://")
+
+	parsed, err := name.ParseReference(ref, nameOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image reference: %w", err)
+	}
+	return parsed, nil
+}
+
+// remoteOptions builds the remote options used to talk to a registry. Explicit credentials
+// take precedence over an auth file, which takes precedence over the default keychain.
+func remoteOptions(reg authn.Resource) ([]remote.Option, error) {
+	auth, err := resolveAuth(reg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve authentication: %w", err)
+	}
+
+	opts := []remote.Option{remote.WithAuth(auth)}
+	if insecure {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		opts = append(opts, remote.WithTransport(transport))
+	}
+	return opts, nil
+}
+
+// resolveAuth picks the authenticator for a registry from the credential flags
+func resolveAuth(reg authn.Resource) (authn.Authenticator, error) {
+	password := registryPassword
+	if password == "" {
+		password = os.Getenv("REGISTRY_PASSWORD")
+	}
+	token := registryToken
+	if token == "" {
+		token = os.Getenv("REGISTRY_TOKEN")
+	}
+	authfile := registryAuthfile
+	if authfile == "" {
+		authfile = os.Getenv("REGISTRY_AUTH_FILE")
+	}
+	redact.Add(password, token)
+
+	switch {
+	case token != "":
+		return &authn.Bearer{Token: token}, nil
+	case registryUsername != "":
+		return &authn.Basic{Username: registryUsername, Password: password}, nil
+	case authfile != "":
+		return authFromFile(authfile, reg.RegistryStr())
+	default:
+		return authn.DefaultKeychain.Resolve(reg)
+	}
+}
+
+// authFile is the subset of the containers auth.json / docker config.json format we read
+type authFile struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+}
+
+// authFromFile reads the credentials for a registry from an auth file. Anonymous access is
+// used if the file has no entry for the registry.
+func authFromFile(path, registry string) (authn.Authenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth file: %w", err)
+	}
+
+	var f authFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse auth file %s: %w", path, err)
+	}
+
+	for key, entry := range f.Auths {
+		// Entries may be keyed by a bare host or a URL
+		host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+		host = strings.SplitN(host, "/", 2)[0]
+		if host != registry {
+			continue
+		}
+
+		cfg := authn.AuthConfig{
+			Username:      entry.Username,
+			Password:      entry.Password,
+			IdentityToken: entry.IdentityToken,
+		}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth entry for %s: %w", key, err)
+			}
+			user, pass, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid auth entry for %s: expected user:password", key)
+			}
+			cfg.Username, cfg.Password = user, pass
+			redact.Add(entry.Auth)
+		}
+		redact.Add(cfg.Password, cfg.IdentityToken)
+		return authn.FromConfig(cfg), nil
+	}
+
+	return authn.Anonymous, nil
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import "testing"
+
+func TestParseImageReferenceScheme(t *testing.T) {
+	defer func() { insecure = false }()
+
+	tests := []struct {
+		name     string
+		ref      string
+		insecure bool
+	}{
+		{name: "default", ref: "registry.example.com/image:tag"},
+		{name: "https prefix", ref: "https://registry.example.com/image:tag"},
+		{name: "http prefix", ref: "http://registry.example.com/image:tag", insecure: true},
+		{name: "insecure flag", ref: "registry.example.com/image:tag", insecure: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			insecure = tt.insecure
+			ref, err := parseImageReference(tt.ref)
+			if err != nil {
+				t.Fatalf("parseImageReference(%s) error = %v", tt.ref, err)
+			}
+			// Plain HTTP is allowed as a fallback whether or not --insecure is given
+			if got := ref.Context().Registry.Scheme(); got != "http" {
+				t.Errorf("parseImageReference(%s) scheme = %s, want http", tt.ref, got)
+			}
+		})
+	}
+}
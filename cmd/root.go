@@ -3,6 +3,14 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+
+	"go-image-builder/internal/limits"
+	"go-image-builder/internal/redact"
+	"go-image-builder/internal/version"
+	"go-image-builder/pkg/oci"
+	"go-image-builder/pkg/preflight"
+	"go-image-builder/pkg/utils"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -14,14 +22,20 @@ var (
 	logLevel       string
 	createSquashfs bool
 	createInitrd   bool
+	rateLimit      string
+	tmpDir         string
 )
 
+// storageAnnotation marks the commands that use container storage
+const storageAnnotation = "storage"
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "go-image-builder",
 	Short: "A tool for building container images",
 	Long: `A tool for building container images with support for various package managers
 and customization options.`,
+	Version: version.String(),
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 		// Set log level
 		level, err := log.ParseLevel(logLevel)
@@ -35,10 +49,56 @@ and customization options.`,
 			DisableTimestamp: false,
 			FullTimestamp:    true,
 		})
+		// Commands and their output are logged, and may hold credentials
+		log.AddHook(redact.Hook{})
+
+		// Temporary files of the builder and the tools it runs go to --tmpdir
+		if tmpDir != "" {
+			dir, err := filepath.Abs(tmpDir)
+			if err != nil {
+				return fmt.Errorf("invalid temporary directory: %w", err)
+			}
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("failed to create temporary directory: %w", err)
+			}
+			tmpDir = dir
+			os.Setenv("TMPDIR", dir)
+		}
+
+		if rateLimit != "" {
+			bytesPerSecond, err := utils.ParseSize(rateLimit)
+			if err != nil || bytesPerSecond == 0 {
+				return fmt.Errorf("invalid rate limit '%s': must be a size per second such as '10M'", rateLimit)
+			}
+			limits.LimitBandwidth(bytesPerSecond)
+			log.Debugf("Limiting registry transfers to %s per second", rateLimit)
+		}
+
+		if _, ok := cmd.Annotations[storageAnnotation]; ok {
+			return useStorage(cmd)
+		}
 		return nil
 	},
 }
 
+// useStorage prepares a command for container storage. Commands with --skip-preflight first
+// check that rootless builds can work, as the unprivileged user, before buildah fails mid-build.
+func useStorage(cmd *cobra.Command) error {
+	if cmd.Flags().Lookup("skip-preflight") != nil && os.Getuid() != 0 {
+		skipPreflight, err := cmd.Flags().GetBool("skip-preflight")
+		if err != nil {
+			return fmt.Errorf("failed to get skip-preflight flag: %w", err)
+		}
+		if !skipPreflight {
+			if err := preflight.Report(preflight.CheckRootless()); err != nil {
+				return err
+			}
+		}
+	}
+	oci.MaybeReexec()
+	return nil
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
@@ -53,4 +113,6 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Log level (debug, info, warn, error, fatal)")
 	rootCmd.PersistentFlags().BoolVar(&createSquashfs, "create-squashfs", true, "Create a squashfs image")
 	rootCmd.PersistentFlags().BoolVar(&createInitrd, "create-initrd", true, "Create an initrd image")
+	rootCmd.PersistentFlags().StringVar(&tmpDir, "tmpdir", "", "Directory for temporary files, instead of the system default (overrides options.temp_dir)")
+	rootCmd.PersistentFlags().StringVar(&rateLimit, "rate-limit", "", "Limit registry pushes and pulls to this many bytes per second in each direction, such as 10M (pulls by the container backend are not limited)")
 }
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"go-image-builder/pkg/image"
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/qemu"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run [IMAGE]",
+	Short: "Boot an image in QEMU as a smoke test",
+	Long: `Boot a built image in QEMU and report whether it came up. Either pass an
+image reference, in which case the kernel and initrd are extracted from its
+layers and its filesystem is attached as a squashfs unless --squashfs is given,
+or point --kernel, --initrd and --squashfs at build artifacts.
+
+Once the expected console output is seen, an optional check script is run on
+the host with QEMU_SSH_PORT and QEMU_SERIAL_LOG set in its environment. The
+command fails if the image does not boot or the check script fails.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var c qemu.Config
+		var err error
+
+		if c.Kernel, err = cmd.Flags().GetString("kernel"); err != nil {
+			return fmt.Errorf("failed to get kernel flag: %w", err)
+		}
+		if c.Initrd, err = cmd.Flags().GetString("initrd"); err != nil {
+			return fmt.Errorf("failed to get initrd flag: %w", err)
+		}
+		if c.Squashfs, err = cmd.Flags().GetString("squashfs"); err != nil {
+			return fmt.Errorf("failed to get squashfs flag: %w", err)
+		}
+		if c.Cmdline, err = cmd.Flags().GetString("cmdline"); err != nil {
+			return fmt.Errorf("failed to get cmdline flag: %w", err)
+		}
+		if c.Memory, err = cmd.Flags().GetString("memory"); err != nil {
+			return fmt.Errorf("failed to get memory flag: %w", err)
+		}
+		if c.CPUs, err = cmd.Flags().GetInt("cpus"); err != nil {
+			return fmt.Errorf("failed to get cpus flag: %w", err)
+		}
+		if c.Expect, err = cmd.Flags().GetString("expect"); err != nil {
+			return fmt.Errorf("failed to get expect flag: %w", err)
+		}
+		if c.Timeout, err = cmd.Flags().GetDuration("timeout"); err != nil {
+			return fmt.Errorf("failed to get timeout flag: %w", err)
+		}
+		if c.CheckScript, err = cmd.Flags().GetString("check"); err != nil {
+			return fmt.Errorf("failed to get check flag: %w", err)
+		}
+		if c.SSHPort, err = cmd.Flags().GetInt("ssh-port"); err != nil {
+			return fmt.Errorf("failed to get ssh-port flag: %w", err)
+		}
+		if c.SerialLog, err = cmd.Flags().GetString("serial-log"); err != nil {
+			return fmt.Errorf("failed to get serial-log flag: %w", err)
+		}
+
+		// Extract the boot artifacts from the image when a reference is given
+		if len(args) == 1 {
+			tempDir, err := os.MkdirTemp("", "run-*")
+			if err != nil {
+				return fmt.Errorf("failed to create temporary directory: %w", err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			if err := extractBootArtifacts(args[0], tempDir, c.Squashfs == ""); err != nil {
+				return err
+			}
+			c.Kernel = filepath.Join(tempDir, "vmlinuz")
+			c.Initrd = filepath.Join(tempDir, "initrd.img")
+			if c.Squashfs == "" {
+				c.Squashfs = filepath.Join(tempDir, "image.squashfs")
+			}
+		}
+
+		if c.Kernel == "" || c.Initrd == "" {
+			return fmt.Errorf("either an image reference or both --kernel and --initrd are required")
+		}
+
+		result, err := qemu.Run(context.Background(), c)
+		if err != nil {
+			return fmt.Errorf("failed to run image: %w", err)
+		}
+
+		if !result.Passed {
+			return fmt.Errorf("smoke test failed after %s (booted: %v)", result.Duration.Round(time.Second), result.Booted)
+		}
+		log.Infof("Smoke test passed in %s", result.Duration.Round(time.Second))
+		return nil
+	},
+}
+
+// extractBootArtifacts pulls an image from a registry and writes its kernel and initrd to dir,
+// and with rootfs its filesystem as image.squashfs
+func extractBootArtifacts(reference, dir string, rootfs bool) error {
+	ref, err := parseImageReference(reference)
+	if err != nil {
+		return err
+	}
+
+	opts, err := remoteOptions(ref.Context())
+	if err != nil {
+		return err
+	}
+
+	remoteImg, err := remote.Image(ref, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to fetch image: %w", err)
+	}
+
+	img, err := image.NewImage("", ref.Context().RepositoryStr(), &imageconfig.Config{}, remoteImg, "")
+	if err != nil {
+		return fmt.Errorf("failed to load image: %w", err)
+	}
+
+	log.Infof("Extracting kernel and initrd from %s", ref)
+	if err := img.ExtractKernel(filepath.Join(dir, "vmlinuz")); err != nil {
+		return fmt.Errorf("failed to extract kernel: %w", err)
+	}
+	if err := img.ExtractInitrd(filepath.Join(dir, "initrd.img")); err != nil {
+		return fmt.Errorf("failed to extract initrd: %w", err)
+	}
+	if rootfs {
+		log.Infof("Creating root filesystem squashfs from %s", ref)
+		if err := createRootSquashfs(remoteImg, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createRootSquashfs unpacks the filesystem of an image under dir and packs it into
+// dir/image.squashfs, as the build does with the rootfs
+func createRootSquashfs(img v1.Image, dir string) error {
+	rootfs := filepath.Join(dir, "rootfs")
+	if err := os.Mkdir(rootfs, 0755); err != nil {
+		return fmt.Errorf("failed to create rootfs directory: %w", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	fs := mutate.Extract(img)
+	defer fs.Close()
+	extract := exec.Command("tar", "-x", "--numeric-owner", "-C", rootfs)
+	extract.Stdin = fs
+	if output, err := extract.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to unpack image filesystem: %s: %w", string(output), err)
+	}
+
+	squashfs := exec.Command("mksquashfs", rootfs, filepath.Join(dir, "image.squashfs"), "-comp", "xz", "-no-progress", "-noappend")
+	if output, err := squashfs.CombinedOutput(); err != nil {
+		return fmt.Errorf("mksquashfs failed: %s: %w", string(output), err)
+	}
+	return nil
+}
+
+func init() {
+	addRegistryFlags(runCmd)
+	runCmd.Flags().String("kernel", "", "Path to the kernel to boot")
+	runCmd.Flags().String("initrd", "", "Path to the initrd to boot")
+	runCmd.Flags().String("squashfs", "", "Path to the squashfs root filesystem, attached as /dev/vda")
+	runCmd.Flags().String("cmdline", qemu.DefaultCmdline, "Kernel command line")
+	runCmd.Flags().String("memory", "2048", "Guest memory in MiB")
+	runCmd.Flags().Int("cpus", 2, "Number of guest CPUs")
+	runCmd.Flags().String("expect", "login:", "Console output that signals a successful boot")
+	runCmd.Flags().Duration("timeout", 5*time.Minute, "How long to wait for the image to boot")
+	runCmd.Flags().String("check", "", "Script run on the host after the image has booted")
+	runCmd.Flags().Int("ssh-port", 2222, "Host port forwarded to port 22 of the guest")
+	runCmd.Flags().String("serial-log", "", "File to write the guest console output to")
+	rootCmd.AddCommand(runCmd)
+}
@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"go-image-builder/internal/redact"
+	"go-image-builder/pkg/server"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run an HTTP service that builds images on request",
+	Long: `Run an HTTP service that builds images on request. Configurations are submitted as
+YAML and built one at a time in the order they were received.
+
+Endpoints:
+  POST /builds                        Submit a configuration (query: squashfs, initrd)
+  GET  /builds                        List builds and their status
+  GET  /builds/{id}                   Show the status of a build
+  GET  /builds/{id}/logs              Stream the build log as server-sent events
+  GET  /builds/{id}/artifacts/{name}  Download an artifact of a completed build
+  GET  /artifacts                     List the artifacts of completed builds
+
+The service listens on the loopback interface by default. Listening on any other
+address requires a bearer token (--token-file) or client certificates
+(--tls-client-ca). Local files referenced by submitted configurations, such as
+copyfiles sources, must be in the --files-dir directory. Options that would reach
+outside the build on the server, such as buildah volumes, are rejected, and so are
+notifications unless --allow-notifications is set.`,
+	Annotations: map[string]string{storageAnnotation: ""},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		listen, err := cmd.Flags().GetString("listen")
+		if err != nil {
+			return fmt.Errorf("failed to get listen flag: %w", err)
+		}
+
+		workDir, err := cmd.Flags().GetString("workdir")
+		if err != nil {
+			return fmt.Errorf("failed to get workdir flag: %w", err)
+		}
+
+		queueSize, err := cmd.Flags().GetInt("queue-size")
+		if err != nil {
+			return fmt.Errorf("failed to get queue-size flag: %w", err)
+		}
+		if queueSize < 1 {
+			return fmt.Errorf("queue-size must be at least 1")
+		}
+
+		tokenFile, err := cmd.Flags().GetString("token-file")
+		if err != nil {
+			return fmt.Errorf("failed to get token-file flag: %w", err)
+		}
+		filesDir, err := cmd.Flags().GetString("files-dir")
+		if err != nil {
+			return fmt.Errorf("failed to get files-dir flag: %w", err)
+		}
+		tlsCert, err := cmd.Flags().GetString("tls-cert")
+		if err != nil {
+			return fmt.Errorf("failed to get tls-cert flag: %w", err)
+		}
+		tlsKey, err := cmd.Flags().GetString("tls-key")
+		if err != nil {
+			return fmt.Errorf("failed to get tls-key flag: %w", err)
+		}
+		clientCA, err := cmd.Flags().GetString("tls-client-ca")
+		if err != nil {
+			return fmt.Errorf("failed to get tls-client-ca flag: %w", err)
+		}
+		allowNotifications, err := cmd.Flags().GetBool("allow-notifications")
+		if err != nil {
+			return fmt.Errorf("failed to get allow-notifications flag: %w", err)
+		}
+		if (tlsCert == "") != (tlsKey == "") {
+			return fmt.Errorf("--tls-cert and --tls-key must be set together")
+		}
+		if clientCA != "" && tlsCert == "" {
+			return fmt.Errorf("--tls-client-ca requires --tls-cert and --tls-key")
+		}
+		if tokenFile == "" && clientCA == "" && !isLoopback(listen) {
+			return fmt.Errorf("refusing to listen on %s without --token-file or --tls-client-ca", listen)
+		}
+
+		srv, err := server.NewServer(workDir, queueSize)
+		if err != nil {
+			return fmt.Errorf("failed to create server: %w", err)
+		}
+		if tokenFile != "" {
+			token, err := os.ReadFile(tokenFile)
+			if err != nil {
+				return fmt.Errorf("failed to read token file: %w", err)
+			}
+			if strings.TrimSpace(string(token)) == "" {
+				return fmt.Errorf("token file %s is empty", tokenFile)
+			}
+			srv.SetToken(strings.TrimSpace(string(token)))
+			redact.Add(strings.TrimSpace(string(token)))
+		}
+		srv.SetFilesDir(filesDir)
+		srv.SetAllowNotifications(allowNotifications)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		go srv.Run(ctx.Done())
+
+		httpServer := &http.Server{
+			Addr:              listen,
+			Handler:           srv.Handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		if clientCA != "" {
+			pem, err := os.ReadFile(clientCA)
+			if err != nil {
+				return fmt.Errorf("failed to read client CA: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no certificates found in %s", clientCA)
+			}
+			httpServer.TLSConfig = &tls.Config{
+				ClientCAs:  pool,
+				ClientAuth: tls.RequireAndVerifyClientCert,
+				MinVersion: tls.VersionTLS12,
+			}
+		}
+
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			httpServer.Shutdown(shutdownCtx)
+		}()
+
+		log.Infof("Listening on %s, building in %s", listen, workDir)
+		if tlsCert != "" {
+			err = httpServer.ListenAndServeTLS(tlsCert, tlsKey)
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("failed to serve: %w", err)
+		}
+		return nil
+	},
+}
+
+// isLoopback reports whether a listen address only accepts connections from the local host
+func isLoopback(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().String("listen", "127.0.0.1:8080", "Address to listen on")
+	serveCmd.Flags().String("token-file", "", "File holding the bearer token requests must carry")
+	serveCmd.Flags().String("files-dir", "", "Directory local files referenced by submitted configurations must be in")
+	serveCmd.Flags().String("tls-cert", "", "TLS certificate to serve HTTPS with")
+	serveCmd.Flags().String("tls-key", "", "Private key of the TLS certificate")
+	serveCmd.Flags().String("tls-client-ca", "", "CA certificates client certificates must be signed by")
+	serveCmd.Flags().Bool("allow-notifications", false, "Allow submitted configurations to send notifications to the URLs they name")
+	serveCmd.Flags().String("workdir", filepath.Join(os.TempDir(), "image-build-serve"), "Directory holding build working directories and artifacts")
+	serveCmd.Flags().Int("queue-size", 16, "Maximum number of builds waiting to run")
+}
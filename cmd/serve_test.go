@@ -0,0 +1,23 @@
+package cmd
+
+import "testing"
+
+func TestIsLoopback(t *testing.T) {
+	tests := []struct {
+		listen string
+		want   bool
+	}{
+		{listen: "127.0.0.1:8080", want: true},
+		{listen: "localhost:8080", want: true},
+		{listen: "[::1]:8080", want: true},
+		{listen: ":8080", want: false},
+		{listen: "0.0.0.0:8080", want: false},
+		{listen: "192.0.2.1:8080", want: false},
+		{listen: "invalid", want: false},
+	}
+	for _, tt := range tests {
+		if got := isLoopback(tt.listen); got != tt.want {
+			t.Errorf("isLoopback(%q) = %v, want %v", tt.listen, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+
+	"go-image-builder/internal/chroot"
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/oci"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var shellCmd = &cobra.Command{
+	Use:   "shell IMAGE",
+	Short: "Open a shell inside an image",
+	Long: `Create a temporary container from an image, mount it and start a shell
+chrooted into its filesystem, for quick inspection of images that have already
+been built or pushed. The image is pulled if it is not in local storage. The
+container is removed when the shell exits, so changes made in it are lost.`,
+	Args:        cobra.ExactArgs(1),
+	Annotations: map[string]string{storageAnnotation: ""},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backend, err := cmd.Flags().GetString("backend")
+		if err != nil {
+			return fmt.Errorf("failed to get backend flag: %w", err)
+		}
+		shell, err := cmd.Flags().GetString("shell")
+		if err != nil {
+			return fmt.Errorf("failed to get shell flag: %w", err)
+		}
+
+		workDir, err := os.MkdirTemp("", "shell-*")
+		if err != nil {
+			return fmt.Errorf("failed to create work directory: %w", err)
+		}
+		defer os.RemoveAll(workDir)
+
+		config := &imageconfig.Config{}
+		config.Options.Backend = backend
+		o, err := oci.NewOCI(config, workDir)
+		if err != nil {
+			return err
+		}
+		defer o.Close()
+
+		containerName, mountPoint, err := o.MountImage(args[0])
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := o.Cleanup(containerName); err != nil {
+				log.Warnf("Failed to remove container %s: %v", containerName, err)
+			}
+		}()
+
+		return runShell(mountPoint, shell)
+	},
+}
+
+// runShell runs shell chrooted into root, attached to the terminal. The exit status of the
+// shell is not an error, since it is that of the last command typed into it.
+func runShell(root, shell string) error {
+	command, err := chroot.Command(root, shell)
+	if err != nil {
+		return err
+	}
+
+	// Interrupts typed into the shell are meant for it, and must not stop the builder
+	// before the container is cleaned up
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	return chroot.Run(root, func() error {
+		c := exec.Command(command[0], command[1:]...)
+		c.Stdin = os.Stdin
+		c.Stdout = os.Stdout
+		c.Stderr = os.Stderr
+		var exitErr *exec.ExitError
+		if err := c.Run(); err != nil && !errors.As(err, &exitErr) {
+			return fmt.Errorf("failed to run %s: %w", shell, err)
+		}
+		return nil
+	})
+}
+
+func init() {
+	shellCmd.Flags().String("backend", "buildah", "Container backend used to mount the image: buildah, podman or docker")
+	shellCmd.Flags().String("shell", "/bin/sh", "Shell to run inside the image")
+	rootCmd.AddCommand(shellCmd)
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// RepositoryStats summarizes the images of a repository. Size is the size of the distinct
+// images summed, and UniqueSize that of the distinct blobs they consist of.
+type RepositoryStats struct {
+	Repository string `json:"repository" yaml:"repository"`
+	Tags       int    `json:"tags" yaml:"tags"`
+	Images     int    `json:"images" yaml:"images"`
+	Size       int64  `json:"size" yaml:"size"`
+	UniqueSize int64  `json:"unique_size" yaml:"unique_size"`
+}
+
+// RegistryStats summarizes the images of a registry. Savings is the storage saved by images
+// sharing layers, the difference between their summed size and that of the distinct blobs.
+type RegistryStats struct {
+	Repositories []RepositoryStats `json:"repositories" yaml:"repositories"`
+	Tags         int               `json:"tags" yaml:"tags"`
+	Images       int               `json:"images" yaml:"images"`
+	Size         int64             `json:"size" yaml:"size"`
+	UniqueSize   int64             `json:"unique_size" yaml:"unique_size"`
+	Savings      int64             `json:"savings" yaml:"savings"`
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats REGISTRY",
+	Short: "Summarize the storage used by the images of a registry",
+	Long: `Report the number of tags and distinct images of every repository of a registry,
+the size of their blobs, and how much storage images save by sharing layers, for
+capacity planning of site registries.
+
+Tags of the same image are counted once. The size of a repository sums its
+distinct images, and its unique size counts every blob they are made of once.
+The totals dedupe blobs across repositories as well, as registries store them.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return fmt.Errorf("failed to get format flag: %w", err)
+		}
+		if format != "table" && format != "json" && format != "yaml" {
+			return fmt.Errorf("unsupported output format '%s': must be 'table', 'json' or 'yaml'", format)
+		}
+
+		_, infos, err := collectRegistryImages(cmd, args[0])
+		if err != nil {
+			return err
+		}
+		return renderRegistryStats(os.Stdout, computeRegistryStats(infos), format)
+	},
+}
+
+// computeRegistryStats summarizes the images listed from a registry, sorted by repository
+func computeRegistryStats(infos []ImageInfo) RegistryStats {
+	var stats RegistryStats
+	repos := make(map[string]*RepositoryStats)
+	repoBlobs := make(map[string]map[string]bool)
+	images := make(map[string]bool)
+	blobs := make(map[string]bool)
+	for _, info := range infos {
+		repo, ok := repos[info.Repository]
+		if !ok {
+			repo = &RepositoryStats{Repository: info.Repository}
+			repos[info.Repository] = repo
+			repoBlobs[info.Repository] = make(map[string]bool)
+		}
+		repo.Tags++
+		stats.Tags++
+
+		// Images without a digest cannot be told apart, and are counted by tag
+		id := info.Repository + ":" + info.Tag
+		if info.Digest != "" {
+			id = info.Repository + "@" + info.Digest
+		}
+		if images[id] {
+			continue
+		}
+		images[id] = true
+		repo.Images++
+		repo.Size += info.Size
+		stats.Images++
+		stats.Size += info.Size
+
+		for digest, size := range info.blobs {
+			if !repoBlobs[info.Repository][digest] {
+				repoBlobs[info.Repository][digest] = true
+				repo.UniqueSize += size
+			}
+			if !blobs[digest] {
+				blobs[digest] = true
+				stats.UniqueSize += size
+			}
+		}
+	}
+
+	stats.Savings = stats.Size - stats.UniqueSize
+	stats.Repositories = make([]RepositoryStats, 0, len(repos))
+	for _, repo := range repos {
+		stats.Repositories = append(stats.Repositories, *repo)
+	}
+	sort.Slice(stats.Repositories, func(i, j int) bool {
+		return stats.Repositories[i].Repository < stats.Repositories[j].Repository
+	})
+	return stats
+}
+
+// renderRegistryStats writes the statistics to out in the requested format
+func renderRegistryStats(out io.Writer, stats RegistryStats, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	case "yaml":
+		enc := yaml.NewEncoder(out)
+		enc.SetIndent(2)
+		if err := enc.Encode(stats); err != nil {
+			return fmt.Errorf("failed to encode yaml: %w", err)
+		}
+		return enc.Close()
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REPOSITORY\tTAGS\tIMAGES\tSIZE\tUNIQUE SIZE")
+	for _, repo := range stats.Repositories {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", repo.Repository, repo.Tags, repo.Images, formatSize(repo.Size), formatSize(repo.UniqueSize))
+	}
+	fmt.Fprintf(w, "TOTAL\t%d\t%d\t%s\t%s\n", stats.Tags, stats.Images, formatSize(stats.Size), formatSize(stats.UniqueSize))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	savings := "0%"
+	if stats.Size > 0 {
+		savings = fmt.Sprintf("%.1f%%", 100*float64(stats.Savings)/float64(stats.Size))
+	}
+	fmt.Fprintf(out, "\nShared-layer savings: %s (%s of the total size)\n", formatSize(stats.Savings), savings)
+	return nil
+}
+
+func init() {
+	addImageSelectionFlags(statsCmd, "include")
+	statsCmd.Flags().StringP("format", "f", "table", "Output format (table, json, yaml)")
+	rootCmd.AddCommand(statsCmd)
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComputeRegistryStats(t *testing.T) {
+	infos := []ImageInfo{
+		// Two images of compute sharing the base layer, one tagged twice
+		{Repository: "compute", Tag: "9", Digest: "sha256:c1", Size: 130, blobs: map[string]int64{"cfg1": 10, "base": 100, "compute1": 20}},
+		{Repository: "compute", Tag: "latest", Digest: "sha256:c1", Size: 130, blobs: map[string]int64{"cfg1": 10, "base": 100, "compute1": 20}},
+		{Repository: "compute", Tag: "9.1", Digest: "sha256:c2", Size: 140, blobs: map[string]int64{"cfg2": 10, "base": 100, "compute2": 30}},
+		// login shares the base layer across repositories
+		{Repository: "login", Tag: "9", Digest: "sha256:l1", Size: 150, blobs: map[string]int64{"cfg3": 10, "base": 100, "login": 40}},
+	}
+
+	want := RegistryStats{
+		Repositories: []RepositoryStats{
+			{Repository: "compute", Tags: 3, Images: 2, Size: 270, UniqueSize: 170},
+			{Repository: "login", Tags: 1, Images: 1, Size: 150, UniqueSize: 150},
+		},
+		Tags:       4,
+		Images:     3,
+		Size:       420,
+		UniqueSize: 220,
+		Savings:    200,
+	}
+	if got := computeRegistryStats(infos); !reflect.DeepEqual(got, want) {
+		t.Errorf("computeRegistryStats() = %+v, want %+v", got, want)
+	}
+}
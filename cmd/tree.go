@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"go-image-builder/pkg/image"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/spf13/cobra"
+)
+
+var treeCmd = &cobra.Command{
+	Use:   "tree REGISTRY",
+	Short: "Show which images of a registry were built from which",
+	Long: `Walk a registry and render the parent and child relationships of its images,
+as recorded in their com.openchami.image.parent labels, so operators can see
+which derived images are affected when a base image is rebuilt.
+
+Images are named repository:tag. Parents that are not among the images walked,
+such as upstream base images, are shown by their full reference and marked as
+external. The text format prints an indented tree, and the dot format a Graphviz
+graph.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return fmt.Errorf("failed to get format flag: %w", err)
+		}
+		if format != "text" && format != "dot" {
+			return fmt.Errorf("unsupported output format '%s': must be 'text' or 'dot'", format)
+		}
+
+		registry, infos, err := collectRegistryImages(cmd, args[0])
+		if err != nil {
+			return err
+		}
+
+		tree := buildImageTree(registry, infos)
+		if format == "dot" {
+			return tree.writeDOT(os.Stdout)
+		}
+		return tree.writeText(os.Stdout)
+	},
+}
+
+// imageTree is the ancestry of the images of a registry. Nodes are named repository:tag,
+// or by their full reference for parents outside the registry.
+type imageTree struct {
+	children map[string][]string
+	parents  map[string]string
+	external map[string]bool
+}
+
+// buildImageTree links the images of registry to the parents named by their labels
+func buildImageTree(registry string, infos []ImageInfo) *imageTree {
+	t := &imageTree{
+		children: make(map[string][]string),
+		parents:  make(map[string]string),
+		external: make(map[string]bool),
+	}
+
+	// Parents may be referred to by tag or by digest
+	nodes := make(map[string]bool)
+	byDigest := make(map[string]string)
+	for _, info := range infos {
+		node := info.Repository + ":" + info.Tag
+		nodes[node] = true
+		key := info.Repository + "@" + info.Digest
+		if _, ok := byDigest[key]; !ok && info.Digest != "" {
+			byDigest[key] = node
+		}
+	}
+
+	for _, info := range infos {
+		parentRef := info.Labels[image.ParentLabel]
+		if parentRef == "" {
+			continue
+		}
+		node := info.Repository + ":" + info.Tag
+		parent := parentNode(registry, parentRef, byDigest)
+		if !nodes[parent] {
+			t.external[parent] = true
+		}
+		t.parents[node] = parent
+		t.children[parent] = append(t.children[parent], node)
+	}
+	for _, children := range t.children {
+		sort.Strings(children)
+	}
+	for node := range nodes {
+		if _, ok := t.children[node]; !ok {
+			if _, ok := t.parents[node]; !ok {
+				// Images without parent or children are roots of trees of one
+				t.children[node] = nil
+			}
+		}
+	}
+	return t
+}
+
+// parentNode returns the node a parent reference of an image of registry names
+func parentNode(registry, parentRef string, byDigest map[string]string) string {
+	ref, err := name.ParseReference(strings.TrimPrefix(strings.TrimPrefix(parentRef, "http://"), "https://"), nameOptions()...)
+	if err != nil {
+		// Archives and local storage images are never in the registry
+		return parentRef
+	}
+	reg, err := name.NewRegistry(registry, nameOptions()...)
+	if err != nil || ref.Context().RegistryStr() != reg.RegistryStr() {
+		return ref.Name()
+	}
+	repo := ref.Context().RepositoryStr()
+	if digest, ok := ref.(name.Digest); ok {
+		if node, ok := byDigest[repo+"@"+digest.DigestStr()]; ok {
+			return node
+		}
+		return repo + "@" + digest.DigestStr()
+	}
+	return repo + ":" + ref.Identifier()
+}
+
+// roots returns the nodes without a parent in the tree, in order
+func (t *imageTree) roots() []string {
+	var roots []string
+	for node := range t.children {
+		if _, ok := t.parents[node]; !ok {
+			roots = append(roots, node)
+		}
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// label returns how a node is shown
+func (t *imageTree) label(node string) string {
+	if t.external[node] {
+		return node + " (external)"
+	}
+	return node
+}
+
+// writeText writes the tree below every root, with box drawing lines
+func (t *imageTree) writeText(w io.Writer) error {
+	var walk func(node, prefix string)
+	walk = func(node, prefix string) {
+		children := t.children[node]
+		for n, child := range children {
+			branch, indent := "├── ", "│   "
+			if n == len(children)-1 {
+				branch, indent = "└── ", "    "
+			}
+			fmt.Fprintf(w, "%s%s%s\n", prefix, branch, t.label(child))
+			walk(child, prefix+indent)
+		}
+	}
+	for _, root := range t.roots() {
+		fmt.Fprintln(w, t.label(root))
+		walk(root, "")
+	}
+	return nil
+}
+
+// writeDOT writes the tree as a Graphviz digraph with edges from parents to children.
+// External parents are drawn dashed.
+func (t *imageTree) writeDOT(w io.Writer) error {
+	nodes := make([]string, 0, len(t.children)+len(t.parents))
+	for node := range t.children {
+		nodes = append(nodes, node)
+	}
+	for node := range t.parents {
+		if _, ok := t.children[node]; !ok {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+
+	fmt.Fprintln(w, "digraph images {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	for _, node := range nodes {
+		if t.external[node] {
+			fmt.Fprintf(w, "  %q [style=dashed];\n", node)
+		} else {
+			fmt.Fprintf(w, "  %q;\n", node)
+		}
+	}
+	for _, node := range nodes {
+		for _, child := range t.children[node] {
+			fmt.Fprintf(w, "  %q -> %q;\n", node, child)
+		}
+	}
+	fmt.Fprintln(w, "}")
+	return nil
+}
+
+func init() {
+	addImageSelectionFlags(treeCmd, "include")
+	treeCmd.Flags().StringP("format", "f", "text", "Output format (text, dot)")
+	rootCmd.AddCommand(treeCmd)
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go-image-builder/pkg/image"
+)
+
+func TestImageTree(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	infos := []ImageInfo{
+		{Repository: "compute", Tag: "9", Digest: digest, Labels: map[string]string{image.ParentLabel: "registry.local:5000/rocky-base:9"}},
+		{Repository: "compute-gpu", Tag: "9", Labels: map[string]string{image.ParentLabel: "http://registry.local:5000/compute@" + digest}},
+		{Repository: "login", Tag: "9", Labels: map[string]string{image.ParentLabel: "registry.local:5000/rocky-base:9"}},
+		{Repository: "rocky-base", Tag: "9", Labels: map[string]string{image.ParentLabel: "quay.io/rockylinux/rockylinux:9"}},
+		{Repository: "compute", Tag: "latest", Digest: digest},
+		{Repository: "tools", Tag: "1.0"},
+	}
+	// compute-gpu refers to its parent by the digest of compute:9, which is also tagged latest
+
+	tree := buildImageTree("registry.local:5000", infos)
+
+	var text bytes.Buffer
+	if err := tree.writeText(&text); err != nil {
+		t.Fatal(err)
+	}
+	want := `compute:latest
+quay.io/rockylinux/rockylinux:9 (external)
+└── rocky-base:9
+    ├── compute:9
+    │   └── compute-gpu:9
+    └── login:9
+tools:1.0
+`
+	if text.String() != want {
+		t.Errorf("writeText() =\n%s\nwant\n%s", text.String(), want)
+	}
+
+	var dot bytes.Buffer
+	if err := tree.writeDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	want = `digraph images {
+  rankdir=LR;
+  "compute-gpu:9";
+  "compute:9";
+  "compute:latest";
+  "login:9";
+  "quay.io/rockylinux/rockylinux:9" [style=dashed];
+  "rocky-base:9";
+  "tools:1.0";
+  "compute:9" -> "compute-gpu:9";
+  "quay.io/rockylinux/rockylinux:9" -> "rocky-base:9";
+  "rocky-base:9" -> "compute:9";
+  "rocky-base:9" -> "login:9";
+}
+`
+	if dot.String() != want {
+		t.Errorf("writeDOT() =\n%s\nwant\n%s", dot.String(), want)
+	}
+}
@@ -0,0 +1,359 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go-image-builder/pkg/builder"
+	"go-image-builder/pkg/imageconfig"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch DIR",
+	Short: "Rebuild images when their configuration files change",
+	Long: `Watch a directory of configuration files and rebuild (and push) every image whose
+configuration content changed. Changes are debounced so a file is only built once it has
+stopped changing, and an image is never built more than once at a time. Failed builds are
+retried once their configuration changes again. The hashes of successfully built
+configurations are kept in a state file so restarts do not rebuild everything. With
+--git-pull the directory is treated as a git checkout and updated before every scan.
+Every variant of a configuration is built, each into a directory of its own.
+
+Images are built one at a time unless --concurrency is raised. Concurrent builds share
+container storage, where each build only removes the containers of builds that have exited.`,
+	Args:        cobra.ExactArgs(1),
+	Annotations: map[string]string{storageAnnotation: ""},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		w := &watcher{
+			dir:      args[0],
+			failed:   make(map[string]string),
+			building: make(map[string]bool),
+			pending:  make(map[string]*pendingChange),
+		}
+		var err error
+
+		if w.workDir, err = cmd.Flags().GetString("workdir"); err != nil {
+			return fmt.Errorf("failed to get workdir flag: %w", err)
+		}
+		if w.interval, err = cmd.Flags().GetDuration("interval"); err != nil {
+			return fmt.Errorf("failed to get interval flag: %w", err)
+		}
+		if w.debounce, err = cmd.Flags().GetDuration("debounce"); err != nil {
+			return fmt.Errorf("failed to get debounce flag: %w", err)
+		}
+		concurrency, err := cmd.Flags().GetInt("concurrency")
+		if err != nil {
+			return fmt.Errorf("failed to get concurrency flag: %w", err)
+		}
+		if concurrency < 1 {
+			return fmt.Errorf("concurrency must be at least 1")
+		}
+		w.slots = make(chan struct{}, concurrency)
+		if w.gitPull, err = cmd.Flags().GetBool("git-pull"); err != nil {
+			return fmt.Errorf("failed to get git-pull flag: %w", err)
+		}
+		if w.squashfs, err = cmd.Flags().GetBool("squashfs"); err != nil {
+			return fmt.Errorf("failed to get squashfs flag: %w", err)
+		}
+		if w.initrd, err = cmd.Flags().GetBool("initrd"); err != nil {
+			return fmt.Errorf("failed to get initrd flag: %w", err)
+		}
+		if w.stateFile, err = cmd.Flags().GetString("state-file"); err != nil {
+			return fmt.Errorf("failed to get state-file flag: %w", err)
+		}
+		if w.stateFile == "" {
+			w.stateFile = filepath.Join(w.workDir, "watch-state.json")
+		}
+
+		if err := os.MkdirAll(w.workDir, 0755); err != nil {
+			return fmt.Errorf("failed to create work directory: %w", err)
+		}
+		if err := w.loadState(); err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		return w.run(ctx)
+	},
+}
+
+// pendingChange is a configuration change waiting for the debounce period to pass
+type pendingChange struct {
+	hash    string
+	changed time.Time
+}
+
+// watcher polls a directory of configurations and rebuilds the ones that changed
+type watcher struct {
+	dir       string
+	workDir   string
+	stateFile string
+	interval  time.Duration
+	debounce  time.Duration
+	gitPull   bool
+	squashfs  bool
+	initrd    bool
+	slots     chan struct{}
+
+	mu       sync.Mutex
+	built    map[string]string // config path -> hash of the last successful build
+	failed   map[string]string // config path -> hash of the last failed build
+	building map[string]bool   // image name -> build in progress
+	pending  map[string]*pendingChange
+	wg       sync.WaitGroup
+
+	saveMu sync.Mutex // serializes writes of the state file by concurrent builds
+}
+
+// run scans the directory every interval until the context is cancelled, then waits for
+// running builds to finish
+func (w *watcher) run(ctx context.Context) error {
+	log.Infof("Watching %s for configuration changes every %s", w.dir, w.interval)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.scan(); err != nil {
+			log.Errorf("Scan of %s failed: %v", w.dir, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Info("Waiting for running builds to finish")
+			w.wg.Wait()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan looks for changed configurations and starts builds for those that have settled
+func (w *watcher) scan() error {
+	if w.gitPull {
+		if output, err := exec.Command("git", "-C", w.dir, "pull", "--ff-only").CombinedOutput(); err != nil {
+			return fmt.Errorf("git pull failed: %w\nOutput: %s", err, string(output))
+		}
+	}
+
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read config directory: %w", err)
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		path := filepath.Join(w.dir, entry.Name())
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Warnf("Failed to read %s: %v", path, err)
+			continue
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+
+		w.mu.Lock()
+		if w.built[path] == hash || w.failed[path] == hash {
+			delete(w.pending, path)
+			w.mu.Unlock()
+			continue
+		}
+		p, ok := w.pending[path]
+		if !ok || p.hash != hash {
+			log.Infof("Detected change in %s", path)
+			w.pending[path] = &pendingChange{hash: hash, changed: now}
+			w.mu.Unlock()
+			continue
+		}
+		settled := now.Sub(p.changed) >= w.debounce
+		w.mu.Unlock()
+
+		if settled {
+			w.startBuild(path, hash, data)
+		}
+	}
+	return nil
+}
+
+// startBuild builds a configuration in the background unless one of its images is already
+// being built or all build slots are in use, in which case it is retried on the next scan.
+// The variants of a configuration are built one after the other in the same slot.
+func (w *watcher) startBuild(path, hash string, data []byte) {
+	config, err := imageconfig.ParseConfig(data)
+	var configs []*imageconfig.Config
+	if err == nil {
+		// The data was read from path, which publish_tags templates such as GitSHA refer to
+		config.Source = path
+		configs, err = config.Expand()
+	}
+	if err != nil {
+		log.Errorf("Skipping %s: %v", path, err)
+		// Do not retry until the file changes again
+		w.mu.Lock()
+		w.failed[path] = hash
+		delete(w.pending, path)
+		w.mu.Unlock()
+		return
+	}
+
+	imageNames := make([]string, 0, len(configs))
+	for _, config := range configs {
+		imageNames = append(imageNames, config.Options.Name)
+	}
+	imageName := strings.Join(imageNames, ", ")
+	w.mu.Lock()
+	for _, name := range imageNames {
+		if w.building[name] {
+			w.mu.Unlock()
+			return
+		}
+	}
+	select {
+	case w.slots <- struct{}{}:
+	default:
+		w.mu.Unlock()
+		return
+	}
+	for _, name := range imageNames {
+		w.building[name] = true
+	}
+	delete(w.pending, path)
+	w.mu.Unlock()
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer func() { <-w.slots }()
+
+		log.Infof("Building %s from %s", imageName, path)
+		err := w.build(path, configs)
+
+		w.mu.Lock()
+		for _, name := range imageNames {
+			delete(w.building, name)
+		}
+		if err == nil {
+			w.built[path] = hash
+		} else {
+			// Do not retry until the file changes again
+			w.failed[path] = hash
+		}
+		w.mu.Unlock()
+
+		if err != nil {
+			log.Errorf("Build of %s from %s failed: %v", imageName, path, err)
+			return
+		}
+		log.Infof("Build of %s from %s completed", imageName, path)
+		if err := w.saveState(); err != nil {
+			log.Warnf("Failed to save watch state: %v", err)
+		}
+	}()
+}
+
+// build runs the builder for the variants of a configuration in its own working directory,
+// with a directory of their own for each variant. Every variant is built even if another
+// fails.
+func (w *watcher) build(path string, configs []*imageconfig.Config) error {
+	var errs []error
+	for _, config := range configs {
+		outputDir := filepath.Join(w.workDir, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+		if config.Variant != nil {
+			outputDir = filepath.Join(outputDir, config.Variant.Name)
+		}
+		if err := buildVariant(config, outputDir, w.squashfs, w.initrd); err != nil {
+			if config.Variant != nil {
+				err = fmt.Errorf("variant %s: %w", config.Variant.Name, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// buildVariant runs the builder for a single configuration
+func buildVariant(config *imageconfig.Config, outputDir string, squashfs, initrd bool) error {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	b, err := builder.NewBuilder(config, outputDir, squashfs, initrd)
+	if err != nil {
+		return fmt.Errorf("failed to create builder: %w", err)
+	}
+	if _, err := b.Build(context.Background()); err != nil {
+		return fmt.Errorf("failed to build image: %w", err)
+	}
+	return nil
+}
+
+// loadState reads the hashes of previously built configurations
+func (w *watcher) loadState() error {
+	w.built = make(map[string]string)
+	data, err := os.ReadFile(w.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err := json.Unmarshal(data, &w.built); err != nil {
+		return fmt.Errorf("failed to parse state file %s: %w", w.stateFile, err)
+	}
+	return nil
+}
+
+// saveState records the hashes of successfully built configurations. Saves are serialized,
+// so concurrent builds neither write the temporary file at once nor replace a newer state
+// with an older one.
+func (w *watcher) saveState() error {
+	w.saveMu.Lock()
+	defer w.saveMu.Unlock()
+
+	w.mu.Lock()
+	data, err := json.MarshalIndent(w.built, "", "  ")
+	w.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	tmp := w.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return os.Rename(tmp, w.stateFile)
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+
+	watchCmd.Flags().String("workdir", filepath.Join(os.TempDir(), "image-build-watch"), "Directory holding build working directories")
+	watchCmd.Flags().String("state-file", "", "File recording built configuration hashes (default: <workdir>/watch-state.json)")
+	watchCmd.Flags().Duration("interval", 30*time.Second, "How often to scan for changes")
+	watchCmd.Flags().Duration("debounce", 10*time.Second, "How long a file must be unchanged before it is built")
+	watchCmd.Flags().Int("concurrency", 1, "Maximum number of images built at the same time")
+	watchCmd.Flags().Bool("git-pull", false, "Run 'git pull --ff-only' in the directory before every scan")
+	watchCmd.Flags().BoolP("squashfs", "s", false, "Create a squashfs image")
+	watchCmd.Flags().BoolP("initrd", "i", true, "Create an initrd image")
+}
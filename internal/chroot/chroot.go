@@ -0,0 +1,89 @@
+package chroot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// mount describes a filesystem mounted inside the chroot
+type mount struct {
+	target string
+	args   []string
+}
+
+// mounts are the filesystems RPM scriptlets expect, in mount order
+var mounts = []mount{
+	{target: "proc", args: []string{"-t", "proc", "proc"}},
+	{target: "sys", args: []string{"--bind", "/sys"}},
+	{target: "dev", args: []string{"--bind", "/dev"}},
+	{target: "dev/pts", args: []string{"--bind", "/dev/pts"}},
+}
+
+// Mounts tracks the filesystems mounted inside a root
+type Mounts struct {
+	root    string
+	mounted []string
+}
+
+// Mount mounts /proc, /sys, /dev and /dev/pts inside root. Anything already mounted is
+// unmounted again if a later mount fails.
+func Mount(root string) (*Mounts, error) {
+	m := &Mounts{root: root}
+	for _, mnt := range mounts {
+		target := filepath.Join(root, mnt.target)
+		if err := os.MkdirAll(target, 0755); err != nil {
+			m.Unmount()
+			return nil, fmt.Errorf("failed to create mount point %s: %w", target, err)
+		}
+
+		args := append(append([]string{}, mnt.args...), target)
+		log.Debugf("Mounting %s", target)
+		if output, err := exec.Command("mount", args...).CombinedOutput(); err != nil {
+			m.Unmount()
+			return nil, fmt.Errorf("failed to mount %s: %w\nOutput: %s", target, err, string(output))
+		}
+		m.mounted = append(m.mounted, target)
+	}
+	return m, nil
+}
+
+// Unmount unmounts everything mounted by Mount in reverse order. Busy mounts are detached
+// lazily so a failed build never leaves the host's /dev or /proc bound into the rootfs.
+func (m *Mounts) Unmount() error {
+	var errs []error
+	for i := len(m.mounted) - 1; i >= 0; i-- {
+		target := m.mounted[i]
+		log.Debugf("Unmounting %s", target)
+		if err := exec.Command("umount", target).Run(); err == nil {
+			continue
+		}
+		if output, err := exec.Command("umount", "--lazy", target).CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to unmount %s: %w\nOutput: %s", target, err, string(output)))
+		}
+	}
+	m.mounted = nil
+	return errors.Join(errs...)
+}
+
+// Run calls fn with /proc, /sys, /dev and /dev/pts mounted inside root
+func Run(root string, fn func() error) (err error) {
+	m, err := Mount(root)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if unmountErr := m.Unmount(); unmountErr != nil {
+			if err == nil {
+				err = unmountErr
+			} else {
+				log.Warnf("Failed to unmount chroot filesystems: %v", unmountErr)
+			}
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,56 @@
+package chroot
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// helperArg is the first argument the builder is re-executed with to run a command chrooted
+const helperArg = "__chroot"
+
+// defaultPath is used inside the chroot when the environment has no PATH
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// Command returns the command line that runs command inside root. The builder re-executes
+// itself as a helper that changes root with the chroot system call and then executes the
+// command, so no chroot binary is needed on the host. The command line can be wrapped in
+// host commands, like the ones applying resource limits.
+func Command(root string, command ...string) ([]string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find builder executable: %w", err)
+	}
+	return append([]string{self, helperArg, root}, command...), nil
+}
+
+// Init runs the chroot helper if the process was started by a command line from Command.
+// The helper never returns, so Init must be called before anything else in main.
+func Init() {
+	if len(os.Args) < 4 || os.Args[1] != helperArg {
+		return
+	}
+	if err := execIn(os.Args[2], os.Args[3:]); err != nil {
+		fmt.Fprintf(os.Stderr, "chroot: %v\n", err)
+		os.Exit(127)
+	}
+}
+
+// execIn changes root to root and replaces the process with command
+func execIn(root string, command []string) error {
+	if err := syscall.Chroot(root); err != nil {
+		return fmt.Errorf("failed to change root to %s: %w", root, err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return fmt.Errorf("failed to change directory: %w", err)
+	}
+	if os.Getenv("PATH") == "" {
+		os.Setenv("PATH", defaultPath)
+	}
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, command, os.Environ())
+}
@@ -0,0 +1,96 @@
+package limits
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// maxBurst is the most bytes a connection reads or writes at once when its bandwidth is
+// limited, which keeps transfers smooth rather than bursty
+const maxBurst = 32 << 10
+
+// LimitBandwidth limits the registry transfers of the process to bytesPerSecond in each
+// direction, shared by all connections. The dialers of the default transports are wrapped,
+// so registry clients using them or their clones, as pushes and pulls do, are limited;
+// transfers made by the container backend are not.
+func LimitBandwidth(bytesPerSecond int64) {
+	read, write := newLimiter(bytesPerSecond), newLimiter(bytesPerSecond)
+	for _, rt := range []http.RoundTripper{http.DefaultTransport, remote.DefaultTransport} {
+		t, ok := rt.(*http.Transport)
+		if !ok {
+			continue
+		}
+		dial := t.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &limitedConn{Conn: conn, read: read, write: write}, nil
+		}
+	}
+}
+
+// limiter paces transfers to a number of bytes per second
+type limiter struct {
+	mu   sync.Mutex
+	rate float64
+	next time.Time
+}
+
+// newLimiter returns a limiter allowing bytesPerSecond
+func newLimiter(bytesPerSecond int64) *limiter {
+	return &limiter{rate: float64(bytesPerSecond)}
+}
+
+// wait blocks until n more bytes may be transferred
+func (l *limiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// limitedConn is a connection whose reads and writes are paced by limiters
+type limitedConn struct {
+	net.Conn
+	read  *limiter
+	write *limiter
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	if len(p) > maxBurst {
+		p = p[:maxBurst]
+	}
+	n, err := c.Conn.Read(p)
+	c.read.wait(n)
+	return n, err
+}
+
+func (c *limitedConn) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), maxBurst)]
+		c.write.wait(len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,31 @@
+package limits
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimitedConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := &limitedConn{Conn: client, read: newLimiter(1 << 20), write: newLimiter(1 << 20)}
+	go func() {
+		defer conn.Close()
+		conn.Write(make([]byte, 256<<10))
+	}()
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 256<<10 {
+		t.Fatalf("transferred %d bytes, want %d", n, 256<<10)
+	}
+	// 256 KiB at 1 MiB/s takes a quarter of a second, less the first chunk
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("transfer took %v, want it limited to 1 MiB/s", elapsed)
+	}
+}
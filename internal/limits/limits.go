@@ -0,0 +1,43 @@
+package limits
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/utils"
+)
+
+// Wrap returns a command line that runs command on the host with the configured limits.
+// Memory and CPU limits are applied by running the command in a transient systemd scope
+// and niceness with nice.
+func Wrap(resources imageconfig.Resources, command []string) ([]string, error) {
+	var wrapped []string
+	if resources.Memory != "" || resources.CPUs > 0 {
+		wrapped = []string{"systemd-run", "--scope", "--quiet", "--collect"}
+		if os.Getuid() != 0 {
+			wrapped = append(wrapped, "--user")
+		}
+		if resources.Memory != "" {
+			memory, err := utils.ParseSize(resources.Memory)
+			if err != nil {
+				return nil, fmt.Errorf("invalid memory limit: %w", err)
+			}
+			wrapped = append(wrapped, "--property", fmt.Sprintf("MemoryMax=%d", memory))
+		}
+		if resources.CPUs > 0 {
+			wrapped = append(wrapped, "--property", fmt.Sprintf("CPUQuota=%d%%", int(resources.CPUs*100)))
+		}
+		wrapped = append(wrapped, "--")
+	}
+	return append(wrapped, Nice(resources, command)...), nil
+}
+
+// Nice returns command prefixed with nice when a niceness is configured
+func Nice(resources imageconfig.Resources, command []string) []string {
+	if resources.Nice == 0 {
+		return command
+	}
+	return append([]string{"nice", "-n", strconv.Itoa(resources.Nice)}, command...)
+}
@@ -2,20 +2,60 @@ package pkgmgr
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"go-image-builder/pkg/imageconfig"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
+	"go-image-builder/internal/chroot"
+	"go-image-builder/internal/limits"
 	"go-image-builder/pkg/oci"
+	"go-image-builder/pkg/progress"
 
 	log "github.com/sirupsen/logrus"
 )
 
-type DNF struct{}
+type DNF struct {
+	// Output receives the full output of dnf commands
+	Output io.Writer
+	// Limits are the resource limits dnf commands run with
+	Limits imageconfig.Resources
+	// Env holds extra environment variables for dnf commands, such as proxies
+	Env []string
+	// Progress receives the packages installed, progress is logged without it
+	Progress progress.Reporter
+
+	ctx context.Context
+}
+
+// SetOutput sets where the full output of dnf commands is written
+func (d *DNF) SetOutput(w io.Writer) {
+	d.Output = w
+}
+
+// SetProgress sets the reporter that receives the progress of package installations
+func (d *DNF) SetProgress(r progress.Reporter) {
+	d.Progress = r
+}
+
+// SetContext sets the context whose cancellation kills running dnf commands
+func (d *DNF) SetContext(ctx context.Context) {
+	d.ctx = ctx
+}
+
+// context returns the context dnf commands run under
+func (d *DNF) context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
 
 func (d *DNF) InitRootfs(root string, config imageconfig.Config) error {
 	log.Infof("Installing dnf in %s", root)
@@ -37,30 +77,52 @@ func (d *DNF) InitRootfs(root string, config imageconfig.Config) error {
 		}
 	}
 
-	// Copy resolv.conf for DNS resolution
-	resolvConf := "/etc/resolv.conf"
-	if _, err := os.Stat(resolvConf); err == nil {
-		copyInstruction := imageconfig.CopyFile{
-			Src:  resolvConf,
-			Dest: "/etc/resolv.conf",
-		}
-		if err := d.CopyFiles(root, []imageconfig.CopyFile{copyInstruction}); err != nil {
-			return fmt.Errorf("failed to copy resolv.conf: %w", err)
+	if config.Options.Proxy.Persist {
+		if err := writeDNFProxy(root, config.Options.Proxy); err != nil {
+			return err
 		}
 	}
 
+	// Packages installed in the chroot later read the rpm macros of the rootfs
+	if err := writeMinimizeMacros(root, config.Options.Minimize); err != nil {
+		return err
+	}
+
 	// Add repositories first
 	if err := d.AddRepos(root, config.Repositories); err != nil {
 		return fmt.Errorf("failed to add repositories: %w", err)
 	}
 
-	// Install minimal packages using host's dnf
-	cmd := exec.Command("dnf",
-		"--installroot", root,
-		"--releasever", "9", // TODO: Get from config
-		"install",
-		"--assumeyes",
-		"--setopt=install_weak_deps=False",
+	// Install minimal packages using host's dnf. Without it, the rootfs was bootstrapped from
+	// a base image and its own dnf is run in the chroot.
+	chrooted := !HostDNF()
+	args := []string{"install", "--assumeyes", "--setopt=install_weak_deps=False"}
+	if !chrooted {
+		args = append([]string{"--installroot", root, "--releasever", "9"}, args...) // TODO: Get releasever from config
+	}
+	if config.Options.Minimize.NoDocs {
+		args = append(args, "--setopt=tsflags=nodocs")
+	}
+
+	// The dnf doing the install does not trust the configured CAs yet, so hand them over as a
+	// bundle, which must be inside the rootfs for a chrooted dnf
+	if len(config.CACerts) > 0 {
+		dir := ""
+		if chrooted {
+			dir = root
+		}
+		bundle, err := writeCABundle(dir, config.CACerts)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(bundle)
+		if chrooted {
+			bundle = "/" + filepath.Base(bundle)
+		}
+		args = append(args, "--setopt=sslcacert="+bundle)
+	}
+
+	args = append(args,
 		"dnf",
 		"yum",
 		"systemd",
@@ -70,13 +132,29 @@ func (d *DNF) InitRootfs(root string, config imageconfig.Config) error {
 		"rootfiles",
 		"bash",
 	)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to install dnf: %w\nOutput: %s", err, string(output))
+	if chrooted {
+		err := chroot.Run(root, func() error {
+			return d.runIn(root, append([]string{"dnf"}, args...), false)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to install dnf: %w", err)
+		}
+		return nil
+	}
+	if err := d.run(exec.CommandContext(d.context(), "dnf", args...), false); err != nil {
+		return fmt.Errorf("failed to install dnf: %w", err)
 	}
 
 	return nil
 }
 
+// HostDNF reports whether dnf is installed on the build host. Without it, scratch builds
+// are bootstrapped from a base image and dnf runs inside the rootfs.
+func HostDNF() bool {
+	_, err := exec.LookPath("dnf")
+	return err == nil
+}
+
 func (d *DNF) AddRepos(root string, repos []imageconfig.Repository) error {
 	if len(repos) == 0 {
 		log.Debug("No repositories to add")
@@ -103,6 +181,10 @@ gpgcheck=0
 			content += fmt.Sprintf("priority=%d\n", repo.Priority)
 		}
 
+		if repo.Proxy != "" {
+			content += fmt.Sprintf("proxy=%s\n", repo.Proxy)
+		}
+
 		if err := os.WriteFile(repoFile, []byte(content), 0644); err != nil {
 			return fmt.Errorf("failed to write repo file: %w", err)
 		}
@@ -118,114 +200,290 @@ func (d *DNF) InstallPackages(root string, packages []string, groups []string) e
 		return fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
+	if len(packages) == 0 && len(groups) == 0 {
+		return nil
+	}
+
+	// RPM scriptlets expect /proc, /sys and /dev inside the chroot
+	return chroot.Run(root, func() error {
+		return d.install(root, packages, groups)
+	})
+}
+
+// install runs dnf inside the chroot to install packages and groups
+func (d *DNF) install(root string, packages []string, groups []string) error {
 	// Install packages
 	if len(packages) > 0 {
 		log.Infof("Installing %d packages...", len(packages))
-		args := []string{root, "dnf", "--assumeyes", "--setopt=install_weak_deps=False", "install"}
+		args := []string{"dnf", "--assumeyes", "--setopt=install_weak_deps=False", "install"}
 		args = append(args, packages...)
-		cmd := exec.Command("chroot", args...)
+		if err := d.runIn(root, args, true); err != nil {
+			return fmt.Errorf("failed to install packages: %w", err)
+		}
+	}
 
-		// Create a pipe to capture output
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			return fmt.Errorf("failed to create stdout pipe: %w", err)
+	// Install groups
+	if len(groups) > 0 {
+		log.Infof("Installing %d groups...", len(groups))
+		args := []string{"dnf", "--assumeyes", "--setopt=install_weak_deps=False", "group", "install"}
+		args = append(args, groups...)
+		if err := d.runIn(root, args, true); err != nil {
+			return fmt.Errorf("failed to install groups: %w", err)
 		}
-		stderr, err := cmd.StderrPipe()
-		if err != nil {
-			return fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+
+	return nil
+}
+
+// runIn runs command inside root, see run
+func (d *DNF) runIn(root string, command []string, showProgress bool) error {
+	args, err := chroot.Command(root, command...)
+	if err != nil {
+		return err
+	}
+	return d.run(exec.CommandContext(d.context(), args[0], args[1:]...), showProgress)
+}
+
+// transactionLine matches the lines dnf prints for each package it installs, such as
+// "  Installing       : bash-5.1.8-9.el9.x86_64      12/150"
+var transactionLine = regexp.MustCompile(`^\s*(?:Installing|Upgrading|Reinstalling)\s*:\s*(\S+)\s+(\d+)/(\d+)\s*$`)
+
+// parseTransactionLine returns the package and its position in the transaction from a line
+// of dnf output
+func parseTransactionLine(line string) (name string, n, total int, ok bool) {
+	m := transactionLine.FindStringSubmatch(line)
+	if m == nil {
+		return "", 0, 0, false
+	}
+	n, _ = strconv.Atoi(m[2])
+	total, _ = strconv.Atoi(m[3])
+	return m[1], n, total, true
+}
+
+// run executes a dnf command and writes its full output to d.Output. Package operations are
+// reported as they happen when showProgress is set. Without an Output, the full output is
+// included in the returned error instead.
+func (d *DNF) run(cmd *exec.Cmd, showProgress bool) error {
+	wrapped, err := limits.Wrap(d.Limits, cmd.Args)
+	if err != nil {
+		return err
+	}
+	if cmd.Path, err = exec.LookPath(wrapped[0]); err != nil {
+		return fmt.Errorf("failed to find %s: %w", wrapped[0], err)
+	}
+	cmd.Args = wrapped
+	if len(d.Env) > 0 {
+		cmd.Env = append(os.Environ(), d.Env...)
+	}
+
+	var output strings.Builder
+	w := io.Writer(&output)
+	if d.Output != nil {
+		fmt.Fprintf(d.Output, "$ %s\n", strings.Join(cmd.Args, " "))
+		w = d.Output
+	}
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", cmd.Args[0], err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		done <- err
+	}()
+
+	reporter := progress.OrLog(d.Progress)
+	scanner := bufio.NewScanner(pr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fmt.Fprintln(w, line)
+		if !showProgress {
+			continue
 		}
 
-		if err := cmd.Start(); err != nil {
-			return fmt.Errorf("failed to start dnf install: %w", err)
+		// Show progress for package operations
+		if name, n, total, ok := parseTransactionLine(line); ok {
+			reporter.Package(name, n, total)
+		} else if strings.Contains(line, "Downloading") ||
+			strings.Contains(line, "Verifying") ||
+			strings.Contains(line, "Running") {
+			reporter.Status(strings.TrimSpace(line))
 		}
+	}
+	// Drain anything the scanner could not handle so the command never blocks
+	io.Copy(w, pr)
 
-		// Create a scanner to read output
-		scanner := bufio.NewScanner(io.MultiReader(stdout, stderr))
-		scanner.Split(bufio.ScanLines)
-
-		// Buffer to store all output for error reporting
-		var outputBuffer strings.Builder
-
-		for scanner.Scan() {
-			line := scanner.Text()
-			// Store all output
-			outputBuffer.WriteString(line + "\n")
-
-			// Show progress for package operations
-			if strings.Contains(line, "Installing") ||
-				strings.Contains(line, "Downloading") ||
-				strings.Contains(line, "Verifying") ||
-				strings.Contains(line, "Running") {
-				log.Info(line)
-			}
+	if err := <-done; err != nil {
+		if d.Output == nil {
+			return fmt.Errorf("%w\nFull output:\n%s", err, output.String())
 		}
+		return err
+	}
+	return nil
+}
 
-		if err := cmd.Wait(); err != nil {
-			return fmt.Errorf("failed to install packages: %w\nFull output:\n%s", err, outputBuffer.String())
+// writeDNFProxy sets the proxy in the [main] section of the rootfs' dnf.conf, replacing any
+// proxy already configured there
+func writeDNFProxy(root string, proxy imageconfig.Proxy) error {
+	url := proxy.HTTPS
+	if url == "" {
+		url = proxy.HTTP
+	}
+	if url == "" {
+		return nil
+	}
+
+	path := filepath.Join(root, "etc", "dnf", "dnf.conf")
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read dnf.conf: %w", err)
+	}
+
+	var lines []string
+	inserted := false
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "proxy=") {
+			continue
+		}
+		lines = append(lines, line)
+		if strings.TrimSpace(line) == "[main]" {
+			lines = append(lines, "proxy="+url)
+			inserted = true
 		}
 	}
+	if !inserted {
+		lines = append([]string{"[main]", "proxy=" + url}, lines...)
+	}
 
-	// Install groups
-	if len(groups) > 0 {
-		log.Infof("Installing %d groups...", len(groups))
-		args := []string{root, "dnf", "--assumeyes", "--setopt=install_weak_deps=False", "group", "install"}
-		args = append(args, groups...)
-		cmd := exec.Command("chroot", args...)
+	log.Debugf("Writing proxy %s to %s", url, path)
+	if err := os.WriteFile(path, []byte(strings.TrimRight(strings.Join(lines, "\n"), "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write dnf.conf: %w", err)
+	}
+	return nil
+}
+
+// minimizeMacrosFile holds the rpm macros that keep documentation and locales out of the
+// image
+const minimizeMacrosFile = "macros.image-minimize"
+
+// writeMinimizeMacros makes rpm skip documentation and unwanted locales when installing
+// packages into the rootfs. The macros stay in the image, so packages installed on booted
+// nodes are minimized the same way.
+func writeMinimizeMacros(root string, minimize imageconfig.Minimize) error {
+	var macros []string
+	if minimize.NoDocs {
+		macros = append(macros, "%_excludedocs 1")
+	}
+	if len(minimize.Locales) > 0 {
+		macros = append(macros, "%_install_langs "+strings.Join(minimize.Locales, ":"))
+	}
+	if len(macros) == 0 {
+		return nil
+	}
+
+	dir := filepath.Join(root, "etc", "rpm")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create rpm macro directory: %w", err)
+	}
+	path := filepath.Join(dir, minimizeMacrosFile)
+	log.Debugf("Writing rpm macros to %s", path)
+	if err := os.WriteFile(path, []byte(strings.Join(macros, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write rpm macros: %w", err)
+	}
+	return nil
+}
+
+// InstallCACerts adds CA certificates to the rootfs trust store. The bundle is regenerated
+// right away when the rootfs already has update-ca-trust, and otherwise when the
+// ca-certificates package is installed.
+func (d *DNF) InstallCACerts(root string, certs []string) error {
+	anchors := filepath.Join(root, "etc", "pki", "ca-trust", "source", "anchors")
+	if err := os.MkdirAll(anchors, 0755); err != nil {
+		return fmt.Errorf("failed to create trust anchor directory: %w", err)
+	}
 
-		// Create a pipe to capture output
-		stdout, err := cmd.StdoutPipe()
+	for _, cert := range certs {
+		data, err := os.ReadFile(cert)
 		if err != nil {
-			return fmt.Errorf("failed to create stdout pipe: %w", err)
+			return fmt.Errorf("failed to read CA certificate %s: %w", cert, err)
 		}
-		stderr, err := cmd.StderrPipe()
-		if err != nil {
-			return fmt.Errorf("failed to create stderr pipe: %w", err)
+		dest := filepath.Join(anchors, filepath.Base(cert))
+		log.Debugf("Installing CA certificate %s to %s", cert, dest)
+		if err := os.WriteFile(dest, data, 0644); err != nil {
+			return fmt.Errorf("failed to install CA certificate %s: %w", cert, err)
 		}
+	}
 
-		if err := cmd.Start(); err != nil {
-			return fmt.Errorf("failed to start dnf group install: %w", err)
+	if _, err := os.Stat(filepath.Join(root, "usr", "bin", "update-ca-trust")); err != nil {
+		log.Debug("update-ca-trust is not installed yet, the trust store will be updated with ca-certificates")
+		return nil
+	}
+	return chroot.Run(root, func() error {
+		if err := d.runIn(root, []string{"update-ca-trust", "extract"}, false); err != nil {
+			return fmt.Errorf("failed to update CA trust store: %w", err)
 		}
+		return nil
+	})
+}
 
-		// Create a scanner to read output
-		scanner := bufio.NewScanner(io.MultiReader(stdout, stderr))
-		scanner.Split(bufio.ScanLines)
-
-		// Buffer to store all output for error reporting
-		var outputBuffer strings.Builder
-
-		for scanner.Scan() {
-			line := scanner.Text()
-			// Store all output
-			outputBuffer.WriteString(line + "\n")
-
-			// Show progress for group operations
-			if strings.Contains(line, "Installing") ||
-				strings.Contains(line, "Downloading") ||
-				strings.Contains(line, "Verifying") ||
-				strings.Contains(line, "Running") {
-				log.Info(line)
-			}
-		}
+// hostCABundles are the system CA bundles of common distributions
+var hostCABundles = []string{
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/certs/ca-certificates.crt",
+}
+
+// writeCABundle concatenates the host's CA bundle and the given CA certificates into a
+// temporary bundle file in dir, or the default temporary directory, and returns its path.
+// The host bundle is included because sslcacert replaces dnf's trust store rather than
+// adding to it.
+func writeCABundle(dir string, certs []string) (string, error) {
+	bundle, err := os.CreateTemp(dir, "ca-bundle-*.pem")
+	if err != nil {
+		return "", fmt.Errorf("failed to create CA bundle: %w", err)
+	}
+	defer bundle.Close()
 
-		if err := cmd.Wait(); err != nil {
-			return fmt.Errorf("failed to install groups: %w\nFull output:\n%s", err, outputBuffer.String())
+	for _, path := range hostCABundles {
+		if _, err := os.Stat(path); err == nil {
+			certs = append([]string{path}, certs...)
+			break
 		}
 	}
 
-	return nil
+	for _, cert := range certs {
+		data, err := os.ReadFile(cert)
+		if err != nil {
+			os.Remove(bundle.Name())
+			return "", fmt.Errorf("failed to read CA certificate %s: %w", cert, err)
+		}
+		if _, err := fmt.Fprintf(bundle, "%s\n", strings.TrimSpace(string(data))); err != nil {
+			os.Remove(bundle.Name())
+			return "", fmt.Errorf("failed to write CA bundle: %w", err)
+		}
+	}
+	return bundle.Name(), nil
 }
 
 // RunCommand executes a command in the rootfs
-func (d *DNF) RunCommand(oci *oci.OCI, containerName, command string) error {
+func (d *DNF) RunCommand(oci oci.OCIInterface, containerName, command string) error {
 	return oci.RunCommand(containerName, command)
 }
 
 // Cleanup cleans up the rootfs after the build
 func (d *DNF) Cleanup(rootfs string) error {
-	// Clean DNF cache
-	cmd := exec.Command("dnf", "--installroot", rootfs, "clean", "all")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to clean DNF cache: %w\nOutput: %s", err, string(output))
+	// Clean DNF cache, with the dnf of the rootfs if the host has none
+	var err error
+	if HostDNF() {
+		err = d.run(exec.CommandContext(d.context(), "dnf", "--installroot", rootfs, "clean", "all"), false)
+	} else {
+		err = d.runIn(rootfs, []string{"dnf", "clean", "all"}, false)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to clean DNF cache: %w", err)
 	}
 
 	// Remove unnecessary files
@@ -0,0 +1,25 @@
+package pkgmgr
+
+import "testing"
+
+func TestParseTransactionLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		name  string
+		n     int
+		total int
+		ok    bool
+	}{
+		{line: "  Installing       : bash-5.1.8-9.el9.x86_64                           12/150 ", name: "bash-5.1.8-9.el9.x86_64", n: 12, total: 150, ok: true},
+		{line: "  Upgrading        : systemd-252-46.el9.x86_64                           3/8", name: "systemd-252-46.el9.x86_64", n: 3, total: 8, ok: true},
+		{line: "Installing dependencies:"},
+		{line: "  Running scriptlet: bash-5.1.8-9.el9.x86_64                           12/150"},
+		{line: "(1/150): bash-5.1.8-9.el9.x86_64.rpm           2.1 MB/s | 1.7 MB     00:00"},
+	}
+	for _, tt := range tests {
+		name, n, total, ok := parseTransactionLine(tt.line)
+		if name != tt.name || n != tt.n || total != tt.total || ok != tt.ok {
+			t.Errorf("parseTransactionLine(%q) = %q, %d, %d, %v, want %q, %d, %d, %v", tt.line, name, n, total, ok, tt.name, tt.n, tt.total, tt.ok)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package pkgmgr
 
 import (
+	"context"
+	"io"
+
 	"go-image-builder/pkg/imageconfig"
 	"go-image-builder/pkg/oci"
+	"go-image-builder/pkg/progress"
 )
 
 // PackageManager defines the interface for package management operations
@@ -10,7 +14,11 @@ type PackageManager interface {
 	InitRootfs(rootfs string, config imageconfig.Config) error
 	AddRepos(rootfs string, repos []imageconfig.Repository) error
 	InstallPackages(rootfs string, packages []string, groups []string) error
-	RunCommand(oci *oci.OCI, containerName, command string) error
+	RunCommand(oci oci.OCIInterface, containerName, command string) error
 	Cleanup(rootfs string) error
 	CopyFiles(rootfs string, files []imageconfig.CopyFile) error
+	InstallCACerts(rootfs string, certs []string) error
+	SetOutput(w io.Writer)
+	SetContext(ctx context.Context)
+	SetProgress(r progress.Reporter)
 }
@@ -0,0 +1,198 @@
+package redact
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"go-image-builder/pkg/imageconfig"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Mask replaces secret values in redacted text
+const Mask = "****"
+
+// minLength is the length below which values are not masked, since masking them would
+// mangle unrelated text
+const minLength = 4
+
+var (
+	mu sync.RWMutex
+	// secrets are kept longest first, so a secret holding another one is masked whole
+	secrets []string
+)
+
+// Add registers secret values to be masked in logs and errors
+func Add(values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, value := range values {
+		if len(value) >= minLength && !slices.Contains(secrets, value) {
+			secrets = append(secrets, value)
+		}
+	}
+	sort.SliceStable(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+}
+
+// URL registers the password of a URL with user information, both decoded and as written
+// in the URL
+func URL(raw string) {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return
+	}
+	password, ok := u.User.Password()
+	if !ok {
+		return
+	}
+	Add(password)
+	if _, escaped, ok := strings.Cut(u.User.String(), ":"); ok {
+		Add(escaped)
+	}
+}
+
+// Config registers the secrets of a build configuration: passwords embedded in repository,
+// proxy and service URLs, registry credentials, credential headers of notifications and those
+// read from the environment, and the values of the environment variables tokens and passwords
+// are read from
+func Config(config *imageconfig.Config) {
+	for _, opt := range append(append([]string{}, config.Options.RegistryOptsPull...), config.Options.RegistryOptsPush...) {
+		key, value, _ := strings.Cut(strings.TrimLeft(opt, "-"), "=")
+		if key == "creds" {
+			if _, password, ok := strings.Cut(value, ":"); ok {
+				Add(password)
+			}
+		}
+	}
+
+	urls := []string{config.Options.Proxy.HTTP, config.Options.Proxy.HTTPS}
+	for _, repo := range config.Repositories {
+		urls = append(urls, repo.Url, repo.Proxy)
+	}
+	if config.BuildStage != nil {
+		for _, repo := range config.BuildStage.Repositories {
+			urls = append(urls, repo.Url, repo.Proxy)
+		}
+	}
+	for _, notification := range config.Notifications {
+		urls = append(urls, notification.URL)
+		// Headers are registered as they are sent; those read from the environment are secrets
+		// whatever their name
+		for name, value := range notification.HeaderValues() {
+			if _, ok := notification.HeadersEnv[name]; ok || secretHeader(name) {
+				Add(value)
+				// Authorization schemes like Bearer leave the credentials after a space
+				if _, credentials, ok := strings.Cut(value, " "); ok {
+					Add(credentials)
+				}
+			}
+		}
+	}
+
+	var envs []string
+	if config.BSS != nil {
+		urls = append(urls, config.BSS.URL)
+		envs = append(envs, config.BSS.TokenEnv)
+	}
+	if config.PublishHTTP != nil {
+		urls = append(urls, config.PublishHTTP.URL)
+		envs = append(envs, config.PublishHTTP.PasswordEnv, config.PublishHTTP.TokenEnv)
+	}
+
+	for _, u := range urls {
+		URL(u)
+	}
+	for _, env := range envs {
+		if env != "" {
+			Add(os.Getenv(env))
+		}
+	}
+}
+
+// secretHeader reports whether an HTTP header carries credentials
+func secretHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range []string{"authorization", "token", "secret", "key", "password"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns s with every registered secret masked
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Mask)
+	}
+	return s
+}
+
+// Error wraps err so that its message has every registered secret masked. The wrapped error
+// is still available to errors.Is and errors.As.
+func Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{err: err}
+}
+
+type redactedError struct {
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return String(e.err.Error())
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Writer returns a writer masking every registered secret in what is written to w. Secrets
+// are only masked within a single write, which is enough for output written line by line.
+func Writer(w io.Writer) io.Writer {
+	return &writer{w: w}
+}
+
+type writer struct {
+	w io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Hook is a log hook masking every registered secret in log messages and fields
+type Hook struct{}
+
+// Levels implements log.Hook
+func (Hook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire implements log.Hook
+func (Hook) Fire(entry *log.Entry) error {
+	entry.Message = String(entry.Message)
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			entry.Data[key] = String(v)
+		case error:
+			entry.Data[key] = Error(v)
+		}
+	}
+	return nil
+}
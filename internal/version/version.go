@@ -0,0 +1,44 @@
+package version
+
+import (
+	"runtime/debug"
+)
+
+// Version is the version of go-image-builder. Releases set it at link time with
+// -ldflags "-X go-image-builder/internal/version.Version=v1.2.3".
+var Version = ""
+
+// String returns the version of go-image-builder: the one set at link time, or else the
+// module version or VCS revision the Go toolchain recorded in the binary
+func String() string {
+	if Version != "" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		}
+	}
+	if revision == "" {
+		return "devel"
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified == "true" {
+		revision += "-dirty"
+	}
+	return "devel-" + revision
+}
@@ -1,7 +1,18 @@
 package main
 
-import "go-image-builder/cmd"
+import (
+	"go-image-builder/cmd"
+	"go-image-builder/internal/chroot"
+
+	"github.com/containers/buildah"
+)
 
 func main() {
+	// Buildah re-executes this binary to run commands in chroot isolation
+	if buildah.InitReexec() {
+		return
+	}
+	// Builds re-execute this binary to run commands chrooted into the rootfs
+	chroot.Init()
 	cmd.Execute()
 }
@@ -0,0 +1,131 @@
+package bss
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"go-image-builder/pkg/imageconfig"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bootParametersPath is the boot script service endpoint for boot parameters
+const bootParametersPath = "/boot/v1/bootparameters"
+
+// defaultTokenEnv is the environment variable holding the access token when none is configured
+const defaultTokenEnv = "ACCESS_TOKEN"
+
+// ImageInfo describes the pushed image that is registered
+type ImageInfo struct {
+	Name          string
+	Registry      string
+	Reference     string
+	Tag           string
+	Digest        string
+	KernelVersion string
+	// BootArgs are the kernel arguments the configured live overlay or NFS root needs,
+	// appended to params
+	BootArgs string
+}
+
+// BootParameters is the request body of the boot parameters API
+type BootParameters struct {
+	Hosts  []string `json:"hosts,omitempty"`
+	Macs   []string `json:"macs,omitempty"`
+	Nids   []int    `json:"nids,omitempty"`
+	Params string   `json:"params"`
+	Kernel string   `json:"kernel"`
+	Initrd string   `json:"initrd,omitempty"`
+}
+
+// Register sets the boot parameters of the configured nodes to boot the pushed image
+func Register(cfg *imageconfig.BSSRegistration, info ImageInfo) error {
+	params, err := NewBootParameters(cfg, info)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal boot parameters: %w", err)
+	}
+
+	url := strings.TrimSuffix(cfg.URL, "/") + bootParametersPath
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	tokenEnv := cfg.TokenEnv
+	if tokenEnv == "" {
+		tokenEnv = defaultTokenEnv
+	}
+	if token := os.Getenv(tokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	log.Debugf("Setting boot parameters at %s: %s", url, string(body))
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call boot script service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("boot script service returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// NewBootParameters renders the boot parameters for an image from the registration config
+func NewBootParameters(cfg *imageconfig.BSSRegistration, info ImageInfo) (*BootParameters, error) {
+	params := &BootParameters{
+		Hosts: cfg.Hosts,
+		Macs:  cfg.Macs,
+		Nids:  cfg.Nids,
+	}
+
+	fields := []struct {
+		name  string
+		value string
+		dest  *string
+	}{
+		{"kernel_url", cfg.KernelURL, &params.Kernel},
+		{"initrd_url", cfg.InitrdURL, &params.Initrd},
+		{"params", cfg.Params, &params.Params},
+	}
+	for _, f := range fields {
+		rendered, err := render(f.name, f.value, info)
+		if err != nil {
+			return nil, err
+		}
+		*f.dest = rendered
+	}
+	if info.BootArgs != "" {
+		params.Params = strings.TrimSpace(params.Params + " " + info.BootArgs)
+	}
+	return params, nil
+}
+
+// render executes a template against the image info
+func render(name, text string, info ImageInfo) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse bss.%s template: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, info); err != nil {
+		return "", fmt.Errorf("failed to render bss.%s template: %w", name, err)
+	}
+	return buf.String(), nil
+}
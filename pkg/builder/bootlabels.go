@@ -0,0 +1,81 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"go-image-builder/pkg/image"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bootLabels returns the labels describing how the image boots: the kernel arguments of
+// /etc/kernel/cmdline in the rootfs and of the live overlay or NFS root, the dracut modules
+// of the generated initrd and the digest of the squashfs artifact. Labels without a value
+// are left out.
+func (b *Builder) bootLabels(mountPoint string) (map[string]string, error) {
+	labels := make(map[string]string)
+
+	var args []string
+	if data, err := os.ReadFile(filepath.Join(mountPoint, "etc", "kernel", "cmdline")); err == nil {
+		args = append(args, strings.Fields(string(data))...)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read kernel cmdline: %w", err)
+	}
+	args = append(args, strings.Fields(b.bootArgs())...)
+	if len(args) > 0 {
+		labels[image.KernelCmdlineLabel] = strings.Join(args, " ")
+	}
+
+	if len(b.dracutModules) > 0 {
+		labels[image.DracutModulesLabel] = strings.Join(b.dracutModules, ",")
+	}
+
+	if b.shouldCreateSquashfs && b.config.NFSRoot == nil {
+		sum, err := sha256File(filepath.Join(b.rootfs, "..", "image.squashfs"))
+		if err != nil {
+			return nil, err
+		}
+		labels[image.SquashfsDigestLabel] = "sha256:" + sum
+	}
+	return labels, nil
+}
+
+// listDracutModules returns the dracut modules included in the initrd at initrdPath, below
+// mountPoint. lsinitrd runs where dracut did, on the build host or in the container. The
+// modules only label the image, so failing to list them is not fatal.
+func (b *Builder) listDracutModules(containerName, mountPoint, initrdPath string) []string {
+	var output []byte
+	var err error
+	if b.config.Options.Dracut == "host" {
+		output, err = exec.Command("lsinitrd", "--mod", initrdPath).Output()
+	} else {
+		rel, relErr := filepath.Rel(mountPoint, initrdPath)
+		if relErr != nil {
+			log.Warnf("Failed to list the dracut modules of %s: %v", initrdPath, relErr)
+			return nil
+		}
+		output, err = b.oci.RunCommandWithOutput(containerName, fmt.Sprintf("lsinitrd --mod /%s 2>/dev/null", rel))
+	}
+	if err != nil {
+		log.Warnf("Failed to list the dracut modules of %s: %v", initrdPath, err)
+		return nil
+	}
+	return parseDracutModules(string(output))
+}
+
+// parseDracutModules returns the module names in the output of lsinitrd --mod
+func parseDracutModules(output string) []string {
+	var modules []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasSuffix(line, ":") {
+			continue
+		}
+		modules = append(modules, line)
+	}
+	return modules
+}
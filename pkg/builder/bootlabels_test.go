@@ -0,0 +1,63 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go-image-builder/pkg/image"
+	"go-image-builder/pkg/imageconfig"
+)
+
+func TestParseDracutModules(t *testing.T) {
+	output := "dracut modules:\nbash\nsystemd\n\ndmsquash-live\nlivenet\n"
+	want := []string{"bash", "systemd", "dmsquash-live", "livenet"}
+	if got := parseDracutModules(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDracutModules() = %v, want %v", got, want)
+	}
+}
+
+func TestBootLabels(t *testing.T) {
+	dir := t.TempDir()
+	mountPoint := filepath.Join(dir, "rootfs")
+	if err := os.MkdirAll(filepath.Join(mountPoint, "etc", "kernel"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(mountPoint, "etc", "kernel", "cmdline"), []byte("console=ttyS0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "image.squashfs"), []byte("squashfs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &imageconfig.Config{}
+	config.NFSRoot = &imageconfig.NFSRoot{Server: "10.0.0.1", Path: "/exports/compute"}
+	b := &Builder{config: config, rootfs: mountPoint, dracutModules: []string{"nfs", "network"}}
+	labels, err := b.bootLabels(mountPoint)
+	if err != nil {
+		t.Fatalf("bootLabels() error = %v", err)
+	}
+	want := map[string]string{
+		image.KernelCmdlineLabel: "console=ttyS0 " + config.NFSRoot.Args(),
+		image.DracutModulesLabel: "nfs,network",
+	}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("bootLabels() = %v, want %v", labels, want)
+	}
+
+	// Live images label the digest of their squashfs
+	config.NFSRoot = nil
+	b.shouldCreateSquashfs = true
+	labels, err = b.bootLabels(mountPoint)
+	if err != nil {
+		t.Fatalf("bootLabels() error = %v", err)
+	}
+	if labels[image.KernelCmdlineLabel] != "console=ttyS0" {
+		t.Errorf("kernel cmdline = %q, want %q", labels[image.KernelCmdlineLabel], "console=ttyS0")
+	}
+	sum, _ := sha256File(filepath.Join(dir, "image.squashfs"))
+	if labels[image.SquashfsDigestLabel] != "sha256:"+sum {
+		t.Errorf("squashfs digest = %q, want %q", labels[image.SquashfsDigestLabel], "sha256:"+sum)
+	}
+}
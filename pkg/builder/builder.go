@@ -1,127 +1,590 @@
 package builder
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"go-image-builder/internal/pkgmgr"
+	"go-image-builder/internal/redact"
+	"go-image-builder/pkg/bss"
+	"go-image-builder/pkg/events"
 	"go-image-builder/pkg/image"
 	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/notify"
 	"go-image-builder/pkg/oci"
+	"go-image-builder/pkg/progress"
+	"go-image-builder/pkg/publish"
+	"go-image-builder/pkg/utils"
 
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	log "github.com/sirupsen/logrus"
 )
 
+// CleanupPolicy controls whether the build container and temporary layer directories are
+// removed once a build finishes
+type CleanupPolicy int
+
+const (
+	// CleanupAlways removes everything when the build finishes
+	CleanupAlways CleanupPolicy = iota
+	// KeepOnFailure keeps the mounted rootfs and temporary layers of failed builds
+	KeepOnFailure
+	// KeepAlways never removes the build container or temporary layers
+	KeepAlways
+)
+
 // Builder handles the image building process
 type Builder struct {
 	config               *imageconfig.Config
 	workDir              string
 	rootfs               string
 	pm                   pkgmgr.PackageManager
-	oci                  *oci.OCI
+	oci                  oci.OCIInterface
 	shouldCreateSquashfs bool
 	shouldCreateInitrd   bool
+	kernelVersion        string
+	cleanupPolicy        CleanupPolicy
+	img                  *image.Image
+	events               *events.Writer
+	layerCache           *image.LayerCache
+	blobCache            *image.BlobCache
+	force                bool
+	signKey              string
+	artifacts            []string
+	scanReport           string
+	hardenReport         string
+	nodeOverlays         []string
+	sizeReport           *SizeReport
+	modulesBuilt         bool
+	baseLayerTar         string
+	gpuLayerTar          string
+	gpuDriverVersion     string
+	dracutModules        []string
+	digest               string
+	progress             progress.Reporter
+	planned              []string
+	stages               []StageTiming
+	ctx                  context.Context
+	stageCtx             context.Context
+	runDir               *runDir
 }
 
-// NewBuilder creates a new Builder instance
-func NewBuilder(config *imageconfig.Config, workDir string, createSquashfs, createInitrd bool) (*Builder, error) {
-	var pm pkgmgr.PackageManager
-	switch config.Options.PkgManager {
-	case "dnf":
-		pm = &pkgmgr.DNF{}
-	case "zypper":
-		// TODO: implement zypper
-		return nil, fmt.Errorf("zypper support not implemented yet")
-	case "apt":
-		// TODO: implement apt
-		return nil, fmt.Errorf("apt support not implemented yet")
-	default:
-		return nil, fmt.Errorf("unsupported package manager: %s", config.Options.PkgManager)
-	}
-
-	return &Builder{
-		config:               config,
-		workDir:              workDir,
-		rootfs:               filepath.Join(workDir, "rootfs"),
-		pm:                   pm,
-		oci:                  oci.NewOCI(config, workDir),
-		shouldCreateSquashfs: createSquashfs,
-		shouldCreateInitrd:   createInitrd,
-	}, nil
+// SetEvents sets the writer that receives the machine-readable events of the build
+func (b *Builder) SetEvents(w *events.Writer) {
+	b.events = w
+}
+
+// emit writes an event for the image being built
+func (b *Builder) emit(e events.Event) {
+	e.Image = b.config.Options.Name
+	b.events.Emit(e)
+}
+
+// stage runs a step of the build pipeline between stage_started and stage_finished events.
+// A stage that outlives its deadline or the build timeout fails. Its commands are
+// interrupted, and the stage is waited for so nothing it started outlives the build cleanup.
+func (b *Builder) stage(name string, fn func() error) error {
+	b.emit(events.Event{Type: events.StageStarted, Stage: name})
+	if n := slices.Index(b.planned, name); n >= 0 {
+		progress.OrLog(b.progress).Stage(name, n+1, len(b.planned))
+	}
+	start := time.Now()
+
+	ctx := b.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if timeout, ok := b.config.Options.StageTimeouts[name]; ok {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout for stage %s: %w", name, err)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	b.pm.SetContext(ctx)
+	b.oci.SetContext(ctx)
+	b.stageCtx = ctx
+
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		log.Warnf("Stage %s did not finish in time, waiting for it to stop", name)
+		// Commands that cannot be interrupted run to completion, the stage fails regardless
+		if err = <-done; err == nil {
+			err = ctx.Err()
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		err = withClass(ErrTimeout, fmt.Errorf("stage %s did not finish in time: %w", name, ctx.Err()))
+	}
+	if err != nil {
+		err = &StageError{Stage: name, Err: err}
+	}
+
+	b.stages = append(b.stages, StageTiming{Stage: name, Duration: time.Since(start)})
+	e := events.Event{Type: events.StageFinished, Stage: name, Duration: time.Since(start).Seconds()}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	b.emit(e)
+	return err
+}
+
+// tempDir returns the directory temporary layer directories and the parent archive are
+// created in, so a build only writes below its working directory unless told otherwise.
+// While the build runs this is its locked directory below that.
+func (b *Builder) tempDir() string {
+	if b.runDir != nil {
+		return b.runDir.path
+	}
+	if b.config.Options.TempDir != "" {
+		return b.config.Options.TempDir
+	}
+	return b.workDir
+}
+
+// SetLayerCache sets the cache that unchanged layers are reused from across builds
+func (b *Builder) SetLayerCache(cache *image.LayerCache) {
+	b.layerCache = cache
+}
+
+// SetBlobCache sets the cache of blobs known to exist in registries, which pushes skip
+func (b *Builder) SetBlobCache(cache *image.BlobCache) {
+	b.blobCache = cache
+}
+
+// SetForce allows pushes to overwrite immutable tags that point at another image
+func (b *Builder) SetForce(force bool) {
+	b.force = force
+}
+
+// SetSignKey sets the GPG key SHA256SUMS is signed with. SHA256SUMS is not signed without one.
+func (b *Builder) SetSignKey(key string) {
+	b.signKey = key
+}
+
+// SetCleanupPolicy sets what is kept once the build finishes, for inspecting broken builds
+func (b *Builder) SetCleanupPolicy(policy CleanupPolicy) {
+	b.cleanupPolicy = policy
+}
+
+// SetProgress sets the reporter that receives the progress of the build. Progress is logged
+// without one.
+func (b *Builder) SetProgress(r progress.Reporter) {
+	b.progress = r
+}
+
+// plannedStages returns the stages the build runs, in order
+func (b *Builder) plannedStages() []string {
+	stages := []string{"setup", "customize"}
+	if b.config.Options.Harden.Profile != "" {
+		stages = append(stages, "harden")
+	}
+	if b.config.GPU != nil {
+		stages = append(stages, "gpu")
+	}
+	if len(b.config.Tests) > 0 {
+		stages = append(stages, "test")
+	}
+	stages = append(stages, "package")
+	if b.config.Options.Scan.Scanner != "" {
+		stages = append(stages, "scan")
+	}
+	if b.config.Options.PublishRegistry != "" {
+		stages = append(stages, "push")
+		if b.config.BSS != nil {
+			stages = append(stages, "register")
+		}
+	}
+	if b.config.PublishHTTP != nil || b.config.PublishTFTP != nil {
+		stages = append(stages, "publish")
+	}
+	return stages
+}
+
+// BuildResult describes a build. The result of a failed build holds what the build got to.
+type BuildResult struct {
+	// Image is the name of the image and Tags the tags it was published with. Digest is that
+	// of the pushed image.
+	Image         string
+	Tags          []string
+	Digest        string
+	KernelVersion string
+	// Artifacts are the paths of the files written for provisioning tools
+	Artifacts []string
+	// Layers are the layers of the packaged image, from the bottom layer up
+	Layers   []image.LayerSize
+	Stages   []StageTiming
+	Duration time.Duration
+}
+
+// StageTiming is how long a stage of the build took
+type StageTiming struct {
+	Stage    string
+	Duration time.Duration
 }
 
-// Build executes the image building pipeline
-func (b *Builder) Build() error {
+// Build executes the image building pipeline and sends the configured notifications. The
+// build is stopped when ctx is done.
+func (b *Builder) Build(ctx context.Context) (*BuildResult, error) {
+	started := time.Now()
+	b.emit(events.Event{Type: events.BuildStarted})
+	// Command output in errors may hold credentials of the configuration
+	err := redact.Error(b.build(ctx))
+	if cerr := b.oci.Close(); cerr != nil {
+		log.Warnf("Failed to close container storage: %v", cerr)
+	}
+	result := b.result(time.Since(started))
+
+	if len(b.config.Notifications) > 0 {
+		log.Info("Sending build notifications")
+		report := notify.NewReport(b.config, started, err)
+		// Report the tags that were pushed rather than their templates
+		if result.Tags != nil {
+			report.Tags = result.Tags
+		}
+		report.Kernel = result.KernelVersion
+		if nerr := notify.Send(b.config.Notifications, report); nerr != nil {
+			log.Warnf("Failed to send notifications: %v", nerr)
+		}
+	}
+
+	finished := events.Event{Type: events.BuildFinished, Duration: time.Since(started).Seconds()}
+	if err != nil {
+		finished.Error = err.Error()
+	}
+	b.emit(finished)
+	return result, err
+}
+
+// result describes the build so far
+func (b *Builder) result(duration time.Duration) *BuildResult {
+	result := &BuildResult{
+		Image:         b.config.Options.Name,
+		Digest:        b.digest,
+		KernelVersion: b.kernelVersion,
+		Artifacts:     b.artifacts,
+		Stages:        b.stages,
+		Duration:      duration,
+	}
+	if b.sizeReport != nil {
+		result.Layers = b.sizeReport.Layers
+	}
+	if b.img == nil {
+		return result
+	}
+	result.Image = b.img.Name()
+	if tags, err := b.img.Tags(); err == nil {
+		result.Tags = tags
+	}
+	return result
+}
+
+// build runs the steps of the image building pipeline
+func (b *Builder) build(ctx context.Context) (err error) {
 	log.Info("Starting image build process")
 
+	b.ctx = ctx
+	if b.config.Options.Timeout != "" {
+		timeout, err := time.ParseDuration(b.config.Options.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid build timeout: %w", err)
+		}
+		var cancel context.CancelFunc
+		b.ctx, cancel = context.WithTimeout(b.ctx, timeout)
+		defer cancel()
+	}
+
+	// Full command output goes to per-stage log files, whatever the console log level
+	backend := b.config.Options.Backend
+	if backend == "" {
+		backend = "buildah"
+	}
+	ociLog, err := b.openLog(backend)
+	if err != nil {
+		return err
+	}
+	defer ociLog.Close()
+	b.oci.SetLog(redact.Writer(ociLog))
+
+	pmLog, err := b.openLog(b.config.Options.PkgManager)
+	if err != nil {
+		return err
+	}
+	defer pmLog.Close()
+	b.pm.SetOutput(redact.Writer(pmLog))
+	b.pm.SetProgress(b.progress)
+	b.oci.SetProgress(b.progress)
+	b.planned = b.plannedStages()
+	if b.runDir, err = createRunDir(b.tempDir()); err != nil {
+		return err
+	}
+	defer func() {
+		b.runDir.release(b.cleanupPolicy == KeepAlways || (err != nil && b.cleanupPolicy == KeepOnFailure))
+		b.runDir = nil
+	}()
+	log.Debugf("Temporary files go to %s", b.tempDir())
+
 	// 1. Setup the container, either from a parent or from scratch
 	log.Info("--> Setting up container")
-	containerName, mountPoint, err := b.setupContainer()
-	if err != nil {
+	var containerName, mountPoint string
+	if err := b.stage("setup", func() (err error) {
+		containerName, mountPoint, err = b.setupContainer()
+		return err
+	}); err != nil {
+		// A container created before the failure is cleaned up like that of any failed build
+		if containerName != "" {
+			if b.cleanupPolicy == CleanupAlways {
+				b.oci.Cleanup(containerName)
+			} else {
+				b.reportKept(containerName, mountPoint)
+			}
+		}
 		return err
 	}
-	defer b.oci.Cleanup(containerName)
+	defer func() {
+		if b.cleanupPolicy == KeepAlways || (err != nil && b.cleanupPolicy == KeepOnFailure) {
+			b.reportKept(containerName, mountPoint)
+			return
+		}
+		b.oci.Cleanup(containerName)
+		if err != nil && b.img != nil {
+			b.img.Cleanup()
+		}
+	}()
 	log.Infof("Container %s mounted at %s", containerName, mountPoint)
 
 	// 2. Customize the container's rootfs
 	log.Info("--> Customizing container")
-	if err := b.customizeContainer(containerName, mountPoint); err != nil {
+	if err := b.stage("customize", func() error {
+		return b.customizeContainer(containerName, mountPoint)
+	}); err != nil {
 		return err
 	}
 
+	// Hardening goes before the GPU drivers, which are layered from the rootfs as it is then
+	if b.config.Options.Harden.Profile != "" {
+		log.Info("--> Hardening rootfs")
+		if err := b.stage("harden", func() error {
+			return b.hardenRootfs(mountPoint)
+		}); err != nil {
+			return err
+		}
+	}
+
+	// GPU drivers go into a layer of their own on top of the base layer
+	if b.config.GPU != nil {
+		log.Info("--> Installing GPU drivers")
+		layerDir, err := os.MkdirTemp(b.tempDir(), "gpu-layers-*")
+		if err != nil {
+			return fmt.Errorf("failed to create layer directory: %w", err)
+		}
+		defer os.RemoveAll(layerDir)
+		if err := b.stage("gpu", func() error {
+			return b.installGPUDrivers(containerName, mountPoint, layerDir)
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Test the customized container before anything is packaged
+	if len(b.config.Tests) > 0 {
+		log.Info("--> Testing image")
+		if err := b.stage("test", func() error {
+			return b.runTests(containerName, mountPoint)
+		}); err != nil {
+			return err
+		}
+	}
+
 	// 3. Package the final image and artifacts
 	log.Info("--> Packaging final image")
-	img, err := b.packageImage(containerName, mountPoint)
-	if err != nil {
+	var img *image.Image
+	if err := b.stage("package", func() (err error) {
+		if img, err = b.packageImage(containerName, mountPoint); err != nil {
+			return err
+		}
+		if err := b.writeSizeReport(img, containerName, mountPoint); err != nil {
+			return err
+		}
+		if err := b.writeBootScripts(img); err != nil {
+			return err
+		}
+		if err := b.buildNodeOverlays(); err != nil {
+			return err
+		}
+		if b.config.Seed != nil && b.config.Seed.InArtifacts() {
+			seedFiles, err := b.writeSeed(b.workDir, false)
+			if err != nil {
+				return err
+			}
+			for _, path := range seedFiles {
+				b.addArtifact(path)
+			}
+		}
+		return b.runArtifactGenerators(mountPoint)
+	}); err != nil {
+		return err
+	}
+
+	// Scan the rootfs for vulnerabilities before anything is published
+	if b.config.Options.Scan.Scanner != "" {
+		log.Info("--> Scanning for vulnerabilities")
+		if err := b.stage("scan", func() error {
+			return b.scanRootfs(mountPoint)
+		}); err != nil {
+			return err
+		}
+	}
+	if err := b.writeChecksums(); err != nil {
 		return err
 	}
 
 	// 4. Push the image to a registry if specified
 	if b.config.Options.PublishRegistry != "" {
 		log.Info("--> Pushing image to registry")
-		if err := img.Push(); err != nil {
-			return fmt.Errorf("failed to push image: %w", err)
+		if err := b.stage("push", func() error {
+			if err := img.Push(); err != nil {
+				return pushError(fmt.Errorf("failed to push image: %w", err))
+			}
+			if b.scanReport != "" {
+				if err := img.AttachReport(b.scanReport, scanners[b.config.Options.Scan.Scanner].artifactType); err != nil {
+					return fmt.Errorf("failed to attach scan report: %w", err)
+				}
+			}
+			if b.hardenReport != "" {
+				if err := img.AttachReport(b.hardenReport, hardenArtifactType); err != nil {
+					return fmt.Errorf("failed to attach hardening results: %w", err)
+				}
+			}
+			for _, overlay := range b.nodeOverlays {
+				if err := img.AttachNodeOverlay(overlay); err != nil {
+					return fmt.Errorf("failed to attach node overlay: %w", err)
+				}
+			}
+			if b.config.Options.PublishBootArtifacts && b.kernelVersion != "" {
+				if err := b.attachBootFiles(img); err != nil {
+					return fmt.Errorf("failed to attach boot files: %w", err)
+				}
+			}
+			digest, err := img.Digest()
+			if err != nil {
+				log.Warnf("Failed to compute image digest: %v", err)
+			}
+			b.digest = digest
+			b.emit(events.Event{Type: events.Pushed, Reference: img.Name(), Digest: digest})
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		if b.config.BSS != nil {
+			log.Info("--> Registering image with the boot script service")
+			if err := b.stage("register", func() error {
+				if err := b.registerImage(img); err != nil {
+					return withClass(ErrRegister, fmt.Errorf("failed to register image: %w", err))
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
 		}
 	}
 
-	// 5. Final cleanup
+	// 5. Publish the boot artifacts to HTTP or TFTP servers if specified
+	if b.config.PublishHTTP != nil || b.config.PublishTFTP != nil {
+		log.Info("--> Publishing boot artifacts")
+		if err := b.stage("publish", func() error {
+			if err := b.publishArtifacts(img); err != nil {
+				return withClass(ErrPublish, fmt.Errorf("failed to publish artifacts: %w", err))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	// 6. Final cleanup
 	log.Info("--> Cleaning up build artifacts")
-	img.Cleanup()
+	if b.cleanupPolicy != KeepAlways {
+		img.Cleanup()
+	}
 	if err := b.pm.Cleanup(mountPoint); err != nil {
-		return fmt.Errorf("failed to cleanup rootfs: %w", err)
+		return fmt.Errorf("failed to cleanup rootfs (see %s): %w", b.logPath(b.config.Options.PkgManager), err)
 	}
 
 	log.Info("Image build completed successfully")
 	return nil
 }
 
+// logPath returns the path of the log file of a build stage
+func (b *Builder) logPath(stage string) string {
+	return filepath.Join(b.workDir, "logs", stage+".log")
+}
+
+// openLog creates the log file of a build stage under <workDir>/logs
+func (b *Builder) openLog(stage string) (*os.File, error) {
+	path := b.logPath(stage)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s log: %w", stage, err)
+	}
+	return f, nil
+}
+
+// reportKept logs where the artifacts of a build that were not cleaned up can be found
+func (b *Builder) reportKept(containerName, mountPoint string) {
+	log.Warnf("Keeping build container %s, remove it with 'go-image-builder gc' when done", containerName)
+	log.Warnf("Rootfs: %s", mountPoint)
+	if b.img == nil {
+		return
+	}
+	for _, dir := range b.img.TempDirs() {
+		log.Warnf("Temporary layer directory: %s", dir)
+	}
+}
+
 // setupContainer prepares the base container for the build, either by pulling a parent image
 // or creating a new one from scratch. It returns the container name and mount point.
 func (b *Builder) setupContainer() (containerName, mountPoint string, err error) {
 	if b.config.Options.Parent != "" && b.config.Options.Parent != "scratch" {
 		log.Infof("Pulling parent image: %s", b.config.Options.Parent)
 		if err = b.oci.PullParentImage(); err != nil {
-			return "", "", fmt.Errorf("failed to pull parent image: %w", err)
+			return "", "", parentError(fmt.Errorf("failed to pull parent image: %w", err))
 		}
 		log.Debug("Parent image pulled successfully")
 
 		log.Info("Mounting parent image")
+		// The parent stays mounted until the container is cleaned up at the end of the build
 		if err = b.oci.MountParent(); err != nil {
 			return "", "", fmt.Errorf("failed to mount parent image: %w", err)
 		}
-		// Defer unmount until the end of the build process
-		defer b.oci.UnmountParent()
 
 		mountPoint = b.oci.GetParentMountPoint()
 		containerName = b.oci.GetParentContainer()
 		if mountPoint == "" || containerName == "" {
-			return "", "", fmt.Errorf("got empty mount point or container name from parent image")
+			return containerName, "", fmt.Errorf("got empty mount point or container name from parent image")
 		}
 	} else {
 		log.Info("Starting from scratch")
@@ -132,10 +595,10 @@ func (b *Builder) setupContainer() (containerName, mountPoint string, err error)
 
 		mountPoint, err = b.oci.MountContainer(containerName)
 		if err != nil {
-			return "", "", fmt.Errorf("failed to mount container: %w", err)
+			return containerName, "", fmt.Errorf("failed to mount container: %w", err)
 		}
 		if mountPoint == "" {
-			return "", "", fmt.Errorf("got empty mount point from container %s", containerName)
+			return containerName, "", fmt.Errorf("got empty mount point from container %s", containerName)
 		}
 	}
 	return containerName, mountPoint, nil
@@ -143,12 +606,39 @@ func (b *Builder) setupContainer() (containerName, mountPoint string, err error)
 
 // customizeContainer runs through all the steps to configure the rootfs, including
 // package installation, repository configuration, file copying, and running commands.
-func (b *Builder) customizeContainer(containerName, mountPoint string) error {
+func (b *Builder) customizeContainer(containerName, mountPoint string) (err error) {
+	// Name resolution for the build must not end up in the image
+	restoreNetwork, err := b.setupNetworkFiles(mountPoint)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr := restoreNetwork(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}()
+
 	// Only initialize package manager if there are packages to install.
 	if len(b.config.Packages) > 0 || len(b.config.PackageGroups) > 0 {
+		// Without dnf on the host, scratch builds start from a base image that has it
+		parent := b.config.Options.Parent
+		if (parent == "" || parent == "scratch") && !pkgmgr.HostDNF() {
+			if err := b.bootstrapRootfs(mountPoint); err != nil {
+				return err
+			}
+		}
+
 		log.Info("Initializing rootfs with package manager")
 		if err := b.pm.InitRootfs(mountPoint, *b.config); err != nil {
-			return fmt.Errorf("failed to initialize rootfs: %w", err)
+			return withClass(ErrPackageInstall, fmt.Errorf("failed to initialize rootfs (see %s): %w", b.logPath(b.config.Options.PkgManager), err))
+		}
+
+		// Private CAs must be trusted before packages are fetched from internal mirrors
+		if len(b.config.CACerts) > 0 {
+			log.Info("Installing CA certificates")
+			if err := b.pm.InstallCACerts(mountPoint, b.config.CACerts); err != nil {
+				return fmt.Errorf("failed to install CA certificates: %w", err)
+			}
 		}
 
 		log.Info("Adding repositories")
@@ -158,10 +648,32 @@ func (b *Builder) customizeContainer(containerName, mountPoint string) error {
 
 		log.Info("Installing packages and groups")
 		if err := b.pm.InstallPackages(mountPoint, b.config.Packages, b.config.PackageGroups); err != nil {
-			return fmt.Errorf("failed to install packages: %w", err)
+			return withClass(ErrPackageInstall, fmt.Errorf("failed to install packages (see %s): %w", b.logPath(b.config.Options.PkgManager), err))
 		}
 	} else {
 		log.Info("Skipping package manager setup as no packages are defined.")
+
+		if len(b.config.CACerts) > 0 {
+			log.Info("Installing CA certificates")
+			if err := b.pm.InstallCACerts(mountPoint, b.config.CACerts); err != nil {
+				return fmt.Errorf("failed to install CA certificates: %w", err)
+			}
+		}
+	}
+
+	// Build stage artifacts go before copyfiles and commands so both can adjust them
+	if b.config.BuildStage != nil {
+		log.Info("Running build stage")
+		if err := b.runBuildStage(mountPoint); err != nil {
+			return err
+		}
+	}
+
+	// Merged images go before copyfiles and commands so both can adjust what they provide
+	if len(b.config.MergeImages) > 0 {
+		if err := b.mergeImages(mountPoint); err != nil {
+			return err
+		}
 	}
 
 	if len(b.config.CopyFiles) > 0 {
@@ -171,15 +683,39 @@ func (b *Builder) customizeContainer(containerName, mountPoint string) error {
 		}
 	}
 
+	if b.config.Seed != nil && b.config.Seed.InImage() {
+		log.Info("Writing provisioning seed into rootfs")
+		if _, err := b.writeSeed(mountPoint, true); err != nil {
+			return err
+		}
+	}
+
+	if b.config.KernelModules != nil {
+		log.Info("Building kernel modules")
+		if err := b.buildKernelModules(containerName, mountPoint); err != nil {
+			return err
+		}
+	}
+
 	if len(b.config.Cmds) > 0 {
 		log.Info("Running post-install commands")
 		for _, cmd := range b.config.Cmds {
 			log.Infof("Running command: %s", cmd.Cmd)
-			if err := b.pm.RunCommand(b.oci, containerName, cmd.Cmd); err != nil {
-				return fmt.Errorf("failed to run command '%s': %w", cmd.Cmd, err)
+			opts := oci.RunOptions{Network: cmd.Network, Proxy: cmd.Proxy}
+			if err := b.oci.RunCommandWithOptions(containerName, cmd.Cmd, opts); err != nil {
+				return withClass(ErrCommand, fmt.Errorf("failed to run command '%s': %w", cmd.Cmd, err))
 			}
 		}
 	}
+
+	// Minimizing comes last so files added by merged images and commands are removed too
+	minimize := b.config.Options.Minimize
+	if minimize.NoDocs || len(minimize.Locales) > 0 || len(minimize.FirmwareExclude) > 0 {
+		log.Info("Minimizing rootfs")
+		if err := b.minimize(mountPoint); err != nil {
+			return fmt.Errorf("failed to minimize rootfs: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -189,42 +725,28 @@ func (b *Builder) packageImage(containerName, mountPoint string) (*image.Image,
 	var kernelVersion string
 	var err error
 
+	if b.config.NFSRoot != nil {
+		if err := b.writeNFSFstab(mountPoint); err != nil {
+			return nil, err
+		}
+	}
+
 	if b.shouldCreateInitrd {
 		kernelVersion, err = b.getKernelVersion(containerName)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get kernel version: %w", err)
 		}
+		b.kernelVersion = kernelVersion
 	}
 
-	// Load parent image from local storage if it exists.
+	// Load the parent image if there is one.
 	var parentImage v1.Image
 	var parentArchivePath string
 	if b.config.Options.Parent != "" && b.config.Options.Parent != "scratch" {
-		log.Infof("Loading parent image '%s' from local storage.", b.config.Options.Parent)
-
-		// Create a temporary file in the working directory to ensure adequate space.
-		tempArchive, err := os.CreateTemp(b.workDir, "parent-image-*.tar")
-		if err != nil {
-			return nil, fmt.Errorf("failed to create temporary archive file: %w", err)
-		}
-		// This file must persist until the push is complete. It will be removed
-		// by the image.Cleanup() method.
-		parentArchivePath = tempArchive.Name()
-		tempArchive.Close() // Close the file so buildah can write to it.
-
-		// Save the image from buildah's storage to the archive.
-		if err := b.oci.SaveImage(b.config.Options.Parent, parentArchivePath); err != nil {
-			os.Remove(parentArchivePath) // Clean up on failure.
-			return nil, fmt.Errorf("failed to save parent image to archive: %w", err)
-		}
-
-		// Load the image into a v1.Image object.
-		parentImage, err = tarball.ImageFromPath(parentArchivePath, nil)
+		parentImage, parentArchivePath, err = b.loadParentImage()
 		if err != nil {
-			os.Remove(parentArchivePath) // Clean up on failure.
-			return nil, fmt.Errorf("failed to load parent image from archive: %w", err)
+			return nil, err
 		}
-		log.Debug("Successfully loaded parent image.")
 	}
 
 	log.Info("Creating OCI image with layers")
@@ -232,10 +754,31 @@ func (b *Builder) packageImage(containerName, mountPoint string) (*image.Image,
 	if err != nil {
 		return nil, fmt.Errorf("failed to create image: %w", err)
 	}
+	b.img = img
+	img.SetLayerCache(b.layerCache)
+	img.SetBlobCache(b.blobCache)
+	img.SetTempDir(b.tempDir())
+	img.SetForce(b.force)
+	img.SetProgress(b.progress)
+	provenance, err := b.provenance()
+	if err != nil {
+		return nil, err
+	}
+	img.SetProvenance(provenance)
 
-	if err = img.AddBaseLayer(mountPoint); err != nil {
+	if b.baseLayerTar != "" {
+		err = img.AddBaseLayerFromTar(b.baseLayerTar)
+	} else {
+		err = img.AddBaseLayer(mountPoint)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("failed to add base layer: %w", err)
 	}
+	if b.gpuLayerTar != "" {
+		if err := img.AddGPULayer(b.gpuLayerTar, b.config.GPU.Vendor, b.gpuDriverVersion); err != nil {
+			return nil, fmt.Errorf("failed to add GPU driver layer: %w", err)
+		}
+	}
 
 	if err := img.AddConfigLayer(); err != nil {
 		return nil, fmt.Errorf("failed to add config layer: %w", err)
@@ -249,79 +792,399 @@ func (b *Builder) packageImage(containerName, mountPoint string) (*image.Image,
 		}
 
 		var initrdPath string
-		if !hasInitrd {
-			log.Info("Parent does not have an initrd layer. Generating a new one.")
-			if err := b.generateInitrd(containerName, kernelVersion); err != nil {
-				return nil, fmt.Errorf("failed to generate initrd: %w", err)
+		if !hasInitrd || b.modulesBuilt {
+			if hasInitrd {
+				log.Info("Regenerating the initrd of the parent image to include the built kernel modules.")
+			} else {
+				log.Info("Parent does not have an initrd layer. Generating a new one.")
 			}
+			if b.config.Options.Dracut == "host" {
+				initrdPath, err = b.generateInitrdOnHost(mountPoint, kernelVersion)
+				if err != nil {
+					return nil, fmt.Errorf("failed to generate initrd: %w", err)
+				}
+			} else {
+				if err := b.generateInitrd(containerName, kernelVersion); err != nil {
+					return nil, fmt.Errorf("failed to generate initrd: %w", err)
+				}
 
-			// After generating it, find its path for the layer creation.
-			initrdPath = filepath.Join(mountPoint, "boot", fmt.Sprintf("initramfs-%s.img", kernelVersion))
-			if _, err := os.Stat(initrdPath); os.IsNotExist(err) {
-				initrdPath = filepath.Join(mountPoint, "boot", "initrd.img")
-				if _, err := os.Stat(initrdPath); os.IsNotExist(err) {
+				// After generating it, find its path for the layer creation.
+				initrdPath, err = b.findInitrd(mountPoint, kernelVersion)
+				if err != nil {
 					return nil, fmt.Errorf("failed to find initrd file after generating it: %w", err)
 				}
 			}
+			b.dracutModules = b.listDracutModules(containerName, mountPoint, initrdPath)
+			// Keep a copy next to the kernel for provisioning tools
+			if err := copyFile(initrdPath, filepath.Join(b.workDir, "initrd.img")); err != nil {
+				return nil, fmt.Errorf("failed to copy initrd: %w", err)
+			}
+			b.addArtifact(filepath.Join(b.workDir, "initrd.img"))
 		} else {
 			log.Info("Found initrd layer in parent image. Skipping generation.")
 			// initrdPath remains an empty string, which is handled correctly by AddInitrdLayer.
 		}
 
-		// Only extract the kernel if we are building from scratch.
-		if b.config.Options.Parent == "" || b.config.Options.Parent == "scratch" {
-			log.Info("Extracting kernel for scratch build")
+		// Without boot layers the kernel of a parent isn't in the image, and the kernel
+		// artifact comes from the rootfs
+		extract := b.config.Options.SkipBootLayers
+		if !extract {
+			extract, err = needsKernel(img)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if extract {
+			log.Info("Extracting kernel from rootfs")
 			if err := b.extractKernel(containerName, kernelVersion); err != nil {
 				return nil, fmt.Errorf("failed to extract kernel: %w", err)
 			}
+			b.addArtifact(filepath.Join(b.workDir, "kernel"))
 		}
 
-		kernelPath := filepath.Join(b.rootfs, "..", "kernel")
-		if err := img.AddKernelLayer(kernelPath, kernelVersion); err != nil {
-			return nil, fmt.Errorf("failed to add kernel layer: %w", err)
-		}
-		if err := img.AddInitrdLayer(initrdPath); err != nil {
-			return nil, fmt.Errorf("failed to add initrd layer: %w", err)
+		if b.config.Options.SkipBootLayers {
+			log.Info("Leaving the kernel and initrd out of the image as per configuration")
+			if err := img.SetKernelVersion(kernelVersion); err != nil {
+				return nil, err
+			}
+		} else {
+			kernelPath := filepath.Join(b.rootfs, "..", "kernel")
+			if err := img.AddKernelLayer(kernelPath, kernelVersion); err != nil {
+				return nil, fmt.Errorf("failed to add kernel layer: %w", err)
+			}
+			if err := img.AddInitrdLayer(initrdPath); err != nil {
+				return nil, fmt.Errorf("failed to add initrd layer: %w", err)
+			}
 		}
 	}
 
-	if b.shouldCreateSquashfs {
+	if b.config.Options.Squash {
+		if err := img.Squash(); err != nil {
+			return nil, fmt.Errorf("failed to squash image: %w", err)
+		}
+	}
+	if b.shouldCreateSquashfs && b.config.NFSRoot != nil {
+		log.Info("Creating rootfs tarball for NFS root")
+		if err := b.createRootfsTarball(mountPoint); err != nil {
+			return nil, fmt.Errorf("failed to create rootfs tarball: %w", err)
+		}
+		b.addArtifact(filepath.Join(b.workDir, "rootfs.tar.gz"))
+	} else if b.shouldCreateSquashfs {
 		log.Info("Creating squashfs image")
 		if err := b.createSquashfs(mountPoint); err != nil {
 			return nil, fmt.Errorf("failed to create squashfs: %w", err)
 		}
+		b.addArtifact(filepath.Join(b.workDir, "image.squashfs"))
 	} else {
 		log.Debug("Skipping squashfs creation as per configuration")
 	}
+
+	bootLabels, err := b.bootLabels(mountPoint)
+	if err != nil {
+		return nil, err
+	}
+	if err := img.SetLabels(bootLabels); err != nil {
+		return nil, fmt.Errorf("failed to label image: %w", err)
+	}
+	if err := img.ApplyAnnotations(); err != nil {
+		return nil, fmt.Errorf("failed to annotate image: %w", err)
+	}
 	return img, nil
 }
 
+// loadParentImage loads the parent image for layering. The parent is read directly from its
+// registry, so layers are only fetched when they are needed, as long as the registry holds the
+// same image that was mounted from local storage. Otherwise the mounted parent is exported to
+// a docker-archive in the working directory instead; the returned archive path must be
+// removed once the image has been pushed.
+func (b *Builder) loadParentImage() (v1.Image, string, error) {
+	parent := b.config.Options.Parent
+	if archive, ok := b.config.Options.ParentArchive(); ok {
+		parentImage, err := oci.LoadArchive(archive)
+		if err != nil {
+			return nil, "", err
+		}
+		log.Infof("Using parent image from %s.", parent)
+		return parentImage, "", nil
+	}
+
+	// With the 'never' policy the parent must come from local storage only
+	ref, err := name.ParseReference(utils.SanitizeRegistryURL(parent), name.Insecure)
+	if err == nil && b.config.Options.ParentPullPolicy != "never" {
+		var registryOpts []string
+		if b.config.Options.PublishRegistry != "" {
+			registryOpts = b.config.Options.RegistryOptsPull
+		}
+		if opts, ok := remoteOptions(registryOpts); ok {
+			parentImage, err := remote.Image(ref, opts...)
+			if err == nil {
+				err = b.checkMountedParent(parentImage)
+			}
+			if err == nil {
+				log.Infof("Using parent image '%s' from its registry.", parent)
+				return parentImage, "", nil
+			}
+			log.Debugf("Not reading parent image '%s' from its registry: %v", parent, err)
+		}
+	}
+
+	log.Infof("Loading parent image '%s' from local storage.", parent)
+
+	// Create a temporary file in the temporary directory, which defaults to the working
+	// directory, to ensure adequate space.
+	tempArchive, err := os.CreateTemp(b.tempDir(), "parent-image-*.tar")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create temporary archive file: %w", err)
+	}
+	// This file must persist until the push is complete. It will be removed
+	// by the image.Cleanup() method.
+	parentArchivePath := tempArchive.Name()
+	tempArchive.Close() // Close the file so the backend can write to it.
+
+	// Save the image from local storage to the archive.
+	if err := b.oci.SaveImage(parent, parentArchivePath); err != nil {
+		os.Remove(parentArchivePath) // Clean up on failure.
+		return nil, "", fmt.Errorf("failed to save parent image to archive: %w", err)
+	}
+
+	// Load the image into a v1.Image object.
+	parentImage, err := tarball.ImageFromPath(parentArchivePath, nil)
+	if err != nil {
+		os.Remove(parentArchivePath) // Clean up on failure.
+		return nil, "", fmt.Errorf("failed to load parent image from archive: %w", err)
+	}
+	log.Debug("Successfully loaded parent image.")
+	return parentImage, parentArchivePath, nil
+}
+
+// checkMountedParent fails unless img is the parent image the rootfs was mounted from, which
+// may differ from the registry's when a local parent is used without pulling
+func (b *Builder) checkMountedParent(img v1.Image) error {
+	local, err := b.oci.ParentImageID()
+	if err != nil {
+		return err
+	}
+	config, err := img.ConfigName()
+	if err != nil {
+		return fmt.Errorf("failed to get parent image config digest: %w", err)
+	}
+	if config.Hex != local {
+		return fmt.Errorf("registry holds %.12s, but the mounted parent is %.12s", config.Hex, local)
+	}
+	return nil
+}
+
+// remoteOptions returns the options to read from a registry with, honouring the registry
+// options of a pull. The boolean is false if an option cannot be honoured that way.
+func remoteOptions(registryOpts []string) ([]remote.Option, bool) {
+	auth := remote.WithAuthFromKeychain(authn.DefaultKeychain)
+	var opts []remote.Option
+	for _, opt := range registryOpts {
+		key, value, _ := strings.Cut(strings.TrimLeft(opt, "-"), "=")
+		switch key {
+		case "tls-verify":
+			if value == "false" {
+				transport := http.DefaultTransport.(*http.Transport).Clone()
+				transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+				opts = append(opts, remote.WithTransport(transport))
+			}
+		case "creds":
+			username, password, _ := strings.Cut(value, ":")
+			auth = remote.WithAuth(&authn.Basic{Username: username, Password: password})
+		default:
+			return nil, false
+		}
+	}
+	return append(opts, auth), true
+}
+
+// needsKernel reports whether the kernel must be extracted from the rootfs. Scratch builds
+// always need it. A parent only provides one if it has a kernel layer, which parents that
+// were not built by go-image-builder, such as images made bootable with convert, lack.
+func needsKernel(img *image.Image) (bool, error) {
+	hasKernel, err := img.HasLayerWithComment("Kernel Layer")
+	if err != nil {
+		return false, fmt.Errorf("failed to check for kernel layer in parent: %w", err)
+	}
+	return !hasKernel, nil
+}
+
+// registerImage points the configured nodes at the pushed image
+func (b *Builder) registerImage(img *image.Image) error {
+	digest, err := img.Digest()
+	if err != nil {
+		return err
+	}
+
+	tag, err := primaryTag(img)
+	if err != nil {
+		return err
+	}
+
+	info := bss.ImageInfo{
+		Name:          b.config.Options.Name,
+		Registry:      b.config.Options.PublishRegistry,
+		Reference:     img.Name(),
+		Tag:           tag,
+		Digest:        digest,
+		KernelVersion: b.kernelVersion,
+		BootArgs:      b.bootArgs(),
+	}
+	if err := bss.Register(b.config.BSS, info); err != nil {
+		return err
+	}
+	log.Infof("Registered %s@%s with %s", info.Reference, digest, b.config.BSS.URL)
+	return nil
+}
+
+// publishArtifacts uploads the artifacts of the build to the configured HTTP server and
+// copies the kernel and initrd into the configured TFTP root
+func (b *Builder) publishArtifacts(img *image.Image) error {
+	info, err := b.artifactInfo(img)
+	if err != nil {
+		return err
+	}
+
+	if b.config.PublishHTTP != nil {
+		if err := publish.HTTP(b.stageCtx, b.config.PublishHTTP, info, b.artifacts); err != nil {
+			return err
+		}
+	}
+	if b.config.PublishTFTP != nil {
+		kernel, err := b.bootFile("kernel", img.ExtractKernel)
+		if err != nil {
+			return err
+		}
+		initrd, err := b.bootFile("initrd.img", img.ExtractInitrd)
+		if err != nil {
+			return err
+		}
+		if err := publish.TFTP(b.config.PublishTFTP, info, kernel, initrd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBootScripts renders the configured boot scripts into the working directory
+func (b *Builder) writeBootScripts(img *image.Image) error {
+	if b.config.BootScripts == nil {
+		return nil
+	}
+	info, err := b.artifactInfo(img)
+	if err != nil {
+		return err
+	}
+	labels, err := img.Labels()
+	if err != nil {
+		return err
+	}
+	scripts, err := publish.BootScripts(b.config.BootScripts, info, labels, b.workDir)
+	if err != nil {
+		return fmt.Errorf("failed to write boot scripts: %w", err)
+	}
+	for _, script := range scripts {
+		b.addArtifact(script)
+	}
+	return nil
+}
+
+// artifactInfo describes the build for publishing and boot script templates
+func (b *Builder) artifactInfo(img *image.Image) (publish.ArtifactInfo, error) {
+	tag, err := primaryTag(img)
+	if err != nil {
+		return publish.ArtifactInfo{}, err
+	}
+	return publish.ArtifactInfo{
+		Name:          b.config.Options.Name,
+		Tag:           tag,
+		KernelVersion: b.kernelVersion,
+		BootArgs:      b.bootArgs(),
+	}, nil
+}
+
+// attachBootFiles pushes the kernel and initrd of the image as artifacts referring to it
+func (b *Builder) attachBootFiles(img *image.Image) error {
+	kernel, err := b.bootFile("kernel", img.ExtractKernel)
+	if err != nil {
+		return err
+	}
+	initrd, err := b.bootFile("initrd.img", img.ExtractInitrd)
+	if err != nil {
+		return err
+	}
+	return img.AttachBootFiles(kernel, initrd)
+}
+
+// bootFile returns the path of a boot file in the working directory. Files the build did not
+// write there, because they came from the parent, are extracted from the image.
+func (b *Builder) bootFile(name string, extract func(string) error) (string, error) {
+	path := filepath.Join(b.workDir, name)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := extract(path); err != nil {
+		return "", fmt.Errorf("failed to extract %s from the image: %w", name, err)
+	}
+	return path, nil
+}
+
+// primaryTag returns the first publish tag of an image, or latest if it has none
+func primaryTag(img *image.Image) (string, error) {
+	tags, err := img.Tags()
+	if err != nil {
+		return "", err
+	}
+	if len(tags) == 0 {
+		return "latest", nil
+	}
+	return tags[0], nil
+}
+
 func (b *Builder) generateInitrd(containerName, kernelVersion string) error {
-	// Run dracut to generate initrd
-	dracutCmd := fmt.Sprintf("dracut --add \"dmsquash-live livenet network-manager\" --kver %s -N -f --logfile /tmp/dracut.log 2>/dev/null", kernelVersion)
-	if err := b.pm.RunCommand(b.oci, containerName, dracutCmd); err != nil {
-		return fmt.Errorf("failed to run dracut: %w", err)
+	// dracut runs inside the image, so it must have been installed there
+	if err := b.pm.RunCommand(b.oci, containerName, "command -v dracut >/dev/null"); err != nil {
+		return fmt.Errorf("dracut is not installed in the image, add dracut to packages, set options.dracut to 'host' or build with --initrd=false: %w", err)
 	}
 
-	// Show dracut log
-	logCmd := "echo DRACUT LOG:; cat /tmp/dracut.log"
-	if err := b.pm.RunCommand(b.oci, containerName, logCmd); err != nil {
-		return fmt.Errorf("failed to show dracut log: %w", err)
+	// Run dracut to generate initrd
+	dracutArgs := append([]string{"--add \"dmsquash-live livenet network-manager\""}, b.dracutArgs()...)
+	dracutCmd := fmt.Sprintf("dracut %s --kver %s -N -f --logfile /tmp/dracut.log 2>/dev/null", strings.Join(dracutArgs, " "), kernelVersion)
+	runErr := b.pm.RunCommand(b.oci, containerName, dracutCmd)
+
+	// Keep the dracut log whether or not it succeeded
+	logPath := b.logPath("dracut")
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := b.oci.CopyFromContainerWithCat(containerName, "/tmp/dracut.log", logPath); err != nil {
+		log.Warnf("Failed to copy dracut log: %v", err)
 	}
 
+	if runErr != nil {
+		return fmt.Errorf("failed to run dracut (see %s): %w", logPath, runErr)
+	}
 	return nil
 }
 
 func (b *Builder) createSquashfs(rootfs string) error {
 	outputPath := filepath.Join(b.rootfs, "..", "image.squashfs")
+	logFile, err := b.openLog("mksquashfs")
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
 	cmd := exec.Command("mksquashfs",
 		rootfs,
 		outputPath,
 		"-comp", "xz",
 		"-no-progress",
 	)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("mksquashfs failed: %w\nOutput: %s", err, string(output))
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("mksquashfs failed (see %s): %w", logFile.Name(), err)
 	}
 
 	return nil
@@ -329,10 +1192,7 @@ func (b *Builder) createSquashfs(rootfs string) error {
 
 func (b *Builder) extractKernel(containerName, kernelVersion string) error {
 	// Define potential paths for the kernel inside the container.
-	potentialPaths := []string{
-		fmt.Sprintf("/boot/vmlinuz-%s", kernelVersion),
-		fmt.Sprintf("/lib/modules/%s/vmlinuz", kernelVersion),
-	}
+	potentialPaths := image.KernelPaths(kernelVersion)
 
 	var kernelPathInContainer string
 	for _, path := range potentialPaths {
@@ -360,10 +1220,17 @@ func (b *Builder) extractKernel(containerName, kernelVersion string) error {
 	if err := b.oci.CopyFromContainerWithCat(containerName, kernelPathInContainer, kernelDestPathOnHost); err != nil {
 		return fmt.Errorf("failed to copy kernel from container: %w", err)
 	}
+	if !b.config.Options.KeepCompressedKernel {
+		if _, err := image.DecompressKernel(kernelDestPathOnHost); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+// getKernelVersion returns the kernel version the image boots: the highest version installed
+// in the container, or the highest matching options.kernel_version, an exact version or glob
 func (b *Builder) getKernelVersion(containerName string) (string, error) {
 	// Execute 'ls /lib/modules' inside the container to find kernel versions.
 	// This is more robust than reading from the host's view of the mount point.
@@ -373,18 +1240,91 @@ func (b *Builder) getKernelVersion(containerName string) (string, error) {
 		return "", fmt.Errorf("failed to list /lib/modules in container: %w", err)
 	}
 
-	// The output might contain multiple lines if multiple kernels are installed.
-	// We'll take the first non-empty one.
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		kernelVersion := strings.TrimSpace(line)
-		if kernelVersion != "" {
-			log.Debugf("Found kernel version: %s", kernelVersion)
-			return kernelVersion, nil
+	var versions []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if version := strings.TrimSpace(line); version != "" {
+			versions = append(versions, version)
 		}
 	}
+	if len(versions) == 0 {
+		return "", fmt.Errorf("could not determine kernel version: /lib/modules is empty or does not exist in container")
+	}
+
+	candidates := versions
+	if pattern := b.config.Options.KernelVersion; pattern != "" {
+		candidates = nil
+		for _, version := range versions {
+			if ok, _ := filepath.Match(pattern, version); ok {
+				candidates = append(candidates, version)
+			}
+		}
+		if len(candidates) == 0 {
+			return "", fmt.Errorf("no installed kernel matches options.kernel_version '%s', found: %s", pattern, strings.Join(versions, ", "))
+		}
+	}
+
+	// Newest first
+	slices.SortFunc(candidates, func(a, b string) int {
+		return compareVersions(b, a)
+	})
+	kernelVersion := candidates[0]
+	if len(candidates) > 1 {
+		log.Warnf("Found kernels %s, using %s. Set options.kernel_version to choose another.", strings.Join(candidates, ", "), kernelVersion)
+	}
+	log.Debugf("Found kernel version: %s", kernelVersion)
+	return kernelVersion, nil
+}
+
+// compareVersions compares two versions like rpm does, returning a negative number, zero or
+// a positive number when a is older than, the same as or newer than b. Versions are compared
+// segment by segment, numeric segments by value and alphabetic ones lexically, with numeric
+// segments newer than alphabetic ones.
+func compareVersions(a, b string) int {
+	as, bs := versionSegments(a), versionSegments(b)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, y := as[i], bs[i]
+		xNum, yNum := x[0] >= '0' && x[0] <= '9', y[0] >= '0' && y[0] <= '9'
+		switch {
+		case xNum && !yNum:
+			return 1
+		case !xNum && yNum:
+			return -1
+		case xNum:
+			x, y = strings.TrimLeft(x, "0"), strings.TrimLeft(y, "0")
+			if len(x) != len(y) {
+				return len(x) - len(y)
+			}
+		}
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// versionSegments splits a version into its runs of digits and of letters
+func versionSegments(v string) []string {
+	var segments []string
+	start := -1
+	for i := 0; i <= len(v); i++ {
+		if start >= 0 && (i == len(v) || !sameClass(v[start], v[i])) {
+			segments = append(segments, v[start:i])
+			start = -1
+		}
+		if i < len(v) && start < 0 && isAlnum(v[i]) {
+			start = i
+		}
+	}
+	return segments
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
 
-	return "", fmt.Errorf("could not determine kernel version: /lib/modules is empty or does not exist in container")
+func sameClass(a, b byte) bool {
+	digit := func(c byte) bool { return c >= '0' && c <= '9' }
+	return isAlnum(b) && digit(a) == digit(b)
 }
 
 func copyFile(src, dst string) error {
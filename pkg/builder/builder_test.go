@@ -0,0 +1,373 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go-image-builder/pkg/image"
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/oci/ocitest"
+	"go-image-builder/pkg/progress"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func newTestBuilder(t *testing.T, fake *ocitest.Fake) *Builder {
+	t.Helper()
+	config := &imageconfig.Config{}
+	config.Options.PkgManager = "dnf"
+	b, err := NewBuilderWithOCI(config, t.TempDir(), false, true, fake)
+	if err != nil {
+		t.Fatalf("NewBuilderWithOCI() error = %v", err)
+	}
+	return b
+}
+
+func TestGetKernelVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		pattern string
+		want    string
+		wantErr bool
+	}{
+		{name: "single kernel", output: "5.14.0-503.el9.x86_64\n", want: "5.14.0-503.el9.x86_64"},
+		{name: "leading blank line", output: "\n5.14.0-503.el9.x86_64\n6.1.0\n", want: "6.1.0"},
+		{name: "newest kernel", output: "5.14.0-503.40.1.el9_5.x86_64\n5.14.0-503.5.1.el9_5.x86_64\n5.14.0-70.13.1.el9_0.x86_64\n", want: "5.14.0-503.40.1.el9_5.x86_64"},
+		{name: "configured glob", output: "5.14.0-503.el9.x86_64\n5.14.0-427.el9.x86_64+rt\n", pattern: "*+rt", want: "5.14.0-427.el9.x86_64+rt"},
+		{name: "configured version missing", output: "5.14.0-503.el9.x86_64\n", pattern: "6.1.*", wantErr: true},
+		{name: "no kernels", output: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := ocitest.NewFake(t.TempDir())
+			fake.Outputs["ls /lib/modules"] = []byte(tt.output)
+			b := newTestBuilder(t, fake)
+			b.config.Options.KernelVersion = tt.pattern
+
+			got, err := b.getKernelVersion("container")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getKernelVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getKernelVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractKernel(t *testing.T) {
+	fake := ocitest.NewFake(t.TempDir())
+	fake.Files["/lib/modules/5.14.0/vmlinuz"] = []byte("kernel")
+	b := newTestBuilder(t, fake)
+
+	if err := b.extractKernel("container", "5.14.0"); err != nil {
+		t.Fatalf("extractKernel() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(b.workDir, "kernel"))
+	if err != nil {
+		t.Fatalf("failed to read extracted kernel: %v", err)
+	}
+	if string(data) != "kernel" {
+		t.Errorf("extracted kernel = %q, want %q", data, "kernel")
+	}
+
+	if err := b.extractKernel("container", "6.1.0"); err == nil {
+		t.Error("extractKernel() expected an error for a missing kernel")
+	}
+}
+
+func TestNew(t *testing.T) {
+	config := &imageconfig.Config{}
+	config.Options.Name = "compute"
+	config.Options.PkgManager = "dnf"
+	workDir := t.TempDir()
+	b, err := New(config, WithWorkDir(workDir), WithArtifacts(false, true), WithOCI(ocitest.NewFake(t.TempDir())), WithForce(true))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if b.workDir != workDir || b.rootfs != filepath.Join(workDir, "rootfs") || b.shouldCreateSquashfs || !b.shouldCreateInitrd || !b.force {
+		t.Errorf("New() did not apply its options: %+v", b)
+	}
+
+	if err := b.stage("setup", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	result := b.result(time.Second)
+	if result.Image != "compute" || len(result.Stages) != 1 || result.Stages[0].Stage != "setup" {
+		t.Errorf("result() = %+v, want the image name and the setup stage", result)
+	}
+
+	config.Options.PkgManager = "pacman"
+	if _, err := New(config, WithOCI(ocitest.NewFake(t.TempDir()))); err == nil {
+		t.Error("New() expected an error for an unsupported package manager")
+	}
+}
+
+// stageProgress records the stages reported to it
+type stageProgress struct {
+	progress.Log
+	stages []string
+}
+
+func (p *stageProgress) Stage(name string, n, total int) {
+	p.stages = append(p.stages, fmt.Sprintf("%s %d/%d", name, n, total))
+}
+
+func TestStageProgress(t *testing.T) {
+	b := newTestBuilder(t, ocitest.NewFake(t.TempDir()))
+	b.config.Tests = []imageconfig.ImageTest{{Cmd: "true"}}
+	b.config.Options.PublishRegistry = "registry.example.com"
+	reporter := &stageProgress{}
+	b.SetProgress(reporter)
+	b.planned = b.plannedStages()
+
+	for _, stage := range []string{"setup", "customize", "test", "package", "push"} {
+		if err := b.stage(stage, func() error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"setup 1/5", "customize 2/5", "test 3/5", "package 4/5", "push 5/5"}
+	if !reflect.DeepEqual(reporter.stages, want) {
+		t.Errorf("reported stages %v, want %v", reporter.stages, want)
+	}
+}
+
+func TestStageWaitsAfterTimeout(t *testing.T) {
+	b := newTestBuilder(t, ocitest.NewFake(t.TempDir()))
+	b.config.Options.StageTimeouts = map[string]string{"slow": "10ms"}
+
+	finished := false
+	err := b.stage("slow", func() error {
+		time.Sleep(50 * time.Millisecond)
+		finished = true
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrTimeout) {
+		t.Errorf("stage() error = %v, want deadline exceeded", err)
+	}
+	var serr *StageError
+	if !errors.As(err, &serr) || serr.Stage != "slow" {
+		t.Errorf("stage() error = %v, want a StageError of stage slow", err)
+	}
+	if !finished {
+		t.Error("stage() returned before the stage finished")
+	}
+}
+
+func TestCheckMountedParent(t *testing.T) {
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := img.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		local   string
+		wantErr bool
+	}{
+		{name: "same image", local: config.Hex},
+		{name: "newer registry image", local: strings.Repeat("0", 64), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := ocitest.NewFake(t.TempDir())
+			fake.ParentID = tt.local
+			b := newTestBuilder(t, fake)
+			if err := b.checkMountedParent(img); (err != nil) != tt.wantErr {
+				t.Errorf("checkMountedParent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRemoteOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []string
+		wantOK bool
+	}{
+		{name: "none", wantOK: true},
+		{name: "insecure", opts: []string{"--tls-verify=false"}, wantOK: true},
+		{name: "credentials", opts: []string{"--creds=user:pass"}, wantOK: true},
+		{name: "auth file", opts: []string{"--authfile=/run/auth.json"}, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := remoteOptions(tt.opts); ok != tt.wantOK {
+				t.Errorf("remoteOptions(%v) ok = %v, want %v", tt.opts, ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNeedsKernel(t *testing.T) {
+	withHistory := func(comments ...string) v1.Image {
+		img, err := random.Image(64, int64(len(comments)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := img.ConfigFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		config = config.DeepCopy()
+		config.History = nil
+		for _, comment := range comments {
+			config.History = append(config.History, v1.History{Comment: comment})
+		}
+		img, err = mutate.ConfigFile(img, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+
+	tests := []struct {
+		name   string
+		parent string
+		img    v1.Image
+		want   bool
+	}{
+		{name: "scratch", parent: "scratch", want: true},
+		{name: "parent with kernel layer", parent: "registry/base:latest", img: withHistory("Base Layer", "Kernel Layer", "Initrd Layer"), want: false},
+		{name: "container image parent", parent: "registry/rocky:9", img: withHistory("Base Layer"), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &imageconfig.Config{}
+			config.Options.Parent = tt.parent
+			img, err := image.NewImage("", "test", config, tt.img, "")
+			if err != nil {
+				t.Fatalf("NewImage() error = %v", err)
+			}
+			got, err := needsKernel(img)
+			if err != nil {
+				t.Fatalf("needsKernel() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("needsKernel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunTests(t *testing.T) {
+	root := t.TempDir()
+	fake := ocitest.NewFake(root)
+	fake.Outputs["systemctl is-enabled sshd"] = []byte("enabled\n")
+	b := newTestBuilder(t, fake)
+
+	gossFile := filepath.Join(t.TempDir(), "goss.yaml")
+	if err := os.WriteFile(gossFile, []byte("service:\n  sshd:\n    enabled: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b.config.Tests = []imageconfig.ImageTest{
+		{Name: "sshd", Cmd: "systemctl is-enabled sshd"},
+		{Cmd: "ls /boot/vmlinuz-*"},
+		{Goss: gossFile},
+	}
+
+	err := b.runTests("container", root)
+	if err == nil {
+		t.Fatal("runTests() expected an error for the failed tests")
+	}
+	if !errors.Is(err, ErrTests) || !strings.Contains(err.Error(), "2 of 3") || strings.Contains(err.Error(), "sshd") {
+		t.Errorf("runTests() error = %v, want the two failed tests only", err)
+	}
+
+	var ranGoss bool
+	for _, call := range fake.Calls() {
+		ranGoss = ranGoss || strings.Contains(call, "goss --gofile")
+	}
+	if !ranGoss {
+		t.Errorf("runTests() did not run goss: %v", fake.Calls())
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("goss file left in the rootfs: %v", entries)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"5.14.0-503.el9.x86_64", "5.14.0-503.el9.x86_64", 0},
+		{"6.1.0", "5.14.0", 1},
+		{"5.14.0-70.el9", "5.14.0-503.el9", -1},
+		{"5.14.0-503.el9_5", "5.14.0-503.el9", 1},
+		{"5.14.0-503.1", "5.14.0-503.a", 1},
+		{"5.14.0-0503", "5.14.0-503", 0},
+	}
+	for _, tt := range tests {
+		got := compareVersions(tt.a, tt.b)
+		if (got > 0) != (tt.want > 0) || (got < 0) != (tt.want < 0) {
+			t.Errorf("compareVersions(%q, %q) = %d, want sign of %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCustomizeContainerCmdOptions(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config, err := imageconfig.ParseConfig([]byte(`options:
+  layer_type: base
+  name: compute
+  pkg_manager: dnf
+  network: host
+cmds:
+  - cmd: systemctl enable sshd
+  - cmd: /opt/post-install.sh
+    network: none
+  - cmd: curl -fsSO https://mirror.internal/firmware.bin
+    proxy:
+      https: http://proxy.internal:3128
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := ocitest.NewFake(root)
+	b := newTestBuilder(t, fake)
+	b.config.Cmds = config.Cmds
+	if err := b.customizeContainer("ctr", root); err != nil {
+		t.Fatalf("customizeContainer() error = %v", err)
+	}
+
+	want := []string{
+		"RunCommandWithOptions ctr systemctl enable sshd",
+		"RunCommandWithOptions ctr /opt/post-install.sh network=none",
+		"RunCommandWithOptions ctr curl -fsSO https://mirror.internal/firmware.bin proxy=https_proxy=http://proxy.internal:3128,HTTPS_PROXY=http://proxy.internal:3128",
+	}
+	var got []string
+	for _, call := range fake.Calls() {
+		if strings.HasPrefix(call, "RunCommand") {
+			got = append(got, call)
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("customizeContainer() ran\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,92 @@
+package builder
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runBuildStage builds the build stage in a container of its own and copies its artifacts
+// onto root. The container is thrown away afterwards, unless the cleanup policy keeps it.
+func (b *Builder) runBuildStage(root string) (err error) {
+	stage := b.config.BuildStage
+	containerName, stageRoot, err := b.mountBuildStage()
+	defer func() {
+		if containerName == "" {
+			return
+		}
+		if b.cleanupPolicy == KeepAlways || (err != nil && b.cleanupPolicy == KeepOnFailure) {
+			b.reportKept(containerName, stageRoot)
+			return
+		}
+		if cerr := b.oci.Cleanup(containerName); cerr != nil {
+			log.Warnf("Failed to clean up build stage container %s: %v", containerName, cerr)
+		}
+	}()
+	if err != nil {
+		return fmt.Errorf("failed to set up build stage: %w", err)
+	}
+
+	restoreNetwork, err := b.setupNetworkFiles(stageRoot)
+	if err != nil {
+		return err
+	}
+	defer restoreNetwork()
+
+	if len(stage.Packages) > 0 || len(stage.PackageGroups) > 0 {
+		config := *b.config
+		config.Repositories = stage.Repositories
+		log.Info("Initializing build stage rootfs with package manager")
+		if err := b.pm.InitRootfs(stageRoot, config); err != nil {
+			return withClass(ErrPackageInstall, fmt.Errorf("failed to initialize build stage rootfs (see %s): %w", b.logPath(b.config.Options.PkgManager), err))
+		}
+		if len(b.config.CACerts) > 0 {
+			if err := b.pm.InstallCACerts(stageRoot, b.config.CACerts); err != nil {
+				return fmt.Errorf("failed to install CA certificates in build stage: %w", err)
+			}
+		}
+		log.Info("Installing build stage packages and groups")
+		if err := b.pm.InstallPackages(stageRoot, stage.Packages, stage.PackageGroups); err != nil {
+			return withClass(ErrPackageInstall, fmt.Errorf("failed to install build stage packages (see %s): %w", b.logPath(b.config.Options.PkgManager), err))
+		}
+	}
+
+	if len(stage.CopyFiles) > 0 {
+		log.Info("Copying files into build stage")
+		if err := b.pm.CopyFiles(stageRoot, stage.CopyFiles); err != nil {
+			return fmt.Errorf("failed to copy files into build stage: %w", err)
+		}
+	}
+
+	for _, cmd := range stage.Cmds {
+		log.Infof("Running build stage command: %s", cmd)
+		if err := b.pm.RunCommand(b.oci, containerName, cmd); err != nil {
+			return withClass(ErrCommand, fmt.Errorf("failed to run build stage command '%s': %w", cmd, err))
+		}
+	}
+
+	log.Infof("Copying %d build stage artifacts into rootfs", len(stage.Artifacts))
+	if err := mergePaths(stageRoot, root, stage.Artifacts); err != nil {
+		return fmt.Errorf("failed to copy build stage artifacts: %w", err)
+	}
+	return nil
+}
+
+// mountBuildStage creates and mounts the build stage container, from its parent or from
+// scratch. The container name is returned even if mounting fails, so it can be cleaned up.
+func (b *Builder) mountBuildStage() (containerName, mountPoint string, err error) {
+	parent := b.config.BuildStage.Parent
+	if parent != "" && parent != "scratch" {
+		log.Infof("Starting build stage from %s", parent)
+		return b.oci.MountImage(parent)
+	}
+
+	log.Info("Starting build stage from scratch")
+	if containerName, err = b.oci.CreateContainer(); err != nil {
+		return "", "", err
+	}
+	if mountPoint, err = b.oci.MountContainer(containerName); err != nil {
+		return containerName, "", err
+	}
+	return containerName, mountPoint, nil
+}
@@ -0,0 +1,97 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go-image-builder/pkg/events"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// checksumsFile is the name of the file listing the sha256 of every artifact in the working
+// directory, in the format read by sha256sum -c
+const checksumsFile = "SHA256SUMS"
+
+// addArtifact records a file written to the working directory for provisioning tools, so it
+// is covered by SHA256SUMS
+func (b *Builder) addArtifact(path string) {
+	b.artifacts = append(b.artifacts, path)
+	b.emit(events.Event{Type: events.Artifact, Path: path})
+}
+
+// writeChecksums writes SHA256SUMS for the artifacts of the build and, with a signing key, a
+// detached armored GPG signature of it as SHA256SUMS.asc
+func (b *Builder) writeChecksums() error {
+	if len(b.artifacts) == 0 {
+		return nil
+	}
+	path := filepath.Join(b.workDir, checksumsFile)
+	if err := writeChecksums(path, b.artifacts); err != nil {
+		return err
+	}
+	b.emit(events.Event{Type: events.Artifact, Path: path})
+	// The checksums are published along with the artifacts they cover
+	b.artifacts = append(b.artifacts, path)
+
+	if b.signKey == "" {
+		return nil
+	}
+	log.Infof("Signing %s with key %s", path, b.signKey)
+	output, err := exec.Command("gpg", "--batch", "--yes", "--local-user", b.signKey,
+		"--armor", "--detach-sign", "--output", path+".asc", path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to sign %s: %s: %w", path, strings.TrimSpace(string(output)), err)
+	}
+	b.emit(events.Event{Type: events.Artifact, Path: path + ".asc"})
+	b.artifacts = append(b.artifacts, path+".asc")
+	return nil
+}
+
+// writeChecksums writes the sha256 of files to path, one "<hex>  <name>" line per file.
+// Files are named relative to the directory holding path.
+func writeChecksums(path string, files []string) error {
+	dir := filepath.Dir(path)
+	files = append([]string(nil), files...)
+	sort.Strings(files)
+
+	var lines []string
+	for _, file := range files {
+		sum, err := sha256File(file)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			return fmt.Errorf("failed to name %s relative to %s: %w", file, dir, err)
+		}
+		lines = append(lines, fmt.Sprintf("%s  %s\n", sum, name))
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "")), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// sha256File returns the hex sha256 of a file
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,41 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteChecksums(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"kernel":         "kernel",
+		"image.squashfs": "",
+	}
+	var paths []string
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	path := filepath.Join(dir, checksumsFile)
+	if err := writeChecksums(path, paths); err != nil {
+		t.Fatalf("writeChecksums() error = %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855  image.squashfs\n" +
+		"6923dd1bc0460082c5d55a831908c24a282860b7f1cd6c2b79cf1bc8857c639c  kernel\n"
+	if string(got) != want {
+		t.Errorf("writeChecksums() wrote\n%s\nwant\n%s", got, want)
+	}
+
+	if err := writeChecksums(path, []string{filepath.Join(dir, "missing")}); err == nil {
+		t.Error("writeChecksums() of a missing file succeeded, want error")
+	}
+}
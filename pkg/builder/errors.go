@@ -0,0 +1,93 @@
+package builder
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"go-image-builder/pkg/oci"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// Failure classes of a build. Build errors wrap the class of their failure, if it has one,
+// so callers embedding the builder can tell failures apart with errors.Is.
+var (
+	ErrParentNotFound     = errors.New("parent image not found")
+	ErrParentVerification = errors.New("parent image failed verification")
+	ErrPackageInstall     = errors.New("package installation failed")
+	ErrCommand            = errors.New("command failed")
+	ErrHardening          = errors.New("hardening rules failed")
+	ErrTests              = errors.New("image tests failed")
+	ErrVulnerabilities    = errors.New("vulnerabilities found")
+	ErrPushAuth           = errors.New("registry authentication failed")
+	ErrPush               = errors.New("push failed")
+	ErrRegister           = errors.New("image registration failed")
+	ErrPublish            = errors.New("artifact publishing failed")
+	ErrTimeout            = errors.New("stage timed out")
+)
+
+// StageError is the error of a build that failed in one of its stages
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// classError attaches a failure class to an error without changing its message
+type classError struct {
+	class error
+	err   error
+}
+
+func (e *classError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classError) Unwrap() []error {
+	return []error{e.class, e.err}
+}
+
+// withClass marks err as a failure of class
+func withClass(class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classError{class: class, err: err}
+}
+
+// parentError classifies a failure to pull the parent image
+func parentError(err error) error {
+	var verr *oci.VerificationError
+	if errors.As(err, &verr) {
+		return withClass(ErrParentVerification, err)
+	}
+	var terr *transport.Error
+	if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+		return withClass(ErrParentNotFound, err)
+	}
+	// Backends report missing images in their output only
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"not found", "manifest unknown", "name unknown"} {
+		if strings.Contains(msg, s) {
+			return withClass(ErrParentNotFound, err)
+		}
+	}
+	return err
+}
+
+// pushError classifies a failure to push the image
+func pushError(err error) error {
+	var terr *transport.Error
+	if errors.As(err, &terr) && (terr.StatusCode == http.StatusUnauthorized || terr.StatusCode == http.StatusForbidden) {
+		return withClass(ErrPushAuth, err)
+	}
+	return withClass(ErrPush, err)
+}
@@ -0,0 +1,47 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"go-image-builder/pkg/oci"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+func TestErrorClasses(t *testing.T) {
+	unauthorized := &transport.Error{StatusCode: http.StatusUnauthorized}
+	tests := []struct {
+		name  string
+		err   error
+		class error
+	}{
+		{name: "missing parent", err: parentError(errors.New("reading manifest 9 in registry/base: manifest unknown")), class: ErrParentNotFound},
+		{name: "parent not in registry", err: parentError(fmt.Errorf("failed to pull: %w", &transport.Error{StatusCode: http.StatusNotFound})), class: ErrParentNotFound},
+		{name: "unverified parent", err: parentError(&oci.VerificationError{Image: "base", Method: "cosign", Err: errors.New("no signatures")}), class: ErrParentVerification},
+		{name: "other pull failure", err: parentError(errors.New("connection refused"))},
+		{name: "push unauthorized", err: pushError(fmt.Errorf("failed to push: %w", unauthorized)), class: ErrPushAuth},
+		{name: "push failure", err: pushError(errors.New("connection refused")), class: ErrPush},
+	}
+	classes := []error{ErrParentNotFound, ErrParentVerification, ErrPushAuth, ErrPush}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, class := range classes {
+				if got := errors.Is(tt.err, class); got != (class == tt.class) {
+					t.Errorf("errors.Is(%v, %v) = %v", tt.err, class, got)
+				}
+			}
+		})
+	}
+
+	err := withClass(ErrPush, fmt.Errorf("failed to push image: %w", unauthorized))
+	if err.Error() != "failed to push image: "+unauthorized.Error() {
+		t.Errorf("withClass() changed the message to %q", err.Error())
+	}
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		t.Error("withClass() hides the wrapped error from errors.As")
+	}
+}
@@ -0,0 +1,63 @@
+package builder
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runArtifactGenerators runs the configured artifact generators against the rootfs. Each
+// writes to a directory of its own in the working directory, and every file it leaves there
+// becomes an artifact of the build.
+func (b *Builder) runArtifactGenerators(rootfs string) error {
+	for _, generator := range b.config.ArtifactGenerators {
+		outputDir := filepath.Join(b.workDir, generator.Name)
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory of generator %s: %w", generator.Name, err)
+		}
+
+		logFile, err := b.openLog(generator.Name)
+		if err != nil {
+			return err
+		}
+		log.Infof("Running artifact generator %s", generator.Name)
+		args := append(append([]string{}, generator.Args...), rootfs, outputDir)
+		cmd := exec.CommandContext(b.stageCtx, generator.Command, args...)
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+		err = cmd.Run()
+		logFile.Close()
+		if err != nil {
+			return fmt.Errorf("artifact generator %s failed (see %s): %w", generator.Name, logFile.Name(), err)
+		}
+
+		artifacts, err := generatedFiles(outputDir)
+		if err != nil {
+			return fmt.Errorf("failed to read artifacts of generator %s: %w", generator.Name, err)
+		}
+		log.Infof("Artifact generator %s wrote %d artifacts", generator.Name, len(artifacts))
+		for _, artifact := range artifacts {
+			b.addArtifact(artifact)
+		}
+	}
+	return nil
+}
+
+// generatedFiles returns the regular files below dir in lexical order
+func generatedFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,48 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/oci/ocitest"
+)
+
+func TestRunArtifactGenerators(t *testing.T) {
+	root := t.TempDir()
+	b := newTestBuilder(t, ocitest.NewFake(root))
+	b.stageCtx = context.Background()
+
+	script := filepath.Join(t.TempDir(), "vnfs.sh")
+	content := "#!/bin/sh\nmkdir -p \"$3/chroot\"\necho \"$1 $2\" > \"$3/chroot/rootfs\"\necho vnfs > \"$3/image.vnfs\"\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	b.config.ArtifactGenerators = []imageconfig.ArtifactGenerator{
+		{Name: "vnfs", Command: script, Args: []string{"--compress"}},
+	}
+
+	if err := b.runArtifactGenerators(root); err != nil {
+		t.Fatalf("runArtifactGenerators() error = %v", err)
+	}
+	outputDir := filepath.Join(b.workDir, "vnfs")
+	want := []string{filepath.Join(outputDir, "chroot", "rootfs"), filepath.Join(outputDir, "image.vnfs")}
+	if !reflect.DeepEqual(b.artifacts, want) {
+		t.Errorf("artifacts = %v, want %v", b.artifacts, want)
+	}
+	data, err := os.ReadFile(want[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "--compress "+root+"\n" {
+		t.Errorf("generator arguments = %q, want the args followed by the rootfs", got)
+	}
+
+	b.config.ArtifactGenerators[0].Command = "false"
+	if err := b.runArtifactGenerators(root); err == nil {
+		t.Error("runArtifactGenerators() expected an error for a failing generator")
+	}
+}
@@ -0,0 +1,147 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"go-image-builder/pkg/image"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// gpuModules are the kernel modules the driver version of each vendor is read from
+var gpuModules = map[string]string{
+	"nvidia": "nvidia",
+	"amd":    "amdgpu",
+}
+
+// installGPUDrivers installs the GPU drivers into the rootfs. The base layer is taken from
+// the rootfs before they are installed, and the files the installation changed become the
+// GPU driver layer. Both tarballs are written to layerDir.
+func (b *Builder) installGPUDrivers(containerName, mountPoint, layerDir string) (err error) {
+	gpu := b.config.GPU
+	kernelVersion := gpu.KernelVersion
+	if kernelVersion == "" {
+		if kernelVersion, err = b.getKernelVersion(containerName); err != nil {
+			return fmt.Errorf("failed to get kernel version: %w", err)
+		}
+	}
+
+	log.Info("Creating base layer before installing GPU drivers")
+	baseLayer := filepath.Join(layerDir, "base.tar")
+	sourceDate := b.config.Options.SourceDate()
+	args := append([]string{"-cf", baseLayer}, image.TarArgs(sourceDate)...)
+	if output, err := exec.Command("tar", append(args, "-C", mountPoint, ".")...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create base layer: %w\nOutput: %s", err, output)
+	}
+	snapshot, err := snapshotRootfs(mountPoint)
+	if err != nil {
+		return err
+	}
+
+	if err := b.installGPUPackages(containerName, mountPoint, kernelVersion); err != nil {
+		return err
+	}
+
+	log.Info("Creating GPU driver layer")
+	gpuLayer := filepath.Join(layerDir, "gpu.tar")
+	if err := writeLayerDiff(mountPoint, snapshot, gpuLayer, sourceDate); err != nil {
+		return err
+	}
+	b.baseLayerTar = baseLayer
+	b.gpuLayerTar = gpuLayer
+
+	output, err := b.oci.RunCommandWithOutput(containerName, fmt.Sprintf("modinfo -k %s -F version %s", kernelVersion, gpuModules[gpu.Vendor]))
+	if err != nil {
+		log.Warnf("Failed to read the GPU driver version, the image will not be labeled with it: %v", err)
+	} else {
+		b.gpuDriverVersion = strings.TrimSpace(string(output))
+		log.Infof("Installed %s GPU driver %s", gpu.Vendor, b.gpuDriverVersion)
+	}
+
+	// The initrd of a parent image lacks the new modules
+	b.modulesBuilt = true
+	return nil
+}
+
+// installGPUPackages installs the driver packages or runfile and the container toolkit.
+// Name resolution is set up only for the installation, so it stays out of the GPU layer.
+func (b *Builder) installGPUPackages(containerName, mountPoint, kernelVersion string) (err error) {
+	gpu := b.config.GPU
+	restoreNetwork, err := b.setupNetworkFiles(mountPoint)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr := restoreNetwork(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}()
+
+	if len(gpu.Repos) > 0 {
+		if err := b.pm.AddRepos(mountPoint, gpu.Repos); err != nil {
+			return fmt.Errorf("failed to add GPU driver repositories: %w", err)
+		}
+	}
+
+	if len(gpu.Packages) > 0 {
+		log.Infof("Installing %d GPU driver packages", len(gpu.Packages))
+		if err := b.pm.InstallPackages(mountPoint, gpu.Packages, nil); err != nil {
+			return withClass(ErrPackageInstall, fmt.Errorf("failed to install GPU driver packages (see %s): %w", b.logPath(b.config.Options.PkgManager), err))
+		}
+	} else {
+		if err := b.runNVIDIAInstaller(containerName, mountPoint, kernelVersion); err != nil {
+			return err
+		}
+	}
+
+	// Packaged drivers may ship as DKMS modules, whose scriptlets build for the host's kernel
+	commands := []string{
+		fmt.Sprintf("if command -v dkms >/dev/null; then dkms autoinstall -k %s; fi", kernelVersion),
+		fmt.Sprintf("depmod -a %s", kernelVersion),
+	}
+
+	if gpu.ContainerRuntime != "" {
+		log.Info("Installing NVIDIA container toolkit")
+		if err := b.pm.InstallPackages(mountPoint, []string{"nvidia-container-toolkit"}, nil); err != nil {
+			return withClass(ErrPackageInstall, fmt.Errorf("failed to install NVIDIA container toolkit (see %s): %w", b.logPath(b.config.Options.PkgManager), err))
+		}
+		commands = append(commands, fmt.Sprintf("nvidia-ctk runtime configure --runtime=%s", gpu.ContainerRuntime))
+	}
+
+	for _, command := range commands {
+		log.Infof("Running command: %s", command)
+		if err := b.pm.RunCommand(b.oci, containerName, command); err != nil {
+			return fmt.Errorf("failed to install GPU drivers: %w", err)
+		}
+	}
+	return nil
+}
+
+// runNVIDIAInstaller runs an NVIDIA .run installer in the container for the given kernel.
+// The installer is copied into the rootfs for the run and removed afterwards.
+func (b *Builder) runNVIDIAInstaller(containerName, mountPoint, kernelVersion string) error {
+	runfile := b.config.GPU.Runfile
+	data, err := os.ReadFile(runfile)
+	if err != nil {
+		return fmt.Errorf("failed to read NVIDIA installer: %w", err)
+	}
+	dir, err := os.MkdirTemp(mountPoint, ".nvidia-")
+	if err != nil {
+		return fmt.Errorf("failed to create installer directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "installer.run"), data, 0755); err != nil {
+		return fmt.Errorf("failed to copy NVIDIA installer: %w", err)
+	}
+
+	log.Infof("Running NVIDIA installer %s", filepath.Base(runfile))
+	command := fmt.Sprintf("sh /%s/installer.run --silent --no-questions --ui=none --kernel-name=%s", filepath.Base(dir), kernelVersion)
+	if err := b.pm.RunCommand(b.oci, containerName, command); err != nil {
+		return fmt.Errorf("failed to run NVIDIA installer: %w", err)
+	}
+	return nil
+}
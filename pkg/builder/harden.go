@@ -0,0 +1,99 @@
+package builder
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Files the hardening stage writes to the working directory
+const (
+	hardenResultsFile = "hardening-results.xml"
+	hardenReportFile  = "hardening-report.html"
+)
+
+// hardenArtifactType is the artifact type of the XCCDF results attached to pushed images
+const hardenArtifactType = "application/vnd.openchami.image.xccdf-results+xml"
+
+// hardenRootfs remediates the rootfs with the configured OpenSCAP profile, using the
+// oscap-chroot of the build host so the image doesn't need OpenSCAP. The XCCDF results and
+// the HTML report are kept with the build artifacts. It fails if rules still fail after
+// remediation and options.harden.fail_on_failed is set.
+func (b *Builder) hardenRootfs(rootfs string) error {
+	harden := b.config.Options.Harden
+	results := filepath.Join(b.workDir, hardenResultsFile)
+	report := filepath.Join(b.workDir, hardenReportFile)
+
+	logFile, err := b.openLog("harden")
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	args := []string{rootfs, "xccdf", "eval", "--remediate", "--profile", harden.Profile, "--results", results, "--report", report}
+	if harden.Tailoring != "" {
+		args = append(args, "--tailoring-file", harden.Tailoring)
+	}
+	args = append(args, harden.Datastream)
+
+	log.Infof("Applying hardening profile %s", harden.Profile)
+	cmd := exec.CommandContext(b.stageCtx, "oscap-chroot", args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// oscap exits with 2 when rules failed, which the results tell apart
+	var exitErr *exec.ExitError
+	if err := cmd.Run(); err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 2) {
+		return fmt.Errorf("oscap-chroot failed (see %s): %w", logFile.Name(), err)
+	}
+	b.addArtifact(results)
+	b.addArtifact(report)
+	b.hardenReport = results
+
+	f, err := os.Open(results)
+	if err != nil {
+		return fmt.Errorf("failed to read hardening results: %w", err)
+	}
+	defer f.Close()
+	counts, err := ruleResults(f)
+	if err != nil {
+		return fmt.Errorf("failed to parse hardening results %s: %w", results, err)
+	}
+	log.Infof("Hardening results: %d passed, %d fixed, %d failed", counts["pass"], counts["fixed"], counts["fail"])
+
+	if n := counts["fail"]; n > 0 && harden.FailOnFailed {
+		return withClass(ErrHardening, fmt.Errorf("%d rules of profile %s failed after remediation (see %s)", n, harden.Profile, report))
+	}
+	return nil
+}
+
+// ruleResults counts the rule results of XCCDF results by result, such as pass or fail
+func ruleResults(r io.Reader) (map[string]int, error) {
+	counts := make(map[string]int)
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return counts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "rule-result" {
+			continue
+		}
+		var result struct {
+			Result string `xml:"result"`
+		}
+		if err := dec.DecodeElement(&result, &start); err != nil {
+			return nil, err
+		}
+		counts[result.Result]++
+	}
+}
@@ -0,0 +1,29 @@
+package builder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRuleResults(t *testing.T) {
+	results := `<?xml version="1.0" encoding="UTF-8"?>
+<Benchmark xmlns="http://checklists.nist.gov/xccdf/1.2" id="xccdf_org.ssgproject.content_benchmark_RHEL-9">
+  <Rule id="xccdf_org.ssgproject.content_rule_no_empty_passwords"><title>No empty passwords</title></Rule>
+  <TestResult id="xccdf_org.open-scap_testresult_cis">
+    <rule-result idref="xccdf_org.ssgproject.content_rule_no_empty_passwords"><result>fixed</result></rule-result>
+    <rule-result idref="xccdf_org.ssgproject.content_rule_sshd_disable_root_login"><result>pass</result></rule-result>
+    <rule-result idref="xccdf_org.ssgproject.content_rule_partition_for_tmp"><result>fail</result></rule-result>
+    <rule-result idref="xccdf_org.ssgproject.content_rule_grub2_password"><result>notapplicable</result></rule-result>
+  </TestResult>
+</Benchmark>`
+
+	got, err := ruleResults(strings.NewReader(results))
+	if err != nil {
+		t.Fatalf("ruleResults() error = %v", err)
+	}
+	want := map[string]int{"fixed": 1, "pass": 1, "fail": 1, "notapplicable": 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ruleResults() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,100 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// initrdPatterns are the names of the initrd of a kernel in /boot, as dracut on Red Hat
+// style distributions, initramfs-tools on Debian and dracut elsewhere name them
+var initrdPatterns = []string{
+	"initramfs-{{.KernelVersion}}.img",
+	"initrd.img-{{.KernelVersion}}",
+	"initrd-{{.KernelVersion}}.img",
+	"initrd-{{.KernelVersion}}",
+}
+
+// findInitrd returns the path of the initrd of kernelVersion below mountPoint. The initrd
+// is looked up by options.initrd_pattern, or by initrdPatterns with /boot/initrd.img as a
+// fallback, and must be the only file matching.
+func (b *Builder) findInitrd(mountPoint, kernelVersion string) (string, error) {
+	patterns := initrdPatterns
+	if b.config.Options.InitrdPattern != "" {
+		patterns = []string{b.config.Options.InitrdPattern}
+	}
+
+	var candidates []string
+	for _, pattern := range patterns {
+		tmpl, err := template.New("initrd").Parse(pattern)
+		if err != nil {
+			return "", fmt.Errorf("invalid initrd pattern '%s': %w", pattern, err)
+		}
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, struct{ KernelVersion string }{kernelVersion}); err != nil {
+			return "", fmt.Errorf("failed to render initrd pattern '%s': %w", pattern, err)
+		}
+		matches, err := filepath.Glob(filepath.Join(mountPoint, "boot", sb.String()))
+		if err != nil {
+			return "", fmt.Errorf("invalid initrd pattern '%s': %w", pattern, err)
+		}
+		for _, match := range matches {
+			if !slices.Contains(candidates, match) {
+				candidates = append(candidates, match)
+			}
+		}
+	}
+
+	if len(candidates) == 0 && b.config.Options.InitrdPattern == "" {
+		candidates, _ = filepath.Glob(filepath.Join(mountPoint, "boot", "initrd.img"))
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("no initrd for kernel %s found in /boot, set options.initrd_pattern to its name", kernelVersion)
+	case 1:
+		return candidates[0], nil
+	}
+	names := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		names[i] = "/" + filepath.ToSlash(strings.TrimPrefix(candidate, filepath.Clean(mountPoint)+string(filepath.Separator)))
+	}
+	return "", fmt.Errorf("found several initrds for kernel %s, set options.initrd_pattern to pick one: %s", kernelVersion, strings.Join(names, ", "))
+}
+
+// generateInitrdOnHost runs the dracut of the build host against the kernel modules and
+// firmware of the image, so images without dracut still get an initrd. The initrd is written
+// to /boot in the image, where dracut in the image would have put it, and its path returned.
+func (b *Builder) generateInitrdOnHost(mountPoint, kernelVersion string) (string, error) {
+	kmodDir := filepath.Join(mountPoint, "lib", "modules", kernelVersion)
+	if _, err := os.Stat(kmodDir); err != nil {
+		return "", fmt.Errorf("the image has no modules for kernel %s: %w", kernelVersion, err)
+	}
+	logFile, err := b.openLog("dracut")
+	if err != nil {
+		return "", err
+	}
+	defer logFile.Close()
+
+	initrdPath := filepath.Join(mountPoint, "boot", fmt.Sprintf("initramfs-%s.img", kernelVersion))
+	log.Infof("Generating initrd for kernel %s with the dracut of the build host", kernelVersion)
+	args := append([]string{"--add", "dmsquash-live livenet network-manager"}, b.dracutArgs()...)
+	cmd := exec.Command("dracut", append(args,
+		"--kver", kernelVersion,
+		"--kmoddir", kmodDir,
+		"--fwdir", filepath.Join(mountPoint, "lib", "firmware"),
+		"-N", "-f",
+		initrdPath,
+	)...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to run dracut (see %s): %w", logFile.Name(), err)
+	}
+	return initrdPath, nil
+}
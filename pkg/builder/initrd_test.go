@@ -0,0 +1,83 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-image-builder/pkg/oci/ocitest"
+)
+
+func TestFindInitrd(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		pattern string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "dracut",
+			files: []string{"initramfs-6.1.0.img", "initramfs-6.1.0kdump.img", "initramfs-5.14.0.img"},
+			want:  "initramfs-6.1.0.img",
+		},
+		{
+			name:  "debian",
+			files: []string{"initrd.img-6.1.0", "initrd.img-5.10.0"},
+			want:  "initrd.img-6.1.0",
+		},
+		{
+			name:  "fallback",
+			files: []string{"initrd.img"},
+			want:  "initrd.img",
+		},
+		{
+			name:    "ambiguous",
+			files:   []string{"initramfs-6.1.0.img", "initrd.img-6.1.0"},
+			wantErr: "/boot/initramfs-6.1.0.img, /boot/initrd.img-6.1.0",
+		},
+		{
+			name:    "pattern",
+			files:   []string{"initramfs-6.1.0.img", "custom-6.1.0.cpio"},
+			pattern: "custom-{{.KernelVersion}}.*",
+			want:    "custom-6.1.0.cpio",
+		},
+		{
+			name:    "pattern without fallback",
+			files:   []string{"initrd.img"},
+			pattern: "custom-{{.KernelVersion}}.*",
+			wantErr: "no initrd",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBuilder(t, &ocitest.Fake{})
+			b.config.Options.InitrdPattern = tt.pattern
+			mountPoint := t.TempDir()
+			if err := os.Mkdir(filepath.Join(mountPoint, "boot"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.files {
+				if err := os.WriteFile(filepath.Join(mountPoint, "boot", name), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := b.findInitrd(mountPoint, "6.1.0")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("findInitrd() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("findInitrd() error = %v", err)
+			}
+			if want := filepath.Join(mountPoint, "boot", tt.want); got != want {
+				t.Errorf("findInitrd() = %q, want %q", got, want)
+			}
+		})
+	}
+}
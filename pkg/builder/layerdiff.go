@@ -0,0 +1,160 @@
+package builder
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// whiteoutPrefix marks files removed by a layer in the OCI layer format
+const whiteoutPrefix = ".wh."
+
+// fileState is what a rootfs snapshot records of a file to tell whether it changed
+type fileState struct {
+	mode    fs.FileMode
+	size    int64
+	modTime time.Time
+	link    string
+}
+
+// snapshotRootfs records the state of every file of root, by path relative to root
+func snapshotRootfs(root string) (map[string]fileState, error) {
+	snapshot := make(map[string]fileState)
+	err := walkRootfs(root, func(rel string, info fs.FileInfo, state fileState) error {
+		snapshot[rel] = state
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot rootfs: %w", err)
+	}
+	return snapshot, nil
+}
+
+// writeLayerDiff writes the files of root added or changed since the snapshot to an
+// uncompressed layer tarball at tarPath, with whiteouts for the files removed since. File
+// times are clamped to sourceDate unless it is zero.
+func writeLayerDiff(root string, before map[string]fileState, tarPath string, sourceDate time.Time) error {
+	f, err := os.Create(tarPath)
+	if err != nil {
+		return fmt.Errorf("failed to create layer: %w", err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+
+	seen := make(map[string]bool)
+	err = walkRootfs(root, func(rel string, info fs.FileInfo, state fileState) error {
+		seen[rel] = true
+		if old, ok := before[rel]; ok && old == state {
+			return nil
+		}
+		return writeTarEntry(tw, filepath.Join(root, rel), rel, info, state.link, sourceDate)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write layer: %w", err)
+	}
+
+	var removed []string
+	for rel := range before {
+		if !seen[rel] {
+			removed = append(removed, rel)
+		}
+	}
+	sort.Strings(removed)
+	whiteoutTime := time.Now()
+	if !sourceDate.IsZero() {
+		whiteoutTime = sourceDate
+	}
+	for _, rel := range removed {
+		// Removing a directory removes its contents, which need no whiteouts of their own
+		if dir := path.Dir(rel); dir != "." && !seen[dir] {
+			continue
+		}
+		header := &tar.Header{
+			Name:     path.Join(path.Dir(rel), whiteoutPrefix+path.Base(rel)),
+			Typeflag: tar.TypeReg,
+			ModTime:  whiteoutTime,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write whiteout of %s: %w", rel, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write layer: %w", err)
+	}
+	return f.Close()
+}
+
+// walkRootfs calls fn for every file below root, parents before their contents. Sockets
+// cannot be stored in layers and are skipped.
+func walkRootfs(root string, fn func(rel string, info fs.FileInfo, state fileState) error) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root || d.Type()&fs.ModeSocket != 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		state := fileState{mode: info.Mode(), modTime: info.ModTime()}
+		if info.Mode().IsRegular() {
+			state.size = info.Size()
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if state.link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		return fn(filepath.ToSlash(rel), info, state)
+	})
+}
+
+// writeTarEntry writes a file of the rootfs to the layer. With a sourceDate, its time is
+// clamped to it and only numeric owners are recorded, so the entry does not depend on when
+// or on which host it was written.
+func writeTarEntry(tw *tar.Writer, p, rel string, info fs.FileInfo, link string, sourceDate time.Time) error {
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("failed to create header for %s: %w", rel, err)
+	}
+	if !sourceDate.IsZero() {
+		if header.ModTime.After(sourceDate) {
+			header.ModTime = sourceDate
+		}
+		header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+		header.Uname, header.Gname = "", ""
+	}
+	header.Name = rel
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", rel, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("failed to write %s: %w", rel, err)
+	}
+	return nil
+}
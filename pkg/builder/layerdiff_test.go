@@ -0,0 +1,88 @@
+package builder
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestWriteLayerDiff(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("etc/hostname", "node")
+	write("etc/ld.so.cache", "old")
+	write("usr/share/nouveau/firmware.bin", "nouveau")
+	write("usr/bin/bash", "bash")
+
+	snapshot, err := snapshotRootfs(root)
+	if err != nil {
+		t.Fatalf("snapshotRootfs() error = %v", err)
+	}
+
+	// Keep modification times apart from those of the snapshot
+	time.Sleep(10 * time.Millisecond)
+	write("usr/lib/modules/5.14.0/extra/nvidia.ko", "module")
+	write("etc/ld.so.cache", "new")
+	if err := os.RemoveAll(filepath.Join(root, "usr", "share", "nouveau")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("nvidia-smi.real", filepath.Join(root, "usr", "bin", "nvidia-smi")); err != nil {
+		t.Fatal(err)
+	}
+
+	tarPath := filepath.Join(t.TempDir(), "layer.tar")
+	if err := writeLayerDiff(root, snapshot, tarPath, time.Time{}); err != nil {
+		t.Fatalf("writeLayerDiff() error = %v", err)
+	}
+
+	f, err := os.Open(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []string
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, header.Name)
+	}
+	sort.Strings(got)
+
+	// Directories whose entries changed are part of the layer, unchanged files are not
+	want := []string{
+		"etc/ld.so.cache",
+		"usr/",
+		"usr/bin/",
+		"usr/bin/nvidia-smi",
+		"usr/lib/",
+		"usr/lib/modules/",
+		"usr/lib/modules/5.14.0/",
+		"usr/lib/modules/5.14.0/extra/",
+		"usr/lib/modules/5.14.0/extra/nvidia.ko",
+		"usr/share/",
+		"usr/share/.wh.nouveau",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("writeLayerDiff() entries = %v, want %v", got, want)
+	}
+}
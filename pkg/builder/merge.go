@@ -0,0 +1,82 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// mergeImages copies the configured merge images, or the listed paths of them, onto the
+// rootfs in order, so later images win over earlier ones
+func (b *Builder) mergeImages(root string) error {
+	for _, merge := range b.config.MergeImages {
+		log.Infof("Merging image %s into rootfs", merge.Image)
+		containerName, mountPoint, err := b.oci.MountImage(merge.Image)
+		if err != nil {
+			return err
+		}
+		err = mergePaths(mountPoint, root, merge.Paths)
+		if cerr := b.oci.Cleanup(containerName); cerr != nil {
+			log.Warnf("Failed to clean up container for %s: %v", merge.Image, cerr)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to merge image %s: %w", merge.Image, err)
+		}
+	}
+	return nil
+}
+
+// bootstrapRootfs copies the filesystem of the bootstrap image onto the rootfs, so its
+// package manager can install the image when the build host has none
+func (b *Builder) bootstrapRootfs(root string) error {
+	image := b.config.Options.Bootstrap()
+	log.Infof("Bootstrapping rootfs from %s, the package manager is not installed on the host", image)
+	containerName, mountPoint, err := b.oci.MountImage(image)
+	if err != nil {
+		return fmt.Errorf("failed to mount bootstrap image: %w", err)
+	}
+	err = mergePaths(mountPoint, root, nil)
+	if cerr := b.oci.Cleanup(containerName); cerr != nil {
+		log.Warnf("Failed to clean up container for %s: %v", image, cerr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to bootstrap rootfs from %s: %w", image, err)
+	}
+	return nil
+}
+
+// mergePaths copies paths from the src filesystem to the same paths under dest, preserving
+// ownership, modes and links. Directories are merged into existing ones and the whole
+// filesystem is copied when no paths are given.
+func mergePaths(src, dest string, paths []string) error {
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+	for _, path := range paths {
+		from := filepath.Join(src, path)
+		to := filepath.Join(dest, path)
+		info, err := os.Lstat(from)
+		if err != nil {
+			return fmt.Errorf("failed to find %s: %w", path, err)
+		}
+
+		if info.IsDir() {
+			if err := os.MkdirAll(to, info.Mode().Perm()); err != nil {
+				return fmt.Errorf("failed to create %s: %w", to, err)
+			}
+			from += "/."
+		} else if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", to, err)
+		}
+
+		log.Debugf("Copying %s to %s", from, to)
+		cmd := exec.Command("cp", "-a", "--remove-destination", from, to)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to copy %s: %s: %w", path, string(output), err)
+		}
+	}
+	return nil
+}
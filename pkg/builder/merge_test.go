@@ -0,0 +1,54 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergePaths(t *testing.T) {
+	src := t.TempDir()
+	dest := t.TempDir()
+	for path, data := range map[string]string{
+		"opt/nvidia/bin/nvidia-smi": "smi",
+		"opt/nvidia/lib/libcuda.so": "cuda",
+		"etc/unrelated.conf":        "unrelated",
+	} {
+		if err := os.MkdirAll(filepath.Join(src, filepath.Dir(path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(src, path), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("libcuda.so", filepath.Join(src, "opt/nvidia/lib/libcuda.so.1")); err != nil {
+		t.Fatal(err)
+	}
+	// Existing files in merged directories are kept
+	if err := os.MkdirAll(filepath.Join(dest, "opt/nvidia"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dest, "opt/nvidia/site.conf"), []byte("site"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mergePaths(src, dest, []string{"/opt/nvidia"}); err != nil {
+		t.Fatalf("mergePaths() error = %v", err)
+	}
+
+	for _, path := range []string{"opt/nvidia/bin/nvidia-smi", "opt/nvidia/lib/libcuda.so", "opt/nvidia/site.conf"} {
+		if _, err := os.Stat(filepath.Join(dest, path)); err != nil {
+			t.Errorf("expected %s in rootfs: %v", path, err)
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(dest, "opt/nvidia/lib/libcuda.so.1")); err != nil || link != "libcuda.so" {
+		t.Errorf("libcuda.so.1 link = %q, %v, want libcuda.so", link, err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "etc/unrelated.conf")); !os.IsNotExist(err) {
+		t.Errorf("unlisted path was merged: %v", err)
+	}
+
+	if err := mergePaths(src, dest, []string{"/missing"}); err == nil {
+		t.Error("mergePaths() of a missing path succeeded, want error")
+	}
+}
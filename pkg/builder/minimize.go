@@ -0,0 +1,103 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// firmwareDirs are the firmware directories of the rootfs, /lib/firmware being a link to
+// /usr/lib/firmware on merged-usr distributions
+var firmwareDirs = []string{"usr/lib/firmware", "lib/firmware"}
+
+// docDirs are the documentation directories emptied for nodocs images
+var docDirs = []string{"usr/share/doc", "usr/share/man", "usr/share/info"}
+
+// minimize removes the firmware, documentation and locales the configuration leaves out.
+// Packages were already installed without them where rpm allows, this catches the files of
+// the parent image and of packages installed before the rpm macros were in place.
+func (b *Builder) minimize(root string) error {
+	opts := b.config.Options.Minimize
+
+	var removed int
+	for _, pattern := range opts.FirmwareExclude {
+		for _, dir := range firmwareDirs {
+			matches, err := filepath.Glob(filepath.Join(root, dir, pattern))
+			if err != nil {
+				return fmt.Errorf("invalid firmware pattern '%s': %w", pattern, err)
+			}
+			for _, match := range matches {
+				if err := os.RemoveAll(match); err != nil {
+					return fmt.Errorf("failed to remove firmware %s: %w", match, err)
+				}
+				removed++
+			}
+		}
+	}
+	if len(opts.FirmwareExclude) > 0 {
+		log.Infof("Removed %d firmware entries", removed)
+	}
+
+	if opts.NoDocs {
+		for _, dir := range docDirs {
+			if err := removeContents(filepath.Join(root, dir), func(os.DirEntry) bool { return true }); err != nil {
+				return err
+			}
+		}
+		log.Info("Removed documentation")
+	}
+
+	if len(opts.Locales) > 0 {
+		// Files such as locale.alias are not locales
+		remove := func(entry os.DirEntry) bool { return entry.IsDir() && !keepLocale(entry.Name(), opts.Locales) }
+		if err := removeContents(filepath.Join(root, "usr", "share", "locale"), remove); err != nil {
+			return err
+		}
+		log.Infof("Removed locales except %s", strings.Join(opts.Locales, ", "))
+	}
+	return nil
+}
+
+// removeContents removes the entries of dir selected by remove. A missing directory has
+// nothing to remove.
+func removeContents(dir string, remove func(entry os.DirEntry) bool) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		if !remove(entry) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", filepath.Join(dir, entry.Name()), err)
+		}
+	}
+	return nil
+}
+
+// keepLocale reports whether a locale directory of /usr/share/locale is kept. Translations
+// are looked up from the most specific locale to its language, so en_US keeps both en_US
+// and en.
+func keepLocale(name string, locales []string) bool {
+	for _, locale := range locales {
+		if name == locale || language(name) == locale || name == language(locale) {
+			return true
+		}
+	}
+	return false
+}
+
+// language returns the language of a locale name, such as en for en_US.UTF-8@euro
+func language(locale string) string {
+	if i := strings.IndexAny(locale, "_.@"); i >= 0 {
+		return locale[:i]
+	}
+	return locale
+}
@@ -0,0 +1,71 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/oci/ocitest"
+)
+
+func TestMinimize(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"usr/lib/firmware/netronome/nic.bin",
+		"usr/lib/firmware/qcom/a.bin",
+		"usr/lib/firmware/intel/ucode.bin",
+		"usr/share/doc/bash/README",
+		"usr/share/man/man1/bash.1.gz",
+		"usr/share/locale/locale.alias",
+		"usr/share/locale/en/LC_MESSAGES/bash.mo",
+		"usr/share/locale/en_US/LC_MESSAGES/bash.mo",
+		"usr/share/locale/en_GB/LC_MESSAGES/bash.mo",
+		"usr/share/locale/de/LC_MESSAGES/bash.mo",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("usr/lib", filepath.Join(root, "lib")); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newTestBuilder(t, ocitest.NewFake(root))
+	b.config.Options.Minimize = imageconfig.Minimize{
+		NoDocs:          true,
+		Locales:         []string{"en_US"},
+		FirmwareExclude: []string{"netronome", "qcom/*"},
+	}
+	if err := b.minimize(root); err != nil {
+		t.Fatalf("minimize() error = %v", err)
+	}
+
+	var got []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			rel, _ := filepath.Rel(root, path)
+			got = append(got, rel)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"usr/lib/firmware/intel/ucode.bin",
+		"usr/share/locale/en/LC_MESSAGES/bash.mo",
+		"usr/share/locale/en_US/LC_MESSAGES/bash.mo",
+		"usr/share/locale/locale.alias",
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("minimize() left %v, want %v", got, want)
+	}
+}
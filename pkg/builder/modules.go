@@ -0,0 +1,130 @@
+package builder
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// validModuleName matches module names and versions, which end up in shell commands
+var validModuleName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+
+// buildKernelModules builds the configured out-of-tree kernel modules against the kernel of
+// the image and installs them under /lib/modules, so they end up in the base layer and in
+// the initrd generated when packaging
+func (b *Builder) buildKernelModules(containerName, mountPoint string) error {
+	modules := b.config.KernelModules
+	kernelVersion, err := b.getKernelVersion(containerName)
+	if err != nil {
+		return fmt.Errorf("failed to get kernel version: %w", err)
+	}
+
+	if len(modules.DKMS) > 0 {
+		log.Infof("Installing %d DKMS packages", len(modules.DKMS))
+		if err := b.pm.InstallPackages(mountPoint, modules.DKMS, nil); err != nil {
+			return withClass(ErrPackageInstall, fmt.Errorf("failed to install DKMS packages (see %s): %w", b.logPath(b.config.Options.PkgManager), err))
+		}
+		// Package scriptlets build for the running kernel, which is the host's
+		if err := b.runModuleCommand(containerName, fmt.Sprintf("dkms autoinstall -k %s", kernelVersion)); err != nil {
+			return err
+		}
+	}
+
+	for _, src := range modules.Sources {
+		command, err := installModuleSource(src, mountPoint, kernelVersion)
+		if err != nil {
+			return err
+		}
+		if err := b.runModuleCommand(containerName, command); err != nil {
+			return err
+		}
+	}
+
+	if err := b.runModuleCommand(containerName, fmt.Sprintf("depmod -a %s", kernelVersion)); err != nil {
+		return err
+	}
+	b.modulesBuilt = true
+	return nil
+}
+
+// runModuleCommand runs a module build command in the container
+func (b *Builder) runModuleCommand(containerName, command string) error {
+	log.Infof("Running command: %s", command)
+	if err := b.pm.RunCommand(b.oci, containerName, command); err != nil {
+		return fmt.Errorf("failed to build kernel modules: %w", err)
+	}
+	return nil
+}
+
+// installModuleSource copies a module source tree into /usr/src of the rootfs and returns
+// the command that builds and installs it. DKMS trees are added to dkms as the module and
+// version of their dkms.conf, other trees are built with Kbuild.
+func installModuleSource(src, root, kernelVersion string) (string, error) {
+	name, version, err := readDKMSConf(filepath.Join(src, "dkms.conf"))
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Base(filepath.Clean(src))
+	if name != "" {
+		dir = name + "-" + version
+	}
+	if !validModuleName.MatchString(dir) {
+		return "", fmt.Errorf("invalid module source name '%s'", dir)
+	}
+	dest := filepath.Join(root, "usr", "src", dir)
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return "", fmt.Errorf("failed to create module source directory: %w", err)
+	}
+	log.Debugf("Copying module sources %s to %s", src, dest)
+	// A trailing /. copies the contents of src rather than src itself
+	if output, err := exec.Command("cp", "-a", filepath.Clean(src)+"/.", dest).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to copy module sources %s: %w\nOutput: %s", src, err, output)
+	}
+
+	if name != "" {
+		return fmt.Sprintf("dkms add -m %s -v %s && dkms install -m %s -v %s -k %s", name, version, name, version, kernelVersion), nil
+	}
+	return fmt.Sprintf("make -C /lib/modules/%s/build M=/usr/src/%s modules modules_install", kernelVersion, dir), nil
+}
+
+// readDKMSConf returns the module name and version of a dkms.conf. Both are empty if the
+// file does not exist.
+func readDKMSConf(path string) (name, version string, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read dkms.conf: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "PACKAGE_NAME":
+			name = value
+		case "PACKAGE_VERSION":
+			version = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", fmt.Errorf("failed to read dkms.conf: %w", err)
+	}
+	if !validModuleName.MatchString(name) || !validModuleName.MatchString(version) {
+		return "", "", fmt.Errorf("%s must set PACKAGE_NAME and PACKAGE_VERSION to names without spaces or shell characters", path)
+	}
+	return name, version, nil
+}
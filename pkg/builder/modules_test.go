@@ -0,0 +1,70 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstallModuleSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    string
+		wantDir string
+		wantErr bool
+	}{
+		{
+			name:    "kbuild",
+			files:   map[string]string{"Makefile": "obj-m += mlnx.o\n"},
+			want:    "make -C /lib/modules/5.14.0/build M=/usr/src/mlnx modules modules_install",
+			wantDir: "mlnx",
+		},
+		{
+			name:    "dkms",
+			files:   map[string]string{"dkms.conf": "PACKAGE_NAME=\"lustre-client\"\nPACKAGE_VERSION=2.15.4\n", "Makefile": ""},
+			want:    "dkms add -m lustre-client -v 2.15.4 && dkms install -m lustre-client -v 2.15.4 -k 5.14.0",
+			wantDir: "lustre-client-2.15.4",
+		},
+		{
+			name:    "dkms without version",
+			files:   map[string]string{"dkms.conf": "PACKAGE_NAME=lustre-client\n"},
+			wantErr: true,
+		},
+		{
+			name:    "dkms with shell characters",
+			files:   map[string]string{"dkms.conf": "PACKAGE_NAME=\"x;reboot\"\nPACKAGE_VERSION=1\n"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := filepath.Join(t.TempDir(), "mlnx")
+			if err := os.Mkdir(src, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			root := t.TempDir()
+
+			got, err := installModuleSource(src, root, "5.14.0")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("installModuleSource() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("installModuleSource() = %q, want %q", got, tt.want)
+			}
+			if tt.wantDir == "" {
+				return
+			}
+			for name := range tt.files {
+				if _, err := os.Stat(filepath.Join(root, "usr", "src", tt.wantDir, name)); err != nil {
+					t.Errorf("source file %s was not copied: %v", name, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go-image-builder/pkg/imageconfig"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// savedFile records a rootfs file, or its absence, so it can be put back after the build
+// has replaced it
+type savedFile struct {
+	path   string
+	exists bool
+	link   string
+	data   []byte
+	mode   os.FileMode
+}
+
+// saveFile records the file at path. Symlinks are recorded rather than followed, since
+// their targets resolve against the host rather than the rootfs.
+func saveFile(path string) (*savedFile, error) {
+	f := &savedFile{path: path}
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	f.exists = true
+	f.mode = info.Mode()
+	if info.Mode()&os.ModeSymlink != 0 {
+		if f.link, err = os.Readlink(path); err != nil {
+			return nil, fmt.Errorf("failed to read link %s: %w", path, err)
+		}
+		return f, nil
+	}
+	if f.data, err = os.ReadFile(path); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// replace writes data to the file as a regular file, replacing any symlink
+func (f *savedFile) replace(data []byte) error {
+	if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", f.path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", f.path, err)
+	}
+	if err := os.WriteFile(f.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", f.path, err)
+	}
+	return nil
+}
+
+// restore puts the recorded file back, or removes the file if there was none. A copy that
+// a package install set aside as .rpmnew because the build-time file was in the way takes
+// the place of a missing file.
+func (f *savedFile) restore() error {
+	if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", f.path, err)
+	}
+	switch {
+	case !f.exists:
+		if _, err := os.Stat(f.path + ".rpmnew"); err == nil {
+			return os.Rename(f.path+".rpmnew", f.path)
+		}
+		return nil
+	case f.link != "":
+		return os.Symlink(f.link, f.path)
+	default:
+		return os.WriteFile(f.path, f.data, f.mode.Perm())
+	}
+}
+
+// resolvConf returns the resolv.conf used while building: the configured nameservers and
+// search domains, or the host's resolv.conf when none are configured
+func resolvConf(dns imageconfig.DNS) ([]byte, error) {
+	if len(dns.Nameservers) == 0 && len(dns.Search) == 0 {
+		data, err := os.ReadFile("/etc/resolv.conf")
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return data, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("# Generated by go-image-builder for the build\n")
+	if len(dns.Search) > 0 {
+		fmt.Fprintf(&sb, "search %s\n", strings.Join(dns.Search, " "))
+	}
+	for _, ns := range dns.Nameservers {
+		fmt.Fprintf(&sb, "nameserver %s\n", ns)
+	}
+	return []byte(sb.String()), nil
+}
+
+// hostsEntries returns the configured hosts entries sorted by host name
+func hostsEntries(hosts map[string]string) string {
+	names := make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sb, "%s\t%s\n", hosts[name], name)
+	}
+	return sb.String()
+}
+
+// setupNetworkFiles installs the build-time resolv.conf and hosts entries in the rootfs.
+// The returned function puts the image's own files back and must be called before the
+// rootfs is packaged.
+func (b *Builder) setupNetworkFiles(root string) (func() error, error) {
+	var saved []*savedFile
+	restore := func() error {
+		var errs []error
+		for _, f := range saved {
+			if err := f.restore(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	resolv, err := resolvConf(b.config.Options.DNS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read host resolv.conf: %w", err)
+	}
+	if resolv != nil {
+		f, err := saveFile(filepath.Join(root, "etc", "resolv.conf"))
+		if err != nil {
+			return nil, err
+		}
+		saved = append(saved, f)
+		log.Debug("Installing build-time resolv.conf")
+		if err := f.replace(resolv); err != nil {
+			restore()
+			return nil, err
+		}
+	}
+
+	if len(b.config.Options.DNS.Hosts) > 0 {
+		f, err := saveFile(filepath.Join(root, "etc", "hosts"))
+		if err != nil {
+			restore()
+			return nil, err
+		}
+		saved = append(saved, f)
+		hosts := string(f.data)
+		if hosts == "" {
+			hosts = "127.0.0.1\tlocalhost\n::1\tlocalhost\n"
+		}
+		if !strings.HasSuffix(hosts, "\n") {
+			hosts += "\n"
+		}
+		log.Debug("Adding build-time hosts entries")
+		if err := f.replace([]byte(hosts + hostsEntries(b.config.Options.DNS.Hosts))); err != nil {
+			restore()
+			return nil, err
+		}
+	}
+
+	return restore, nil
+}
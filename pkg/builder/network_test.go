@@ -0,0 +1,65 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/oci/ocitest"
+)
+
+func TestSetupNetworkFiles(t *testing.T) {
+	root := t.TempDir()
+	etc := filepath.Join(root, "etc")
+	if err := os.MkdirAll(etc, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// resolv.conf symlinks must not be followed out of the rootfs
+	if err := os.Symlink("/run/systemd/resolve/stub-resolv.conf", filepath.Join(etc, "resolv.conf")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(etc, "hosts"), []byte("127.0.0.1\tlocalhost"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newTestBuilder(t, ocitest.NewFake(root))
+	b.config.Options.DNS = imageconfig.DNS{
+		Nameservers: []string{"10.0.0.1"},
+		Search:      []string{"cluster.local"},
+		Hosts:       map[string]string{"mirror.internal": "10.0.0.5"},
+	}
+
+	restore, err := b.setupNetworkFiles(root)
+	if err != nil {
+		t.Fatalf("setupNetworkFiles() error = %v", err)
+	}
+
+	resolv, err := os.ReadFile(filepath.Join(etc, "resolv.conf"))
+	if err != nil {
+		t.Fatalf("failed to read build-time resolv.conf: %v", err)
+	}
+	wantResolv := "# Generated by go-image-builder for the build\nsearch cluster.local\nnameserver 10.0.0.1\n"
+	if string(resolv) != wantResolv {
+		t.Errorf("build-time resolv.conf = %q, want %q", resolv, wantResolv)
+	}
+	hosts, err := os.ReadFile(filepath.Join(etc, "hosts"))
+	if err != nil {
+		t.Fatalf("failed to read build-time hosts: %v", err)
+	}
+	if want := "127.0.0.1\tlocalhost\n10.0.0.5\tmirror.internal\n"; string(hosts) != want {
+		t.Errorf("build-time hosts = %q, want %q", hosts, want)
+	}
+
+	if err := restore(); err != nil {
+		t.Fatalf("restore() error = %v", err)
+	}
+	link, err := os.Readlink(filepath.Join(etc, "resolv.conf"))
+	if err != nil || link != "/run/systemd/resolve/stub-resolv.conf" {
+		t.Errorf("resolv.conf not restored to symlink, got %q (%v)", link, err)
+	}
+	hosts, err = os.ReadFile(filepath.Join(etc, "hosts"))
+	if err != nil || string(hosts) != "127.0.0.1\tlocalhost" {
+		t.Errorf("hosts not restored, got %q (%v)", hosts, err)
+	}
+}
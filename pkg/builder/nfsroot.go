@@ -0,0 +1,78 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bootArgs returns the kernel arguments the configured live overlay or NFS root needs
+func (b *Builder) bootArgs() string {
+	if b.config.NFSRoot != nil {
+		return b.config.NFSRoot.Args()
+	}
+	return b.config.LiveOverlay.Args()
+}
+
+// dracutArgs returns the arguments dracut needs for the configured live overlay or NFS root
+func (b *Builder) dracutArgs() []string {
+	return append(b.config.LiveOverlay.DracutArgs(), b.config.NFSRoot.DracutArgs()...)
+}
+
+// writeNFSFstab makes the root filesystem entry of the image's fstab the configured NFS
+// export, replacing any entry for / the image has
+func (b *Builder) writeNFSFstab(mountPoint string) error {
+	nfs := b.config.NFSRoot
+	path := filepath.Join(mountPoint, "etc", "fstab")
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read fstab: %w", err)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[1] == "/" && !strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if line != "" || len(lines) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	options := nfs.Options
+	if options == "" {
+		options = "defaults"
+	}
+	lines = append(lines, fmt.Sprintf("%s / nfs %s 0 0", nfs.Source(), options))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create /etc: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write fstab: %w", err)
+	}
+	log.Infof("Mounting the root filesystem from %s", nfs.Source())
+	return nil
+}
+
+// createRootfsTarball writes the rootfs as a gzip-compressed tarball to unpack into the NFS
+// export, keeping ownership, permissions and extended attributes
+func (b *Builder) createRootfsTarball(rootfs string) error {
+	outputPath := filepath.Join(b.workDir, "rootfs.tar.gz")
+	logFile, err := b.openLog("rootfs-tarball")
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command("tar", "--xattrs", "--acls", "--numeric-owner", "-czf", outputPath, "-C", rootfs, ".")
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("tar failed (see %s): %w", logFile.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/oci/ocitest"
+)
+
+func TestWriteNFSFstab(t *testing.T) {
+	tests := []struct {
+		name  string
+		fstab string
+		want  string
+	}{
+		{
+			name: "no fstab",
+			want: "nfs:/images/compute / nfs ro,vers=4.2 0 0\n",
+		},
+		{
+			name:  "root entry replaced",
+			fstab: "# comment\nUUID=1234 / xfs defaults 0 0\ntmpfs /tmp tmpfs defaults 0 0\n",
+			want:  "# comment\ntmpfs /tmp tmpfs defaults 0 0\nnfs:/images/compute / nfs ro,vers=4.2 0 0\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBuilder(t, &ocitest.Fake{})
+			b.config.NFSRoot = &imageconfig.NFSRoot{Server: "nfs", Path: "/images/compute", Options: "ro,vers=4.2"}
+			mountPoint := t.TempDir()
+			path := filepath.Join(mountPoint, "etc", "fstab")
+			if tt.fstab != "" {
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(tt.fstab), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := b.writeNFSFstab(mountPoint); err != nil {
+				t.Fatalf("writeNFSFstab() error = %v", err)
+			}
+			if data, err := os.ReadFile(path); err != nil || string(data) != tt.want {
+				t.Errorf("fstab = %q, %v, want %q", data, err, tt.want)
+			}
+			if got, want := b.bootArgs(), "root=nfs:nfs:/images/compute:ro,vers=4.2"; got != want {
+				t.Errorf("bootArgs() = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go-image-builder/internal/pkgmgr"
+	"go-image-builder/internal/redact"
+	"go-image-builder/pkg/events"
+	"go-image-builder/pkg/image"
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/oci"
+	"go-image-builder/pkg/progress"
+)
+
+// Option configures a Builder created with New
+type Option func(*Builder)
+
+// WithWorkDir sets the directory the rootfs, logs and artifacts of the build are written to.
+// A temporary directory is created without one.
+func WithWorkDir(dir string) Option {
+	return func(b *Builder) {
+		b.workDir = dir
+	}
+}
+
+// WithArtifacts sets whether the squashfs image and the initrd are created. Both are by
+// default.
+func WithArtifacts(squashfs, initrd bool) Option {
+	return func(b *Builder) {
+		b.shouldCreateSquashfs = squashfs
+		b.shouldCreateInitrd = initrd
+	}
+}
+
+// WithOCI sets the backend container operations are performed with, instead of the one
+// the configuration selects
+func WithOCI(o oci.OCIInterface) Option {
+	return func(b *Builder) {
+		b.oci = o
+	}
+}
+
+// WithEvents sets the writer that receives the machine-readable events of the build
+func WithEvents(w *events.Writer) Option {
+	return func(b *Builder) {
+		b.SetEvents(w)
+	}
+}
+
+// WithLayerCache sets the cache that unchanged layers are reused from across builds
+func WithLayerCache(cache *image.LayerCache) Option {
+	return func(b *Builder) {
+		b.SetLayerCache(cache)
+	}
+}
+
+// WithBlobCache sets the cache of blobs known to exist in registries, which pushes skip
+func WithBlobCache(cache *image.BlobCache) Option {
+	return func(b *Builder) {
+		b.SetBlobCache(cache)
+	}
+}
+
+// WithForce allows pushes to overwrite immutable tags that point at another image
+func WithForce(force bool) Option {
+	return func(b *Builder) {
+		b.SetForce(force)
+	}
+}
+
+// WithSignKey sets the GPG key SHA256SUMS is signed with
+func WithSignKey(key string) Option {
+	return func(b *Builder) {
+		b.SetSignKey(key)
+	}
+}
+
+// WithProgress sets the reporter that receives the progress of the build
+func WithProgress(r progress.Reporter) Option {
+	return func(b *Builder) {
+		b.SetProgress(r)
+	}
+}
+
+// WithCleanupPolicy sets what is kept once the build finishes
+func WithCleanupPolicy(policy CleanupPolicy) Option {
+	return func(b *Builder) {
+		b.SetCleanupPolicy(policy)
+	}
+}
+
+// New creates a Builder for config, configured by opts
+func New(config *imageconfig.Config, opts ...Option) (*Builder, error) {
+	b := &Builder{
+		config:               config,
+		shouldCreateSquashfs: true,
+		shouldCreateInitrd:   true,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	switch config.Options.PkgManager {
+	case "dnf":
+		b.pm = &pkgmgr.DNF{Limits: config.Options.Resources, Env: config.Options.Proxy.Env()}
+	case "zypper":
+		// TODO: implement zypper
+		return nil, fmt.Errorf("zypper support not implemented yet")
+	case "apt":
+		// TODO: implement apt
+		return nil, fmt.Errorf("apt support not implemented yet")
+	default:
+		return nil, fmt.Errorf("unsupported package manager: %s", config.Options.PkgManager)
+	}
+
+	if b.workDir == "" {
+		dir, err := os.MkdirTemp("", "image-build-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create working directory: %w", err)
+		}
+		b.workDir = dir
+	}
+	b.rootfs = filepath.Join(b.workDir, "rootfs")
+
+	if b.oci == nil {
+		o, err := oci.NewOCI(config, b.workDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OCI backend: %w", err)
+		}
+		b.oci = o
+	}
+	redact.Config(config)
+	return b, nil
+}
+
+// NewBuilder creates a new Builder instance
+func NewBuilder(config *imageconfig.Config, workDir string, createSquashfs, createInitrd bool) (*Builder, error) {
+	return New(config, WithWorkDir(workDir), WithArtifacts(createSquashfs, createInitrd))
+}
+
+// NewBuilderWithOCI creates a new Builder instance that performs container operations with o
+func NewBuilderWithOCI(config *imageconfig.Config, workDir string, createSquashfs, createInitrd bool, o oci.OCIInterface) (*Builder, error) {
+	return New(config, WithWorkDir(workDir), WithArtifacts(createSquashfs, createInitrd), WithOCI(o))
+}
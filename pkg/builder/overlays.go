@@ -0,0 +1,61 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"go-image-builder/pkg/imageconfig"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// buildNodeOverlays builds the configured node overlays into the working directory as
+// overlay-<group>.tar.gz or overlay-<group>.squashfs. Files in them are owned by root.
+func (b *Builder) buildNodeOverlays() error {
+	for _, overlay := range b.config.NodeOverlays {
+		path, err := b.buildNodeOverlay(overlay)
+		if err != nil {
+			return fmt.Errorf("failed to build node overlay %s: %w", overlay.Group, err)
+		}
+		b.nodeOverlays = append(b.nodeOverlays, path)
+		b.addArtifact(path)
+	}
+	return nil
+}
+
+// buildNodeOverlay copies the files of an overlay into a staging directory and packs it
+func (b *Builder) buildNodeOverlay(overlay imageconfig.NodeOverlay) (string, error) {
+	stageDir, err := os.MkdirTemp(b.tempDir(), "node-overlay-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(stageDir)
+	if err := b.pm.CopyFiles(stageDir, overlay.Files); err != nil {
+		return "", err
+	}
+
+	logFile, err := b.openLog("overlay-" + overlay.Group)
+	if err != nil {
+		return "", err
+	}
+	defer logFile.Close()
+
+	var path string
+	var cmd *exec.Cmd
+	if overlay.Format == "squashfs" {
+		path = filepath.Join(b.workDir, fmt.Sprintf("overlay-%s.squashfs", overlay.Group))
+		cmd = exec.Command("mksquashfs", stageDir, path, "-comp", "xz", "-all-root", "-noappend", "-no-progress")
+	} else {
+		path = filepath.Join(b.workDir, fmt.Sprintf("overlay-%s.tar.gz", overlay.Group))
+		cmd = exec.Command("tar", "--owner=0", "--group=0", "--numeric-owner", "-czf", path, "-C", stageDir, ".")
+	}
+	log.Infof("Building node overlay %s with %d files", overlay.Group, len(overlay.Files))
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s failed (see %s): %w", filepath.Base(cmd.Path), logFile.Name(), err)
+	}
+	return path, nil
+}
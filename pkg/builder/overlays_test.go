@@ -0,0 +1,43 @@
+package builder
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/oci/ocitest"
+)
+
+func TestBuildNodeOverlays(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar is not installed")
+	}
+	src := filepath.Join(t.TempDir(), "sssd.conf")
+	if err := os.WriteFile(src, []byte("[sssd]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newTestBuilder(t, &ocitest.Fake{})
+	b.config.NodeOverlays = []imageconfig.NodeOverlay{
+		{Group: "login", Files: []imageconfig.CopyFile{{Src: src, Dest: "/etc/sssd/sssd.conf"}}},
+	}
+	if err := b.buildNodeOverlays(); err != nil {
+		t.Fatalf("buildNodeOverlays() error = %v", err)
+	}
+
+	want := filepath.Join(b.workDir, "overlay-login.tar.gz")
+	if !reflect.DeepEqual(b.nodeOverlays, []string{want}) {
+		t.Fatalf("node overlays = %v, want [%s]", b.nodeOverlays, want)
+	}
+	output, err := exec.Command("tar", "-tzvf", want).CombinedOutput()
+	if err != nil {
+		t.Fatalf("tar failed: %s: %v", output, err)
+	}
+	if !strings.Contains(string(output), "0/0") || !strings.Contains(string(output), "./etc/sssd/sssd.conf") {
+		t.Errorf("overlay holds\n%s\nwant etc/sssd/sssd.conf owned by root", output)
+	}
+}
@@ -0,0 +1,49 @@
+package builder
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"go-image-builder/internal/version"
+	"go-image-builder/pkg/image"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// provenance returns the build inputs recorded in the labels and the config layer of the image
+func (b *Builder) provenance() (image.Provenance, error) {
+	digest, err := b.config.Digest()
+	if err != nil {
+		return image.Provenance{}, err
+	}
+	p := image.Provenance{ConfigDigest: digest, BuilderVersion: version.String()}
+	if b.config.Source != "" {
+		p.ConfigCommit = gitCommit(b.config.Source)
+	}
+	return p, nil
+}
+
+// gitCommit returns the commit that last changed the file at path, with a -dirty suffix if
+// the file has uncommitted changes. It returns an empty string if the file is not tracked by
+// a git repository or git is not installed.
+func gitCommit(path string) string {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	dir, file := filepath.Split(path)
+	output, err := exec.Command("git", "-C", dir, "log", "-1", "--format=%H", "--", file).Output()
+	if err != nil {
+		log.Debugf("Not recording the git commit of %s: %v", path, err)
+		return ""
+	}
+	commit := strings.TrimSpace(string(output))
+	if commit == "" {
+		return "" // Not committed yet.
+	}
+	if output, err := exec.Command("git", "-C", dir, "status", "--porcelain", "--", file).Output(); err == nil && len(strings.TrimSpace(string(output))) > 0 {
+		commit += "-dirty"
+	}
+	return commit
+}
@@ -0,0 +1,49 @@
+package builder
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestGitCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+	}
+	config := filepath.Join(dir, "image.yaml")
+	if err := os.WriteFile(config, []byte("options: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := gitCommit(config); got != "" {
+		t.Errorf("gitCommit() outside a repository = %q, want none", got)
+	}
+	git("init", "-q")
+	if got := gitCommit(config); got != "" {
+		t.Errorf("gitCommit() of an untracked file = %q, want none", got)
+	}
+
+	git("add", "image.yaml")
+	git("commit", "-q", "-m", "Add image")
+	commit := regexp.MustCompile(`^[0-9a-f]{40}$`)
+	if got := gitCommit(config); !commit.MatchString(got) {
+		t.Errorf("gitCommit() = %q, want a commit", got)
+	}
+
+	if err := os.WriteFile(config, []byte("options: {name: test}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := gitCommit(config); !regexp.MustCompile(`^[0-9a-f]{40}-dirty$`).MatchString(got) {
+		t.Errorf("gitCommit() of a changed file = %q, want a dirty commit", got)
+	}
+}
@@ -0,0 +1,144 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// scanReportFile is the name of the vulnerability report written to the working directory
+const scanReportFile = "scan-report.json"
+
+// severityRank orders vulnerability severities, unknown and negligible ones rank lowest
+var severityRank = map[string]int{
+	"low":      1,
+	"medium":   2,
+	"high":     3,
+	"critical": 4,
+}
+
+// scanner describes how a vulnerability scanner is run against a rootfs and how the
+// severities are read from its JSON report
+type scanner struct {
+	args         func(rootfs, report string) []string
+	severities   func(report []byte) ([]string, error)
+	artifactType types.MediaType
+}
+
+var scanners = map[string]scanner{
+	"trivy": {
+		args: func(rootfs, report string) []string {
+			return []string{"rootfs", "--quiet", "--format", "json", "--output", report, rootfs}
+		},
+		severities: func(report []byte) ([]string, error) {
+			var r struct {
+				Results []struct {
+					Vulnerabilities []struct {
+						Severity string
+					}
+				}
+			}
+			if err := json.Unmarshal(report, &r); err != nil {
+				return nil, err
+			}
+			var severities []string
+			for _, result := range r.Results {
+				for _, v := range result.Vulnerabilities {
+					severities = append(severities, v.Severity)
+				}
+			}
+			return severities, nil
+		},
+		artifactType: "application/vnd.aquasec.trivy.report+json",
+	},
+	"grype": {
+		args: func(rootfs, report string) []string {
+			return []string{"dir:" + rootfs, "--quiet", "--output", "json", "--file", report}
+		},
+		severities: func(report []byte) ([]string, error) {
+			var r struct {
+				Matches []struct {
+					Vulnerability struct {
+						Severity string `json:"severity"`
+					} `json:"vulnerability"`
+				} `json:"matches"`
+			}
+			if err := json.Unmarshal(report, &r); err != nil {
+				return nil, err
+			}
+			var severities []string
+			for _, m := range r.Matches {
+				severities = append(severities, m.Vulnerability.Severity)
+			}
+			return severities, nil
+		},
+		artifactType: "application/vnd.anchore.grype.report+json",
+	},
+}
+
+// scanRootfs runs the configured scanner against the rootfs and keeps its report with the
+// build artifacts. It fails if the report has a vulnerability at or above the fail_on
+// severity.
+func (b *Builder) scanRootfs(rootfs string) error {
+	name := b.config.Options.Scan.Scanner
+	s := scanners[name]
+	report := filepath.Join(b.workDir, scanReportFile)
+
+	logFile, err := b.openLog(name)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	log.Infof("Scanning rootfs with %s", name)
+	cmd := exec.CommandContext(b.stageCtx, name, s.args(rootfs, report)...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed (see %s): %w", name, logFile.Name(), err)
+	}
+	b.addArtifact(report)
+	b.scanReport = report
+
+	data, err := os.ReadFile(report)
+	if err != nil {
+		return fmt.Errorf("failed to read scan report: %w", err)
+	}
+	severities, err := s.severities(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s report %s: %w", name, report, err)
+	}
+
+	counts := make(map[string]int)
+	for _, severity := range severities {
+		counts[strings.ToLower(severity)]++
+	}
+	log.Infof("Found %d vulnerabilities: %d critical, %d high, %d medium, %d low", len(severities),
+		counts["critical"], counts["high"], counts["medium"], counts["low"])
+
+	failOn := b.config.Options.Scan.FailOn
+	if failOn == "" {
+		return nil
+	}
+	if n := countAtLeast(severities, failOn); n > 0 {
+		return withClass(ErrVulnerabilities, fmt.Errorf("found %d vulnerabilities of severity %s or higher (see %s)", n, failOn, report))
+	}
+	return nil
+}
+
+// countAtLeast returns the number of severities ranked at or above threshold
+func countAtLeast(severities []string, threshold string) int {
+	var n int
+	for _, severity := range severities {
+		if severityRank[strings.ToLower(severity)] >= severityRank[threshold] {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,37 @@
+package builder
+
+import (
+	"testing"
+)
+
+func TestScannerSeverities(t *testing.T) {
+	tests := []struct {
+		scanner string
+		report  string
+		want    int
+	}{
+		{"trivy", `{"Results":[{"Vulnerabilities":[{"Severity":"CRITICAL"},{"Severity":"LOW"}]},{"Vulnerabilities":[{"Severity":"HIGH"}]}]}`, 2},
+		{"trivy", `{"Results":[{"Target":"rootfs"}]}`, 0},
+		{"grype", `{"matches":[{"vulnerability":{"severity":"High"}},{"vulnerability":{"severity":"Negligible"}},{"vulnerability":{"severity":"Medium"}}]}`, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scanner, func(t *testing.T) {
+			severities, err := scanners[tt.scanner].severities([]byte(tt.report))
+			if err != nil {
+				t.Fatalf("severities() error = %v", err)
+			}
+			if got := countAtLeast(severities, "high"); got != tt.want {
+				t.Errorf("countAtLeast(%v, high) = %d, want %d", severities, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountAtLeast(t *testing.T) {
+	severities := []string{"CRITICAL", "High", "medium", "LOW", "UNKNOWN", "Negligible"}
+	for threshold, want := range map[string]int{"critical": 1, "high": 2, "medium": 3, "low": 4} {
+		if got := countAtLeast(severities, threshold); got != want {
+			t.Errorf("countAtLeast(%s) = %d, want %d", threshold, got, want)
+		}
+	}
+}
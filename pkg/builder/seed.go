@@ -0,0 +1,85 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Where cloud-init and Ignition look for the seed in the image
+const (
+	noCloudSeedDir = "var/lib/cloud/seed/nocloud"
+	ignitionConfig = "usr/lib/ignition/base.d/50-go-image-builder.ign"
+)
+
+// writeSeed writes the configured seed below root, either into the image where cloud-init
+// and Ignition find it, or as artifacts: a NoCloud seed directory to serve with
+// ds=nocloud;s=<url>/seed/, or config.ign. It returns the paths of the files written.
+func (b *Builder) writeSeed(root string, inImage bool) ([]string, error) {
+	seed := b.config.Seed
+	files := make(map[string][]byte)
+
+	if seed.Format == "ignition" {
+		data, err := os.ReadFile(seed.Ignition)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ignition config: %w", err)
+		}
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("ignition config %s is not valid JSON", seed.Ignition)
+		}
+		if inImage {
+			files[ignitionConfig] = data
+		} else {
+			files["config.ign"] = data
+		}
+	} else {
+		dir := "seed"
+		if inImage {
+			dir = noCloudSeedDir
+		}
+		userData, err := os.ReadFile(seed.UserData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read user-data: %w", err)
+		}
+		if !strings.HasPrefix(string(userData), "#cloud-config") && !strings.HasPrefix(string(userData), "#!") {
+			log.Warnf("user-data %s starts with neither #cloud-config nor #!, cloud-init may ignore it", seed.UserData)
+		}
+		files[filepath.Join(dir, "user-data")] = userData
+
+		metaData := []byte(fmt.Sprintf("instance-id: iid-%s\n", b.config.Options.Name))
+		if seed.MetaData != "" {
+			if metaData, err = os.ReadFile(seed.MetaData); err != nil {
+				return nil, fmt.Errorf("failed to read meta-data: %w", err)
+			}
+		}
+		files[filepath.Join(dir, "meta-data")] = metaData
+
+		if seed.NetworkConfig != "" {
+			networkConfig, err := os.ReadFile(seed.NetworkConfig)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read network-config: %w", err)
+			}
+			files[filepath.Join(dir, "network-config")] = networkConfig
+		}
+	}
+
+	var written []string
+	for name, data := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create seed directory: %w", err)
+		}
+		// Seeds may hold credentials
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write seed: %w", err)
+		}
+		written = append(written, path)
+	}
+	slices.Sort(written)
+	return written, nil
+}
@@ -0,0 +1,85 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/oci/ocitest"
+)
+
+func TestWriteSeed(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"user-data":   "#cloud-config\nusers: []\n",
+		"config.ign":  `{"ignition": {"version": "3.4.0"}}`,
+		"invalid.ign": "ignition: {}",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		seed    imageconfig.Seed
+		inImage bool
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "cloud-init in image",
+			seed:    imageconfig.Seed{UserData: filepath.Join(dir, "user-data")},
+			inImage: true,
+			want: map[string]string{
+				"var/lib/cloud/seed/nocloud/user-data": "#cloud-config\nusers: []\n",
+				"var/lib/cloud/seed/nocloud/meta-data": "instance-id: iid-compute\n",
+			},
+		},
+		{
+			name: "cloud-init artifact",
+			seed: imageconfig.Seed{UserData: filepath.Join(dir, "user-data"), MetaData: filepath.Join(dir, "user-data")},
+			want: map[string]string{
+				"seed/user-data": "#cloud-config\nusers: []\n",
+				"seed/meta-data": "#cloud-config\nusers: []\n",
+			},
+		},
+		{
+			name:    "ignition in image",
+			seed:    imageconfig.Seed{Format: "ignition", Ignition: filepath.Join(dir, "config.ign")},
+			inImage: true,
+			want:    map[string]string{"usr/lib/ignition/base.d/50-go-image-builder.ign": `{"ignition": {"version": "3.4.0"}}`},
+		},
+		{
+			name:    "invalid ignition config",
+			seed:    imageconfig.Seed{Format: "ignition", Ignition: filepath.Join(dir, "invalid.ign")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBuilder(t, &ocitest.Fake{})
+			b.config.Options.Name = "compute"
+			b.config.Seed = &tt.seed
+			root := t.TempDir()
+
+			written, err := b.writeSeed(root, tt.inImage)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeSeed() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(written) != len(tt.want) {
+				t.Errorf("writeSeed() wrote %v, want %d files", written, len(tt.want))
+			}
+			for name, want := range tt.want {
+				if data, err := os.ReadFile(filepath.Join(root, name)); err != nil || string(data) != want {
+					t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+				}
+			}
+		})
+	}
+}
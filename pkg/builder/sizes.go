@@ -0,0 +1,153 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-image-builder/pkg/image"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sizeReportFile is the name of the size report written to the working directory
+const sizeReportFile = "size-report.json"
+
+// sizeReportEntries is the number of packages and directories kept in the size report
+const sizeReportEntries = 50
+
+// directoryDepth is how deep below the root directory sizes are reported, deep enough to
+// tell /usr/lib/firmware and /usr/share/locale apart
+const directoryDepth = 3
+
+// packageSizesCommand lists the installed size of every package of the image
+const packageSizesCommand = `rpm -qa --queryformat '%{NAME} %{SIZE}\n'`
+
+// SizeReport breaks down the size of a built image by layer, package and directory, to help
+// keep diskless images within their memory budget
+type SizeReport struct {
+	Layers      []image.LayerSize `json:"layers"`
+	Packages    []SizeEntry       `json:"packages,omitempty"`
+	Directories []SizeEntry       `json:"directories"`
+}
+
+// SizeEntry is the installed size of a package or directory
+type SizeEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// SizeReport returns the size report of the last build, or nil if it did not get that far
+func (b *Builder) SizeReport() *SizeReport {
+	return b.sizeReport
+}
+
+// writeSizeReport measures the packaged image and its rootfs and keeps the report with the
+// build artifacts
+func (b *Builder) writeSizeReport(img *image.Image, containerName, mountPoint string) error {
+	var report SizeReport
+	var err error
+	if report.Layers, err = img.LayerSizes(); err != nil {
+		return err
+	}
+	if report.Directories, err = directorySizes(mountPoint); err != nil {
+		return err
+	}
+	report.Directories = largest(report.Directories, sizeReportEntries)
+
+	// Images without rpm, such as those built from other distributions, have no package sizes
+	if output, err := b.oci.RunCommandWithOutput(containerName, packageSizesCommand); err != nil {
+		log.Warnf("Failed to list package sizes, leaving them out of the size report: %v", err)
+	} else {
+		report.Packages = largest(parsePackageSizes(output), sizeReportEntries)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal size report: %w", err)
+	}
+	path := filepath.Join(b.workDir, sizeReportFile)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write size report: %w", err)
+	}
+	b.addArtifact(path)
+	b.sizeReport = &report
+	return nil
+}
+
+// directorySizes sums the size of the regular files of root by directory, files deeper than
+// directoryDepth count towards their ancestor at that depth
+func directorySizes(root string) ([]SizeEntry, error) {
+	sizes := make(map[string]int64)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) > directoryDepth {
+			parts = parts[:directoryDepth]
+		}
+		sizes[filepath.Clean("/"+strings.Join(parts, "/"))] += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure rootfs: %w", err)
+	}
+
+	entries := make([]SizeEntry, 0, len(sizes))
+	for dir, size := range sizes {
+		entries = append(entries, SizeEntry{Name: dir, Size: size})
+	}
+	return entries, nil
+}
+
+// parsePackageSizes reads the "name size" lines of the package size query. Lines that do
+// not parse, such as warnings of the package manager, are skipped.
+func parsePackageSizes(output []byte) []SizeEntry {
+	var entries []SizeEntry
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, SizeEntry{Name: fields[0], Size: size})
+	}
+	return entries
+}
+
+// largest returns the n largest entries, largest first
+func largest(entries []SizeEntry, n int) []SizeEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Size != entries[j].Size {
+			return entries[i].Size > entries[j].Size
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
@@ -0,0 +1,56 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDirectorySizes(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]int{
+		"etc/hostname":                     10,
+		"usr/bin/ls":                       100,
+		"usr/lib/firmware/intel/ucode.bin": 1000,
+		"usr/lib/firmware/amd.bin":         500,
+		"usr/share/locale/de/LC_MESSAGES":  20,
+	}
+	for name, size := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("usr/bin", filepath.Join(root, "bin")); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := directorySizes(root)
+	if err != nil {
+		t.Fatalf("directorySizes() error = %v", err)
+	}
+	want := []SizeEntry{
+		{Name: "/usr/lib/firmware", Size: 1500},
+		{Name: "/usr/bin", Size: 100},
+		{Name: "/usr/share/locale", Size: 20},
+		{Name: "/etc", Size: 10},
+	}
+	if got := largest(entries, 10); !reflect.DeepEqual(got, want) {
+		t.Errorf("directorySizes() = %v, want %v", got, want)
+	}
+}
+
+func TestParsePackageSizes(t *testing.T) {
+	output := []byte("kernel-core 70123456\nwarning: rpmdb: damaged header\nglibc 6234567\nbash 8001234\n")
+	want := []SizeEntry{
+		{Name: "kernel-core", Size: 70123456},
+		{Name: "bash", Size: 8001234},
+	}
+	if got := largest(parsePackageSizes(output), 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("largest(parsePackageSizes()) = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,117 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runDirPrefix names the directories holding the temporary files of a build. Each holds a
+// lock file that the build keeps locked while it runs.
+const (
+	runDirPrefix = ".image-builder-"
+	runDirLock   = "lock"
+)
+
+// runDir is the locked directory of a running build
+type runDir struct {
+	path string
+	lock *os.File
+}
+
+// createRunDir creates the directory the temporary files of a build go to below base, and
+// locks it for as long as the build runs. The directories of builds that crashed are
+// removed first: their lock is free, as the kernel released it when the build died.
+func createRunDir(base string) (*runDir, error) {
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	removeOrphanedRunDirs(base)
+
+	path, err := os.MkdirTemp(base, runDirPrefix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	// The lock file is locked before it gets its name, so a build cleaning up never sees
+	// it unlocked
+	lock, err := os.CreateTemp(path, runDirLock+".*")
+	if err == nil {
+		err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			err = os.Rename(lock.Name(), filepath.Join(path, runDirLock))
+		}
+		if err != nil {
+			lock.Close()
+		}
+	}
+	if err != nil {
+		os.RemoveAll(path)
+		return nil, fmt.Errorf("failed to lock temporary directory: %w", err)
+	}
+	fmt.Fprintf(lock, "%d\n", os.Getpid())
+	return &runDir{path: path, lock: lock}, nil
+}
+
+// release removes the directory, or with keep leaves it for inspection. Kept directories
+// lose their lock file, so they are not taken for those of a crashed build.
+func (d *runDir) release(keep bool) {
+	defer d.lock.Close()
+	if keep {
+		os.Remove(filepath.Join(d.path, runDirLock))
+		return
+	}
+	if err := os.RemoveAll(d.path); err != nil {
+		log.Warnf("Failed to remove temporary directory %s: %v", d.path, err)
+	}
+}
+
+// removeOrphanedRunDirs removes the directories below base left by builds that crashed.
+// Those of running builds are locked, and those kept for inspection have no lock file.
+func removeOrphanedRunDirs(base string) {
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), runDirPrefix) {
+			continue
+		}
+		path := filepath.Join(base, entry.Name())
+		orphaned, err := isOrphaned(path)
+		if err != nil {
+			log.Debugf("Not removing temporary directory %s: %v", path, err)
+			continue
+		}
+		if !orphaned {
+			continue
+		}
+		log.Infof("Removing the temporary files of a crashed build: %s", path)
+		if err := os.RemoveAll(path); err != nil {
+			log.Warnf("Failed to remove temporary directory %s: %v", path, err)
+		}
+	}
+}
+
+// isOrphaned reports whether the lock file of the directory at path is unlocked
+func isOrphaned(path string) (bool, error) {
+	lock, err := os.Open(filepath.Join(path, runDirLock))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,57 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunDir(t *testing.T) {
+	base := t.TempDir()
+
+	// A crashed build left its directory with an unlocked lock file, and a kept build its
+	// directory without one
+	crashed := filepath.Join(base, runDirPrefix+"crashed")
+	kept := filepath.Join(base, runDirPrefix+"kept")
+	for _, dir := range []string{crashed, kept} {
+		if err := os.MkdirAll(filepath.Join(dir, "base-layer-1"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(crashed, runDirLock), []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	running, err := createRunDir(base)
+	if err != nil {
+		t.Fatalf("createRunDir() error = %v", err)
+	}
+	if _, err := os.Stat(crashed); !os.IsNotExist(err) {
+		t.Errorf("directory of the crashed build was not removed: %v", err)
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("directory of the kept build was removed: %v", err)
+	}
+
+	// Running builds are left alone by the others
+	other, err := createRunDir(base)
+	if err != nil {
+		t.Fatalf("createRunDir() error = %v", err)
+	}
+	if _, err := os.Stat(running.path); err != nil {
+		t.Errorf("directory of the running build was removed: %v", err)
+	}
+
+	running.release(false)
+	if _, err := os.Stat(running.path); !os.IsNotExist(err) {
+		t.Errorf("released directory was not removed: %v", err)
+	}
+	other.release(true)
+	if _, err := os.Stat(filepath.Join(other.path, runDirLock)); !os.IsNotExist(err) {
+		t.Errorf("kept directory still has its lock file: %v", err)
+	}
+	removeOrphanedRunDirs(base)
+	if _, err := os.Stat(other.path); err != nil {
+		t.Errorf("kept directory was removed: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go-image-builder/pkg/imageconfig"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runTests runs the image tests in the customized container. Every test runs, the build
+// fails listing those that failed.
+func (b *Builder) runTests(containerName, mountPoint string) error {
+	logFile, err := b.openLog("test")
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	var failed []string
+	for i, test := range b.config.Tests {
+		name := testName(i, test)
+		var output []byte
+		if test.Goss != "" {
+			output, err = b.runGoss(containerName, mountPoint, test.Goss)
+		} else {
+			output, err = b.oci.RunCommandWithOutput(containerName, test.Cmd)
+		}
+		fmt.Fprintf(logFile, "=== %s\n", name)
+		if err != nil {
+			fmt.Fprintf(logFile, "%v\n", err)
+			log.Errorf("Test %s failed", name)
+			failed = append(failed, name)
+			continue
+		}
+		logFile.Write(output)
+		log.Infof("Test %s passed", name)
+	}
+
+	if len(failed) > 0 {
+		return withClass(ErrTests, fmt.Errorf("%d of %d image tests failed (see %s): %s", len(failed), len(b.config.Tests), logFile.Name(), strings.Join(failed, ", ")))
+	}
+	return nil
+}
+
+// runGoss validates a goss file with the goss binary of the image. The file is copied into
+// the rootfs for the run and removed afterwards.
+func (b *Builder) runGoss(containerName, mountPoint, gossFile string) ([]byte, error) {
+	data, err := os.ReadFile(gossFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read goss file: %w", err)
+	}
+	dir, err := os.MkdirTemp(mountPoint, ".goss-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create goss directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "goss.yaml"), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to copy goss file: %w", err)
+	}
+	return b.oci.RunCommandWithOutput(containerName, fmt.Sprintf("goss --gofile /%s/goss.yaml validate --no-color", filepath.Base(dir)))
+}
+
+// testName returns the name a test is reported by
+func testName(i int, test imageconfig.ImageTest) string {
+	switch {
+	case test.Name != "":
+		return test.Name
+	case test.Goss != "":
+		return "goss " + test.Goss
+	case test.Cmd != "":
+		return test.Cmd
+	}
+	return fmt.Sprintf("tests[%d]", i)
+}
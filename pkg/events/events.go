@@ -0,0 +1,82 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	BuildStarted  = "build_started"
+	BuildFinished = "build_finished"
+	StageStarted  = "stage_started"
+	StageFinished = "stage_finished"
+	Artifact      = "artifact"
+	Pushed        = "pushed"
+)
+
+// Event is a single line of the event stream
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Image     string    `json:"image,omitempty"`
+	Stage     string    `json:"stage,omitempty"`
+	Duration  float64   `json:"duration_seconds,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Reference string    `json:"reference,omitempty"`
+	Digest    string    `json:"digest,omitempty"`
+}
+
+// Writer writes events as newline-delimited JSON. It is safe for concurrent use and a nil
+// Writer discards all events.
+type Writer struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+// NewWriter returns a Writer that writes events to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w)}
+}
+
+// Open returns a Writer that appends events to the file at path, or writes them to stdout
+// when path is "-"
+func Open(path string) (*Writer, error) {
+	if path == "-" {
+		return NewWriter(os.Stdout), nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open events file: %w", err)
+	}
+	w := NewWriter(f)
+	w.closer = f
+	return w, nil
+}
+
+// Emit writes an event, setting its time if it is not set
+func (w *Writer) Emit(e Event) {
+	if w == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.enc.Encode(e) // Events are best effort and never fail a build
+}
+
+// Close closes the underlying file
+func (w *Writer) Close() error {
+	if w == nil || w.closer == nil {
+		return nil
+	}
+	return w.closer.Close()
+}
@@ -0,0 +1,71 @@
+package image
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// annotatedLayerComments are the history comments of the layers named in
+// options.layer_annotations
+var annotatedLayerComments = map[string]string{
+	"base":     baseLayerComment,
+	"gpu":      gpuLayerComment,
+	"config":   configLayerComment,
+	"kernel":   kernelLayerComment,
+	"initrd":   initrdLayerComment,
+	"squashed": squashedLayerComment,
+}
+
+// ApplyAnnotations adds options.annotations to the manifest of the image and
+// options.layer_annotations to the descriptors of the layers the build added. Layers are
+// told apart by their history comments, and those of the parent are left as they are.
+func (i *Image) ApplyAnnotations() error {
+	options := i.config.Options
+	if len(options.LayerAnnotations) > 0 {
+		layers, err := i.LayerSizes()
+		if err != nil {
+			return err
+		}
+		first := 0
+		if i.parent != nil && !i.squashed {
+			parentLayers, err := i.parent.Layers()
+			if err != nil {
+				return fmt.Errorf("failed to get parent layers: %w", err)
+			}
+			first = len(parentLayers)
+		}
+		manifest, err := i.img.Manifest()
+		if err != nil {
+			return fmt.Errorf("failed to get image manifest: %w", err)
+		}
+
+		i.img, err = rewriteManifest(i.img, manifest.MediaType, manifest.Config.MediaType, func(n int, layer v1.Layer, desc v1.Descriptor) (mutate.Addendum, error) {
+			annotations := desc.Annotations
+			if n >= first && n < len(layers) {
+				for name, extra := range options.LayerAnnotations {
+					if !strings.HasPrefix(layers[n].Comment, annotatedLayerComments[name]) {
+						continue
+					}
+					annotations = maps.Clone(annotations)
+					if annotations == nil {
+						annotations = make(map[string]string, len(extra))
+					}
+					maps.Copy(annotations, extra)
+				}
+			}
+			return mutate.Addendum{Layer: layer, MediaType: desc.MediaType, Annotations: annotations}, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(options.Annotations) > 0 {
+		i.img = mutate.Annotations(i.img, options.Annotations).(v1.Image)
+	}
+	return nil
+}
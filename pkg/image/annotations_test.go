@@ -0,0 +1,70 @@
+package image
+
+import (
+	"reflect"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestApplyAnnotations(t *testing.T) {
+	addendum := func(comment string, annotations map[string]string) mutate.Addendum {
+		layer, err := random.Layer(64, types.DockerLayer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mutate.Addendum{Layer: layer, History: v1.History{Comment: comment}, Annotations: annotations}
+	}
+	parent, err := mutate.Append(empty.Image, addendum(baseLayerComment, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := mutate.Append(parent,
+		addendum(baseLayerComment, nil),
+		addendum(kernelLayerComment, map[string]string{"org.opencontainers.image.type": "kernel"}),
+		addendum(configLayerComment, nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &imageconfig.Config{}
+	config.Options.Annotations = map[string]string{"org.openchami.partition": "compute"}
+	config.Options.LayerAnnotations = map[string]map[string]string{
+		"base":   {"org.openchami.layer": "os"},
+		"kernel": {"org.openchami.layer": "boot"},
+	}
+	i := &Image{img: img, parent: parent, config: config}
+	if err := i.ApplyAnnotations(); err != nil {
+		t.Fatalf("ApplyAnnotations() error = %v", err)
+	}
+
+	manifest, err := i.img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(manifest.Annotations, config.Options.Annotations) {
+		t.Errorf("manifest annotations = %v, want %v", manifest.Annotations, config.Options.Annotations)
+	}
+	want := []map[string]string{
+		nil, // The base layer of the parent
+		{"org.openchami.layer": "os"},
+		{"org.opencontainers.image.type": "kernel", "org.openchami.layer": "boot"},
+		nil,
+	}
+	original, _ := img.Manifest()
+	for n, layer := range manifest.Layers {
+		if !reflect.DeepEqual(layer.Annotations, want[n]) {
+			t.Errorf("layer %d annotations = %v, want %v", n, layer.Annotations, want[n])
+		}
+		if layer.Digest != original.Layers[n].Digest {
+			t.Errorf("layer %d digest = %s, want %s", n, layer.Digest, original.Layers[n].Digest)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// blobCacheMaxAge is how long a blob is remembered after a registry last confirmed it, so
+// blobs removed by garbage collection are eventually checked again
+const blobCacheMaxAge = 7 * 24 * time.Hour
+
+// BlobCache remembers the blobs known to exist in each registry repository, in a file
+// shared by builds. Pushes skip the existence checks and uploads of remembered blobs. Blobs
+// are remembered when a registry confirms them, by answering an existence check, completing
+// an upload or mounting them. A nil BlobCache remembers nothing.
+type BlobCache struct {
+	path  string
+	mu    sync.Mutex
+	blobs map[string]map[string]time.Time
+}
+
+// NewBlobCache returns a cache kept in the file at path
+func NewBlobCache(path string) (*BlobCache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob cache directory: %w", err)
+	}
+	c := &BlobCache{path: path, blobs: make(map[string]map[string]time.Time)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob cache: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.blobs); err != nil {
+		log.Warnf("Ignoring unreadable blob cache %s: %v", path, err)
+		c.blobs = make(map[string]map[string]time.Time)
+	}
+	return c, nil
+}
+
+// has reports whether the blob with digest is known to exist in repo
+func (c *BlobCache) has(repo, digest string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	confirmed, ok := c.blobs[repo][digest]
+	return ok && time.Since(confirmed) < blobCacheMaxAge
+}
+
+// add remembers that the blob with digest exists in repo
+func (c *BlobCache) add(repo, digest string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.blobs[repo] == nil {
+		c.blobs[repo] = make(map[string]time.Time)
+	}
+	c.blobs[repo][digest] = time.Now().UTC()
+}
+
+// forget drops the blobs remembered for repo, after the registry reported one missing
+func (c *BlobCache) forget(repo string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.blobs[repo]
+	delete(c.blobs, repo)
+	return ok
+}
+
+// Save writes the cache to its file, leaving out blobs that are too old to be trusted. The
+// file is replaced atomically, so concurrent builds never read a partial cache.
+func (c *BlobCache) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	for repo, blobs := range c.blobs {
+		for digest, confirmed := range blobs {
+			if time.Since(confirmed) >= blobCacheMaxAge {
+				delete(blobs, digest)
+			}
+		}
+		if len(blobs) == 0 {
+			delete(c.blobs, repo)
+		}
+	}
+	data, err := json.MarshalIndent(c.blobs, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode blob cache: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".blobs-*")
+	if err != nil {
+		return fmt.Errorf("failed to write blob cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write blob cache: %w", err)
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// Transport returns rt answering the blob existence checks of remembered blobs itself and
+// remembering the blobs the registry confirms
+func (c *BlobCache) Transport(rt http.RoundTripper) http.RoundTripper {
+	if c == nil {
+		return rt
+	}
+	return &blobCacheTransport{cache: c, inner: rt}
+}
+
+type blobCacheTransport struct {
+	cache *BlobCache
+	inner http.RoundTripper
+}
+
+func (t *blobCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	repo, rest, ok := blobPath(req.URL.Path)
+	if !ok {
+		return t.inner.RoundTrip(req)
+	}
+	repo = req.URL.Host + "/" + repo
+
+	// HEAD /v2/<repo>/blobs/<digest> checks for a blob, PUT .../blobs/uploads/<id>?digest=
+	// completes an upload and POST .../blobs/uploads/?mount= mounts a blob of another
+	// repository
+	var digest string
+	var confirmed int
+	switch {
+	case req.Method == http.MethodHead && !strings.HasPrefix(rest, "uploads/"):
+		digest, confirmed = rest, http.StatusOK
+		if t.cache.has(repo, digest) {
+			log.Debugf("Blob %s is known to exist in %s", digest, repo)
+			return &http.Response{
+				Status:     "200 OK",
+				StatusCode: http.StatusOK,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     make(http.Header),
+				Body:       http.NoBody,
+				Request:    req,
+			}, nil
+		}
+	case req.Method == http.MethodPut && strings.HasPrefix(rest, "uploads/"):
+		digest, confirmed = req.URL.Query().Get("digest"), http.StatusCreated
+	case req.Method == http.MethodPost && strings.HasPrefix(rest, "uploads/"):
+		digest, confirmed = req.URL.Query().Get("mount"), http.StatusCreated
+	}
+
+	resp, err := t.inner.RoundTrip(req)
+	if err == nil && digest != "" && resp.StatusCode == confirmed {
+		t.cache.add(repo, digest)
+	}
+	return resp, err
+}
+
+// blobPath splits a /v2/<repo>/blobs/<rest> request path into the repository and the rest
+func blobPath(path string) (repo, rest string, ok bool) {
+	path, ok = strings.CutPrefix(path, "/v2/")
+	if !ok {
+		return "", "", false
+	}
+	i := strings.LastIndex(path, "/blobs/")
+	if i < 0 {
+		return "", "", false
+	}
+	return path[:i], path[i+len("/blobs/"):], true
+}
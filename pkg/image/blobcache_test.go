@@ -0,0 +1,79 @@
+package image
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestBlobCache(t *testing.T) {
+	var checks atomic.Int32
+	reg := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead && strings.Contains(req.URL.Path, "/blobs/") {
+			checks.Add(1)
+		}
+		reg.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+	repo := strings.TrimPrefix(server.URL, "http://") + "/test"
+
+	img, err := random.Image(64, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "blobs.json")
+	cache, err := NewBlobCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	push := func(cache *BlobCache, tag string) {
+		t.Helper()
+		checks.Store(0)
+		if err := crane.Push(img, repo+":"+tag, crane.WithTransport(cache.Transport(http.DefaultTransport))); err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+	}
+
+	// The config and layers are checked and uploaded, then remembered
+	push(cache, "first")
+	if n := checks.Load(); n != 3 {
+		t.Errorf("first push checked %d blobs, want 3", n)
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// Later builds read the cache and skip the checks
+	reloaded, err := NewBlobCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	push(reloaded, "second")
+	if n := checks.Load(); n != 0 {
+		t.Errorf("second push checked %d blobs, want 0", n)
+	}
+
+	// Forgotten repositories are checked again
+	if !reloaded.forget(repo) {
+		t.Fatalf("forget(%s) = false, want the repository remembered", repo)
+	}
+	push(reloaded, "third")
+	if n := checks.Load(); n != 3 {
+		t.Errorf("push after forget checked %d blobs, want 3", n)
+	}
+
+	// A nil cache remembers nothing
+	var none *BlobCache
+	push(none, "fourth")
+	if n := checks.Load(); n != 3 {
+		t.Errorf("push without a cache checked %d blobs, want 3", n)
+	}
+}
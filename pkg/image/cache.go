@@ -0,0 +1,147 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	log "github.com/sirupsen/logrus"
+)
+
+// LayerCache keeps compressed layers in a directory, keyed by a hash of the files they were
+// created from. A layer whose files are unchanged is reused without creating and compressing
+// its tarball again, and keeps its digest, so registries already hold its blob. A nil
+// LayerCache caches nothing. Cached layers are touched when reused, so stale entries can be
+// removed by age.
+type LayerCache struct {
+	dir string
+}
+
+// NewLayerCache returns a cache that stores layers in dir
+func NewLayerCache(dir string) (*LayerCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create layer cache directory: %w", err)
+	}
+	return &LayerCache{dir: dir}, nil
+}
+
+// layer returns the layer for the files in dir. On a cache miss create writes the
+// uncompressed layer tarball and returns its path, and the compressed layer is stored.
+func (c *LayerCache) layer(kind, dir string, create func() (string, error), opts ...tarball.LayerOption) (v1.Layer, error) {
+	if c == nil {
+		tarPath, err := create()
+		if err != nil {
+			return nil, err
+		}
+		return tarball.LayerFromFile(tarPath, opts...)
+	}
+
+	key, err := hashTree(kind, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash %s files: %w", kind, err)
+	}
+	path := filepath.Join(c.dir, key+".tar.gz")
+	if _, err := os.Stat(path); err == nil {
+		log.Infof("Reusing cached %s", kind)
+		now := time.Now()
+		os.Chtimes(path, now, now) // Only used to expire old entries
+		return tarball.LayerFromFile(path)
+	}
+
+	tarPath, err := create()
+	if err != nil {
+		return nil, err
+	}
+	layer, err := tarball.LayerFromFile(tarPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.store(path, layer); err != nil {
+		log.Warnf("Failed to cache %s: %v", kind, err)
+		return layer, nil
+	}
+	log.Debugf("Cached %s as %s", kind, path)
+	return tarball.LayerFromFile(path)
+}
+
+// store writes the compressed layer to path. The layer is written to a temporary file
+// first, so concurrent builds never see a partial layer.
+func (c *LayerCache) store(path string, layer v1.Layer) error {
+	rc, err := layer.Compressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp(c.dir, ".layer-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, rc); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// hashTree returns a hash of the names, types, modes, ownership, link targets and contents
+// of the files under dir. Modification times are left out so rebuilding identical files
+// gives the same hash.
+func hashTree(kind, dir string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00", kind)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%o\x00", rel, info.Mode())
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			fmt.Fprintf(h, "%d:%d:%d\x00", stat.Uid, stat.Gid, stat.Rdev)
+		}
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00", target)
+		case info.Mode().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(h, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "\x00%d\x00", info.Size())
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,59 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashTree(t *testing.T) {
+	dir := t.TempDir()
+	kernel := filepath.Join(dir, "boot", "vmlinuz")
+	if err := os.MkdirAll(filepath.Dir(kernel), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(kernel, []byte("kernel"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hash := func() string {
+		t.Helper()
+		h, err := hashTree("kernel layer", dir)
+		if err != nil {
+			t.Fatalf("hashTree() error = %v", err)
+		}
+		return h
+	}
+	first := hash()
+
+	// Rewriting the same contents must not change the hash
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(kernel, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if got := hash(); got != first {
+		t.Errorf("hash changed with modification time: %s != %s", got, first)
+	}
+
+	if err := os.WriteFile(kernel, []byte("kernel2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := hash(); got == first {
+		t.Error("hash did not change with file contents")
+	}
+
+	if err := os.WriteFile(kernel, []byte("kernel"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(kernel, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := hash(); got == first {
+		t.Error("hash did not change with file mode")
+	}
+
+	if other, err := hashTree("initrd layer", dir); err != nil || other == hash() {
+		t.Errorf("hashTree() of another layer kind = %s, %v, want a different hash", other, err)
+	}
+}
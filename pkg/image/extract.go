@@ -0,0 +1,282 @@
+package image
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxSymlinks is the number of symlinks followed resolving a path before giving up, as on
+// Linux
+const maxSymlinks = 40
+
+// Whiteout entries of OCI layers. A whiteout deletes the file it names from lower layers,
+// and an opaque whiteout hides the lower contents of the directory holding it.
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+var errNotExist = errors.New("no such file")
+
+// layerIndex holds the entries of a layer, so files are looked up without reading it again
+type layerIndex struct {
+	entries   map[string]indexEntry
+	whiteouts map[string]bool // Paths deleted from lower layers.
+	opaque    map[string]bool // Directories whose lower contents are hidden.
+}
+
+type indexEntry struct {
+	typeflag byte
+	linkname string
+}
+
+// hidden reports whether the layer hides p in lower layers, by deleting it or one of its
+// parent directories or by making a parent directory opaque
+func (idx *layerIndex) hidden(p string) bool {
+	if idx.whiteouts[p] {
+		return true
+	}
+	for dir := path.Dir(p); ; dir = path.Dir(dir) {
+		if idx.whiteouts[dir] || idx.opaque[dir] {
+			return true
+		}
+		if dir == "/" {
+			return false
+		}
+	}
+}
+
+// extractFile is a helper to extract a single file from the image layers. The file is
+// resolved as in a container started from the image: whiteouts are honored, and symlinks
+// are followed within the image. Layers are indexed from the top as far as needed, so the
+// large base layer is only read if the file isn't found in the boot layers above it.
+func (i *Image) extractFile(pathInImage, destPath string) error {
+	layers, err := i.img.Layers()
+	if err != nil {
+		return fmt.Errorf("could not get layers: %w", err)
+	}
+
+	j, resolved, err := i.resolve(layers, pathInImage)
+	if errors.Is(err, errNotExist) {
+		return fmt.Errorf("file '%s' not found in any layer of the image: %w", pathInImage, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resolve '%s': %w", pathInImage, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory for '%s': %w", destPath, err)
+	}
+	if err := extractFromLayer(layers[j], resolved, destPath); err != nil {
+		return fmt.Errorf("failed to extract '%s' from layer %d: %w", resolved, j, err)
+	}
+	log.Debugf("Extracted '%s' from layer %d to '%s'", resolved, j, destPath)
+	return nil
+}
+
+// resolve follows p through the layers component by component, returning the layer holding
+// the regular file it leads to and the path of the file
+func (i *Image) resolve(layers []v1.Layer, p string) (int, string, error) {
+	parts := splitPath(p)
+	cur, links := "/", 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		if part == ".." {
+			cur = path.Dir(cur)
+			continue
+		}
+		next := path.Join(cur, part)
+
+		j, entry, err := i.lookup(layers, next)
+		if err != nil {
+			return -1, "", err
+		}
+		switch {
+		case entry.typeflag == tar.TypeSymlink:
+			if links++; links > maxSymlinks {
+				return -1, "", fmt.Errorf("too many levels of symbolic links")
+			}
+			// Relative targets are relative to the directory of the link, and absolute
+			// ones to the root of the image
+			target := entry.linkname
+			if !path.IsAbs(target) {
+				target = path.Join(cur, target)
+			}
+			parts = append(splitPath(target), parts...)
+			cur = "/"
+		case len(parts) == 0:
+			if entry.typeflag != tar.TypeReg && entry.typeflag != tar.TypeLink {
+				return -1, "", fmt.Errorf("'%s' is not a regular file", next)
+			}
+			return j, next, nil
+		case entry.typeflag != tar.TypeDir:
+			return -1, "", fmt.Errorf("'%s' is not a directory", next)
+		default:
+			cur = next
+		}
+	}
+	return -1, "", fmt.Errorf("'%s' is not a regular file", p)
+}
+
+// lookup returns the topmost entry for p, without following symlinks
+func (i *Image) lookup(layers []v1.Layer, p string) (int, indexEntry, error) {
+	for j := len(layers) - 1; j >= 0; j-- {
+		idx, err := i.layerIndex(layers[j])
+		if err != nil {
+			return -1, indexEntry{}, fmt.Errorf("error reading layer tar %d: %w", j, err)
+		}
+		if entry, ok := idx.entries[p]; ok {
+			return j, entry, nil
+		}
+		if idx.hidden(p) {
+			break
+		}
+	}
+	return -1, indexEntry{}, errNotExist
+}
+
+// layerIndex returns the index of layer, reading the layer on first use
+func (i *Image) layerIndex(layer v1.Layer) (*layerIndex, error) {
+	diffID, err := layer.DiffID()
+	if err != nil {
+		return nil, fmt.Errorf("could not get layer diff ID: %w", err)
+	}
+	if idx, ok := i.indexes[diffID]; ok {
+		return idx, nil
+	}
+
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return nil, fmt.Errorf("could not uncompress layer: %w", err)
+	}
+	defer rc.Close()
+
+	idx := &layerIndex{
+		entries:   make(map[string]indexEntry),
+		whiteouts: make(map[string]bool),
+		opaque:    make(map[string]bool),
+	}
+	tr := tar.NewReader(rc)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break // End of layer
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// The path in the tarball is relative, so we add a leading /
+		name := path.Join("/", header.Name)
+		dir, base := path.Split(name)
+		switch {
+		case base == whiteoutOpaque:
+			idx.opaque[path.Clean(dir)] = true
+		case strings.HasPrefix(base, whiteoutPrefix):
+			idx.whiteouts[path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))] = true
+		default:
+			idx.entries[name] = indexEntry{typeflag: header.Typeflag, linkname: header.Linkname}
+		}
+	}
+	// Layers may leave out the directories holding their files
+	for name := range idx.entries {
+		for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+			if _, ok := idx.entries[dir]; ok {
+				break
+			}
+			idx.entries[dir] = indexEntry{typeflag: tar.TypeDir}
+		}
+	}
+
+	if i.indexes == nil {
+		i.indexes = make(map[v1.Hash]*layerIndex)
+	}
+	i.indexes[diffID] = idx
+	return idx, nil
+}
+
+// extractFromLayer copies the regular file at p in layer to destPath. Hard links are
+// followed to the file they link to, which the layer holds as well.
+func extractFromLayer(layer v1.Layer, p, destPath string) error {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return fmt.Errorf("could not uncompress layer: %w", err)
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return errNotExist
+		}
+		if err != nil {
+			return err
+		}
+		if path.Join("/", header.Name) != p {
+			continue
+		}
+		if header.Typeflag == tar.TypeLink {
+			return extractFromLayer(layer, path.Join("/", header.Linkname), destPath)
+		}
+
+		outFile, err := os.Create(destPath)
+		if err != nil {
+			return fmt.Errorf("failed to create destination file '%s': %w", destPath, err)
+		}
+		defer outFile.Close()
+
+		if _, err := io.Copy(outFile, tr); err != nil {
+			return fmt.Errorf("failed to copy file content for '%s': %w", p, err)
+		}
+		return outFile.Close()
+	}
+}
+
+// splitPath returns the components of p
+func splitPath(p string) []string {
+	var parts []string
+	for _, part := range strings.Split(p, "/") {
+		if part != "" && part != "." {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// ExtractKernel extracts the kernel from the image and saves it to the destination path.
+// The kernel is expected to be located at /boot/vmlinuz in the image, or where arm64 and
+// EFI-stub kernels are installed. A gzip-compressed kernel is decompressed unless
+// options.keep_compressed_kernel is set.
+func (i *Image) ExtractKernel(destPath string) error {
+	var err error
+	for _, p := range kernelImagePaths {
+		if err = i.extractFile(p, destPath); !errors.Is(err, errNotExist) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !i.config.Options.KeepCompressedKernel {
+		if _, err := DecompressKernel(destPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExtractInitrd extracts the initrd from the image
+func (i *Image) ExtractInitrd(destPath string) error {
+	return i.extractFile("/boot/initrd.img", destPath)
+}
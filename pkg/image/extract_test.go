@@ -0,0 +1,200 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// entry is a file of a test layer. Entries with a link are symlinks, and entries ending in
+// a slash directories.
+type entry struct {
+	name, content, link string
+	hardlink            bool
+}
+
+func testLayer(t *testing.T, entries ...entry) v1.Layer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.content))}
+		switch {
+		case e.hardlink:
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, e.link, 0
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		case e.name[len(e.name)-1] == '/':
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layer
+}
+
+func TestExtractFile(t *testing.T) {
+	base := testLayer(t,
+		entry{name: "boot/"},
+		entry{name: "boot/vmlinuz-5.14.0", content: "kernel 5.14"},
+		entry{name: "boot/vmlinuz", link: "vmlinuz-5.14.0"},
+		entry{name: "boot/initrd.img", content: "base initrd"},
+	)
+
+	tests := []struct {
+		name    string
+		layers  []v1.Layer
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "relative symlink",
+			layers: []v1.Layer{base},
+			path:   "/boot/vmlinuz",
+			want:   "kernel 5.14",
+		},
+		{
+			name:   "topmost layer wins",
+			layers: []v1.Layer{base, testLayer(t, entry{name: "boot/vmlinuz", content: "kernel layer"})},
+			path:   "/boot/vmlinuz",
+			want:   "kernel layer",
+		},
+		{
+			name:   "symlink to a file of a lower layer",
+			layers: []v1.Layer{base, testLayer(t, entry{name: "boot/vmlinuz", link: "/boot/vmlinuz-5.14.0"})},
+			path:   "/boot/vmlinuz",
+			want:   "kernel 5.14",
+		},
+		{
+			name:    "whiteout",
+			layers:  []v1.Layer{base, testLayer(t, entry{name: "boot/.wh.initrd.img"})},
+			path:    "/boot/initrd.img",
+			wantErr: true,
+		},
+		{
+			name:    "whiteout of the symlink target",
+			layers:  []v1.Layer{base, testLayer(t, entry{name: "boot/.wh.vmlinuz-5.14.0"})},
+			path:    "/boot/vmlinuz",
+			wantErr: true,
+		},
+		{
+			name:   "opaque directory",
+			layers: []v1.Layer{base, testLayer(t, entry{name: "boot/.wh..wh..opq"}, entry{name: "boot/initrd.img", content: "new initrd"})},
+			path:   "/boot/initrd.img",
+			want:   "new initrd",
+		},
+		{
+			name:    "opaque directory hides lower files",
+			layers:  []v1.Layer{base, testLayer(t, entry{name: "boot/.wh..wh..opq"})},
+			path:    "/boot/vmlinuz",
+			wantErr: true,
+		},
+		{
+			name: "symlinked directory",
+			layers: []v1.Layer{
+				testLayer(t, entry{name: "usr/lib/boot/vmlinuz", content: "usr kernel"}),
+				testLayer(t, entry{name: "boot", link: "usr/lib/boot"}),
+			},
+			path: "/boot/vmlinuz",
+			want: "usr kernel",
+		},
+		{
+			name:   "symlink leaving the root stays in the image",
+			layers: []v1.Layer{base, testLayer(t, entry{name: "boot/vmlinuz", link: "../../../boot/vmlinuz-5.14.0"})},
+			path:   "/boot/vmlinuz",
+			want:   "kernel 5.14",
+		},
+		{
+			name:   "hard link",
+			layers: []v1.Layer{testLayer(t, entry{name: "boot/vmlinuz-6.1", content: "kernel 6.1"}, entry{name: "boot/vmlinuz", link: "boot/vmlinuz-6.1", hardlink: true})},
+			path:   "/boot/vmlinuz",
+			want:   "kernel 6.1",
+		},
+		{
+			name:    "symlink loop",
+			layers:  []v1.Layer{testLayer(t, entry{name: "boot/vmlinuz", link: "vmlinuz"})},
+			path:    "/boot/vmlinuz",
+			wantErr: true,
+		},
+		{
+			name:    "directory",
+			layers:  []v1.Layer{base},
+			path:    "/boot",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := mutate.AppendLayers(empty.Image, tt.layers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			i := &Image{img: img, config: &imageconfig.Config{}}
+
+			dest := filepath.Join(t.TempDir(), "out", "file")
+			err = i.extractFile(tt.path, dest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if data, err := os.ReadFile(dest); err != nil || string(data) != tt.want {
+				t.Errorf("extracted file = %q, %v, want %q", data, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractFileIndexesLayers(t *testing.T) {
+	img, err := mutate.AppendLayers(empty.Image,
+		testLayer(t, entry{name: "usr/bin/bash", content: "bash"}),
+		testLayer(t, entry{name: "boot/vmlinuz", content: "kernel"}),
+		testLayer(t, entry{name: "boot/initrd.img", content: "initrd"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &Image{img: img, config: &imageconfig.Config{}}
+
+	dir := t.TempDir()
+	if err := i.ExtractKernel(filepath.Join(dir, "vmlinuz")); err != nil {
+		t.Fatalf("ExtractKernel() error = %v", err)
+	}
+	// Only the layers down to the kernel layer are read
+	if len(i.indexes) != 2 {
+		t.Errorf("indexed %d layers, want 2", len(i.indexes))
+	}
+	if err := i.ExtractInitrd(filepath.Join(dir, "initrd.img")); err != nil {
+		t.Fatalf("ExtractInitrd() error = %v", err)
+	}
+	if len(i.indexes) != 2 {
+		t.Errorf("indexed %d layers, want 2", len(i.indexes))
+	}
+}
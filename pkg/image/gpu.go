@@ -0,0 +1,63 @@
+package image
+
+import (
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	log "github.com/sirupsen/logrus"
+)
+
+// GPU labels set on images with a GPU driver layer
+const (
+	GPUVendorLabel        = "com.openchami.image.gpu.vendor"
+	GPUDriverVersionLabel = "com.openchami.image.gpu.driver_version"
+)
+
+// AddGPULayer adds the GPU driver layer from a layer tarball holding the files the driver
+// installation changed, labeling the image with the vendor and driver version. An empty
+// driver version is left out.
+func (i *Image) AddGPULayer(tarPath, vendor, driverVersion string) error {
+	log.Debugf("Adding GPU driver layer from tarball: %s", tarPath)
+	layer, err := tarball.LayerFromFile(tarPath)
+	if err != nil {
+		return fmt.Errorf("failed to create layer: %w", err)
+	}
+
+	config, err := i.img.ConfigFile()
+	if err != nil {
+		return fmt.Errorf("failed to get image config: %w", err)
+	}
+	if config.Config.Labels == nil {
+		config.Config.Labels = make(map[string]string)
+	}
+	config.Config.Labels[GPUVendorLabel] = vendor
+	if driverVersion != "" {
+		config.Config.Labels[GPUDriverVersionLabel] = driverVersion
+	}
+
+	// Update the image creation time
+	now := creationTime(i.config)
+	config.Created = v1.Time{Time: now}
+
+	// Update history
+	config.History = append(config.History, v1.History{
+		Created:   v1.Time{Time: now},
+		CreatedBy: "go-image-builder",
+		Comment:   gpuLayerComment,
+	})
+
+	// Update image config
+	i.img, err = mutate.ConfigFile(i.img, config)
+	if err != nil {
+		return fmt.Errorf("failed to update image config: %w", err)
+	}
+
+	// Add the layer to the image
+	i.img, err = mutate.AppendLayers(i.img, layer)
+	if err != nil {
+		return fmt.Errorf("failed to add layer: %w", err)
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package image
 
 import (
 	"fmt"
+	"go-image-builder/internal/redact"
 	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/progress"
 	"go-image-builder/pkg/utils"
 	"io"
 	"os"
@@ -21,16 +23,60 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/klauspost/compress/gzip"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
 )
 
+// History comments of the layers holding the boot files
+const (
+	kernelLayerComment = "Kernel Layer"
+	initrdLayerComment = "Initrd Layer"
+)
+
+// History comments of the other layers the builder adds. Squashed layers list the layers
+// they were squashed from after the comment.
+const (
+	baseLayerComment     = "Base OS Layer"
+	configLayerComment   = "Configuration Layer"
+	gpuLayerComment      = "GPU Driver Layer"
+	squashedLayerComment = "Squashed Layer"
+)
+
+// ParentLabel records the parent reference an image was built from
+const ParentLabel = "com.openchami.image.parent"
+
+// KernelVersionLabel records the version of the kernel the image boots
+const KernelVersionLabel = "com.openchami.image.kernel-version"
+
+// Labels recording how the image boots: the kernel arguments it needs, the dracut modules in
+// its initrd and the digest of its squashfs artifact
+const (
+	KernelCmdlineLabel  = "com.openchami.image.kernel-cmdline"
+	DracutModulesLabel  = "com.openchami.image.initrd.dracut-modules"
+	SquashfsDigestLabel = "com.openchami.image.squashfs.digest"
+)
+
+// ExpiresLabel records the time after which an image built with options.expires_after is
+// removed by the prune command, in RFC3339
+const ExpiresLabel = "com.openchami.image.expires"
+
 // Image represents a container image
 type Image struct {
 	img           v1.Image
+	parent        v1.Image // The parent image as loaded, before any layers were added.
 	registry      string
 	name          string
 	config        *imageconfig.Config
-	tempDirs      []string // Track temporary directories for cleanup
-	parentArchive string   // Path to temporary parent archive file, if any.
+	tempDirs      []string                // Track temporary directories for cleanup
+	tempDir       string                  // Directory temporary directories are created in.
+	parentArchive string                  // Path to temporary parent archive file, if any.
+	squashed      bool                    // The parent layers have been folded into a single layer.
+	cache         *LayerCache             // Reuses layers made from unchanged files, if set.
+	blobCache     *BlobCache              // Blobs known to exist in registries, if set.
+	indexes       map[v1.Hash]*layerIndex // Entries of the layers extractFile read, by diff ID.
+	tags          []string                // Rendered publish tags, set on first use.
+	force         bool                    // Overwrite immutable tags that point at another image.
+	progress      progress.Reporter
+	provenance    *Provenance // Build inputs recorded in the config layer, if set.
 }
 
 // NewImage creates a new image with the given registry and name.
@@ -58,7 +104,7 @@ func NewImage(registry, imgname string, cfg *imageconfig.Config, parentImage v1.
 		img, err = mutate.ConfigFile(empty.Image, &v1.ConfigFile{
 			Architecture: "amd64",
 			OS:           "linux",
-			Created:      v1.Time{Time: time.Now().UTC()},
+			Created:      v1.Time{Time: creationTime(cfg)},
 		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to create empty image: %w", err)
@@ -67,6 +113,7 @@ func NewImage(registry, imgname string, cfg *imageconfig.Config, parentImage v1.
 
 	return &Image{
 		img:           img,
+		parent:        parentImage,
 		registry:      registry,
 		name:          fullName,
 		config:        cfg,
@@ -74,12 +121,42 @@ func NewImage(registry, imgname string, cfg *imageconfig.Config, parentImage v1.
 	}, nil
 }
 
+// creationTime returns the time recorded as the creation time of images and layers built
+// with cfg: options.source_date_epoch when set, so rebuilds are reproducible, or now
+func creationTime(cfg *imageconfig.Config) time.Time {
+	if sourceDate := cfg.Options.SourceDate(); !sourceDate.IsZero() {
+		return sourceDate
+	}
+	return time.Now().UTC()
+}
+
+// SetLayerCache sets the cache that layers created from files are reused from
+func (i *Image) SetLayerCache(cache *LayerCache) {
+	i.cache = cache
+}
+
+// SetBlobCache sets the cache of blobs known to exist in registries, which pushes skip
+func (i *Image) SetBlobCache(cache *BlobCache) {
+	i.blobCache = cache
+}
+
+// SetTempDir sets the directory temporary layer directories are created in. The system
+// temporary directory is used without one.
+func (i *Image) SetTempDir(dir string) {
+	i.tempDir = dir
+}
+
+// SetForce allows Push to overwrite immutable tags that point at another image
+func (i *Image) SetForce(force bool) {
+	i.force = force
+}
+
 // AddBaseLayer adds a base layer to the image
 func (i *Image) AddBaseLayer(path string) error {
 	log.Debugf("Adding base layer from path: %s", path)
 
 	// Create a temporary directory for the layer
-	tempDir, err := os.MkdirTemp("", "base-layer-*")
+	tempDir, err := os.MkdirTemp(i.tempDir, "base-layer-*")
 	if err != nil {
 		return fmt.Errorf("failed to create temporary directory: %w", err)
 	}
@@ -92,33 +169,144 @@ func (i *Image) AddBaseLayer(path string) error {
 		}
 	}()
 
-	// Create the tar archive
-	tarPath := filepath.Join(tempDir, "layer.tar")
-	log.Debugf("Creating tar archive at: %s", tarPath)
-	cmd := exec.Command("tar", "-cf", tarPath, "-C", path, ".")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to create tar archive: %w\nOutput: %s", err, string(output))
+	// Create the layer, or reuse a cached one made from the same rootfs
+	layer, err := i.cache.layer("base layer", path, func() (string, error) {
+		return createTar(path, filepath.Join(tempDir, "layer.tar"), i.config.Options.SourceDate())
+	}, tarball.WithCompressionLevel(gzip.BestCompression))
+	if err != nil {
+		return fmt.Errorf("failed to create layer: %w", err)
+	}
+
+	// Read OS information from /etc/os-release
+	osReleaseData, err := os.ReadFile(filepath.Join(path, "etc", "os-release"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read /etc/os-release: %w", err)
+	}
+
+	if err := i.addBaseLayer(layer, osReleaseData); err != nil {
+		return err
+	}
+
+	// Mark success
+	success = true
+
+	// Store the temporary directory path
+	i.tempDirs = append(i.tempDirs, tempDir)
+
+	return nil
+}
+
+// AddBaseLayerFromTar adds a base layer to the image from an existing rootfs tarball
+func (i *Image) AddBaseLayerFromTar(tarPath string) error {
+	log.Debugf("Adding base layer from tarball: %s", tarPath)
+
+	osReleaseData, err := readFileFromTar(tarPath, "/etc/os-release")
+	if err != nil {
+		return fmt.Errorf("failed to read /etc/os-release from tarball: %w", err)
 	}
-	log.Debug("Tar archive created successfully")
 
-	// Create the layer
 	log.Debug("Creating layer from tar file")
 	layer, err := tarball.LayerFromFile(tarPath, tarball.WithCompressionLevel(gzip.BestCompression))
 	if err != nil {
 		return fmt.Errorf("failed to create layer: %w", err)
 	}
-	log.Debug("Layer created successfully")
+	return i.addBaseLayer(layer, osReleaseData)
+}
+
+// TarArgs returns the tar options that write the same tarball of unchanged files on every
+// build, clamping file times to sourceDate. There are none for a zero sourceDate.
+func TarArgs(sourceDate time.Time) []string {
+	if sourceDate.IsZero() {
+		return nil
+	}
+	return []string{"--sort=name", "--numeric-owner", fmt.Sprintf("--mtime=@%d", sourceDate.Unix()), "--clamp-mtime"}
+}
+
+// createTar writes the contents of dir to an uncompressed tarball at tarPath. File times are
+// clamped to sourceDate unless it is zero.
+func createTar(dir, tarPath string, sourceDate time.Time) (string, error) {
+	log.Debugf("Creating tar archive at: %s", tarPath)
+	args := append([]string{"-cf", tarPath}, TarArgs(sourceDate)...)
+	cmd := exec.Command("tar", append(args, "-C", dir, ".")...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to create tar archive: %w\nOutput: %s", err, string(output))
+	}
+	return tarPath, nil
+}
+
+// createFileTar writes a tar archive at tarPath holding the file at src as name, with the
+// directories leading to it. The file is streamed into the archive rather than read into
+// memory, since initrds can be hundreds of megabytes. Its time is clamped to sourceDate
+// unless it is zero.
+func createFileTar(src, name, tarPath string, sourceDate time.Time) (string, error) {
+	log.Debugf("Creating tar archive of %s at: %s", src, tarPath)
+	in, err := os.Open(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat %s: %w", src, err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", src)
+	}
+	modTime := info.ModTime()
+	if !sourceDate.IsZero() && modTime.After(sourceDate) {
+		modTime = sourceDate
+	}
 
+	out, err := os.Create(tarPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create tar archive: %w", err)
+	}
+	defer out.Close()
+
+	tw := tar.NewWriter(out)
+	dirs := strings.Split(name, "/")
+	for n := 1; n < len(dirs); n++ {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     strings.Join(dirs[:n], "/") + "/",
+			Mode:     0755,
+			ModTime:  modTime,
+		}); err != nil {
+			return "", fmt.Errorf("failed to write tar archive: %w", err)
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     info.Size(),
+		ModTime:  modTime,
+	}); err != nil {
+		return "", fmt.Errorf("failed to write tar archive: %w", err)
+	}
+	if _, err := io.Copy(tw, in); err != nil {
+		return "", fmt.Errorf("failed to copy %s into tar archive: %w", src, err)
+	}
+	if err := tw.Close(); err != nil {
+		return "", fmt.Errorf("failed to write tar archive: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return "", fmt.Errorf("failed to write tar archive: %w", err)
+	}
+	return tarPath, nil
+}
+
+// addBaseLayer appends the base OS layer, labeling the image with the OS information
+// parsed from osReleaseData. An empty osReleaseData keeps the labels inherited from the
+// parent.
+func (i *Image) addBaseLayer(layer v1.Layer, osReleaseData []byte) error {
 	// Get current config
 	config, err := i.img.ConfigFile()
 	if err != nil {
 		return fmt.Errorf("failed to get image config: %w", err)
 	}
 
-	// Read OS information from /etc/os-release
-	osReleasePath := filepath.Join(path, "etc", "os-release")
-	osReleaseData, err := os.ReadFile(osReleasePath)
-	if err == nil {
+	if len(osReleaseData) > 0 {
 		// If os-release exists in the new layer, parse it and set the labels.
 		log.Debug("Found /etc/os-release in new layer, parsing for OS info.")
 		osInfo := make(map[string]string)
@@ -141,13 +329,10 @@ func (i *Image) AddBaseLayer(path string) error {
 		config.Config.Labels["com.openchami.image.os.version"] = osInfo["VERSION"]
 		config.Config.Labels["com.openchami.image.os.id"] = osInfo["ID"]
 		config.Config.Labels["com.openchami.image.os.id_like"] = osInfo["ID_LIKE"]
-	} else if os.IsNotExist(err) {
+	} else {
 		// If it doesn't exist, we just log it. The labels from the parent (if any)
 		// are already in the config and will be preserved.
 		log.Warn("'/etc/os-release' not found in new layer. OS labels will be inherited from parent if available.")
-	} else {
-		// For any other error, we fail.
-		return fmt.Errorf("failed to read /etc/os-release: %w", err)
 	}
 
 	// Get build information
@@ -164,7 +349,7 @@ func (i *Image) AddBaseLayer(path string) error {
 
 	// Add parent image information
 	if i.config.Options.Parent != "" && i.config.Options.Parent != "scratch" {
-		config.Config.Labels["com.openchami.image.parent"] = i.config.Options.Parent
+		config.Config.Labels[ParentLabel] = i.config.Options.Parent
 		// Get parent image layers
 		parentLayers, err := i.img.Layers()
 		if err != nil {
@@ -177,15 +362,30 @@ func (i *Image) AddBaseLayer(path string) error {
 	config.Config.Labels["com.openchami.image.build.host"] = hostname
 	config.Config.Labels["com.openchami.image.build.user"] = username
 
+	// Add retention labels, quay.expires-after is enforced by Quay and the expiry by the prune command
+	if i.config.Options.ExpiresAfter != "" {
+		expiry, err := imageconfig.ParseExpiry(i.config.Options.ExpiresAfter)
+		if err != nil {
+			return err
+		}
+		config.Config.Labels["quay.expires-after"] = i.config.Options.ExpiresAfter
+		config.Config.Labels[ExpiresLabel] = time.Now().UTC().Add(expiry).Format(time.RFC3339)
+	}
+
+	// Add user supplied labels
+	for key, value := range i.config.Options.Labels {
+		config.Config.Labels[key] = value
+	}
+
 	// Update the image creation time
-	now := time.Now().UTC()
+	now := creationTime(i.config)
 	config.Created = v1.Time{Time: now}
 
 	// Update history
 	config.History = append(config.History, v1.History{
 		Created:   v1.Time{Time: now},
 		CreatedBy: "go-image-builder",
-		Comment:   "Base OS Layer",
+		Comment:   baseLayerComment,
 	})
 
 	// Update image config
@@ -202,13 +402,30 @@ func (i *Image) AddBaseLayer(path string) error {
 	}
 	log.Debug("Layer appended successfully")
 
-	// Mark success
-	success = true
+	return nil
+}
 
-	// Store the temporary directory path
-	i.tempDirs = append(i.tempDirs, tempDir)
+// readFileFromTar returns the contents of a file in a tarball, or nil if it does not exist
+func readFileFromTar(tarPath, pathInTar string) ([]byte, error) {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tarball: %w", err)
+	}
+	defer f.Close()
 
-	return nil
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading tarball: %w", err)
+		}
+		if filepath.Clean("/"+header.Name) == pathInTar && header.Typeflag == tar.TypeReg {
+			return io.ReadAll(tr)
+		}
+	}
 }
 
 // HasLayerWithComment checks if the parent image contains a layer with the specified comment.
@@ -314,7 +531,7 @@ func (i *Image) findAndCopyLayerFromParent(layerComment string) (bool, error) {
 
 // AddKernelLayer adds a kernel layer to the image
 func (i *Image) AddKernelLayer(kernelPath, kernelVersion string) error {
-	layerComment := "Kernel Layer"
+	layerComment := kernelLayerComment
 	copied, err := i.findAndCopyLayerFromParent(layerComment)
 	if err != nil {
 		return err
@@ -327,7 +544,7 @@ func (i *Image) AddKernelLayer(kernelPath, kernelVersion string) error {
 	log.Debugf("Adding kernel layer from path: %s", kernelPath)
 
 	// Create a temporary directory for the layer
-	tempDir, err := os.MkdirTemp("", "kernel-layer-*")
+	tempDir, err := os.MkdirTemp(i.tempDir, "kernel-layer-*")
 	if err != nil {
 		return fmt.Errorf("failed to create temporary directory: %w", err)
 	}
@@ -340,36 +557,10 @@ func (i *Image) AddKernelLayer(kernelPath, kernelVersion string) error {
 		}
 	}()
 
-	// Create the layer structure
-	layerPath := filepath.Join(tempDir, "layer")
-	if err := os.MkdirAll(layerPath, 0755); err != nil {
-		return fmt.Errorf("failed to create layer directory: %w", err)
-	}
-
-	// Copy the kernel to the layer
-	kernelDest := filepath.Join(layerPath, "boot", "vmlinuz")
-	if err := os.MkdirAll(filepath.Dir(kernelDest), 0755); err != nil {
-		return fmt.Errorf("failed to create boot directory: %w", err)
-	}
-
-	kernelData, err := os.ReadFile(kernelPath)
-	if err != nil {
-		return fmt.Errorf("failed to read kernel: %w", err)
-	}
-
-	if err := os.WriteFile(kernelDest, kernelData, 0644); err != nil {
-		return fmt.Errorf("failed to write kernel: %w", err)
-	}
-
-	// Create the tar archive
-	tarPath := filepath.Join(tempDir, "layer.tar")
-	cmd := exec.Command("tar", "-cf", tarPath, "-C", layerPath, ".")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to create tar archive: %w\nOutput: %s", err, string(output))
-	}
-
-	// Create the layer
-	layer, err := tarball.LayerFromFile(tarPath)
+	// Create the layer straight from the kernel, or reuse a cached one made from the same file
+	layer, err := i.cache.layer("kernel layer", kernelPath, func() (string, error) {
+		return createFileTar(kernelPath, "boot/vmlinuz", filepath.Join(tempDir, "layer.tar"), i.config.Options.SourceDate())
+	})
 	if err != nil {
 		return fmt.Errorf("failed to create layer: %w", err)
 	}
@@ -384,10 +575,10 @@ func (i *Image) AddKernelLayer(kernelPath, kernelVersion string) error {
 	if config.Config.Labels == nil {
 		config.Config.Labels = make(map[string]string)
 	}
-	config.Config.Labels["com.openchami.image.kernel-version"] = kernelVersion
+	config.Config.Labels[KernelVersionLabel] = kernelVersion
 
 	// Update the image creation time
-	now := time.Now().UTC()
+	now := creationTime(i.config)
 	config.Created = v1.Time{Time: now}
 
 	// Update history
@@ -418,22 +609,25 @@ func (i *Image) AddKernelLayer(kernelPath, kernelVersion string) error {
 	return nil
 }
 
-// AddInitrdLayer adds an initrd layer to the image
+// AddInitrdLayer adds an initrd layer to the image. Without an initrd path, the initrd
+// layer of the parent image is reused.
 func (i *Image) AddInitrdLayer(initrdPath string) error {
-	layerComment := "Initrd Layer"
-	copied, err := i.findAndCopyLayerFromParent(layerComment)
-	if err != nil {
-		return err
-	}
-	if copied {
-		return nil // Success, layer was copied from parent.
+	layerComment := initrdLayerComment
+	if initrdPath == "" {
+		copied, err := i.findAndCopyLayerFromParent(layerComment)
+		if err != nil {
+			return err
+		}
+		if copied {
+			return nil // Success, layer was copied from parent.
+		}
 	}
 
 	// Fallback: If not copied, create the layer from scratch.
 	log.Debugf("Adding initrd layer from path: %s", initrdPath)
 
 	// Create a temporary directory for the layer
-	tempDir, err := os.MkdirTemp("", "initrd-layer-*")
+	tempDir, err := os.MkdirTemp(i.tempDir, "initrd-layer-*")
 	if err != nil {
 		return fmt.Errorf("failed to create temporary directory: %w", err)
 	}
@@ -446,36 +640,10 @@ func (i *Image) AddInitrdLayer(initrdPath string) error {
 		}
 	}()
 
-	// Create the layer structure
-	layerPath := filepath.Join(tempDir, "layer")
-	if err := os.MkdirAll(layerPath, 0755); err != nil {
-		return fmt.Errorf("failed to create layer directory: %w", err)
-	}
-
-	// Copy the initrd to the layer
-	initrdDest := filepath.Join(layerPath, "boot", "initrd.img")
-	if err := os.MkdirAll(filepath.Dir(initrdDest), 0755); err != nil {
-		return fmt.Errorf("failed to create boot directory: %w", err)
-	}
-
-	initrdData, err := os.ReadFile(initrdPath)
-	if err != nil {
-		return fmt.Errorf("failed to read initrd: %w", err)
-	}
-
-	if err := os.WriteFile(initrdDest, initrdData, 0644); err != nil {
-		return fmt.Errorf("failed to write initrd: %w", err)
-	}
-
-	// Create the tar archive
-	tarPath := filepath.Join(tempDir, "layer.tar")
-	cmd := exec.Command("tar", "-cf", tarPath, "-C", layerPath, ".")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to create tar archive: %w\nOutput: %s", err, string(output))
-	}
-
-	// Create the layer
-	layer, err := tarball.LayerFromFile(tarPath)
+	// Create the layer straight from the initrd, or reuse a cached one made from the same file
+	layer, err := i.cache.layer("initrd layer", initrdPath, func() (string, error) {
+		return createFileTar(initrdPath, "boot/initrd.img", filepath.Join(tempDir, "layer.tar"), i.config.Options.SourceDate())
+	})
 	if err != nil {
 		return fmt.Errorf("failed to create layer: %w", err)
 	}
@@ -487,7 +655,7 @@ func (i *Image) AddInitrdLayer(initrdPath string) error {
 	}
 
 	// Update the image creation time
-	now := time.Now().UTC()
+	now := creationTime(i.config)
 	config.Created = v1.Time{Time: now}
 
 	// Update history
@@ -518,12 +686,75 @@ func (i *Image) AddInitrdLayer(initrdPath string) error {
 	return nil
 }
 
+// SetKernelVersion labels the image with the version of the kernel it boots, for images
+// built without a kernel layer
+func (i *Image) SetKernelVersion(kernelVersion string) error {
+	return i.SetLabels(map[string]string{KernelVersionLabel: kernelVersion})
+}
+
+// SetLabels adds labels to the image, replacing those it has with the same keys
+func (i *Image) SetLabels(labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	config, err := i.img.ConfigFile()
+	if err != nil {
+		return fmt.Errorf("failed to get image config: %w", err)
+	}
+	if config.Config.Labels == nil {
+		config.Config.Labels = make(map[string]string)
+	}
+	for key, value := range labels {
+		config.Config.Labels[key] = value
+	}
+	i.img, err = mutate.ConfigFile(i.img, config)
+	if err != nil {
+		return fmt.Errorf("failed to update image config: %w", err)
+	}
+	return nil
+}
+
+// writeEmbeddedConfig writes the config to dir as the operator wrote it, keeping comments,
+// key order and unknown fields, next to the configuration resolved for this build. The
+// sections options.embed_config leaves out are removed, and unless the whole configuration
+// is embedded, known secrets are masked.
+func (i *Image) writeEmbeddedConfig(dir string) error {
+	embed := i.config.Options.EmbedConfig
+	if embed.Mode == "none" {
+		log.Debug("Not embedding the configuration in the image")
+		return nil
+	}
+
+	resolved, err := yaml.Marshal(i.config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	written := i.config.Raw
+	if len(written) == 0 {
+		written = resolved
+	}
+
+	for name, data := range map[string][]byte{"image-config.yaml": written, "image-config.resolved.yaml": resolved} {
+		data, err := imageconfig.StripSections(data, embed.Sections())
+		if err != nil {
+			return fmt.Errorf("failed to sanitize %s: %w", name, err)
+		}
+		if embed.Mode != "full" {
+			data = []byte(redact.String(string(data)))
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
 // AddConfigLayer adds the configuration as a separate layer
 func (i *Image) AddConfigLayer() error {
 	log.Debug("Adding config layer")
 
 	// Create a temporary directory for the layer
-	tempDir, err := os.MkdirTemp("", "config-layer-*")
+	tempDir, err := os.MkdirTemp(i.tempDir, "config-layer-*")
 	if err != nil {
 		return fmt.Errorf("failed to create temporary directory: %w", err)
 	}
@@ -542,25 +773,32 @@ func (i *Image) AddConfigLayer() error {
 		return fmt.Errorf("failed to create layer directory: %w", err)
 	}
 
-	// Write the config to the layer
-	configPath := filepath.Join(layerPath, "etc", "image-config.yaml")
-	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+	etcDir := filepath.Join(layerPath, "etc")
+	if err := os.MkdirAll(etcDir, 0755); err != nil {
 		return fmt.Errorf("failed to create etc directory: %w", err)
 	}
-
-	if err := imageconfig.WriteConfig(i.config, configPath); err != nil {
-		return fmt.Errorf("failed to write config: %w", err)
+	if err := i.writeEmbeddedConfig(etcDir); err != nil {
+		return err
 	}
-
-	// Create the tar archive
-	tarPath := filepath.Join(tempDir, "layer.tar")
-	cmd := exec.Command("tar", "-cf", tarPath, "-C", layerPath, ".")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to create tar archive: %w\nOutput: %s", err, string(output))
+	if i.provenance != nil {
+		if err := i.provenance.write(filepath.Join(etcDir, "image-provenance.yaml")); err != nil {
+			return fmt.Errorf("failed to write provenance: %w", err)
+		}
+	}
+	info, err := i.imageInfo()
+	if err != nil {
+		return err
+	}
+	if info != nil {
+		if err := writeImageInfo(filepath.Join(layerPath, ImageInfoPath), info); err != nil {
+			return fmt.Errorf("failed to write image info: %w", err)
+		}
 	}
 
-	// Create the layer
-	layer, err := tarball.LayerFromFile(tarPath)
+	// Create the layer, or reuse a cached one made from the same files
+	layer, err := i.cache.layer("configuration layer", layerPath, func() (string, error) {
+		return createTar(layerPath, filepath.Join(tempDir, "layer.tar"), i.config.Options.SourceDate())
+	})
 	if err != nil {
 		return fmt.Errorf("failed to create layer: %w", err)
 	}
@@ -571,15 +809,31 @@ func (i *Image) AddConfigLayer() error {
 		return fmt.Errorf("failed to get image config: %w", err)
 	}
 
+	// Label the image with its build inputs
+	if i.provenance != nil {
+		if config.Config.Labels == nil {
+			config.Config.Labels = make(map[string]string)
+		}
+		i.provenance.labels(config.Config.Labels)
+	}
+
+	// Label the image with its provisioning metadata
+	if info != nil && config.Config.Labels == nil {
+		config.Config.Labels = make(map[string]string)
+	}
+	for key, value := range info {
+		config.Config.Labels[ImageInfoLabelPrefix+key] = value
+	}
+
 	// Update the image creation time
-	now := time.Now().UTC()
+	now := creationTime(i.config)
 	config.Created = v1.Time{Time: now}
 
 	// Update history
 	config.History = append(config.History, v1.History{
 		Created:   v1.Time{Time: now},
 		CreatedBy: "go-image-builder",
-		Comment:   "Configuration Layer",
+		Comment:   configLayerComment,
 	})
 
 	// Update image config
@@ -603,6 +857,83 @@ func (i *Image) AddConfigLayer() error {
 	return nil
 }
 
+// Squash collapses all layers, including those of the parent, into a single layer. Labels
+// are kept and the history is replaced by one entry naming the squashed layers, so images
+// built on a squashed parent cannot reuse its kernel and initrd layers.
+func (i *Image) Squash() error {
+	config, err := i.img.ConfigFile()
+	if err != nil {
+		return fmt.Errorf("failed to get image config: %w", err)
+	}
+	layers, err := i.img.Layers()
+	if err != nil {
+		return fmt.Errorf("failed to get image layers: %w", err)
+	}
+	log.Infof("Squashing %d layers into one", len(layers))
+
+	tempDir, err := os.MkdirTemp(i.tempDir, "squash-layer-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	i.tempDirs = append(i.tempDirs, tempDir)
+
+	// Extract applies whiteouts, so files deleted by a later layer are dropped
+	tarPath := filepath.Join(tempDir, "layer.tar")
+	f, err := os.Create(tarPath)
+	if err != nil {
+		return fmt.Errorf("failed to create squashed layer: %w", err)
+	}
+	if err := i.ExportRootfs(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write squashed layer: %w", err)
+	}
+
+	layer, err := tarball.LayerFromFile(tarPath, tarball.WithCompressionLevel(gzip.BestCompression))
+	if err != nil {
+		return fmt.Errorf("failed to create squashed layer: %w", err)
+	}
+
+	var squashed []string
+	for _, h := range config.History {
+		if h.EmptyLayer {
+			continue
+		}
+		switch {
+		case h.Comment != "":
+			squashed = append(squashed, h.Comment)
+		case h.CreatedBy != "":
+			squashed = append(squashed, h.CreatedBy)
+		}
+	}
+
+	config.RootFS.DiffIDs = nil
+	config.History = nil
+	if config.Config.Labels == nil {
+		config.Config.Labels = make(map[string]string)
+	}
+	config.Config.Labels["com.openchami.image.squashed"] = "true"
+	base, err := mutate.ConfigFile(empty.Image, config)
+	if err != nil {
+		return fmt.Errorf("failed to update image config: %w", err)
+	}
+	i.img, err = mutate.Append(base, mutate.Addendum{
+		Layer: layer,
+		History: v1.History{
+			Created:   v1.Time{Time: creationTime(i.config)},
+			CreatedBy: "go-image-builder",
+			Comment:   fmt.Sprintf("%s: %s", squashedLayerComment, strings.Join(squashed, ", ")),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to append squashed layer: %w", err)
+	}
+	i.squashed = true
+	return nil
+}
+
 // Push pushes the image to the registry, handling multiple tags and retries.
 func (i *Image) Push() error {
 	log.Debugf("Starting image push to registry: %s", i.name)
@@ -611,21 +942,42 @@ func (i *Image) Push() error {
 		return fmt.Errorf("failed to parse image reference: %w", err)
 	}
 
-	// 1. Ensure the parent image exists in the registry first.
+	// Pushed manifests use the configured media types, not whichever the parent had
+	if err := i.applyManifestFormat(); err != nil {
+		return err
+	}
+
+	// 1. Get the list of tags to publish.
+	tags, err := i.Tags()
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		tags = []string{baseRef.Identifier()} // Default to the base reference's identifier (e.g., 'latest')
+	}
+	log.Debugf("Publishing with tags: %v", tags)
+
+	// 2. Refuse to move immutable tags before anything is pushed.
+	if err := i.checkImmutableTags(baseRef, tags); err != nil {
+		return err
+	}
+
+	// 3. Ensure the parent image exists in the registry first.
 	if err := i.ensureParentImage(); err != nil {
+		if i.config.Options.ParentPushPolicy == "fail" {
+			return err
+		}
 		// Log as a warning because this might not be a fatal error if the
 		// parent already exists and is accessible.
 		log.Warnf("Could not ensure parent image exists (this may be safe to ignore): %v", err)
 	}
 
-	// 2. Get the list of tags to publish.
-	tags := strings.Split(i.config.Options.PublishTags, ",")
-	if len(tags) == 0 || (len(tags) == 1 && tags[0] == "") {
-		tags = []string{baseRef.Identifier()} // Default to the base reference's identifier (e.g., 'latest')
+	// 4. Upload large layers in chunks that survive dropped connections.
+	if err := i.uploadLargeLayers(baseRef.Context()); err != nil {
+		return err
 	}
-	log.Debugf("Publishing with tags: %v", tags)
 
-	// 3. Push the image with each tag.
+	// 5. Push the image with each tag.
 	for _, tag := range tags {
 		tag = strings.TrimSpace(tag)
 		if tag == "" {
@@ -636,16 +988,83 @@ func (i *Image) Push() error {
 		}
 	}
 
+	if err := i.blobCache.Save(); err != nil {
+		log.Warnf("Failed to save blob cache: %v", err)
+	}
 	log.Infof("Successfully pushed all tags for image: %s", i.name)
 	return nil
 }
 
-// ensureParentImage checks for the parent image in the remote registry and pushes it
-// if it's not available. This is important for ensuring layers can be found.
+// Name returns the full reference of the image without a tag
+func (i *Image) Name() string {
+	return i.name
+}
+
+// Labels returns the labels of the image config
+func (i *Image) Labels() (map[string]string, error) {
+	config, err := i.img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image config: %w", err)
+	}
+	return config.Config.Labels, nil
+}
+
+// LayerSize is the compressed size and digest of an image layer, named by its history comment
+type LayerSize struct {
+	Comment string `json:"comment"`
+	Digest  string `json:"digest"`
+	Size    int64  `json:"size"`
+}
+
+// LayerSizes returns the sizes and digests of the image layers, from the bottom layer up
+func (i *Image) LayerSizes() ([]LayerSize, error) {
+	config, err := i.img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image config: %w", err)
+	}
+	manifest, err := i.img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image manifest: %w", err)
+	}
+
+	// History entries that are not empty layers map to the manifest layers in order
+	sizes := make([]LayerSize, 0, len(manifest.Layers))
+	for _, h := range config.History {
+		if h.EmptyLayer || len(sizes) == len(manifest.Layers) {
+			continue
+		}
+		layer := manifest.Layers[len(sizes)]
+		sizes = append(sizes, LayerSize{Comment: h.Comment, Digest: layer.Digest.String(), Size: layer.Size})
+	}
+	return sizes, nil
+}
+
+// Digest returns the manifest digest of the image
+func (i *Image) Digest() (string, error) {
+	digest, err := i.img.Digest()
+	if err != nil {
+		return "", fmt.Errorf("failed to get image digest: %w", err)
+	}
+	return digest.String(), nil
+}
+
+// ensureParentImage checks for the parent image in the remote registry and pushes the
+// loaded parent if it's not available, so the layers the image shares with it can be found.
+// options.parent_push_policy "never" skips this, and "fail" fails instead of pushing.
 func (i *Image) ensureParentImage() error {
 	if i.config.Options.Parent == "" || i.config.Options.Parent == "scratch" {
 		return nil // No parent to ensure.
 	}
+	if _, ok := i.config.Options.ParentArchive(); ok {
+		return nil // Archive parents have no registry to push to.
+	}
+	if i.squashed {
+		return nil // A squashed image does not share layers with its parent.
+	}
+	policy := i.config.Options.ParentPushPolicy
+	if policy == "never" {
+		return nil
+	}
 
 	log.Debugf("Ensuring parent image is pushed: %s", i.config.Options.Parent)
 	parentRefStr := utils.SanitizeRegistryURL(i.config.Options.Parent)
@@ -654,16 +1073,23 @@ func (i *Image) ensureParentImage() error {
 		return fmt.Errorf("failed to parse parent image reference '%s': %w", parentRefStr, err)
 	}
 
-	// Attempting to pull the parent's manifest is a lightweight way to check if it exists.
-	if _, err := crane.Manifest(parentRef.String(), crane.Insecure); err == nil {
+	// Fetching the parent's descriptor is a lightweight way to check if it exists.
+	if _, err := crane.Head(parentRef.String(), crane.Insecure); err == nil {
 		log.Debugf("Parent image manifest found in registry: %s", parentRef.String())
 		return nil // Parent already exists.
 	}
 
-	// If the parent doesn't exist, we need to push it. This assumes the current
-	// image `i.img` was built from this parent and contains all its layers.
-	log.Infof("Parent image not found in registry, attempting to push it: %s", parentRef.String())
-	if err := crane.Push(i.img, parentRef.String(), crane.Insecure); err != nil {
+	if policy == "fail" {
+		return fmt.Errorf("parent image %s not found in registry, push it before publishing images built on it", parentRef.String())
+	}
+	if i.parent == nil {
+		return fmt.Errorf("parent image %s not found in registry and was not loaded, so it cannot be pushed", parentRef.String())
+	}
+
+	// Push the parent as it was loaded, never the image built on it, which would replace
+	// the parent tag with the child.
+	log.Infof("Parent image not found in registry, pushing it: %s", parentRef.String())
+	if err := crane.Push(i.parent, parentRef.String(), i.pushOptions(parentRef.String())...); err != nil {
 		return fmt.Errorf("failed to push parent image: %w", err)
 	}
 	log.Debugf("Successfully pushed parent image: %s", parentRef.String())
@@ -689,7 +1115,7 @@ func (i *Image) pushTagWithRetries(baseRef name.Reference, tag string) error {
 		}
 
 		log.Infof("Pushing image with tag: %s", taggedRef.String())
-		err = crane.Push(i.img, taggedRef.String(), crane.Insecure)
+		err = crane.Push(i.img, taggedRef.String(), i.pushOptions(taggedRef.String())...)
 		if err == nil {
 			log.Infof("Successfully pushed tag: %s", taggedRef.String())
 			return nil // Success
@@ -698,6 +1124,12 @@ func (i *Image) pushTagWithRetries(baseRef name.Reference, tag string) error {
 		lastErr = err
 		log.Warnf("Push attempt %d for tag %s failed: %v", attempt+1, tag, err)
 
+		// A blob the cache remembered may have been removed from the registry since
+		if strings.Contains(err.Error(), "BLOB_UNKNOWN") && i.blobCache.forget(taggedRef.Context().Name()) {
+			log.Warnf("Registry is missing a cached blob of %s, checking all blobs again", taggedRef.Context().Name())
+			continue
+		}
+
 		// Only retry on "BLOB_UPLOAD_UNKNOWN", which can be a transient registry issue.
 		if !strings.Contains(err.Error(), "BLOB_UPLOAD_UNKNOWN") {
 			log.Errorf("Unrecoverable error while pushing tag %s, stopping retries.", tag)
@@ -708,64 +1140,34 @@ func (i *Image) pushTagWithRetries(baseRef name.Reference, tag string) error {
 	return fmt.Errorf("failed to push tag %s after %d attempts: %w", tag, maxRetries, lastErr)
 }
 
-// extractFile is a helper to extract a single file from the image layers.
-func (i *Image) extractFile(pathInImage, destPath string) error {
-	layers, err := i.img.Layers()
-	if err != nil {
-		return fmt.Errorf("could not get layers: %w", err)
-	}
-
-	// Loop through layers in reverse to find the last version of the file.
-	for j := len(layers) - 1; j >= 0; j-- {
-		layer := layers[j]
-		rc, err := layer.Uncompressed()
-		if err != nil {
-			return fmt.Errorf("could not uncompress layer %d: %w", j, err)
-		}
-		defer rc.Close()
+// ExportRootfs writes the flattened filesystem of all image layers to w as a tarball
+func (i *Image) ExportRootfs(w io.Writer) error {
+	rc := mutate.Extract(i.img)
+	defer rc.Close()
 
-		tr := tar.NewReader(rc)
-		for {
-			header, err := tr.Next()
-			if err == io.EOF {
-				break // End of layer
-			}
-			if err != nil {
-				return fmt.Errorf("error reading layer tar %d: %w", j, err)
-			}
-
-			// The path in the tarball is relative, so we add a leading /
-			if filepath.Clean("/"+header.Name) == pathInImage {
-				if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-					return fmt.Errorf("failed to create destination directory for '%s': %w", destPath, err)
-				}
-				outFile, err := os.Create(destPath)
-				if err != nil {
-					return fmt.Errorf("failed to create destination file '%s': %w", destPath, err)
-				}
-				defer outFile.Close()
-
-				if _, err := io.Copy(outFile, tr); err != nil {
-					return fmt.Errorf("failed to copy file content for '%s': %w", pathInImage, err)
-				}
-				log.Debugf("Extracted '%s' to '%s'", pathInImage, destPath)
-				return nil // File found and extracted.
-			}
-		}
+	if _, err := io.Copy(w, rc); err != nil {
+		return fmt.Errorf("failed to export rootfs: %w", err)
 	}
-
-	return fmt.Errorf("file '%s' not found in any layer of the image", pathInImage)
+	return nil
 }
 
-// ExtractKernel extracts the kernel from the image and saves it to the destination path.
-// The kernel is expected to be located at /boot/vmlinuz in the image.
-func (i *Image) ExtractKernel(destPath string) error {
-	return i.extractFile("/boot/vmlinuz", destPath)
+// Save writes the image to a Docker archive tarball at the given path
+func (i *Image) Save(path string) error {
+	tag, err := name.NewTag(i.name, name.Insecure)
+	if err != nil {
+		return fmt.Errorf("failed to parse image reference: %w", err)
+	}
+
+	log.Debugf("Saving image %s to %s", tag.String(), path)
+	if err := tarball.WriteToFile(path, tag, i.img); err != nil {
+		return fmt.Errorf("failed to save image: %w", err)
+	}
+	return nil
 }
 
-// ExtractInitrd extracts the initrd from the image
-func (i *Image) ExtractInitrd(destPath string) error {
-	return i.extractFile("/boot/initrd.img", destPath)
+// TempDirs returns the temporary layer directories created so far
+func (i *Image) TempDirs() []string {
+	return i.tempDirs
 }
 
 // Cleanup removes all temporary directories and files created during the image build.
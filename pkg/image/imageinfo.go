@@ -0,0 +1,75 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// ImageInfoPath is where images carry their provisioning metadata, relative to the root
+const ImageInfoPath = "etc/openchami/image-info"
+
+// ImageInfoLabelPrefix prefixes the labels recording the provisioning metadata, as in
+// com.openchami.image.info.role
+const ImageInfoLabelPrefix = "com.openchami.image.info."
+
+// imageInfoQuoting escapes the characters a double quoted shell string gives a meaning to
+var imageInfoQuoting = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+
+// imageInfo returns the provisioning metadata of options.image_info by key, with the build
+// ID rendered, or nil if none is configured
+func (i *Image) imageInfo() (map[string]string, error) {
+	info := i.config.Options.ImageInfo
+	if info.IsZero() {
+		return nil, nil
+	}
+
+	values := map[string]string{"name": i.config.Options.Name}
+	for key, value := range info.Extra {
+		values[key] = value
+	}
+	if info.Cluster != "" {
+		values["cluster"] = info.Cluster
+	}
+	if info.Role != "" {
+		values["role"] = info.Role
+	}
+	if info.BuildID != "" {
+		data, err := i.tagData("options.image_info.build_id", info.BuildID)
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := template.New("build_id").Parse(info.BuildID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid build id template '%s': %w", info.BuildID, err)
+		}
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, data); err != nil {
+			return nil, fmt.Errorf("failed to render build id '%s': %w", info.BuildID, err)
+		}
+		values["build_id"] = sb.String()
+	}
+	return values, nil
+}
+
+// writeImageInfo writes info to path in the format of os-release, a shell-compatible
+// IMAGE_KEY="value" line per key in sorted order
+func writeImageInfo(path string, info map[string]string) error {
+	keys := make([]string, 0, len(info))
+	for key := range info {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&sb, "IMAGE_%s=\"%s\"\n", strings.ToUpper(key), imageInfoQuoting.Replace(info[key]))
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0644)
+}
@@ -0,0 +1,89 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/gzip"
+	log "github.com/sirupsen/logrus"
+)
+
+// kernelImagePaths are the paths ExtractKernel looks for the kernel at, in order. Kernel
+// layers hold it at /boot/vmlinuz; the others are where arm64 and EFI-stub kernels are found
+// in images without one.
+var kernelImagePaths = []string{
+	"/boot/vmlinuz",
+	"/boot/Image",
+	"/boot/vmlinuz.efi",
+	"/boot/Image.gz",
+	"/boot/vmlinuz.gz",
+}
+
+// KernelPaths returns the paths the kernel of version may be installed at, in order: x86
+// vmlinuz, arm64 Image and EFI-stub kernels, in /boot and in the modules directory, and
+// their gzip-compressed forms
+func KernelPaths(version string) []string {
+	var paths []string
+	for _, dir := range []string{"/boot/%s-" + version, "/lib/modules/" + version + "/%s"} {
+		for _, name := range []string{"vmlinuz", "Image", "vmlinuz.efi", "vmlinuz.gz", "Image.gz"} {
+			paths = append(paths, fmt.Sprintf(dir, name))
+		}
+	}
+	return paths
+}
+
+// gzipMagic starts gzip streams
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// DecompressKernel decompresses the kernel at path in place if it is gzip-compressed, as
+// arm64 Image.gz kernels are, reporting whether it was. Kernels that decompress themselves,
+// such as x86 bzImages and EFI zboot kernels, are left as they are.
+func DecompressKernel(path string) (bool, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open kernel: %w", err)
+	}
+	defer in.Close()
+
+	r := bufio.NewReader(in)
+	magic, err := r.Peek(len(gzipMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("failed to read kernel: %w", err)
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return false, nil
+	}
+
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return false, fmt.Errorf("failed to decompress kernel: %w", err)
+	}
+	defer zr.Close()
+
+	// Decompress next to the kernel, so it is replaced whole
+	out, err := os.CreateTemp(filepath.Dir(path), ".kernel-*")
+	if err != nil {
+		return false, fmt.Errorf("failed to create decompressed kernel: %w", err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	if _, err := io.Copy(out, zr); err != nil {
+		return false, fmt.Errorf("failed to decompress kernel: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return false, fmt.Errorf("failed to write decompressed kernel: %w", err)
+	}
+	if err := os.Chmod(out.Name(), 0644); err != nil {
+		return false, err
+	}
+	if err := os.Rename(out.Name(), path); err != nil {
+		return false, fmt.Errorf("failed to replace kernel: %w", err)
+	}
+	log.Infof("Decompressed gzip-compressed kernel %s", path)
+	return true, nil
+}
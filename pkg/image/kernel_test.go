@@ -0,0 +1,98 @@
+package image
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/klauspost/compress/gzip"
+)
+
+func gzipped(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestExtractKernel(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		keep    bool
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "vmlinuz",
+			entries: []entry{{name: "boot/vmlinuz", content: "MZ bzImage"}, {name: "boot/Image", content: "arm64"}},
+			want:    "MZ bzImage",
+		},
+		{
+			name:    "arm64 Image",
+			entries: []entry{{name: "boot/Image", content: "arm64"}},
+			want:    "arm64",
+		},
+		{
+			name:    "EFI stub",
+			entries: []entry{{name: "boot/vmlinuz.efi", content: "MZ zboot"}},
+			want:    "MZ zboot",
+		},
+		{
+			name:    "gzip-compressed Image",
+			entries: []entry{{name: "boot/Image.gz", content: gzipped(t, "arm64")}},
+			want:    "arm64",
+		},
+		{
+			name:    "compressed vmlinuz is decompressed",
+			entries: []entry{{name: "boot/vmlinuz", content: gzipped(t, "kernel")}},
+			want:    "kernel",
+		},
+		{
+			name:    "keep compressed kernel",
+			entries: []entry{{name: "boot/Image.gz", content: gzipped(t, "arm64")}},
+			keep:    true,
+			want:    gzipped(t, "arm64"),
+		},
+		{
+			name:    "no kernel",
+			entries: []entry{{name: "boot/initrd.img", content: "initrd"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := mutate.AppendLayers(empty.Image, testLayer(t, tt.entries...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := &imageconfig.Config{}
+			config.Options.KeepCompressedKernel = tt.keep
+			i := &Image{img: img, config: config}
+
+			dest := filepath.Join(t.TempDir(), "vmlinuz")
+			err = i.ExtractKernel(dest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExtractKernel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if data, err := os.ReadFile(dest); err != nil || string(data) != tt.want {
+				t.Errorf("extracted kernel = %q, %v, want %q", data, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package image
+
+import (
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// rewriteManifest returns img with a manifest of manifestType and a config of configType,
+// whose layer descriptors are returned by fn from the current ones. The blobs, config and
+// manifest annotations are kept, so only the manifest and its digest differ.
+func rewriteManifest(img v1.Image, manifestType, configType types.MediaType, fn func(n int, layer v1.Layer, desc v1.Descriptor) (mutate.Addendum, error)) (v1.Image, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image manifest: %w", err)
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image config: %w", err)
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image layers: %w", err)
+	}
+
+	additions := make([]mutate.Addendum, len(layers))
+	for n, layer := range layers {
+		if additions[n], err = fn(n, layer, manifest.Layers[n]); err != nil {
+			return nil, err
+		}
+	}
+
+	rewritten := mutate.MediaType(empty.Image, manifestType)
+	rewritten = mutate.ConfigMediaType(rewritten, configType)
+	if rewritten, err = mutate.Append(rewritten, additions...); err != nil {
+		return nil, fmt.Errorf("failed to rewrite layers: %w", err)
+	}
+	// The config of the image replaces the one Append built, keeping its history
+	if rewritten, err = mutate.ConfigFile(rewritten, config); err != nil {
+		return nil, fmt.Errorf("failed to rewrite config: %w", err)
+	}
+	if len(manifest.Annotations) > 0 {
+		rewritten = mutate.Annotations(rewritten, manifest.Annotations).(v1.Image)
+	}
+	return rewritten, nil
+}
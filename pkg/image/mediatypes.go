@@ -0,0 +1,83 @@
+package image
+
+import (
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// manifestFormat holds the media types of a manifest format selected with
+// options.manifest_format. Layers maps the layer media types of either format to the
+// corresponding type of this one; types missing from it cannot be held by the format.
+type manifestFormat struct {
+	manifest types.MediaType
+	config   types.MediaType
+	layers   map[types.MediaType]types.MediaType
+}
+
+var manifestFormats = map[string]manifestFormat{
+	"oci": {
+		manifest: types.OCIManifestSchema1,
+		config:   types.OCIConfigJSON,
+		layers: map[types.MediaType]types.MediaType{
+			types.DockerLayer:                    types.OCILayer,
+			types.DockerUncompressedLayer:        types.OCIUncompressedLayer,
+			types.DockerForeignLayer:             types.OCIRestrictedLayer,
+			types.OCILayer:                       types.OCILayer,
+			types.OCILayerZStd:                   types.OCILayerZStd,
+			types.OCIUncompressedLayer:           types.OCIUncompressedLayer,
+			types.OCIRestrictedLayer:             types.OCIRestrictedLayer,
+			types.OCIUncompressedRestrictedLayer: types.OCIUncompressedRestrictedLayer,
+		},
+	},
+	"docker": {
+		manifest: types.DockerManifestSchema2,
+		config:   types.DockerConfigJSON,
+		layers: map[types.MediaType]types.MediaType{
+			types.OCILayer:                types.DockerLayer,
+			types.OCIUncompressedLayer:    types.DockerUncompressedLayer,
+			types.OCIRestrictedLayer:      types.DockerForeignLayer,
+			types.DockerLayer:             types.DockerLayer,
+			types.DockerUncompressedLayer: types.DockerUncompressedLayer,
+			types.DockerForeignLayer:      types.DockerForeignLayer,
+		},
+	},
+}
+
+// convertMediaTypes returns img with the manifest, config and layer media types of format,
+// "oci" or "docker". The blobs are unchanged, so only the manifest and its digest differ.
+// Images mixing the types of both formats, such as OCI parents with layers added by the
+// builder, are made consistent.
+func convertMediaTypes(img v1.Image, format string) (v1.Image, error) {
+	f, ok := manifestFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported manifest format: %s", format)
+	}
+
+	return rewriteManifest(img, f.manifest, f.config, func(n int, layer v1.Layer, desc v1.Descriptor) (mutate.Addendum, error) {
+		converted, ok := f.layers[desc.MediaType]
+		if !ok {
+			return mutate.Addendum{}, fmt.Errorf("layer %d has media type %s, which %s manifests cannot hold", n, desc.MediaType, format)
+		}
+		return mutate.Addendum{Layer: layer, MediaType: converted, Annotations: desc.Annotations}, nil
+	})
+}
+
+// applyManifestFormat converts the image to the media types of options.manifest_format, if
+// set
+func (i *Image) applyManifestFormat() error {
+	format := i.config.Options.ManifestFormat
+	if format == "" {
+		return nil
+	}
+	img, err := convertMediaTypes(i.img, format)
+	if err != nil {
+		return err
+	}
+	i.img = img
+	log.Debugf("Using %s media types for %s", format, i.name)
+	return nil
+}
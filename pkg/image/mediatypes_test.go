@@ -0,0 +1,94 @@
+package image
+
+import (
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestConvertMediaTypes(t *testing.T) {
+	layer := func(mediaType types.MediaType) v1.Layer {
+		l, err := random.Layer(64, mediaType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	// An OCI parent with a layer added by the builder, which uses Docker media types
+	parent := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	parent = mutate.ConfigMediaType(parent, types.OCIConfigJSON)
+	mixed, err := mutate.Append(parent,
+		mutate.Addendum{Layer: layer(types.OCILayer), History: v1.History{Comment: "Base Layer"}},
+		mutate.Addendum{Layer: layer(types.DockerLayer), History: v1.History{Comment: kernelLayerComment}, Annotations: map[string]string{"org.opencontainers.image.type": "kernel"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		img          v1.Image
+		format       string
+		wantManifest types.MediaType
+		wantLayer    types.MediaType
+		wantErr      bool
+	}{
+		{name: "mixed to oci", img: mixed, format: "oci", wantManifest: types.OCIManifestSchema1, wantLayer: types.OCILayer},
+		{name: "mixed to docker", img: mixed, format: "docker", wantManifest: types.DockerManifestSchema2, wantLayer: types.DockerLayer},
+		{name: "zstd to docker", img: mustAppend(t, parent, layer(types.OCILayerZStd)), format: "docker", wantErr: true},
+		{name: "unknown format", img: mixed, format: "schema1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			converted, err := convertMediaTypes(tt.img, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("convertMediaTypes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			manifest, err := converted.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if manifest.MediaType != tt.wantManifest {
+				t.Errorf("manifest media type = %s, want %s", manifest.MediaType, tt.wantManifest)
+			}
+			original, _ := tt.img.Manifest()
+			for n, l := range manifest.Layers {
+				if l.MediaType != tt.wantLayer {
+					t.Errorf("layer %d media type = %s, want %s", n, l.MediaType, tt.wantLayer)
+				}
+				if l.Digest != original.Layers[n].Digest {
+					t.Errorf("layer %d digest = %s, want %s", n, l.Digest, original.Layers[n].Digest)
+				}
+			}
+			if manifest.Layers[1].Annotations["org.opencontainers.image.type"] != "kernel" {
+				t.Errorf("kernel layer annotations = %v, want them kept", manifest.Layers[1].Annotations)
+			}
+
+			config, err := converted.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(config.History) != 2 || config.History[1].Comment != kernelLayerComment {
+				t.Errorf("history = %+v, want it kept", config.History)
+			}
+		})
+	}
+}
+
+func mustAppend(t *testing.T, img v1.Image, layers ...v1.Layer) v1.Image {
+	t.Helper()
+	img, err := mutate.AppendLayers(img, layers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
@@ -0,0 +1,53 @@
+package image
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"go-image-builder/pkg/progress"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// SetProgress sets the reporter that receives the bytes pushed by Push. Finished pushes are
+// logged without one.
+func (i *Image) SetProgress(r progress.Reporter) {
+	i.progress = r
+}
+
+// pushOptions returns the crane options of a push to ref. Untrusted certificates are
+// accepted, as with crane.Insecure, and blobs in the blob cache are skipped.
+func (i *Image) pushOptions(ref string) []crane.Option {
+	return []crane.Option{
+		crane.Insecure,
+		crane.WithTransport(i.blobCache.Transport(insecureTransport())),
+		i.withProgress(ref),
+	}
+}
+
+// insecureTransport returns a registry transport accepting untrusted certificates
+func insecureTransport() *http.Transport {
+	t := remote.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	return t
+}
+
+// withProgress returns a crane option reporting the progress of a push to ref
+func (i *Image) withProgress(ref string) crane.Option {
+	reporter := progress.OrLog(i.progress)
+	// The registry client closes the channel once the push is done
+	updates := make(chan v1.Update, 16)
+	go func() {
+		for update := range updates {
+			// Failed pushes are reported by the push itself
+			if update.Error == nil {
+				reporter.Transfer(ref, update.Complete, update.Total)
+			}
+		}
+	}()
+	return func(o *crane.Options) {
+		o.Remote = append(o.Remote, remote.WithProgress(updates))
+	}
+}
@@ -0,0 +1,54 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Labels recording the inputs an image was built from
+const (
+	ConfigDigestLabel   = "com.openchami.image.config.digest"
+	ConfigCommitLabel   = "com.openchami.image.config.commit"
+	BuilderVersionLabel = "com.openchami.image.builder.version"
+)
+
+// Provenance records what an image was built from, so a running node can be traced back to
+// its build inputs
+type Provenance struct {
+	// ConfigDigest is the sha256 digest of the configuration file
+	ConfigDigest string `yaml:"config_digest"`
+	// ConfigCommit is the git commit that last changed the configuration file, with a
+	// -dirty suffix if it has uncommitted changes. It is empty outside a git repository.
+	ConfigCommit string `yaml:"config_commit,omitempty"`
+	// BuilderVersion is the version of go-image-builder the image was built with
+	BuilderVersion string `yaml:"builder_version"`
+}
+
+// SetProvenance sets the build inputs recorded in the labels and the config layer
+func (i *Image) SetProvenance(p Provenance) {
+	i.provenance = &p
+}
+
+// labels adds the provenance labels to labels
+func (p *Provenance) labels(labels map[string]string) {
+	labels[ConfigDigestLabel] = p.ConfigDigest
+	labels[BuilderVersionLabel] = p.BuilderVersion
+	if p.ConfigCommit != "" {
+		labels[ConfigCommitLabel] = p.ConfigCommit
+	}
+}
+
+// write writes the provenance as YAML to path
+func (p *Provenance) write(path string) error {
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to marshal provenance: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
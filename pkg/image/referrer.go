@@ -0,0 +1,89 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// Artifact types of the boot files pushed with AttachBootFiles and the node overlays pushed
+// with AttachNodeOverlay
+const (
+	KernelArtifactType      types.MediaType = "application/vnd.openchami.image.kernel"
+	InitrdArtifactType      types.MediaType = "application/vnd.openchami.image.initrd"
+	NodeOverlayArtifactType types.MediaType = "application/vnd.openchami.image.node-overlay"
+)
+
+// titleAnnotation names the file a blob is written to by clients such as oras pull
+const titleAnnotation = "org.opencontainers.image.title"
+
+// AttachReport pushes a file as an OCI artifact referring to the pushed image, so registries
+// supporting the referrers API list it with the image. The image must have been pushed.
+func (i *Image) AttachReport(path string, artifactType types.MediaType) error {
+	return i.attach("report", path, artifactType)
+}
+
+// AttachBootFiles pushes the kernel and the initrd as OCI artifacts of their own referring to
+// the pushed image, so boot servers can fetch them without pulling the image. The image must
+// have been pushed.
+func (i *Image) AttachBootFiles(kernelPath, initrdPath string) error {
+	if err := i.attach("kernel", kernelPath, KernelArtifactType); err != nil {
+		return err
+	}
+	return i.attach("initrd", initrdPath, InitrdArtifactType)
+}
+
+// AttachNodeOverlay pushes a node overlay as an OCI artifact referring to the pushed image,
+// so provisioning finds the overlays of an image with it. The image must have been pushed.
+func (i *Image) AttachNodeOverlay(path string) error {
+	return i.attach("node overlay", path, NodeOverlayArtifactType)
+}
+
+// attach pushes the file at path as an artifact of artifactType with the image as subject
+func (i *Image) attach(kind, path string, artifactType types.MediaType) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", kind, err)
+	}
+
+	subject, err := partial.Descriptor(i.img)
+	if err != nil {
+		return fmt.Errorf("failed to describe image: %w", err)
+	}
+	artifact, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(data, artifactType),
+		Annotations: map[string]string{titleAnnotation: filepath.Base(path)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s artifact: %w", kind, err)
+	}
+	artifact = mutate.MediaType(artifact, types.OCIManifestSchema1)
+	artifact = mutate.ConfigMediaType(artifact, artifactType)
+	artifact = mutate.Subject(artifact, *subject).(v1.Image)
+
+	digest, err := artifact.Digest()
+	if err != nil {
+		return fmt.Errorf("failed to get %s artifact digest: %w", kind, err)
+	}
+	baseRef, err := name.ParseReference(i.name, name.Insecure)
+	if err != nil {
+		return fmt.Errorf("failed to parse image reference: %w", err)
+	}
+	ref := baseRef.Context().Digest(digest.String())
+
+	log.Infof("Attaching %s to %s as %s", path, baseRef.Context(), ref.DigestStr())
+	if err := crane.Push(artifact, ref.String(), crane.Insecure); err != nil {
+		return fmt.Errorf("failed to push %s artifact: %w", kind, err)
+	}
+	return nil
+}
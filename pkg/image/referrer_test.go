@@ -0,0 +1,108 @@
+package image
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestAttachReport(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.WithReferrersSupport(true)))
+	defer server.Close()
+	repo := strings.TrimPrefix(server.URL, "http://") + "/test"
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crane.Push(img, repo+":latest", crane.Insecure); err != nil {
+		t.Fatal(err)
+	}
+	report := filepath.Join(t.TempDir(), "scan-report.json")
+	if err := os.WriteFile(report, []byte(`{"Results":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	i := &Image{img: img, name: repo, config: &imageconfig.Config{}}
+	if err := i.AttachReport(report, "application/vnd.test.report+json"); err != nil {
+		t.Fatalf("AttachReport() error = %v", err)
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := name.NewDigest(repo+"@"+digest.String(), name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := remote.Referrers(subject)
+	if err != nil {
+		t.Fatalf("Referrers() error = %v", err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Manifests) != 1 || manifest.Manifests[0].ArtifactType != "application/vnd.test.report+json" {
+		t.Errorf("referrers of the image = %+v, want the report", manifest.Manifests)
+	}
+}
+
+func TestAttachBootFiles(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.WithReferrersSupport(true)))
+	defer server.Close()
+	repo := strings.TrimPrefix(server.URL, "http://") + "/test"
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crane.Push(img, repo+":latest", crane.Insecure); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"kernel", "initrd.img"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	i := &Image{img: img, name: repo, config: &imageconfig.Config{}}
+	if err := i.AttachBootFiles(filepath.Join(dir, "kernel"), filepath.Join(dir, "initrd.img")); err != nil {
+		t.Fatalf("AttachBootFiles() error = %v", err)
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := name.NewDigest(repo+"@"+digest.String(), name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, artifactType := range []types.MediaType{KernelArtifactType, InitrdArtifactType} {
+		index, err := remote.Referrers(subject, remote.WithFilter("artifactType", string(artifactType)))
+		if err != nil {
+			t.Fatalf("Referrers() error = %v", err)
+		}
+		manifest, err := index.IndexManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(manifest.Manifests) != 1 {
+			t.Errorf("referrers of type %s = %+v, want one", artifactType, manifest.Manifests)
+		}
+	}
+}
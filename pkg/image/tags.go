@@ -0,0 +1,178 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	log "github.com/sirupsen/logrus"
+)
+
+// validTag matches the tags a registry accepts
+var validTag = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// TagData holds the values publish_tags templates can refer to, as in
+// "{{.Date}}-{{.KernelVersion}}"
+type TagData struct {
+	// Date is the UTC date of the push as YYYYMMDD
+	Date string
+	// GitSHA is the short commit of the git checkout holding the configuration file
+	GitSHA string
+	// KernelVersion is the kernel version the image boots
+	KernelVersion string
+	// ConfigHash is the first 12 hex digits of the sha256 of the configuration
+	ConfigHash string
+	// Variant is the name of the variant being built
+	Variant string
+	// Vars are the vars of the variant being built
+	Vars map[string]string
+}
+
+// RenderTags expands the templates in a comma separated list of tags, dropping empty tags
+func RenderTags(tags string, data TagData) ([]string, error) {
+	var rendered []string
+	for _, tag := range strings.Split(tags, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		tmpl, err := template.New("tag").Parse(tag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag template '%s': %w", tag, err)
+		}
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, data); err != nil {
+			return nil, fmt.Errorf("failed to render tag '%s': %w", tag, err)
+		}
+		if !validTag.MatchString(sb.String()) {
+			return nil, fmt.Errorf("tag '%s' rendered to '%s', which is not a valid tag", tag, sb.String())
+		}
+		rendered = append(rendered, sb.String())
+	}
+	return rendered, nil
+}
+
+// Tags returns the publish tags of the image with their templates rendered. Tags are
+// rendered on first use, so every push of the image uses the same tags.
+func (i *Image) Tags() ([]string, error) {
+	if i.tags != nil {
+		return i.tags, nil
+	}
+
+	tags := i.config.Options.PublishTags
+	data, err := i.tagData("publish_tags", tags)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := RenderTags(tags, data)
+	if err != nil {
+		return nil, err
+	}
+	i.tags = rendered
+	return rendered, nil
+}
+
+// tagData returns the values the templates of the option field refer to. Only the values
+// the templates use are looked up.
+func (i *Image) tagData(field, templates string) (TagData, error) {
+	data := TagData{Date: time.Now().UTC().Format("20060102")}
+	if i.config.Variant != nil {
+		data.Variant = i.config.Variant.Name
+		data.Vars = i.config.Variant.Vars
+	}
+	if strings.Contains(templates, ".GitSHA") {
+		sha, err := gitSHA(field, i.config.Source)
+		if err != nil {
+			return TagData{}, err
+		}
+		data.GitSHA = sha
+	}
+	if strings.Contains(templates, ".KernelVersion") {
+		config, err := i.img.ConfigFile()
+		if err != nil {
+			return TagData{}, fmt.Errorf("failed to get image config: %w", err)
+		}
+		data.KernelVersion = config.Config.Labels[KernelVersionLabel]
+		if data.KernelVersion == "" {
+			return TagData{}, fmt.Errorf("%s uses KernelVersion but the image has no kernel version label", field)
+		}
+	}
+	if strings.Contains(templates, ".ConfigHash") {
+		hash, err := i.config.Hash()
+		if err != nil {
+			return TagData{}, err
+		}
+		data.ConfigHash = hash[:12]
+	}
+	return data, nil
+}
+
+// gitSHA returns the short commit of the git checkout holding the configuration file
+func gitSHA(field, configPath string) (string, error) {
+	if configPath == "" {
+		return "", fmt.Errorf("%s uses GitSHA but the configuration was not loaded from a file", field)
+	}
+	output, err := exec.Command("git", "-C", filepath.Dir(configPath), "rev-parse", "--short", "HEAD").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the git commit of %s: %s: %w", configPath, strings.TrimSpace(string(output)), err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// isImmutableTag reports whether a tag matches one of the immutable_tags patterns
+func isImmutableTag(patterns []string, tag string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkImmutableTags fails if an immutable tag already exists in the registry with a
+// different digest, unless the image is forced. Tags that do not exist yet or already point
+// at the image are pushed as usual.
+func (i *Image) checkImmutableTags(baseRef name.Reference, tags []string) error {
+	patterns := i.config.Options.ImmutableTags
+	if len(patterns) == 0 {
+		return nil
+	}
+	digest, err := i.img.Digest()
+	if err != nil {
+		return fmt.Errorf("failed to get image digest: %w", err)
+	}
+
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if !isImmutableTag(patterns, tag) {
+			continue
+		}
+		ref := fmt.Sprintf("%s:%s", baseRef.Context().String(), tag)
+		existing, err := crane.Digest(ref, crane.Insecure)
+		var terr *transport.Error
+		if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to check immutable tag %s: %w", ref, err)
+		}
+		if existing == digest.String() {
+			continue
+		}
+		if !i.force {
+			return fmt.Errorf("immutable tag %s already points at %s, use --force to overwrite it", ref, existing)
+		}
+		log.Warnf("Overwriting immutable tag %s, which points at %s", ref, existing)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package image
+
+import (
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestRenderTags(t *testing.T) {
+	data := TagData{
+		Date:          "20260115",
+		GitSHA:        "1a2b3c4",
+		KernelVersion: "5.14.0-427.el9.x86_64",
+		ConfigHash:    "0123456789ab",
+	}
+
+	tests := []struct {
+		name    string
+		tags    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "plain", tags: "latest, v1", want: []string{"latest", "v1"}},
+		{name: "empty", tags: "", want: nil},
+		{name: "date and sha", tags: "{{.Date}}-{{.GitSHA}},latest", want: []string{"20260115-1a2b3c4", "latest"}},
+		{name: "kernel and config", tags: "{{.KernelVersion}}_{{.ConfigHash}}", want: []string{"5.14.0-427.el9.x86_64_0123456789ab"}},
+		{name: "unknown field", tags: "{{.Branch}}", wantErr: true},
+		{name: "invalid result", tags: "v1/{{.Date}}", wantErr: true},
+		{name: "bad template", tags: "{{.Date", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderTags(tt.tags, data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RenderTags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RenderTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTagsGitSHA(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "image.yaml")
+	data := []byte("options:\n  layer_type: base\n  name: test\n  pkg_manager: dnf\n  publish_tags: 'build-{{.GitSHA}}'\n")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "image.yaml"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "add image"},
+		{"rev-parse", "--short", "HEAD"},
+	} {
+		output, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %s: %v", args, output, err)
+		}
+		data = output
+	}
+	want := "build-" + strings.TrimSpace(string(data))
+
+	config, err := imageconfig.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	img := &Image{config: config}
+	got, err := img.Tags()
+	if err != nil {
+		t.Fatalf("Tags() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{want}) {
+		t.Errorf("Tags() = %v, want [%s]", got, want)
+	}
+
+	// A configuration parsed without its file cannot render GitSHA
+	config.Source = ""
+	if _, err := (&Image{config: config}).Tags(); err == nil {
+		t.Error("Tags() without a source file succeeded, want error")
+	}
+}
+
+func TestTagsKernelVersion(t *testing.T) {
+	config := &imageconfig.Config{}
+	config.Options.PublishTags = "{{.KernelVersion}}"
+	img := &Image{img: empty.Image, config: config}
+	if _, err := img.Tags(); err == nil {
+		t.Fatal("Tags() of an image without a kernel version succeeded, want error")
+	}
+
+	// Images built without boot layers are labeled all the same
+	if err := img.SetKernelVersion("6.1.0"); err != nil {
+		t.Fatalf("SetKernelVersion() error = %v", err)
+	}
+	got, err := img.Tags()
+	if err != nil {
+		t.Fatalf("Tags() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{"6.1.0"}) {
+		t.Errorf("Tags() = %v, want [6.1.0]", got)
+	}
+}
+
+func TestCheckImmutableTags(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	repo := strings.TrimPrefix(server.URL, "http://") + "/test"
+
+	released, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crane.Push(released, repo+":v1", crane.Insecure); err != nil {
+		t.Fatalf("failed to push v1: %v", err)
+	}
+	rebuilt, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseRef, err := name.ParseReference(repo, name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		tags     []string
+		force    bool
+		wantErr  bool
+	}{
+		{name: "no patterns", tags: []string{"v1"}},
+		{name: "moved tag", patterns: []string{"v*"}, tags: []string{"latest", "v1"}, wantErr: true},
+		{name: "forced", patterns: []string{"v*"}, tags: []string{"v1"}, force: true},
+		{name: "mutable tag", patterns: []string{"release-*"}, tags: []string{"v1"}},
+		{name: "new tag", patterns: []string{"v*"}, tags: []string{"v2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &imageconfig.Config{}
+			config.Options.ImmutableTags = tt.patterns
+			img := &Image{img: rebuilt, config: config}
+			img.SetForce(tt.force)
+			err := img.checkImmutableTags(baseRef, tt.tags)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkImmutableTags(%v) error = %v, wantErr %v", tt.tags, err, tt.wantErr)
+			}
+		})
+	}
+
+	// Pushing the image a tag already points at again is not an overwrite
+	config := &imageconfig.Config{}
+	config.Options.ImmutableTags = []string{"v*"}
+	img := &Image{img: released, config: config}
+	if err := img.checkImmutableTags(baseRef, []string{"v1"}); err != nil {
+		t.Errorf("checkImmutableTags() of the tagged image error = %v", err)
+	}
+}
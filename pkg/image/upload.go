@@ -0,0 +1,298 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-image-builder/pkg/progress"
+	"go-image-builder/pkg/utils"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	log "github.com/sirupsen/logrus"
+)
+
+// chunkedUploadRetries is how often a chunked upload is resumed after a failed chunk
+const chunkedUploadRetries = 5
+
+// uploadLargeLayers uploads the layers of the image larger than options.upload_chunk_size
+// to repo in chunks before the image is pushed. A dropped connection then resumes the blob
+// from the last chunk the registry received rather than restarting it, and the push finds
+// the blobs in the registry and skips them. Layers of a parent in a registry are left to the
+// push, which mounts them.
+func (i *Image) uploadLargeLayers(repo name.Repository) error {
+	if i.config.Options.UploadChunkSize == "" {
+		return nil
+	}
+	chunkSize, err := utils.ParseSize(i.config.Options.UploadChunkSize)
+	if err != nil {
+		return fmt.Errorf("invalid upload chunk size: %w", err)
+	}
+
+	layers, err := i.img.Layers()
+	if err != nil {
+		return fmt.Errorf("failed to get image layers: %w", err)
+	}
+	var large []v1.Layer
+	for _, layer := range layers {
+		if _, ok := layer.(*remote.MountableLayer); ok {
+			continue
+		}
+		size, err := layer.Size()
+		if err != nil {
+			return fmt.Errorf("failed to get layer size: %w", err)
+		}
+		if size > chunkSize {
+			large = append(large, layer)
+		}
+	}
+	if len(large) == 0 {
+		return nil
+	}
+
+	auth, err := authn.DefaultKeychain.Resolve(repo)
+	if err != nil {
+		return fmt.Errorf("failed to resolve registry credentials: %w", err)
+	}
+	base := i.blobCache.Transport(insecureTransport())
+	rt, err := transport.NewWithContext(context.Background(), repo.Registry, auth, base, []string{repo.Scope(transport.PushScope)})
+	if err != nil {
+		return fmt.Errorf("failed to connect to registry %s: %w", repo.RegistryStr(), err)
+	}
+
+	u := &chunkedUploader{
+		client:    &http.Client{Transport: rt},
+		repo:      repo,
+		chunkSize: chunkSize,
+		progress:  progress.OrLog(i.progress),
+		backoff:   time.Second,
+	}
+	for _, layer := range large {
+		if err := u.upload(layer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkedUploader uploads blobs with chunked PATCH requests, resuming interrupted uploads
+// from the offset the registry reports
+type chunkedUploader struct {
+	client    *http.Client
+	repo      name.Repository
+	chunkSize int64
+	progress  progress.Reporter
+	backoff   time.Duration
+}
+
+// upload uploads the compressed blob of layer unless the registry already has it
+func (u *chunkedUploader) upload(layer v1.Layer) error {
+	digest, err := layer.Digest()
+	if err != nil {
+		return fmt.Errorf("failed to get layer digest: %w", err)
+	}
+	size, err := layer.Size()
+	if err != nil {
+		return fmt.Errorf("failed to get layer size: %w", err)
+	}
+	ref := u.repo.Digest(digest.String()).String()
+
+	exists, err := u.exists(digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		log.Debugf("Blob %s already exists in %s", digest, u.repo)
+		return nil
+	}
+
+	log.Infof("Uploading %s in chunks of %.1f MiB", ref, float64(u.chunkSize)/(1<<20))
+	loc, err := u.start()
+	if err != nil {
+		return err
+	}
+	var offset int64
+	for attempt := 0; ; attempt++ {
+		loc, offset, err = u.send(layer, ref, loc, offset, size)
+		if err == nil {
+			break
+		}
+		if attempt == chunkedUploadRetries {
+			return fmt.Errorf("failed to upload %s after %d attempts: %w", ref, attempt+1, err)
+		}
+		log.Warnf("Upload of %s interrupted after %d of %d bytes, resuming: %v", ref, offset, size, err)
+		time.Sleep(u.backoff * time.Duration(attempt+1))
+
+		// The registry knows how much of the upload it received, which may differ from
+		// what was sent. Uploads it no longer knows start over.
+		if loc, offset, err = u.status(loc); err != nil {
+			log.Warnf("Restarting upload of %s: %v", ref, err)
+			if loc, err = u.start(); err != nil {
+				return err
+			}
+			offset = 0
+		}
+	}
+	return u.commit(loc, digest)
+}
+
+// exists reports whether the registry has the blob with digest
+func (u *chunkedUploader) exists(digest v1.Hash) (bool, error) {
+	resp, err := u.client.Head(u.url(fmt.Sprintf("/v2/%s/blobs/%s", u.repo.RepositoryStr(), digest)).String())
+	if err != nil {
+		return false, fmt.Errorf("failed to check for blob %s: %w", digest, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// start opens an upload session, returning its location
+func (u *chunkedUploader) start() (*url.URL, error) {
+	uploads := u.url(fmt.Sprintf("/v2/%s/blobs/uploads/", u.repo.RepositoryStr()))
+	resp, err := u.client.Post(uploads.String(), "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start upload to %s: %w", u.repo, err)
+	}
+	defer resp.Body.Close()
+	if err := transport.CheckError(resp, http.StatusAccepted); err != nil {
+		return nil, fmt.Errorf("failed to start upload to %s: %w", u.repo, err)
+	}
+	return location(uploads, resp)
+}
+
+// send uploads the blob of layer from offset in chunks, returning the location and offset
+// of the upload after the last chunk the registry accepted
+func (u *chunkedUploader) send(layer v1.Layer, ref string, loc *url.URL, offset, size int64) (*url.URL, int64, error) {
+	rc, err := layer.Compressed()
+	if err != nil {
+		return loc, offset, fmt.Errorf("failed to read layer: %w", err)
+	}
+	defer rc.Close()
+	if _, err := io.CopyN(io.Discard, rc, offset); err != nil {
+		return loc, offset, fmt.Errorf("failed to seek to offset %d of layer: %w", offset, err)
+	}
+
+	chunk := make([]byte, u.chunkSize)
+	for offset < size {
+		n, err := io.ReadFull(rc, chunk)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return loc, offset, fmt.Errorf("failed to read layer: %w", err)
+		}
+
+		req, err := http.NewRequest(http.MethodPatch, loc.String(), bytes.NewReader(chunk[:n]))
+		if err != nil {
+			return loc, offset, err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(n)-1))
+		resp, err := u.client.Do(req)
+		if err != nil {
+			return loc, offset, err
+		}
+		// Some registries answer chunks with 204 rather than the 202 of the distribution spec
+		err = transport.CheckError(resp, http.StatusAccepted, http.StatusNoContent)
+		resp.Body.Close()
+		if err != nil {
+			return loc, offset, err
+		}
+		if loc, err = location(loc, resp); err != nil {
+			return loc, offset, err
+		}
+		offset += int64(n)
+		u.progress.Transfer(ref, offset, size)
+	}
+	return loc, offset, nil
+}
+
+// status returns the location of the upload at loc and the offset the registry expects the
+// next chunk at
+func (u *chunkedUploader) status(loc *url.URL) (*url.URL, int64, error) {
+	resp, err := u.client.Get(loc.String())
+	if err != nil {
+		return loc, 0, err
+	}
+	defer resp.Body.Close()
+	if err := transport.CheckError(resp, http.StatusNoContent); err != nil {
+		return loc, 0, err
+	}
+	offset, err := parseRange(resp.Header.Get("Range"))
+	if err != nil {
+		return loc, 0, err
+	}
+	if loc, err = location(loc, resp); err != nil {
+		return loc, 0, err
+	}
+	return loc, offset, nil
+}
+
+// commit completes the upload at loc as the blob with digest
+func (u *chunkedUploader) commit(loc *url.URL, digest v1.Hash) error {
+	committed := *loc
+	query := committed.Query()
+	query.Set("digest", digest.String())
+	committed.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodPut, committed.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to commit blob %s: %w", digest, err)
+	}
+	defer resp.Body.Close()
+	if err := transport.CheckError(resp, http.StatusCreated); err != nil {
+		return fmt.Errorf("failed to commit blob %s: %w", digest, err)
+	}
+	return nil
+}
+
+// url returns the URL of path on the registry
+func (u *chunkedUploader) url(path string) *url.URL {
+	return &url.URL{Scheme: u.repo.Scheme(), Host: u.repo.RegistryStr(), Path: path}
+}
+
+// location returns the upload location of resp, which may be relative to the request URL.
+// Responses without one keep the current location.
+func location(current *url.URL, resp *http.Response) (*url.URL, error) {
+	header := resp.Header.Get("Location")
+	if header == "" {
+		return current, nil
+	}
+	loc, err := url.Parse(header)
+	if err != nil {
+		return current, fmt.Errorf("invalid upload location '%s': %w", header, err)
+	}
+	return current.ResolveReference(loc), nil
+}
+
+// parseRange returns the offset following the bytes a registry reports received in the
+// Range header of an upload, "0-<last byte>". Registries report empty uploads as "0-0".
+func parseRange(header string) (int64, error) {
+	header = strings.TrimPrefix(strings.TrimSpace(header), "bytes=")
+	if header == "" {
+		return 0, nil
+	}
+	_, end, ok := strings.Cut(header, "-")
+	if !ok {
+		return 0, fmt.Errorf("invalid upload range '%s'", header)
+	}
+	last, err := strconv.ParseInt(end, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid upload range '%s'", header)
+	}
+	if last == 0 {
+		return 0, nil
+	}
+	return last + 1, nil
+}
@@ -0,0 +1,130 @@
+package image
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go-image-builder/pkg/progress"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    int64
+		wantErr bool
+	}{
+		{header: "", want: 0},
+		{header: "0-0", want: 0},
+		{header: "0-1023", want: 1024},
+		{header: "bytes=0-2047", want: 2048},
+		{header: "1023", wantErr: true},
+		{header: "0-end", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, err := parseRange(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseRange() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// interruptedRegistry drops the second chunk of every upload once, and reports the progress
+// of uploads, which the in-memory registry does not
+type interruptedRegistry struct {
+	handler http.Handler
+	mu      sync.Mutex
+	patches map[string]int
+	ranges  map[string]string
+}
+
+func (r *interruptedRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !strings.Contains(req.URL.Path, "/blobs/uploads/") {
+		r.handler.ServeHTTP(w, req)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch req.Method {
+	case http.MethodPatch:
+		r.patches[req.URL.Path]++
+		if r.patches[req.URL.Path] == 2 {
+			http.Error(w, "connection reset", http.StatusBadGateway)
+			return
+		}
+		rec := httptest.NewRecorder()
+		r.handler.ServeHTTP(rec, req)
+		r.ranges[req.URL.Path] = rec.Header().Get("Range")
+		for key, values := range rec.Header() {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	case http.MethodGet:
+		w.Header().Set("Location", req.URL.Path)
+		w.Header().Set("Range", r.ranges[req.URL.Path])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		r.handler.ServeHTTP(w, req)
+	}
+}
+
+func TestChunkedUpload(t *testing.T) {
+	reg := &interruptedRegistry{handler: registry.New(), patches: map[string]int{}, ranges: map[string]string{}}
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	repo, err := name.NewRepository(strings.TrimPrefix(server.URL, "http://")+"/test", name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layer, err := random.Layer(8192, types.DockerLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &chunkedUploader{client: http.DefaultClient, repo: repo, chunkSize: 1024, progress: progress.Log{}}
+	if err := u.upload(layer); err != nil {
+		t.Fatalf("upload() error = %v", err)
+	}
+
+	digest, _ := layer.Digest()
+	uploaded, err := remote.Layer(repo.Digest(digest.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := uploaded.Digest(); got != digest {
+		t.Errorf("uploaded digest = %s, want %s", got, digest)
+	}
+	size, _ := layer.Size()
+	var patches int
+	for _, n := range reg.patches {
+		patches += n
+	}
+	// Each chunk is sent once, and the dropped one twice
+	if want := int((size+1023)/1024) + 1; patches != want {
+		t.Errorf("sent %d chunks, want %d", patches, want)
+	}
+
+	// Blobs in the registry are not uploaded again
+	if err := u.upload(layer); err != nil {
+		t.Fatalf("upload() error = %v", err)
+	}
+	if n := len(reg.patches); n != 1 {
+		t.Errorf("started %d uploads, want 1", n)
+	}
+}
@@ -0,0 +1,108 @@
+package imageconfig
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EmbedConfig controls the configuration embedded into images at /etc/image-config.yaml.
+// Mode is "sanitized", the default, to leave out the sections in SanitizedSections and mask
+// credentials, "full" to embed the configuration as written, or "none" to leave it out.
+// Exclude lists further sections to leave out, as dotted paths such as "options.labels".
+type EmbedConfig struct {
+	Mode    string   `yaml:"mode"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// SanitizedSections are left out of sanitized embedded configurations, since they hold
+// credentials or only matter to the build host
+var SanitizedSections = []string{
+	"options.registry_opts_push",
+	"options.registry_opts_pull",
+	"options.publish_s3",
+	"options.s3_bucket",
+	"options.s3_prefix",
+	"options.proxy",
+	"options.temp_dir",
+	"notifications",
+	"bss",
+	"publish_http",
+	"publish_tftp",
+}
+
+// Sections returns the sections left out of the embedded configuration
+func (e EmbedConfig) Sections() []string {
+	if e.Mode == "full" {
+		return e.Exclude
+	}
+	return append(append([]string{}, SanitizedSections...), e.Exclude...)
+}
+
+func (e EmbedConfig) validate() error {
+	switch e.Mode {
+	case "", "sanitized", "full", "none":
+	default:
+		return &ValidationError{Field: "options.embed_config.mode", Msg: "must be 'sanitized', 'full' or 'none'"}
+	}
+	for i, section := range e.Exclude {
+		if section == "" || strings.HasPrefix(section, ".") || strings.HasSuffix(section, ".") || strings.Contains(section, "..") {
+			return &ValidationError{Field: fmt.Sprintf("options.embed_config.exclude[%d]", i), Msg: "must be a dotted path such as 'options.labels'"}
+		}
+	}
+	return nil
+}
+
+// StripSections returns the YAML document data without sections, given as dotted paths of
+// mapping keys. Comments and key order are kept, and data is returned as is when it holds
+// none of the sections.
+func StripSections(data []byte, sections []string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return data, nil
+	}
+
+	stripped := false
+	for _, section := range sections {
+		if removeKey(doc.Content[0], strings.Split(section, ".")) {
+			stripped = true
+		}
+	}
+	if !stripped {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// removeKey removes the key at path below the mapping node, reporting whether it was found
+func removeKey(node *yaml.Node, path []string) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != path[0] {
+			continue
+		}
+		if len(path) > 1 {
+			return removeKey(node.Content[i+1], path[1:])
+		}
+		node.Content = append(node.Content[:i], node.Content[i+2:]...)
+		return true
+	}
+	return false
+}
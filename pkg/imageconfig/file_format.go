@@ -1,9 +1,20 @@
 package imageconfig
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
+
+	"go-image-builder/pkg/utils"
 
 	"gopkg.in/yaml.v3"
 )
@@ -24,27 +35,463 @@ type CopyFile struct {
 	Mode int      `yaml:"mode"`
 }
 
+// NodeOverlay is a small overlay built next to the image for a group of nodes, holding files
+// such as host-specific configuration or secrets mount points that provisioning applies over
+// the image. Format is "tar", the default, for a gzip-compressed tarball or "squashfs".
+type NodeOverlay struct {
+	Group  string     `yaml:"group"`
+	Format string     `yaml:"format"`
+	Files  []CopyFile `yaml:"files"`
+}
+
+// MergeImage is an additional image whose filesystem, or the listed paths of it, is copied
+// onto the rootfs before packaging. Image accepts the same references as options.parent.
+type MergeImage struct {
+	Image string   `yaml:"image"`
+	Paths []string `yaml:"paths"`
+}
+
+// BuildStage is a throwaway rootfs artifacts such as Slurm or MPI are compiled in. It starts
+// from Parent, which accepts the same references as options.parent, or from scratch and gets
+// its own repositories, packages, files and commands. Only the Artifacts paths are copied
+// into the image, at the same paths, so compilers and -devel packages stay out of it.
+type BuildStage struct {
+	Parent        string       `yaml:"parent"`
+	Repositories  []Repository `yaml:"repos"`
+	Packages      []string     `yaml:"packages"`
+	PackageGroups []string     `yaml:"package_groups"`
+	CopyFiles     []CopyFile   `yaml:"copyfiles"`
+	Cmds          []string     `yaml:"cmds"`
+	Artifacts     []string     `yaml:"artifacts"`
+}
+
+// Notification is a webhook fired with the build report when a build finishes. Type is
+// 'webhook' (the report is posted as JSON) or 'slack'. Headers are sent as written;
+// HeadersEnv maps headers to the environment variables holding their values, so secrets
+// stay out of the configuration.
+type Notification struct {
+	Type       string            `yaml:"type"`
+	URL        string            `yaml:"url"`
+	On         []string          `yaml:"on"`
+	Headers    map[string]string `yaml:"headers"`
+	HeadersEnv map[string]string `yaml:"headers_env"`
+}
+
+// envReference matches a reference to an environment variable, as in $TOKEN or ${TOKEN}
+var envReference = regexp.MustCompile(`\$\{?[A-Za-z_]`)
+
+// validEnvName matches the names of environment variables
+var validEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// HeaderValues returns the headers the notification is sent with, reading those of
+// HeadersEnv from the environment
+func (n Notification) HeaderValues() map[string]string {
+	headers := make(map[string]string, len(n.Headers)+len(n.HeadersEnv))
+	for key, value := range n.Headers {
+		headers[key] = value
+	}
+	for key, env := range n.HeadersEnv {
+		headers[key] = os.Getenv(env)
+	}
+	return headers
+}
+
+// BSSRegistration configures registering a pushed image with the OpenCHAMI boot script
+// service. The URL and params fields are Go templates rendered with the image name,
+// registry, tag, digest and kernel version.
+type BSSRegistration struct {
+	URL       string   `yaml:"url"`
+	TokenEnv  string   `yaml:"token_env"`
+	Hosts     []string `yaml:"hosts"`
+	Macs      []string `yaml:"macs"`
+	Nids      []int    `yaml:"nids"`
+	KernelURL string   `yaml:"kernel_url"`
+	InitrdURL string   `yaml:"initrd_url"`
+	Params    string   `yaml:"params"`
+}
+
+// Harden applies a compliance profile, such as a CIS or STIG profile, to the rootfs with
+// OpenSCAP before the image is tested and packaged. Profile is the XCCDF profile ID in the
+// SCAP source datastream Datastream, such as
+// "xccdf_org.ssgproject.content_profile_cis" in
+// "/usr/share/xml/scap/ssg/content/ssg-rhel9-ds.xml", and Tailoring an optional tailoring
+// file. The build fails if rules still fail after remediation and FailOnFailed is set.
+type Harden struct {
+	Profile      string `yaml:"profile"`
+	Datastream   string `yaml:"datastream"`
+	Tailoring    string `yaml:"tailoring"`
+	FailOnFailed bool   `yaml:"fail_on_failed"`
+}
+
+// Scan runs a vulnerability scanner against the rootfs before the image is pushed. The build
+// fails if a vulnerability of the FailOn severity or higher is found; without FailOn the
+// report is only kept.
+type Scan struct {
+	Scanner string `yaml:"scanner"`
+	FailOn  string `yaml:"fail_on"`
+}
+
+// Minimize leaves out files diskless nodes rarely need, since the whole image is held in
+// memory. NoDocs installs packages without documentation, Locales lists the only locales
+// kept, such as en_US, and FirmwareExclude holds globs of the /usr/lib/firmware entries
+// removed, such as "netronome" or "qcom/*". They apply before the base layer is created.
+type Minimize struct {
+	NoDocs          bool     `yaml:"nodocs"`
+	Locales         []string `yaml:"locales"`
+	FirmwareExclude []string `yaml:"firmware_exclude"`
+}
+
+// HTTPPublish uploads the boot artifacts of a build to an HTTP(S) server with PUT, which
+// WebDAV servers accept. The URL is a template of the directory the artifacts are put in.
+type HTTPPublish struct {
+	URL         string `yaml:"url"`
+	Username    string `yaml:"username"`
+	PasswordEnv string `yaml:"password_env"`
+	TokenEnv    string `yaml:"token_env"`
+}
+
+// TFTPPublish copies the kernel and initrd into a TFTP root for nodes that boot with legacy
+// PXE, and writes a pxelinux configuration for the MAC addresses of every node group
+type TFTPPublish struct {
+	Root string `yaml:"root"`
+	// Template is a pxelinux configuration template file, a minimal configuration is written
+	// without one
+	Template string      `yaml:"template"`
+	Groups   []TFTPGroup `yaml:"groups"`
+}
+
+// TFTPGroup is a group of nodes booting the image from their own TFTP subdirectory
+type TFTPGroup struct {
+	Name   string   `yaml:"name"`
+	Macs   []string `yaml:"macs"`
+	Params string   `yaml:"params"`
+}
+
+// BootScripts renders boot scripts such as iPXE scripts or GRUB BLS entries from templates
+// when the image is packaged, so the boot configuration always matches the image. URL is the
+// template of the base URL the artifacts are served from and Cmdline that of the kernel
+// command line.
+type BootScripts struct {
+	URL       string       `yaml:"url"`
+	Cmdline   string       `yaml:"cmdline"`
+	Templates []BootScript `yaml:"templates"`
+}
+
+// BootScript is a template rendered into the output directory as Dest
+type BootScript struct {
+	Src  string `yaml:"src"`
+	Dest string `yaml:"dest"`
+}
+
+// ArtifactGenerator is a site-specific program run when the image is packaged to produce
+// custom artifacts, such as Warewulf VNFS images or xCAT bundles. Command is run with Args
+// followed by the path of the rootfs and the directory to write the artifacts to.
+type ArtifactGenerator struct {
+	Name    string   `yaml:"name"`
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args"`
+}
+
+// KernelModules builds out-of-tree kernel modules against the kernel of the image. DKMS
+// lists packages providing DKMS modules, such as lustre-client-dkms. Sources lists host
+// directories of module sources, either DKMS trees with a dkms.conf or plain Kbuild trees.
+// The image needs kernel-devel, and dkms for DKMS modules.
+type KernelModules struct {
+	DKMS    []string `yaml:"dkms"`
+	Sources []string `yaml:"sources"`
+}
+
+// GPU installs NVIDIA or AMD GPU drivers into a layer of their own on top of the base
+// layer, so GPU and non-GPU builds of an image differ only by that layer. The drivers are
+// Packages from Repos or, for NVIDIA, a Runfile installer, built for KernelVersion or the
+// kernel of the image. ContainerRuntime configures that runtime for the NVIDIA container
+// toolkit, whose repository must then be in Repos.
+type GPU struct {
+	Vendor           string       `yaml:"vendor"`
+	Repos            []Repository `yaml:"repos"`
+	Packages         []string     `yaml:"packages"`
+	Runfile          string       `yaml:"runfile"`
+	KernelVersion    string       `yaml:"kernel_version"`
+	ContainerRuntime string       `yaml:"container_runtime"`
+}
+
+// validGroupName matches node group names, which end up in artifact file names
+var validGroupName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// validKernelVersion matches kernel versions, which end up in shell commands
+var validKernelVersion = regexp.MustCompile(`^[A-Za-z0-9._+-]+$`)
+
+// validate checks the GPU driver settings
+func (g *GPU) validate() error {
+	if g.Vendor != "nvidia" && g.Vendor != "amd" {
+		return &ValidationError{Field: "gpu.vendor", Msg: "must be 'nvidia' or 'amd'"}
+	}
+	if (len(g.Packages) == 0) == (g.Runfile == "") {
+		return &ValidationError{Field: "gpu", Msg: "exactly one of packages or runfile is required"}
+	}
+	if g.Runfile != "" && g.Vendor != "nvidia" {
+		return &ValidationError{Field: "gpu.runfile", Msg: "is only supported for nvidia"}
+	}
+	if g.KernelVersion != "" && !validKernelVersion.MatchString(g.KernelVersion) {
+		return &ValidationError{Field: "gpu.kernel_version", Msg: "must be a kernel version such as '5.14.0-503.el9.x86_64'"}
+	}
+	switch g.ContainerRuntime {
+	case "":
+	case "containerd", "crio", "docker":
+		if g.Vendor != "nvidia" {
+			return &ValidationError{Field: "gpu.container_runtime", Msg: "is only supported for nvidia"}
+		}
+	default:
+		return &ValidationError{Field: "gpu.container_runtime", Msg: "must be 'containerd', 'crio' or 'docker'"}
+	}
+	for i, repo := range g.Repos {
+		if repo.Alias == "" {
+			return &ValidationError{Field: fmt.Sprintf("gpu.repos[%d].alias", i), Msg: "is required"}
+		}
+		if repo.Url == "" {
+			return &ValidationError{Field: fmt.Sprintf("gpu.repos[%d].url", i), Msg: "is required"}
+		}
+	}
+	return nil
+}
+
+// ImageTest is a check run in the built container after customization. Cmd is a shell
+// command that must exit successfully, Goss a goss file validated with the goss binary
+// installed in the image.
+type ImageTest struct {
+	Name string `yaml:"name"`
+	Cmd  string `yaml:"cmd"`
+	Goss string `yaml:"goss"`
+}
+
+// BuildahOptions configures the buildah backend for environments where its defaults fail,
+// such as Kubernetes pods and restricted CI runners. Volumes and Mounts are added to every
+// command run in the container, such as a host RPM cache or a license server socket, in
+// the syntax of the --volume and --mount options of buildah run.
+type BuildahOptions struct {
+	Isolation     string   `yaml:"isolation"`
+	Root          string   `yaml:"root"`
+	RunRoot       string   `yaml:"runroot"`
+	StorageDriver string   `yaml:"storage_driver"`
+	StorageOpts   []string `yaml:"storage_opts"`
+	CapAdd        []string `yaml:"cap_add"`
+	Volumes       []string `yaml:"volumes"`
+	Mounts        []string `yaml:"mounts"`
+}
+
+// validateMounts checks the volumes and mounts have the form buildah run accepts. Their
+// sources are checked when the container runs, as they may only exist on the build host.
+func (b BuildahOptions) validateMounts(backend string) error {
+	if len(b.Volumes) == 0 && len(b.Mounts) == 0 {
+		return nil
+	}
+	if backend != "" && backend != "buildah" {
+		return &ValidationError{Field: "options.buildah", Msg: "volumes and mounts require the buildah backend"}
+	}
+	for i, volume := range b.Volumes {
+		parts := strings.Split(volume, ":")
+		if len(parts) < 2 || len(parts) > 3 || !filepath.IsAbs(parts[0]) || !filepath.IsAbs(parts[1]) {
+			return &ValidationError{Field: fmt.Sprintf("options.buildah.volumes[%d]", i), Msg: "must be 'SOURCE:TARGET[:OPTIONS]' with absolute paths"}
+		}
+	}
+	for i, mount := range b.Mounts {
+		var target bool
+		for _, field := range strings.Split(mount, ",") {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "target", "dst", "destination":
+				target = filepath.IsAbs(value)
+			}
+		}
+		if !target {
+			return &ValidationError{Field: fmt.Sprintf("options.buildah.mounts[%d]", i), Msg: "must set an absolute target, as in 'type=bind,source=/var/cache/dnf,target=/var/cache/dnf'"}
+		}
+	}
+	return nil
+}
+
+// Resources limits the memory, CPU and scheduling priority of package installs and commands
+type Resources struct {
+	Memory string  `yaml:"memory"`
+	CPUs   float64 `yaml:"cpus"`
+	Nice   int     `yaml:"nice"`
+}
+
+// Proxy configures the HTTP proxies used while building
+type Proxy struct {
+	HTTP    string `yaml:"http"`
+	HTTPS   string `yaml:"https"`
+	NoProxy string `yaml:"no_proxy"`
+	// Persist writes the proxy into the image's dnf.conf so it is also used on booted nodes
+	Persist bool `yaml:"persist"`
+}
+
+// Env returns the proxy environment variables in both lower and upper case, since tools
+// disagree on which they read
+func (p Proxy) Env() []string {
+	var env []string
+	for _, v := range []struct{ name, value string }{
+		{"http_proxy", p.HTTP},
+		{"https_proxy", p.HTTPS},
+		{"no_proxy", p.NoProxy},
+	} {
+		if v.value != "" {
+			env = append(env, v.name+"="+v.value, strings.ToUpper(v.name)+"="+v.value)
+		}
+	}
+	return env
+}
+
+// validNetwork reports whether network is a network commands in the container can run with:
+// the host network, none but loopback, or the default of the backend when empty
+func validNetwork(network string) bool {
+	switch network {
+	case "", "host", "none":
+		return true
+	}
+	return false
+}
+
+// DNS configures name resolution while building. The resolv.conf and hosts files of the
+// image are restored once the rootfs has been customized, so none of this ships in the image.
+type DNS struct {
+	Nameservers []string `yaml:"nameservers"`
+	Search      []string `yaml:"search"`
+	// Hosts maps host names to the IP addresses added to /etc/hosts
+	Hosts map[string]string `yaml:"hosts"`
+}
+
+// ParentVerify configures how the parent image is verified before a build uses it. A
+// cosign signature is checked against CosignKey, or keylessly against the certificate
+// identity and issuer; a containers policy.json is evaluated as podman and buildah would.
+type ParentVerify struct {
+	Policy         string `yaml:"policy"`
+	CosignKey      string `yaml:"cosign_key"`
+	CosignIdentity string `yaml:"cosign_identity"`
+	CosignIssuer   string `yaml:"cosign_issuer"`
+}
+
+// Cosign reports whether a cosign signature check is configured
+func (v ParentVerify) Cosign() bool {
+	return v.CosignKey != "" || v.CosignIdentity != ""
+}
+
+// Options holds the image and publishing options of a configuration
+type Options struct {
+	LayerType            string                       `yaml:"layer_type"`
+	Name                 string                       `yaml:"name"`
+	PkgManager           string                       `yaml:"pkg_manager"`
+	BootstrapImage       string                       `yaml:"bootstrap_image"`
+	Parent               string                       `yaml:"parent"`
+	ParentPullPolicy     string                       `yaml:"parent_pull_policy"`
+	ParentPushPolicy     string                       `yaml:"parent_push_policy"`
+	ParentVerify         ParentVerify                 `yaml:"parent_verify"`
+	PublishTags          string                       `yaml:"publish_tags"`
+	ImmutableTags        []string                     `yaml:"immutable_tags"`
+	ExpiresAfter         string                       `yaml:"expires_after"`
+	PublishRegistry      string                       `yaml:"publish_registry"`
+	PublishLocal         bool                         `yaml:"publish_local"`
+	PublishS3            string                       `yaml:"publish_s3"`
+	S3Prefix             string                       `yaml:"s3_prefix"`
+	S3Bucket             string                       `yaml:"s3_bucket"`
+	Groups               []string                     `yaml:"groups"`
+	Playbooks            []string                     `yaml:"playbooks"`
+	Inventory            []string                     `yaml:"inventory"`
+	Vars                 map[string]any               `yaml:"vars"`
+	AnsibleVerbosity     int                          `yaml:"ansible_verbosity"`
+	Labels               map[string]string            `yaml:"labels"`
+	Annotations          map[string]string            `yaml:"annotations"`
+	LayerAnnotations     map[string]map[string]string `yaml:"layer_annotations"`
+	RegistryOptsPush     []string                     `yaml:"registry_opts_push"`
+	RegistryOptsPull     []string                     `yaml:"registry_opts_pull"`
+	UploadChunkSize      string                       `yaml:"upload_chunk_size"`
+	Backend              string                       `yaml:"backend"`
+	PruneImages          bool                         `yaml:"prune_images"`
+	Squash               bool                         `yaml:"squash"`
+	Timeout              string                       `yaml:"timeout"`
+	StageTimeouts        map[string]string            `yaml:"stage_timeouts"`
+	Resources            Resources                    `yaml:"resources"`
+	Scan                 Scan                         `yaml:"scan"`
+	Harden               Harden                       `yaml:"harden"`
+	Minimize             Minimize                     `yaml:"minimize"`
+	Proxy                Proxy                        `yaml:"proxy"`
+	DNS                  DNS                          `yaml:"dns"`
+	Network              string                       `yaml:"network"`
+	Buildah              BuildahOptions               `yaml:"buildah"`
+	TempDir              string                       `yaml:"temp_dir"`
+	EmbedConfig          EmbedConfig                  `yaml:"embed_config"`
+	ImageInfo            ImageInfo                    `yaml:"image_info"`
+	KernelVersion        string                       `yaml:"kernel_version"`
+	KeepCompressedKernel bool                         `yaml:"keep_compressed_kernel"`
+	InitrdPattern        string                       `yaml:"initrd_pattern"`
+	Dracut               string                       `yaml:"dracut"`
+	SkipBootLayers       bool                         `yaml:"skip_boot_layers"`
+	PublishBootArtifacts bool                         `yaml:"publish_boot_artifacts"`
+	SourceDateEpoch      int64                        `yaml:"source_date_epoch"`
+	ManifestFormat       string                       `yaml:"manifest_format"`
+}
+
+// ParentArchive describes a parent image read from the filesystem rather than a registry
+type ParentArchive struct {
+	// Transport is "oci" for an OCI image layout or "docker-archive" for a docker save tarball
+	Transport string
+	Path      string
+	// Ref selects an image in an OCI layout by its ref name annotation
+	Ref string
+}
+
+// AnnotatedLayers are the layers the builder adds that options.layer_annotations can
+// annotate
+var AnnotatedLayers = []string{"base", "gpu", "config", "kernel", "initrd", "squashed"}
+
+// DefaultBootstrapImage is the base image scratch builds start from when the package manager
+// is not installed on the build host
+const DefaultBootstrapImage = "quay.io/rockylinux/rockylinux:9"
+
+// Bootstrap returns the base image scratch builds start from when the package manager is not
+// installed on the build host
+func (o Options) Bootstrap() string {
+	if o.BootstrapImage != "" {
+		return o.BootstrapImage
+	}
+	return DefaultBootstrapImage
+}
+
+// SourceDate returns the time options.source_date_epoch sets for reproducible builds, or the
+// zero time when it is unset. Layer file times are clamped to it, and it is recorded as the
+// creation time of the image.
+func (o Options) SourceDate() time.Time {
+	if o.SourceDateEpoch == 0 {
+		return time.Time{}
+	}
+	return time.Unix(o.SourceDateEpoch, 0).UTC()
+}
+
+// ParentArchive returns the archive the parent refers to with an oci: or docker-archive:
+// prefix. The boolean is false for registry and local storage parents.
+func (o Options) ParentArchive() (ParentArchive, bool) {
+	return ParseArchive(o.Parent)
+}
+
+// ParseArchive returns the archive an image reference with an oci: or docker-archive:
+// prefix refers to
+func ParseArchive(image string) (ParentArchive, bool) {
+	transport, path, ok := strings.Cut(image, ":")
+	if !ok || (transport != "oci" && transport != "docker-archive") {
+		return ParentArchive{}, false
+	}
+	archive := ParentArchive{Transport: transport, Path: path}
+	if transport == "oci" {
+		// oci:/path/to/layout:ref, where the ref follows the last path element
+		if i := strings.LastIndex(path, ":"); i > strings.LastIndex(path, "/") {
+			archive.Path, archive.Ref = path[:i], path[i+1:]
+		}
+	}
+	return archive, true
+}
+
 type Config struct {
-	Options struct {
-		LayerType        string            `yaml:"layer_type"`
-		Name             string            `yaml:"name"`
-		PkgManager       string            `yaml:"pkg_manager"`
-		Parent           string            `yaml:"parent"`
-		PublishTags      string            `yaml:"publish_tags"`
-		PublishRegistry  string            `yaml:"publish_registry"`
-		PublishLocal     bool              `yaml:"publish_local"`
-		PublishS3        string            `yaml:"publish_s3"`
-		S3Prefix         string            `yaml:"s3_prefix"`
-		S3Bucket         string            `yaml:"s3_bucket"`
-		Groups           []string          `yaml:"groups"`
-		Playbooks        []string          `yaml:"playbooks"`
-		Inventory        []string          `yaml:"inventory"`
-		Vars             map[string]any    `yaml:"vars"`
-		AnsibleVerbosity int               `yaml:"ansible_verbosity"`
-		Labels           map[string]string `yaml:"labels"`
-		RegistryOptsPush []string          `yaml:"registry_opts_push"`
-		RegistryOptsPull []string          `yaml:"registry_opts_pull"`
-	} `yaml:"options"`
+	Options        Options             `yaml:"options"`
 	Repositories   []Repository        `yaml:"repos"`
 	Packages       []string            `yaml:"packages"`
 	PackageGroups  []string            `yaml:"package_groups"`
@@ -53,8 +500,34 @@ type Config struct {
 	Cmds           []struct {
 		Cmd      string `yaml:"cmd"`
 		LogLevel string `yaml:"loglevel"`
+		Network  string `yaml:"network"`
+		Proxy    *Proxy `yaml:"proxy"`
 	} `yaml:"cmds"`
-	CopyFiles []CopyFile `yaml:"copyfiles"`
+	KernelModules      *KernelModules      `yaml:"kernel_modules"`
+	GPU                *GPU                `yaml:"gpu"`
+	Tests              []ImageTest         `yaml:"tests"`
+	CopyFiles          []CopyFile          `yaml:"copyfiles"`
+	BuildStage         *BuildStage         `yaml:"build_stage"`
+	MergeImages        []MergeImage        `yaml:"merge_images"`
+	CACerts            []string            `yaml:"ca_certs"`
+	Notifications      []Notification      `yaml:"notifications"`
+	BSS                *BSSRegistration    `yaml:"bss"`
+	PublishHTTP        *HTTPPublish        `yaml:"publish_http"`
+	PublishTFTP        *TFTPPublish        `yaml:"publish_tftp"`
+	BootScripts        *BootScripts        `yaml:"boot_scripts"`
+	LiveOverlay        *LiveOverlay        `yaml:"live_overlay"`
+	NFSRoot            *NFSRoot            `yaml:"nfs_root"`
+	NodeOverlays       []NodeOverlay       `yaml:"node_overlays"`
+	Seed               *Seed               `yaml:"seed"`
+	ArtifactGenerators []ArtifactGenerator `yaml:"artifact_generators"`
+	Variants           []Variant           `yaml:"variants"`
+
+	// Source is the file the configuration was loaded from, if any
+	Source string `yaml:"-"`
+	// Raw is the YAML the configuration was parsed from, if any
+	Raw []byte `yaml:"-"`
+	// Variant is the variant this configuration was expanded for, if any
+	Variant *Variant `yaml:"-"`
 }
 
 // ValidationError represents a configuration validation error
@@ -85,6 +558,201 @@ func (c *Config) Validate() error {
 		return &ValidationError{Field: "options.pkg_manager", Msg: "is required for base layer"}
 	}
 
+	if strings.Contains(c.Options.PublishTags, "{{") {
+		if _, err := template.New("publish_tags").Parse(c.Options.PublishTags); err != nil {
+			return &ValidationError{Field: "options.publish_tags", Msg: fmt.Sprintf("invalid template: %v", err)}
+		}
+	}
+
+	for i, pattern := range c.Options.ImmutableTags {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return &ValidationError{Field: fmt.Sprintf("options.immutable_tags[%d]", i), Msg: "must be a glob pattern such as 'v*'"}
+		}
+	}
+
+	if c.Options.ExpiresAfter != "" {
+		if _, err := ParseExpiry(c.Options.ExpiresAfter); err != nil {
+			return &ValidationError{Field: "options.expires_after", Msg: "must be a number of hours, days or weeks such as '12h', '30d' or '2w'"}
+		}
+	}
+
+	switch c.Options.Backend {
+	case "", "buildah", "podman", "docker":
+	default:
+		return &ValidationError{Field: "options.backend", Msg: "must be 'buildah', 'podman' or 'docker'"}
+	}
+
+	if pattern := c.Options.KernelVersion; pattern != "" {
+		if _, err := filepath.Match(pattern, ""); err != nil || !validKernelVersion.MatchString(strings.NewReplacer("*", "", "?", "", "[", "", "]", "").Replace(pattern)) {
+			return &ValidationError{Field: "options.kernel_version", Msg: "must be a kernel version or a glob such as '5.14.0-*.el9_4.x86_64'"}
+		}
+	}
+
+	switch c.Options.Dracut {
+	case "", "image", "host":
+	default:
+		return &ValidationError{Field: "options.dracut", Msg: "must be 'image' or 'host'"}
+	}
+
+	if pattern := c.Options.InitrdPattern; pattern != "" {
+		_, tmplErr := template.New("initrd_pattern").Parse(pattern)
+		if _, err := filepath.Match(pattern, ""); err != nil || tmplErr != nil || filepath.IsAbs(pattern) || strings.Contains(pattern, "..") {
+			return &ValidationError{Field: "options.initrd_pattern", Msg: "must be a glob relative to /boot such as 'initrd.img-{{.KernelVersion}}'"}
+		}
+	}
+
+	for key := range c.Options.Annotations {
+		if key == "" {
+			return &ValidationError{Field: "options.annotations", Msg: "keys must not be empty"}
+		}
+	}
+	for layer, annotations := range c.Options.LayerAnnotations {
+		if !slices.Contains(AnnotatedLayers, layer) {
+			return &ValidationError{Field: "options.layer_annotations." + layer, Msg: "must be one of " + strings.Join(AnnotatedLayers, ", ")}
+		}
+		for key := range annotations {
+			if key == "" {
+				return &ValidationError{Field: "options.layer_annotations." + layer, Msg: "keys must not be empty"}
+			}
+		}
+	}
+
+	switch c.Options.ManifestFormat {
+	case "", "oci", "docker":
+	default:
+		return &ValidationError{Field: "options.manifest_format", Msg: "must be 'oci' or 'docker'"}
+	}
+	if c.Options.UploadChunkSize != "" {
+		if size, err := utils.ParseSize(c.Options.UploadChunkSize); err != nil || size == 0 {
+			return &ValidationError{Field: "options.upload_chunk_size", Msg: "must be a size such as '64M'"}
+		}
+	}
+
+	if c.Options.SourceDateEpoch < 0 {
+		return &ValidationError{Field: "options.source_date_epoch", Msg: "must be a Unix timestamp"}
+	}
+
+	if err := c.Options.EmbedConfig.validate(); err != nil {
+		return err
+	}
+	if err := c.Options.ImageInfo.validate(); err != nil {
+		return err
+	}
+
+	if c.Options.TempDir != "" && !filepath.IsAbs(c.Options.TempDir) {
+		return &ValidationError{Field: "options.temp_dir", Msg: "must be an absolute path"}
+	}
+
+	switch c.Options.ParentPullPolicy {
+	case "", "always", "ifnotpresent", "never":
+	default:
+		return &ValidationError{Field: "options.parent_pull_policy", Msg: "must be 'always', 'ifnotpresent' or 'never'"}
+	}
+
+	switch c.Options.ParentPushPolicy {
+	case "", "ifnotpresent", "never", "fail":
+	default:
+		return &ValidationError{Field: "options.parent_push_policy", Msg: "must be 'ifnotpresent', 'never' or 'fail'"}
+	}
+
+	if verify := c.Options.ParentVerify; verify.Cosign() || verify.Policy != "" {
+		if c.Options.Parent == "" || c.Options.Parent == "scratch" {
+			return &ValidationError{Field: "options.parent_verify", Msg: "requires a parent image"}
+		}
+		if (verify.CosignIdentity == "") != (verify.CosignIssuer == "") {
+			return &ValidationError{Field: "options.parent_verify", Msg: "cosign_identity and cosign_issuer must be set together"}
+		}
+		if _, ok := c.Options.ParentArchive(); ok && verify.Cosign() {
+			return &ValidationError{Field: "options.parent_verify", Msg: "cosign signatures can only be verified for registry parents"}
+		}
+	}
+
+	switch c.Options.Buildah.Isolation {
+	case "", "oci", "chroot", "rootless":
+	default:
+		return &ValidationError{Field: "options.buildah.isolation", Msg: "must be 'oci', 'chroot' or 'rootless'"}
+	}
+	if err := c.Options.Buildah.validateMounts(c.Options.Backend); err != nil {
+		return err
+	}
+	if !validNetwork(c.Options.Network) {
+		return &ValidationError{Field: "options.network", Msg: "must be 'host' or 'none'"}
+	}
+
+	if c.Options.Timeout != "" {
+		if _, err := time.ParseDuration(c.Options.Timeout); err != nil {
+			return &ValidationError{Field: "options.timeout", Msg: "must be a duration such as '2h'"}
+		}
+	}
+	for stage, timeout := range c.Options.StageTimeouts {
+		switch stage {
+		case "setup", "customize", "harden", "gpu", "test", "package", "scan", "push", "register", "publish":
+		default:
+			return &ValidationError{Field: fmt.Sprintf("options.stage_timeouts.%s", stage), Msg: "must be one of: setup, customize, harden, gpu, test, package, scan, push, register, publish"}
+		}
+		if _, err := time.ParseDuration(timeout); err != nil {
+			return &ValidationError{Field: fmt.Sprintf("options.stage_timeouts.%s", stage), Msg: "must be a duration such as '30m'"}
+		}
+	}
+
+	if c.Options.Resources.Memory != "" {
+		if _, err := utils.ParseSize(c.Options.Resources.Memory); err != nil {
+			return &ValidationError{Field: "options.resources.memory", Msg: "must be a size such as '4G'"}
+		}
+	}
+	if c.Options.Resources.CPUs < 0 {
+		return &ValidationError{Field: "options.resources.cpus", Msg: "must not be negative"}
+	}
+	if c.Options.Resources.Nice < -20 || c.Options.Resources.Nice > 19 {
+		return &ValidationError{Field: "options.resources.nice", Msg: "must be between -20 and 19"}
+	}
+
+	switch c.Options.Scan.Scanner {
+	case "", "trivy", "grype":
+	default:
+		return &ValidationError{Field: "options.scan.scanner", Msg: "must be 'trivy' or 'grype'"}
+	}
+	switch c.Options.Scan.FailOn {
+	case "", "low", "medium", "high", "critical":
+		if c.Options.Scan.FailOn != "" && c.Options.Scan.Scanner == "" {
+			return &ValidationError{Field: "options.scan.fail_on", Msg: "requires options.scan.scanner"}
+		}
+	default:
+		return &ValidationError{Field: "options.scan.fail_on", Msg: "must be 'low', 'medium', 'high' or 'critical'"}
+	}
+
+	if harden := c.Options.Harden; harden != (Harden{}) {
+		if harden.Profile == "" {
+			return &ValidationError{Field: "options.harden.profile", Msg: "is required"}
+		}
+		if !filepath.IsAbs(harden.Datastream) {
+			return &ValidationError{Field: "options.harden.datastream", Msg: "must be an absolute path"}
+		}
+	}
+
+	for i, locale := range c.Options.Minimize.Locales {
+		if locale == "" || strings.ContainsAny(locale, "/: ") {
+			return &ValidationError{Field: fmt.Sprintf("options.minimize.locales[%d]", i), Msg: "must be a locale name such as 'en_US'"}
+		}
+	}
+	for i, pattern := range c.Options.Minimize.FirmwareExclude {
+		if _, err := filepath.Match(pattern, ""); err != nil || filepath.IsAbs(pattern) || pattern == "" ||
+			strings.HasPrefix(filepath.Clean(pattern), "..") {
+			return &ValidationError{Field: fmt.Sprintf("options.minimize.firmware_exclude[%d]", i), Msg: "must be a glob relative to /usr/lib/firmware"}
+		}
+	}
+
+	for i, ns := range c.Options.DNS.Nameservers {
+		if net.ParseIP(ns) == nil {
+			return &ValidationError{Field: fmt.Sprintf("options.dns.nameservers[%d]", i), Msg: "must be an IP address"}
+		}
+	}
+	for host, ip := range c.Options.DNS.Hosts {
+		if net.ParseIP(ip) == nil {
+			return &ValidationError{Field: fmt.Sprintf("options.dns.hosts.%s", host), Msg: "must be an IP address"}
+		}
+	}
+
 	// Validate Repositories
 	for i, repo := range c.Repositories {
 		if repo.Alias == "" {
@@ -106,6 +774,16 @@ func (c *Config) Validate() error {
 				return &ValidationError{Field: fmt.Sprintf("cmds[%d].loglevel", i), Msg: "must be one of: INFO, DEBUG, WARNING, ERROR"}
 			}
 		}
+		if !validNetwork(cmd.Network) {
+			return &ValidationError{Field: fmt.Sprintf("cmds[%d].network", i), Msg: "must be 'host' or 'none'"}
+		}
+		network := cmd.Network
+		if network == "" {
+			network = c.Options.Network
+		}
+		if network == "none" && cmd.Proxy != nil && len(cmd.Proxy.Env()) > 0 {
+			return &ValidationError{Field: fmt.Sprintf("cmds[%d].proxy", i), Msg: "cannot be used without a network"}
+		}
 	}
 
 	// Validate CopyFiles
@@ -118,9 +796,265 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	// Validate node overlays
+	groups := make(map[string]bool)
+	for i, overlay := range c.NodeOverlays {
+		if !validGroupName.MatchString(overlay.Group) {
+			return &ValidationError{Field: fmt.Sprintf("node_overlays[%d].group", i), Msg: "must be a name of letters, digits, '.', '_' and '-'"}
+		}
+		if groups[overlay.Group] {
+			return &ValidationError{Field: fmt.Sprintf("node_overlays[%d].group", i), Msg: fmt.Sprintf("duplicate group %s", overlay.Group)}
+		}
+		groups[overlay.Group] = true
+		switch overlay.Format {
+		case "", "tar", "squashfs":
+		default:
+			return &ValidationError{Field: fmt.Sprintf("node_overlays[%d].format", i), Msg: "must be 'tar' or 'squashfs'"}
+		}
+		if len(overlay.Files) == 0 {
+			return &ValidationError{Field: fmt.Sprintf("node_overlays[%d].files", i), Msg: "at least one file is required"}
+		}
+		for j, cf := range overlay.Files {
+			if cf.Src == "" {
+				return &ValidationError{Field: fmt.Sprintf("node_overlays[%d].files[%d].src", i, j), Msg: "is required"}
+			}
+			if cf.Dest == "" {
+				return &ValidationError{Field: fmt.Sprintf("node_overlays[%d].files[%d].dest", i, j), Msg: "is required"}
+			}
+		}
+	}
+
+	// Validate merged images
+	for i, merge := range c.MergeImages {
+		if merge.Image == "" {
+			return &ValidationError{Field: fmt.Sprintf("merge_images[%d].image", i), Msg: "is required"}
+		}
+		for j, path := range merge.Paths {
+			if !filepath.IsAbs(path) || strings.Contains(path, "..") {
+				return &ValidationError{Field: fmt.Sprintf("merge_images[%d].paths[%d]", i, j), Msg: "must be an absolute path"}
+			}
+		}
+	}
+
+	// Validate the build stage
+	if c.BuildStage != nil {
+		if len(c.BuildStage.Artifacts) == 0 {
+			return &ValidationError{Field: "build_stage.artifacts", Msg: "at least one path is required"}
+		}
+		for i, path := range c.BuildStage.Artifacts {
+			if !filepath.IsAbs(path) || strings.Contains(path, "..") {
+				return &ValidationError{Field: fmt.Sprintf("build_stage.artifacts[%d]", i), Msg: "must be an absolute path"}
+			}
+		}
+		for i, repo := range c.BuildStage.Repositories {
+			if repo.Alias == "" {
+				return &ValidationError{Field: fmt.Sprintf("build_stage.repos[%d].alias", i), Msg: "is required"}
+			}
+			if repo.Url == "" {
+				return &ValidationError{Field: fmt.Sprintf("build_stage.repos[%d].url", i), Msg: "is required"}
+			}
+		}
+		for i, cf := range c.BuildStage.CopyFiles {
+			if cf.Src == "" {
+				return &ValidationError{Field: fmt.Sprintf("build_stage.copyfiles[%d].src", i), Msg: "is required"}
+			}
+			if cf.Dest == "" {
+				return &ValidationError{Field: fmt.Sprintf("build_stage.copyfiles[%d].dest", i), Msg: "is required"}
+			}
+		}
+	}
+
+	if err := c.validateVariants(); err != nil {
+		return err
+	}
+
+	// Validate CA certificates
+	for i, cert := range c.CACerts {
+		if cert == "" {
+			return &ValidationError{Field: fmt.Sprintf("ca_certs[%d]", i), Msg: "is required"}
+		}
+	}
+
+	// Validate Notifications
+	for i, n := range c.Notifications {
+		if n.Type != "webhook" && n.Type != "slack" {
+			return &ValidationError{Field: fmt.Sprintf("notifications[%d].type", i), Msg: "must be 'webhook' or 'slack'"}
+		}
+		if n.URL == "" {
+			return &ValidationError{Field: fmt.Sprintf("notifications[%d].url", i), Msg: "is required"}
+		}
+		for _, on := range n.On {
+			if on != "success" && on != "failure" {
+				return &ValidationError{Field: fmt.Sprintf("notifications[%d].on", i), Msg: "must contain only 'success' or 'failure'"}
+			}
+		}
+		for key, value := range n.Headers {
+			if envReference.MatchString(value) {
+				return &ValidationError{Field: fmt.Sprintf("notifications[%d].headers.%s", i, key), Msg: "cannot reference environment variables, name them in headers_env"}
+			}
+		}
+		for key, env := range n.HeadersEnv {
+			if !validEnvName.MatchString(env) {
+				return &ValidationError{Field: fmt.Sprintf("notifications[%d].headers_env.%s", i, key), Msg: "must be the name of an environment variable"}
+			}
+		}
+	}
+
+	// Validate BSS registration
+	if c.BSS != nil {
+		if c.BSS.URL == "" {
+			return &ValidationError{Field: "bss.url", Msg: "is required"}
+		}
+		if len(c.BSS.Hosts) == 0 && len(c.BSS.Macs) == 0 && len(c.BSS.Nids) == 0 {
+			return &ValidationError{Field: "bss.hosts", Msg: "at least one of hosts, macs or nids is required"}
+		}
+		if c.BSS.KernelURL == "" {
+			return &ValidationError{Field: "bss.kernel_url", Msg: "is required"}
+		}
+		if c.Options.PublishRegistry == "" {
+			return &ValidationError{Field: "bss", Msg: "requires options.publish_registry"}
+		}
+	}
+
+	// Validate HTTP publishing
+	if c.PublishHTTP != nil {
+		if c.PublishHTTP.URL == "" {
+			return &ValidationError{Field: "publish_http.url", Msg: "is required"}
+		}
+		if _, err := template.New("url").Parse(c.PublishHTTP.URL); err != nil {
+			return &ValidationError{Field: "publish_http.url", Msg: fmt.Sprintf("invalid template: %v", err)}
+		}
+		if c.PublishHTTP.PasswordEnv != "" && c.PublishHTTP.Username == "" {
+			return &ValidationError{Field: "publish_http.password_env", Msg: "requires publish_http.username"}
+		}
+	}
+
+	// Validate boot scripts
+	if c.KernelModules != nil {
+		if len(c.KernelModules.DKMS) == 0 && len(c.KernelModules.Sources) == 0 {
+			return &ValidationError{Field: "kernel_modules", Msg: "at least one of dkms or sources is required"}
+		}
+		for i, src := range c.KernelModules.Sources {
+			if src == "" {
+				return &ValidationError{Field: fmt.Sprintf("kernel_modules.sources[%d]", i), Msg: "is required"}
+			}
+		}
+	}
+
+	if c.GPU != nil {
+		if err := c.GPU.validate(); err != nil {
+			return err
+		}
+	}
+
+	for i, test := range c.Tests {
+		if (test.Cmd == "") == (test.Goss == "") {
+			return &ValidationError{Field: fmt.Sprintf("tests[%d]", i), Msg: "exactly one of cmd or goss is required"}
+		}
+	}
+
+	if c.BootScripts != nil {
+		for _, field := range []struct{ name, text string }{
+			{"url", c.BootScripts.URL},
+			{"cmdline", c.BootScripts.Cmdline},
+		} {
+			if _, err := template.New(field.name).Parse(field.text); err != nil {
+				return &ValidationError{Field: "boot_scripts." + field.name, Msg: fmt.Sprintf("invalid template: %v", err)}
+			}
+		}
+		if len(c.BootScripts.Templates) == 0 {
+			return &ValidationError{Field: "boot_scripts.templates", Msg: "at least one template is required"}
+		}
+		for i, script := range c.BootScripts.Templates {
+			if script.Src == "" {
+				return &ValidationError{Field: fmt.Sprintf("boot_scripts.templates[%d].src", i), Msg: "is required"}
+			}
+			if script.Dest == "" || filepath.Base(script.Dest) != script.Dest || script.Dest == ".." {
+				return &ValidationError{Field: fmt.Sprintf("boot_scripts.templates[%d].dest", i), Msg: "must be a file name"}
+			}
+		}
+	}
+
+	if c.LiveOverlay != nil {
+		if err := c.LiveOverlay.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Seed != nil {
+		if err := c.Seed.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.NFSRoot != nil {
+		if c.LiveOverlay != nil {
+			return &ValidationError{Field: "nfs_root", Msg: "cannot be combined with live_overlay"}
+		}
+		if err := c.NFSRoot.validate(); err != nil {
+			return err
+		}
+	}
+
+	generators := make(map[string]bool)
+	for i, generator := range c.ArtifactGenerators {
+		// The name is also the directory the artifacts of the generator are written to
+		if !validVariantName.MatchString(generator.Name) || generator.Name == "logs" {
+			return &ValidationError{Field: fmt.Sprintf("artifact_generators[%d].name", i), Msg: "must be a directory name other than logs"}
+		}
+		if generators[generator.Name] {
+			return &ValidationError{Field: fmt.Sprintf("artifact_generators[%d].name", i), Msg: fmt.Sprintf("duplicate generator '%s'", generator.Name)}
+		}
+		generators[generator.Name] = true
+		if generator.Command == "" {
+			return &ValidationError{Field: fmt.Sprintf("artifact_generators[%d].command", i), Msg: "is required"}
+		}
+	}
+
+	// Validate TFTP publishing
+	if c.PublishTFTP != nil {
+		if c.PublishTFTP.Root == "" {
+			return &ValidationError{Field: "publish_tftp.root", Msg: "is required"}
+		}
+		if len(c.PublishTFTP.Groups) == 0 {
+			return &ValidationError{Field: "publish_tftp.groups", Msg: "at least one group is required"}
+		}
+		for i, group := range c.PublishTFTP.Groups {
+			if group.Name == "" || strings.ContainsAny(group.Name, "/\\") || group.Name == "." || group.Name == ".." || group.Name == "pxelinux.cfg" {
+				return &ValidationError{Field: fmt.Sprintf("publish_tftp.groups[%d].name", i), Msg: "must be a directory name"}
+			}
+			if len(group.Macs) == 0 {
+				return &ValidationError{Field: fmt.Sprintf("publish_tftp.groups[%d].macs", i), Msg: "at least one MAC address is required"}
+			}
+			for j, mac := range group.Macs {
+				if _, err := net.ParseMAC(mac); err != nil {
+					return &ValidationError{Field: fmt.Sprintf("publish_tftp.groups[%d].macs[%d]", i, j), Msg: "must be a MAC address"}
+				}
+			}
+		}
+	}
+
 	return nil
 }
 
+// ParseExpiry parses an expires_after retention period, a positive number of hours, days or
+// weeks as in the quay.expires-after label
+func ParseExpiry(value string) (time.Duration, error) {
+	units := map[byte]time.Duration{'h': time.Hour, 'd': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	if len(value) < 2 {
+		return 0, fmt.Errorf("invalid retention period '%s'", value)
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, fmt.Errorf("invalid retention period '%s': unit must be h, d or w", value)
+	}
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid retention period '%s'", value)
+	}
+	return time.Duration(n) * unit, nil
+}
+
 // LoadConfig loads and validates a configuration file
 func LoadConfig(path string) (*Config, error) {
 	// Read the configuration file
@@ -129,6 +1063,16 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
+	config, err := ParseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	config.Source = path
+	return config, nil
+}
+
+// ParseConfig parses and validates a configuration from YAML data
+func ParseConfig(data []byte) (*Config, error) {
 	// Parse the configuration
 	var config Config
 	if err := yaml.Unmarshal(data, &config); err != nil {
@@ -144,9 +1088,34 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("configuration validation failed: %w", err)
 	}
 
+	config.Raw = data
 	return &config, nil
 }
 
+// Hash returns the hex sha256 of the configuration in its YAML form
+func (c *Config) Hash() (string, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal config: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Digest returns the sha256 digest of the YAML the configuration was parsed from, or of its
+// YAML form when it was not parsed from YAML
+func (c *Config) Digest() (string, error) {
+	if c.Raw == nil {
+		hash, err := c.Hash()
+		if err != nil {
+			return "", err
+		}
+		return "sha256:" + hash, nil
+	}
+	sum := sha256.Sum256(c.Raw)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
 // WriteConfig writes a configuration to a YAML file
 func WriteConfig(config *Config, path string) error {
 	// Marshal the configuration to YAML
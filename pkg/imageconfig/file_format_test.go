@@ -2,6 +2,7 @@ package imageconfig
 
 import (
 	"testing"
+	"time"
 )
 
 func TestConfigValidation(t *testing.T) {
@@ -14,26 +15,7 @@ func TestConfigValidation(t *testing.T) {
 		{
 			name: "valid base layer config",
 			config: Config{
-				Options: struct {
-					LayerType        string            `yaml:"layer_type"`
-					Name             string            `yaml:"name"`
-					PkgManager       string            `yaml:"pkg_manager"`
-					Parent           string            `yaml:"parent"`
-					PublishTags      string            `yaml:"publish_tags"`
-					PublishRegistry  string            `yaml:"publish_registry"`
-					PublishLocal     bool              `yaml:"publish_local"`
-					PublishS3        string            `yaml:"publish_s3"`
-					S3Prefix         string            `yaml:"s3_prefix"`
-					S3Bucket         string            `yaml:"s3_bucket"`
-					Groups           []string          `yaml:"groups"`
-					Playbooks        []string          `yaml:"playbooks"`
-					Inventory        []string          `yaml:"inventory"`
-					Vars             map[string]any    `yaml:"vars"`
-					AnsibleVerbosity int               `yaml:"ansible_verbosity"`
-					Labels           map[string]string `yaml:"labels"`
-					RegistryOptsPush []string          `yaml:"registry_opts_push"`
-					RegistryOptsPull []string          `yaml:"registry_opts_pull"`
-				}{
+				Options: Options{
 					LayerType:  "base",
 					Name:       "test-image",
 					PkgManager: "dnf",
@@ -44,26 +26,7 @@ func TestConfigValidation(t *testing.T) {
 		{
 			name: "missing layer type",
 			config: Config{
-				Options: struct {
-					LayerType        string            `yaml:"layer_type"`
-					Name             string            `yaml:"name"`
-					PkgManager       string            `yaml:"pkg_manager"`
-					Parent           string            `yaml:"parent"`
-					PublishTags      string            `yaml:"publish_tags"`
-					PublishRegistry  string            `yaml:"publish_registry"`
-					PublishLocal     bool              `yaml:"publish_local"`
-					PublishS3        string            `yaml:"publish_s3"`
-					S3Prefix         string            `yaml:"s3_prefix"`
-					S3Bucket         string            `yaml:"s3_bucket"`
-					Groups           []string          `yaml:"groups"`
-					Playbooks        []string          `yaml:"playbooks"`
-					Inventory        []string          `yaml:"inventory"`
-					Vars             map[string]any    `yaml:"vars"`
-					AnsibleVerbosity int               `yaml:"ansible_verbosity"`
-					Labels           map[string]string `yaml:"labels"`
-					RegistryOptsPush []string          `yaml:"registry_opts_push"`
-					RegistryOptsPull []string          `yaml:"registry_opts_pull"`
-				}{
+				Options: Options{
 					Name:       "test-image",
 					PkgManager: "dnf",
 				},
@@ -74,26 +37,7 @@ func TestConfigValidation(t *testing.T) {
 		{
 			name: "invalid layer type",
 			config: Config{
-				Options: struct {
-					LayerType        string            `yaml:"layer_type"`
-					Name             string            `yaml:"name"`
-					PkgManager       string            `yaml:"pkg_manager"`
-					Parent           string            `yaml:"parent"`
-					PublishTags      string            `yaml:"publish_tags"`
-					PublishRegistry  string            `yaml:"publish_registry"`
-					PublishLocal     bool              `yaml:"publish_local"`
-					PublishS3        string            `yaml:"publish_s3"`
-					S3Prefix         string            `yaml:"s3_prefix"`
-					S3Bucket         string            `yaml:"s3_bucket"`
-					Groups           []string          `yaml:"groups"`
-					Playbooks        []string          `yaml:"playbooks"`
-					Inventory        []string          `yaml:"inventory"`
-					Vars             map[string]any    `yaml:"vars"`
-					AnsibleVerbosity int               `yaml:"ansible_verbosity"`
-					Labels           map[string]string `yaml:"labels"`
-					RegistryOptsPush []string          `yaml:"registry_opts_push"`
-					RegistryOptsPull []string          `yaml:"registry_opts_pull"`
-				}{
+				Options: Options{
 					LayerType: "invalid",
 					Name:      "test-image",
 				},
@@ -104,26 +48,7 @@ func TestConfigValidation(t *testing.T) {
 		{
 			name: "missing name",
 			config: Config{
-				Options: struct {
-					LayerType        string            `yaml:"layer_type"`
-					Name             string            `yaml:"name"`
-					PkgManager       string            `yaml:"pkg_manager"`
-					Parent           string            `yaml:"parent"`
-					PublishTags      string            `yaml:"publish_tags"`
-					PublishRegistry  string            `yaml:"publish_registry"`
-					PublishLocal     bool              `yaml:"publish_local"`
-					PublishS3        string            `yaml:"publish_s3"`
-					S3Prefix         string            `yaml:"s3_prefix"`
-					S3Bucket         string            `yaml:"s3_bucket"`
-					Groups           []string          `yaml:"groups"`
-					Playbooks        []string          `yaml:"playbooks"`
-					Inventory        []string          `yaml:"inventory"`
-					Vars             map[string]any    `yaml:"vars"`
-					AnsibleVerbosity int               `yaml:"ansible_verbosity"`
-					Labels           map[string]string `yaml:"labels"`
-					RegistryOptsPush []string          `yaml:"registry_opts_push"`
-					RegistryOptsPull []string          `yaml:"registry_opts_pull"`
-				}{
+				Options: Options{
 					LayerType:  "base",
 					PkgManager: "dnf",
 				},
@@ -134,26 +59,7 @@ func TestConfigValidation(t *testing.T) {
 		{
 			name: "base layer missing pkg_manager",
 			config: Config{
-				Options: struct {
-					LayerType        string            `yaml:"layer_type"`
-					Name             string            `yaml:"name"`
-					PkgManager       string            `yaml:"pkg_manager"`
-					Parent           string            `yaml:"parent"`
-					PublishTags      string            `yaml:"publish_tags"`
-					PublishRegistry  string            `yaml:"publish_registry"`
-					PublishLocal     bool              `yaml:"publish_local"`
-					PublishS3        string            `yaml:"publish_s3"`
-					S3Prefix         string            `yaml:"s3_prefix"`
-					S3Bucket         string            `yaml:"s3_bucket"`
-					Groups           []string          `yaml:"groups"`
-					Playbooks        []string          `yaml:"playbooks"`
-					Inventory        []string          `yaml:"inventory"`
-					Vars             map[string]any    `yaml:"vars"`
-					AnsibleVerbosity int               `yaml:"ansible_verbosity"`
-					Labels           map[string]string `yaml:"labels"`
-					RegistryOptsPush []string          `yaml:"registry_opts_push"`
-					RegistryOptsPull []string          `yaml:"registry_opts_pull"`
-				}{
+				Options: Options{
 					LayerType: "base",
 					Name:      "test-image",
 				},
@@ -164,26 +70,7 @@ func TestConfigValidation(t *testing.T) {
 		{
 			name: "invalid repository config",
 			config: Config{
-				Options: struct {
-					LayerType        string            `yaml:"layer_type"`
-					Name             string            `yaml:"name"`
-					PkgManager       string            `yaml:"pkg_manager"`
-					Parent           string            `yaml:"parent"`
-					PublishTags      string            `yaml:"publish_tags"`
-					PublishRegistry  string            `yaml:"publish_registry"`
-					PublishLocal     bool              `yaml:"publish_local"`
-					PublishS3        string            `yaml:"publish_s3"`
-					S3Prefix         string            `yaml:"s3_prefix"`
-					S3Bucket         string            `yaml:"s3_bucket"`
-					Groups           []string          `yaml:"groups"`
-					Playbooks        []string          `yaml:"playbooks"`
-					Inventory        []string          `yaml:"inventory"`
-					Vars             map[string]any    `yaml:"vars"`
-					AnsibleVerbosity int               `yaml:"ansible_verbosity"`
-					Labels           map[string]string `yaml:"labels"`
-					RegistryOptsPush []string          `yaml:"registry_opts_push"`
-					RegistryOptsPull []string          `yaml:"registry_opts_pull"`
-				}{
+				Options: Options{
 					LayerType:  "base",
 					Name:       "test-image",
 					PkgManager: "dnf",
@@ -206,26 +93,7 @@ func TestConfigValidation(t *testing.T) {
 		{
 			name: "invalid command config",
 			config: Config{
-				Options: struct {
-					LayerType        string            `yaml:"layer_type"`
-					Name             string            `yaml:"name"`
-					PkgManager       string            `yaml:"pkg_manager"`
-					Parent           string            `yaml:"parent"`
-					PublishTags      string            `yaml:"publish_tags"`
-					PublishRegistry  string            `yaml:"publish_registry"`
-					PublishLocal     bool              `yaml:"publish_local"`
-					PublishS3        string            `yaml:"publish_s3"`
-					S3Prefix         string            `yaml:"s3_prefix"`
-					S3Bucket         string            `yaml:"s3_bucket"`
-					Groups           []string          `yaml:"groups"`
-					Playbooks        []string          `yaml:"playbooks"`
-					Inventory        []string          `yaml:"inventory"`
-					Vars             map[string]any    `yaml:"vars"`
-					AnsibleVerbosity int               `yaml:"ansible_verbosity"`
-					Labels           map[string]string `yaml:"labels"`
-					RegistryOptsPush []string          `yaml:"registry_opts_push"`
-					RegistryOptsPull []string          `yaml:"registry_opts_pull"`
-				}{
+				Options: Options{
 					LayerType:  "base",
 					Name:       "test-image",
 					PkgManager: "dnf",
@@ -233,6 +101,8 @@ func TestConfigValidation(t *testing.T) {
 				Cmds: []struct {
 					Cmd      string `yaml:"cmd"`
 					LogLevel string `yaml:"loglevel"`
+					Network  string `yaml:"network"`
+					Proxy    *Proxy `yaml:"proxy"`
 				}{
 					{
 						Cmd:      "echo test",
@@ -243,29 +113,237 @@ func TestConfigValidation(t *testing.T) {
 			wantErr: true,
 			errMsg:  "cmds[0].loglevel: must be one of: INFO, DEBUG, WARNING, ERROR",
 		},
+		{
+			name: "cosign identity without issuer",
+			config: Config{
+				Options: Options{
+					LayerType:    "base",
+					Name:         "test-image",
+					PkgManager:   "dnf",
+					Parent:       "registry.local/rocky:9",
+					ParentVerify: ParentVerify{CosignIdentity: "builder@example.com"},
+				},
+			},
+			wantErr: true,
+			errMsg:  "options.parent_verify: cosign_identity and cosign_issuer must be set together",
+		},
+		{
+			name: "relative temp dir",
+			config: Config{
+				Options: Options{
+					LayerType:  "base",
+					Name:       "test-image",
+					PkgManager: "dnf",
+					TempDir:    "scratch",
+				},
+			},
+			wantErr: true,
+			errMsg:  "options.temp_dir: must be an absolute path",
+		},
+		{
+			name: "invalid embed config mode",
+			config: Config{
+				Options: Options{
+					LayerType:   "base",
+					Name:        "test-image",
+					PkgManager:  "dnf",
+					EmbedConfig: EmbedConfig{Mode: "minimal"},
+				},
+			},
+			wantErr: true,
+			errMsg:  "options.embed_config.mode: must be 'sanitized', 'full' or 'none'",
+		},
+		{
+			name: "disk overlay without device",
+			config: Config{
+				Options: Options{
+					LayerType:  "base",
+					Name:       "test-image",
+					PkgManager: "dnf",
+				},
+				LiveOverlay: &LiveOverlay{Mode: "disk"},
+			},
+			wantErr: true,
+			errMsg:  "live_overlay.device: is required for the 'disk' mode",
+		},
+		{
+			name: "invalid dracut",
+			config: Config{
+				Options: Options{
+					LayerType:  "base",
+					Name:       "test-image",
+					PkgManager: "dnf",
+					Dracut:     "chroot",
+				},
+			},
+			wantErr: true,
+			errMsg:  "options.dracut: must be 'image' or 'host'",
+		},
+		{
+			name: "initrd pattern outside /boot",
+			config: Config{
+				Options: Options{
+					LayerType:     "base",
+					Name:          "test-image",
+					PkgManager:    "dnf",
+					InitrdPattern: "../initrd.img",
+				},
+			},
+			wantErr: true,
+			errMsg:  "options.initrd_pattern: must be a glob relative to /boot such as 'initrd.img-{{.KernelVersion}}'",
+		},
+		{
+			name: "annotations of an unknown layer",
+			config: Config{
+				Options: Options{
+					LayerType:        "base",
+					Name:             "test-image",
+					PkgManager:       "dnf",
+					LayerAnnotations: map[string]map[string]string{"rootfs": {"key": "value"}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "options.layer_annotations.rootfs: must be one of base, gpu, config, kernel, initrd, squashed",
+		},
+		{
+			name: "invalid manifest format",
+			config: Config{
+				Options: Options{
+					LayerType:      "base",
+					Name:           "test-image",
+					PkgManager:     "dnf",
+					ManifestFormat: "schema1",
+				},
+			},
+			wantErr: true,
+			errMsg:  "options.manifest_format: must be 'oci' or 'docker'",
+		},
+		{
+			name: "notification header referencing the environment",
+			config: Config{
+				Options: Options{
+					LayerType:  "base",
+					Name:       "test-image",
+					PkgManager: "dnf",
+				},
+				Notifications: []Notification{
+					{Type: "webhook", URL: "https://hooks.example.com", Headers: map[string]string{"X-Key": "$AWS_SECRET_ACCESS_KEY"}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "notifications[0].headers.X-Key: cannot reference environment variables, name them in headers_env",
+		},
+		{
+			name: "invalid network",
+			config: Config{
+				Options: Options{
+					LayerType:  "base",
+					Name:       "test-image",
+					PkgManager: "dnf",
+					Network:    "bridge",
+				},
+			},
+			wantErr: true,
+			errMsg:  "options.network: must be 'host' or 'none'",
+		},
+		{
+			name: "invalid buildah volume",
+			config: Config{
+				Options: Options{
+					LayerType:  "base",
+					Name:       "test-image",
+					PkgManager: "dnf",
+					Buildah:    BuildahOptions{Volumes: []string{"/var/cache/dnf:/var/cache/dnf:ro"}, Mounts: []string{"type=bind,source=/run/lmgrd.sock"}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "options.buildah.mounts[0]: must set an absolute target, as in 'type=bind,source=/var/cache/dnf,target=/var/cache/dnf'",
+		},
+		{
+			name: "invalid image info key",
+			config: Config{
+				Options: Options{
+					LayerType:  "base",
+					Name:       "test-image",
+					PkgManager: "dnf",
+					ImageInfo:  ImageInfo{Role: "compute", Extra: map[string]string{"Rack-Row": "12"}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "options.image_info.extra.Rack-Row: must be lower case words joined with underscores",
+		},
+		{
+			name: "invalid upload chunk size",
+			config: Config{
+				Options: Options{
+					LayerType:       "base",
+					Name:            "test-image",
+					PkgManager:      "dnf",
+					UploadChunkSize: "64 parsecs",
+				},
+			},
+			wantErr: true,
+			errMsg:  "options.upload_chunk_size: must be a size such as '64M'",
+		},
+		{
+			name: "negative source date epoch",
+			config: Config{
+				Options: Options{
+					LayerType:       "base",
+					Name:            "test-image",
+					PkgManager:      "dnf",
+					SourceDateEpoch: -1,
+				},
+			},
+			wantErr: true,
+			errMsg:  "options.source_date_epoch: must be a Unix timestamp",
+		},
+		{
+			name: "test with command and goss file",
+			config: Config{
+				Options: Options{
+					LayerType:  "base",
+					Name:       "test-image",
+					PkgManager: "dnf",
+				},
+				Tests: []ImageTest{{Cmd: "systemctl is-enabled sshd", Goss: "goss.yaml"}},
+			},
+			wantErr: true,
+			errMsg:  "tests[0]: exactly one of cmd or goss is required",
+		},
+		{
+			name: "relative build stage artifact",
+			config: Config{
+				Options: Options{
+					LayerType:  "base",
+					Name:       "test-image",
+					PkgManager: "dnf",
+				},
+				BuildStage: &BuildStage{Packages: []string{"gcc"}, Artifacts: []string{"opt/slurm"}},
+			},
+			wantErr: true,
+			errMsg:  "build_stage.artifacts[0]: must be an absolute path",
+		},
+		{
+			name: "duplicate artifact generator",
+			config: Config{
+				Options: Options{
+					LayerType:  "base",
+					Name:       "test-image",
+					PkgManager: "dnf",
+				},
+				ArtifactGenerators: []ArtifactGenerator{
+					{Name: "vnfs", Command: "/usr/local/bin/wwvnfs"},
+					{Name: "vnfs", Command: "/usr/local/bin/xcat-bundle"},
+				},
+			},
+			wantErr: true,
+			errMsg:  "artifact_generators[1].name: duplicate generator 'vnfs'",
+		},
 		{
 			name: "invalid copyfiles config",
 			config: Config{
-				Options: struct {
-					LayerType        string            `yaml:"layer_type"`
-					Name             string            `yaml:"name"`
-					PkgManager       string            `yaml:"pkg_manager"`
-					Parent           string            `yaml:"parent"`
-					PublishTags      string            `yaml:"publish_tags"`
-					PublishRegistry  string            `yaml:"publish_registry"`
-					PublishLocal     bool              `yaml:"publish_local"`
-					PublishS3        string            `yaml:"publish_s3"`
-					S3Prefix         string            `yaml:"s3_prefix"`
-					S3Bucket         string            `yaml:"s3_bucket"`
-					Groups           []string          `yaml:"groups"`
-					Playbooks        []string          `yaml:"playbooks"`
-					Inventory        []string          `yaml:"inventory"`
-					Vars             map[string]any    `yaml:"vars"`
-					AnsibleVerbosity int               `yaml:"ansible_verbosity"`
-					Labels           map[string]string `yaml:"labels"`
-					RegistryOptsPush []string          `yaml:"registry_opts_push"`
-					RegistryOptsPull []string          `yaml:"registry_opts_pull"`
-				}{
+				Options: Options{
 					LayerType:  "base",
 					Name:       "test-image",
 					PkgManager: "dnf",
@@ -297,3 +375,55 @@ func TestConfigValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestParentArchive(t *testing.T) {
+	tests := []struct {
+		parent string
+		want   ParentArchive
+		ok     bool
+	}{
+		{parent: "registry.local/rocky:9", ok: false},
+		{parent: "localhost:5000/rocky:9", ok: false},
+		{parent: "docker-archive:/srv/images/rocky.tar", want: ParentArchive{Transport: "docker-archive", Path: "/srv/images/rocky.tar"}, ok: true},
+		{parent: "oci:/srv/images/rocky", want: ParentArchive{Transport: "oci", Path: "/srv/images/rocky"}, ok: true},
+		{parent: "oci:/srv/images/rocky:9.5", want: ParentArchive{Transport: "oci", Path: "/srv/images/rocky", Ref: "9.5"}, ok: true},
+		{parent: "oci:/srv/images:v2/rocky", want: ParentArchive{Transport: "oci", Path: "/srv/images:v2/rocky"}, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.parent, func(t *testing.T) {
+			got, ok := Options{Parent: tt.parent}.ParentArchive()
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("ParentArchive() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "12h", want: 12 * time.Hour},
+		{value: "30d", want: 30 * 24 * time.Hour},
+		{value: "2w", want: 14 * 24 * time.Hour},
+		{value: "0d", wantErr: true},
+		{value: "-1d", wantErr: true},
+		{value: "30m", wantErr: true},
+		{value: "d", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseExpiry(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseExpiry(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseExpiry(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package imageconfig
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// imageInfoKey matches the extra keys of image_info, which become shell variable names
+var imageInfoKey = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// ImageInfo is the provisioning metadata written into images at /etc/openchami/image-info
+// and recorded as labels, so node tooling can tell which image a node booted. BuildID is a
+// template with the values of publish_tags other than KernelVersion, such as
+// "{{.Date}}-{{.GitSHA}}". Extra holds further site-specific keys, lower case words joined
+// with underscores.
+type ImageInfo struct {
+	Cluster string            `yaml:"cluster"`
+	Role    string            `yaml:"role"`
+	BuildID string            `yaml:"build_id"`
+	Extra   map[string]string `yaml:"extra"`
+}
+
+// IsZero reports whether no provisioning metadata is configured
+func (i ImageInfo) IsZero() bool {
+	return i.Cluster == "" && i.Role == "" && i.BuildID == "" && len(i.Extra) == 0
+}
+
+func (i ImageInfo) validate() error {
+	if _, err := template.New("build_id").Parse(i.BuildID); err != nil {
+		return &ValidationError{Field: "options.image_info.build_id", Msg: fmt.Sprintf("invalid template: %v", err)}
+	}
+	if strings.Contains(i.BuildID, ".KernelVersion") {
+		return &ValidationError{Field: "options.image_info.build_id", Msg: "cannot use KernelVersion, which is only known after the image info is written"}
+	}
+	values := map[string]string{"cluster": i.Cluster, "role": i.Role, "build_id": i.BuildID}
+	for key, value := range i.Extra {
+		if !imageInfoKey.MatchString(key) {
+			return &ValidationError{Field: fmt.Sprintf("options.image_info.extra.%s", key), Msg: "must be lower case words joined with underscores"}
+		}
+		switch key {
+		case "name", "cluster", "role", "build_id":
+			return &ValidationError{Field: fmt.Sprintf("options.image_info.extra.%s", key), Msg: "is set by the builder or image_info itself"}
+		}
+		values["extra."+key] = value
+	}
+	for key, value := range values {
+		if strings.ContainsAny(value, "\n\r") {
+			return &ValidationError{Field: "options.image_info." + key, Msg: "must be a single line"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package imageconfig
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LiveOverlay configures the writable overlay of nodes booting the squashfs with the dracut
+// dmsquash-live module. Mode is "ram", the default, to keep changes in memory, or "disk" to
+// persist them on Device, a dracut device spec such as "LABEL=persist" optionally followed by
+// ":<path>" of the overlay on it. Size is the size of the overlay in MiB, OverlayFS uses an
+// overlayfs instead of a device-mapper snapshot, and Reset discards a persistent overlay on
+// boot.
+type LiveOverlay struct {
+	Mode      string `yaml:"mode"`
+	Size      int    `yaml:"size"`
+	Device    string `yaml:"device"`
+	OverlayFS bool   `yaml:"overlayfs"`
+	Reset     bool   `yaml:"reset"`
+}
+
+// Args returns the kernel command line arguments selecting the overlay
+func (o *LiveOverlay) Args() string {
+	if o == nil {
+		return ""
+	}
+	var args []string
+	if o.Mode == "disk" {
+		args = append(args, "rd.live.overlay="+o.Device)
+		if o.Reset {
+			args = append(args, "rd.live.overlay.reset")
+		}
+	}
+	if o.Size > 0 {
+		args = append(args, fmt.Sprintf("rd.live.overlay.size=%d", o.Size))
+	}
+	if o.OverlayFS {
+		args = append(args, "rd.live.overlay.overlayfs=1")
+	}
+	return strings.Join(args, " ")
+}
+
+// DracutArgs returns the arguments dracut needs to build an initrd supporting the overlay
+func (o *LiveOverlay) DracutArgs() []string {
+	if o == nil || !o.OverlayFS {
+		return nil
+	}
+	return []string{"--add-drivers", "overlay"}
+}
+
+func (o *LiveOverlay) validate() error {
+	switch o.Mode {
+	case "", "ram":
+		if o.Device != "" || o.Reset {
+			return &ValidationError{Field: "live_overlay.device", Msg: "only applies to the 'disk' mode"}
+		}
+	case "disk":
+		if o.Device == "" {
+			return &ValidationError{Field: "live_overlay.device", Msg: "is required for the 'disk' mode"}
+		}
+		if strings.ContainsAny(o.Device, " \t\n") {
+			return &ValidationError{Field: "live_overlay.device", Msg: "must be a device spec such as 'LABEL=persist'"}
+		}
+	default:
+		return &ValidationError{Field: "live_overlay.mode", Msg: "must be 'ram' or 'disk'"}
+	}
+	if o.Size < 0 {
+		return &ValidationError{Field: "live_overlay.size", Msg: "must be a size in MiB"}
+	}
+	return nil
+}
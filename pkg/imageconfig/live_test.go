@@ -0,0 +1,33 @@
+package imageconfig
+
+import "testing"
+
+func TestLiveOverlayArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		overlay *LiveOverlay
+		want    string
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:    "ram",
+			overlay: &LiveOverlay{Size: 4096, OverlayFS: true},
+			want:    "rd.live.overlay.size=4096 rd.live.overlay.overlayfs=1",
+		},
+		{
+			name:    "disk",
+			overlay: &LiveOverlay{Mode: "disk", Device: "LABEL=persist:/overlay", Reset: true},
+			want:    "rd.live.overlay=LABEL=persist:/overlay rd.live.overlay.reset",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.overlay.Args(); got != tt.want {
+				t.Errorf("Args() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package imageconfig
+
+import (
+	"path"
+	"strings"
+)
+
+// NFSRoot packages the image for nodes mounting their root filesystem over NFS instead of
+// booting the squashfs. The rootfs is written as a tarball to unpack into Path on Server,
+// the image's fstab mounts it as the root filesystem, and Options are the NFS mount
+// options, such as "ro,vers=4.2".
+type NFSRoot struct {
+	Server  string `yaml:"server"`
+	Path    string `yaml:"path"`
+	Options string `yaml:"options"`
+}
+
+// Source returns the NFS export of the root filesystem, as in fstab
+func (n *NFSRoot) Source() string {
+	return n.Server + ":" + n.Path
+}
+
+// Args returns the kernel command line arguments mounting the root filesystem over NFS
+func (n *NFSRoot) Args() string {
+	if n == nil {
+		return ""
+	}
+	root := "root=nfs:" + n.Source()
+	if n.Options != "" {
+		root += ":" + n.Options
+	}
+	return root
+}
+
+// DracutArgs returns the arguments dracut needs to build an initrd mounting the root
+// filesystem over NFS
+func (n *NFSRoot) DracutArgs() []string {
+	if n == nil {
+		return nil
+	}
+	return []string{"--add", "nfs"}
+}
+
+func (n *NFSRoot) validate() error {
+	if n.Server == "" || strings.ContainsAny(n.Server, ": \t") {
+		return &ValidationError{Field: "nfs_root.server", Msg: "must be a host name or IPv4 address"}
+	}
+	if !path.IsAbs(n.Path) || strings.ContainsAny(n.Path, ": \t") {
+		return &ValidationError{Field: "nfs_root.path", Msg: "must be an absolute path"}
+	}
+	if strings.ContainsAny(n.Options, ": \t") {
+		return &ValidationError{Field: "nfs_root.options", Msg: "must be comma separated mount options such as 'ro,vers=4.2'"}
+	}
+	return nil
+}
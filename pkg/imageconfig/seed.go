@@ -0,0 +1,53 @@
+package imageconfig
+
+// Seed generates a cloud-init NoCloud seed or an Ignition config for nodes provisioned as
+// VMs. Format is "cloud-init", the default, or "ignition". UserData, MetaData and
+// NetworkConfig are the files of the cloud-init seed; without MetaData, one naming the image
+// as the instance is written. Ignition is the Ignition config file. Output is "image", the
+// default, to embed the seed where cloud-init or Ignition finds it on boot, "artifact" to
+// write it to the working directory to serve next to the image, or "both".
+type Seed struct {
+	Format        string `yaml:"format"`
+	UserData      string `yaml:"user_data"`
+	MetaData      string `yaml:"meta_data"`
+	NetworkConfig string `yaml:"network_config"`
+	Ignition      string `yaml:"ignition"`
+	Output        string `yaml:"output"`
+}
+
+// InImage reports whether the seed is embedded into the image
+func (s *Seed) InImage() bool {
+	return s.Output == "" || s.Output == "image" || s.Output == "both"
+}
+
+// InArtifacts reports whether the seed is written to the working directory
+func (s *Seed) InArtifacts() bool {
+	return s.Output == "artifact" || s.Output == "both"
+}
+
+func (s *Seed) validate() error {
+	switch s.Format {
+	case "", "cloud-init":
+		if s.UserData == "" {
+			return &ValidationError{Field: "seed.user_data", Msg: "is required for cloud-init seeds"}
+		}
+		if s.Ignition != "" {
+			return &ValidationError{Field: "seed.ignition", Msg: "only applies to the 'ignition' format"}
+		}
+	case "ignition":
+		if s.Ignition == "" {
+			return &ValidationError{Field: "seed.ignition", Msg: "is required for ignition seeds"}
+		}
+		if s.UserData != "" || s.MetaData != "" || s.NetworkConfig != "" {
+			return &ValidationError{Field: "seed", Msg: "user_data, meta_data and network_config only apply to the 'cloud-init' format"}
+		}
+	default:
+		return &ValidationError{Field: "seed.format", Msg: "must be 'cloud-init' or 'ignition'"}
+	}
+	switch s.Output {
+	case "", "image", "artifact", "both":
+	default:
+		return &ValidationError{Field: "seed.output", Msg: "must be 'image', 'artifact' or 'both'"}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package imageconfig
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// validVariantName matches variant names, which are used in directory names and tags
+var validVariantName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Variant is one build of a configuration's build matrix. The variant name and vars are
+// available as {{.Variant}} and {{.Vars.key}} to the options.name, options.parent and
+// publish_tags templates. Its packages, repositories, files and labels are added to those
+// of the configuration.
+type Variant struct {
+	Name           string            `yaml:"name"`
+	Vars           map[string]string `yaml:"vars"`
+	Parent         string            `yaml:"parent"`
+	Packages       []string          `yaml:"packages"`
+	PackageGroups  []string          `yaml:"package_groups"`
+	RemovePackages []string          `yaml:"remove_packages"`
+	Repositories   []Repository      `yaml:"repos"`
+	CopyFiles      []CopyFile        `yaml:"copyfiles"`
+	Labels         map[string]string `yaml:"labels"`
+}
+
+// validateVariants checks the variant names, which must be unique
+func (c *Config) validateVariants() error {
+	seen := make(map[string]bool)
+	for i, v := range c.Variants {
+		if !validVariantName.MatchString(v.Name) {
+			return &ValidationError{Field: fmt.Sprintf("variants[%d].name", i), Msg: "must be letters, digits, '_', '.' or '-'"}
+		}
+		if seen[v.Name] {
+			return &ValidationError{Field: fmt.Sprintf("variants[%d].name", i), Msg: fmt.Sprintf("duplicate variant '%s'", v.Name)}
+		}
+		seen[v.Name] = true
+	}
+	return nil
+}
+
+// Expand returns the configuration of every variant, or the configuration itself when it
+// has no variants
+func (c *Config) Expand() ([]*Config, error) {
+	if len(c.Variants) == 0 {
+		return []*Config{c}, nil
+	}
+	if err := c.validateVariants(); err != nil {
+		return nil, err
+	}
+
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	configs := make([]*Config, 0, len(c.Variants))
+	for _, v := range c.Variants {
+		var vc Config
+		if err := yaml.Unmarshal(data, &vc); err != nil {
+			return nil, fmt.Errorf("failed to copy config: %w", err)
+		}
+		vc.Source = c.Source
+		vc.Raw = c.Raw
+		vc.Variants = nil
+		variant := v
+		vc.Variant = &variant
+
+		if vc.Options.Name, err = renderVariant(c.Options.Name, v); err != nil {
+			return nil, fmt.Errorf("variant %s: options.name: %w", v.Name, err)
+		}
+		parent := c.Options.Parent
+		if v.Parent != "" {
+			parent = v.Parent
+		}
+		if vc.Options.Parent, err = renderVariant(parent, v); err != nil {
+			return nil, fmt.Errorf("variant %s: options.parent: %w", v.Name, err)
+		}
+
+		vc.Packages = append(vc.Packages, v.Packages...)
+		vc.PackageGroups = append(vc.PackageGroups, v.PackageGroups...)
+		vc.RemovePackages = append(vc.RemovePackages, v.RemovePackages...)
+		vc.Repositories = append(vc.Repositories, v.Repositories...)
+		vc.CopyFiles = append(vc.CopyFiles, v.CopyFiles...)
+		if vc.Options.Labels == nil {
+			vc.Options.Labels = make(map[string]string)
+		}
+		for key, value := range v.Labels {
+			vc.Options.Labels[key] = value
+		}
+		vc.Options.Labels["com.openchami.image.variant"] = v.Name
+
+		if err := vc.Validate(); err != nil {
+			return nil, fmt.Errorf("variant %s: %w", v.Name, err)
+		}
+		configs = append(configs, &vc)
+	}
+	return configs, nil
+}
+
+// renderVariant expands the variant name and vars in a template
+func renderVariant(text string, v Variant) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New("variant").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	data := map[string]any{"Variant": v.Name, "Vars": v.Vars}
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
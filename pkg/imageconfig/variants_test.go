@@ -0,0 +1,71 @@
+package imageconfig
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	config := Config{
+		Options: Options{
+			LayerType:  "base",
+			Name:       "compute-{{.Variant}}",
+			PkgManager: "dnf",
+			Parent:     "registry.local/rocky:9-{{.Vars.arch}}",
+		},
+		Packages: []string{"kernel"},
+		Variants: []Variant{
+			{Name: "cpu", Vars: map[string]string{"arch": "x86_64"}},
+			{Name: "gpu", Vars: map[string]string{"arch": "x86_64"}, Packages: []string{"nvidia-driver"}},
+			{Name: "arm", Parent: "registry.local/rocky:9-aarch64"},
+		},
+		Source: "compute.yaml",
+	}
+
+	configs, err := config.Expand()
+	if err != nil {
+		t.Fatalf("Expand() error = %v", err)
+	}
+	if len(configs) != 3 {
+		t.Fatalf("Expand() returned %d configs, want 3", len(configs))
+	}
+
+	gpu := configs[1]
+	if gpu.Options.Name != "compute-gpu" {
+		t.Errorf("name = %s, want compute-gpu", gpu.Options.Name)
+	}
+	if gpu.Options.Parent != "registry.local/rocky:9-x86_64" {
+		t.Errorf("parent = %s, want registry.local/rocky:9-x86_64", gpu.Options.Parent)
+	}
+	if want := []string{"kernel", "nvidia-driver"}; !reflect.DeepEqual(gpu.Packages, want) {
+		t.Errorf("packages = %v, want %v", gpu.Packages, want)
+	}
+	if gpu.Variant == nil || gpu.Variant.Name != "gpu" || gpu.Variants != nil || gpu.Source != "compute.yaml" {
+		t.Errorf("variant = %+v, variants = %v, source = %s", gpu.Variant, gpu.Variants, gpu.Source)
+	}
+	if gpu.Options.Labels["com.openchami.image.variant"] != "gpu" {
+		t.Errorf("variant label = %q, want gpu", gpu.Options.Labels["com.openchami.image.variant"])
+	}
+	// Variants must not share the base configuration's lists
+	if len(configs[0].Packages) != 1 || len(config.Packages) != 1 {
+		t.Errorf("packages leaked between variants: %v, %v", configs[0].Packages, config.Packages)
+	}
+	if configs[2].Options.Parent != "registry.local/rocky:9-aarch64" {
+		t.Errorf("parent override = %s", configs[2].Options.Parent)
+	}
+}
+
+func TestExpandDuplicateVariant(t *testing.T) {
+	config := Config{
+		Options:  Options{LayerType: "base", Name: "compute-{{.Variant}}", PkgManager: "dnf"},
+		Variants: []Variant{{Name: "cpu"}, {Name: "gpu"}, {Name: "cpu"}},
+	}
+
+	_, err := config.Expand()
+	if err == nil {
+		t.Fatal("Expand() with a duplicate variant succeeded, want error")
+	}
+	if want := "variants[2].name: duplicate variant 'cpu'"; err.Error() != want {
+		t.Errorf("Expand() error = %v, want %s", err, want)
+	}
+}
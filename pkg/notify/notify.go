@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go-image-builder/pkg/imageconfig"
+)
+
+// Build outcomes reported to notifications
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+// requestTimeout bounds how long a single notification may take
+const requestTimeout = 30 * time.Second
+
+// Report summarizes a finished build
+type Report struct {
+	Image    string    `json:"image"`
+	Registry string    `json:"registry,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	Parent   string    `json:"parent,omitempty"`
+	Kernel   string    `json:"kernel_version,omitempty"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Host     string    `json:"host"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Duration string    `json:"duration"`
+}
+
+// NewReport builds the report for a build of config that started at started and ended with err
+func NewReport(config *imageconfig.Config, started time.Time, err error) Report {
+	finished := time.Now()
+	host, _ := os.Hostname()
+
+	report := Report{
+		Image:    config.Options.Name,
+		Registry: config.Options.PublishRegistry,
+		Parent:   config.Options.Parent,
+		Status:   StatusSuccess,
+		Host:     host,
+		Started:  started,
+		Finished: finished,
+		Duration: finished.Sub(started).Round(time.Second).String(),
+	}
+	for _, tag := range strings.Split(config.Options.PublishTags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			report.Tags = append(report.Tags, tag)
+		}
+	}
+	if err != nil {
+		report.Status = StatusFailure
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// Send fires every notification that subscribes to the outcome of the report. All
+// notifications are attempted; the errors of those that failed are joined.
+func Send(notifications []imageconfig.Notification, report Report) error {
+	client := &http.Client{Timeout: requestTimeout}
+
+	var errs []error
+	for _, n := range notifications {
+		if len(n.On) > 0 && !slices.Contains(n.On, report.Status) {
+			continue
+		}
+
+		var payload any = report
+		if n.Type == "slack" {
+			payload = map[string]string{"text": slackMessage(report)}
+		}
+
+		if err := post(client, n, payload); err != nil {
+			errs = append(errs, fmt.Errorf("failed to send %s notification to %s: %w", n.Type, n.URL, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// post sends payload as JSON to the notification URL
+func post(client *http.Client, n imageconfig.Notification, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range n.HeaderValues() {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// slackMessage formats the report as a Slack message
+func slackMessage(r Report) string {
+	ref := r.Image
+	if r.Registry != "" {
+		ref = r.Registry + "/" + r.Image
+	}
+	if len(r.Tags) > 0 {
+		ref += ":" + strings.Join(r.Tags, ",")
+	}
+
+	if r.Status == StatusSuccess {
+		return fmt.Sprintf(":white_check_mark: Image build of `%s` succeeded on %s in %s", ref, r.Host, r.Duration)
+	}
+	return fmt.Sprintf(":x: Image build of `%s` failed on %s after %s: %s", ref, r.Host, r.Duration, r.Error)
+}
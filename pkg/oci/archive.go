@@ -0,0 +1,84 @@
+package oci
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go-image-builder/pkg/imageconfig"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	log "github.com/sirupsen/logrus"
+)
+
+// importArchive imports an image from an OCI layout or docker-archive tarball under its
+// ArchiveImageName. The import is skipped if local storage already holds the image in the
+// archive, so unchanged archives are only imported once while changes under the same path
+// are still picked up.
+func (o *OCI) importArchive(image string, archive imageconfig.ParentArchive) error {
+	if _, err := os.Stat(archive.Path); err != nil {
+		return fmt.Errorf("archive not found: %w", err)
+	}
+
+	localName := ArchiveImageName(image)
+	img, err := LoadArchive(archive)
+	if err != nil {
+		return err
+	}
+	config, err := img.ConfigName()
+	if err != nil {
+		return fmt.Errorf("failed to read image config digest: %w", err)
+	}
+	if id, err := o.backend.ImageID(localName); err == nil && strings.TrimPrefix(id, "sha256:") == config.Hex {
+		log.Infof("Image from %s is already imported as %s", image, localName)
+		return nil
+	}
+
+	log.Infof("Importing image from %s", image)
+	if err := o.backend.Import(image, localName); err != nil {
+		return fmt.Errorf("failed to import image '%s': %w", image, err)
+	}
+	log.Debugf("Imported %s as %s", image, localName)
+	return nil
+}
+
+// LoadArchive reads an image from an OCI layout or docker-archive tarball. An
+// OCI layout holding several images is searched for the one whose ref name annotation
+// matches the archive ref.
+func LoadArchive(archive imageconfig.ParentArchive) (v1.Image, error) {
+	if archive.Transport == "docker-archive" {
+		img, err := tarball.ImageFromPath(archive.Path, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load image from %s: %w", archive.Path, err)
+		}
+		return img, nil
+	}
+
+	path, err := layout.FromPath(archive.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open OCI layout %s: %w", archive.Path, err)
+	}
+	index, err := path.ImageIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCI layout index: %w", err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCI layout index: %w", err)
+	}
+	for _, desc := range manifest.Manifests {
+		if archive.Ref == "" || desc.Annotations["org.opencontainers.image.ref.name"] == archive.Ref {
+			img, err := index.Image(desc.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load image from %s: %w", archive.Path, err)
+			}
+			return img, nil
+		}
+	}
+	if archive.Ref != "" {
+		return nil, fmt.Errorf("no image named '%s' in OCI layout %s", archive.Ref, archive.Path)
+	}
+	return nil, fmt.Errorf("OCI layout %s contains no images", archive.Path)
+}
@@ -0,0 +1,107 @@
+package oci
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/progress"
+)
+
+// Backend performs the low-level container operations for OCI. Containers are referred to by
+// the names OCI assigns them.
+type Backend interface {
+	// ImageExists reports whether an image is present in local storage
+	ImageExists(image string) bool
+	// ImageID returns the ID of an image in local storage, the digest of its config
+	ImageID(image string) (string, error)
+	// Pull pulls an image using buildah-style registry options (e.g. --tls-verify=false),
+	// refreshing any local copy
+	Pull(image string, registryOpts []string) error
+	// Import copies an image from an archive transport (oci:... or docker-archive:...) into
+	// local storage under the given name
+	Import(src, name string) error
+	// From creates a working container with the given name from an image, or from an empty
+	// filesystem for "scratch"
+	From(image, name string) error
+	// Mount makes the container filesystem available on the host and returns its path
+	Mount(container string) (string, error)
+	// Unmount releases the container filesystem
+	Unmount(container string) error
+	// Remove deletes a working container
+	Remove(container string) error
+	// Run executes a command inside the container
+	Run(container string, command []string, opts RunOptions, stdout, stderr io.Writer) error
+	// Commit creates an image from the container
+	Commit(container, image string) error
+	// Push pushes a local image to a registry reference
+	Push(image, dest string, registryOpts []string) error
+	// Save writes a local image to a docker-archive tarball
+	Save(image, path string) error
+	// Containers returns the working containers created by go-image-builder, mapped to the
+	// value of their ContainerLabel, which is empty if they have none
+	Containers() (map[string]string, error)
+	// Prune removes dangling images, including those of other users of the storage
+	Prune() error
+	// SetContext sets the context whose cancellation interrupts running operations
+	SetContext(ctx context.Context)
+	// SetProgress sets the reporter that receives the progress of pulls and pushes
+	SetProgress(r progress.Reporter)
+	// Close releases any resources held by the backend
+	Close() error
+}
+
+// RunOptions adjusts how a single command runs in a container. The zero value runs it with
+// the network and proxy of the build.
+type RunOptions struct {
+	// Network is "host" or "none", replacing options.network when set
+	Network string
+	// Proxy replaces options.proxy when set
+	Proxy *imageconfig.Proxy
+}
+
+// resolve returns the network and environment of a command, given those of the build
+func (r RunOptions) resolve(network string, env []string) (string, []string) {
+	if r.Network != "" {
+		network = r.Network
+	}
+	if r.Proxy != nil {
+		env = r.Proxy.Env()
+	}
+	return network, env
+}
+
+// NewBackend returns the backend selected by the options. An empty backend selects buildah.
+func NewBackend(options imageconfig.Options, workDir string) (Backend, error) {
+	switch options.Backend {
+	case "", "buildah":
+		isolation, err := parseIsolation(options.Buildah.Isolation)
+		if err != nil {
+			return nil, err
+		}
+		volumes, err := parseVolumes(options.Buildah.Volumes)
+		if err != nil {
+			return nil, err
+		}
+		return &buildahBackend{
+			options:   options.Buildah,
+			isolation: isolation,
+			volumes:   volumes,
+			resources: options.Resources,
+			env:       options.Proxy.Env(),
+			network:   options.Network,
+			dns:       options.DNS,
+		}, nil
+	case "podman", "docker":
+		return &cliBackend{
+			tool:      options.Backend,
+			workDir:   workDir,
+			resources: options.Resources,
+			env:       options.Proxy.Env(),
+			network:   options.Network,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported OCI backend: %s", options.Backend)
+	}
+}
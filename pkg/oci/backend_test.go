@@ -0,0 +1,31 @@
+package oci
+
+import (
+	"slices"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+)
+
+func TestRunOptionsResolve(t *testing.T) {
+	buildEnv := imageconfig.Proxy{HTTP: "http://build-proxy:3128"}.Env()
+	tests := []struct {
+		name        string
+		opts        RunOptions
+		wantNetwork string
+		wantEnv     []string
+	}{
+		{name: "build defaults", wantNetwork: "host", wantEnv: buildEnv},
+		{name: "own network", opts: RunOptions{Network: "none"}, wantNetwork: "none", wantEnv: buildEnv},
+		{name: "own proxy", opts: RunOptions{Proxy: &imageconfig.Proxy{HTTPS: "http://cmd-proxy:3128"}}, wantNetwork: "host", wantEnv: []string{"https_proxy=http://cmd-proxy:3128", "HTTPS_PROXY=http://cmd-proxy:3128"}},
+		{name: "no proxy", opts: RunOptions{Proxy: &imageconfig.Proxy{}}, wantNetwork: "host"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network, env := tt.opts.resolve("host", buildEnv)
+			if network != tt.wantNetwork || !slices.Equal(env, tt.wantEnv) {
+				t.Errorf("resolve() = %q, %v, want %q, %v", network, env, tt.wantNetwork, tt.wantEnv)
+			}
+		})
+	}
+}
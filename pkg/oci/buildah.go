@@ -0,0 +1,460 @@
+package oci
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go-image-builder/internal/limits"
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/progress"
+	"go-image-builder/pkg/utils"
+
+	"github.com/containers/buildah"
+	"github.com/containers/buildah/define"
+	"github.com/containers/buildah/pkg/parse"
+	is "github.com/containers/image/v5/storage"
+	"github.com/containers/image/v5/transports/alltransports"
+	"github.com/containers/image/v5/types"
+	"github.com/containers/storage"
+	"github.com/containers/storage/pkg/unshare"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// MaybeReexec re-executes the current process inside a user namespace when running as an
+// unprivileged user. Container storage requires one when running rootless: the layers it
+// unpacks are owned by many UIDs, which only a user namespace with subordinate IDs can map.
+// It must be called before any container operation and before the process starts doing
+// work it cannot repeat, since the re-executed process starts over from main.
+func MaybeReexec() {
+	unshare.MaybeReexecUsingUserNamespace(false)
+}
+
+// buildahBackend implements Backend with the buildah and containers/storage libraries
+type buildahBackend struct {
+	options   imageconfig.BuildahOptions
+	isolation define.Isolation
+	volumes   []specs.Mount
+	resources imageconfig.Resources
+	env       []string
+	network   string
+	dns       imageconfig.DNS
+	store     storage.Store
+	ctx       context.Context
+	progress  progress.Reporter
+}
+
+// SetContext sets the context whose cancellation interrupts pulls, commits and pushes.
+// Commands run in a container cannot be interrupted, buildah runs them to completion.
+func (b *buildahBackend) SetContext(ctx context.Context) {
+	b.ctx = ctx
+}
+
+// SetProgress sets the reporter the progress of image copies is reported to. Without one,
+// it is written to stderr.
+func (b *buildahBackend) SetProgress(r progress.Reporter) {
+	b.progress = r
+}
+
+// report returns the writer buildah reports the progress of an image copy to, and a function
+// to call once the copy is done
+func (b *buildahBackend) report() (io.Writer, func()) {
+	if b.progress == nil {
+		return os.Stderr, func() {}
+	}
+	w := progress.Writer(b.progress)
+	return w, func() { w.Close() }
+}
+
+// context returns the context buildah operations run under
+func (b *buildahBackend) context() context.Context {
+	if b.ctx == nil {
+		return context.Background()
+	}
+	return b.ctx
+}
+
+// parseIsolation converts an isolation name into the buildah isolation type
+func parseIsolation(isolation string) (define.Isolation, error) {
+	switch isolation {
+	case "":
+		return define.IsolationDefault, nil
+	case "oci":
+		return define.IsolationOCI, nil
+	case "chroot":
+		return define.IsolationChroot, nil
+	case "rootless":
+		return define.IsolationOCIRootless, nil
+	default:
+		return define.IsolationDefault, fmt.Errorf("unsupported isolation: %s", isolation)
+	}
+}
+
+// parseVolumes converts volumes in the syntax of buildah run --volume into mounts
+func parseVolumes(volumes []string) ([]specs.Mount, error) {
+	var mounts []specs.Mount
+	for _, volume := range volumes {
+		mount, err := parse.Volume(volume)
+		if err != nil {
+			return nil, fmt.Errorf("invalid volume %s: %w", volume, err)
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts, nil
+}
+
+// getStore opens the container storage on first use
+func (b *buildahBackend) getStore() (storage.Store, error) {
+	if b.store != nil {
+		return b.store, nil
+	}
+
+	options, err := storage.DefaultStoreOptions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default storage options: %w", err)
+	}
+	if b.options.Root != "" {
+		options.GraphRoot = b.options.Root
+	}
+	if b.options.RunRoot != "" {
+		options.RunRoot = b.options.RunRoot
+	}
+	if b.options.StorageDriver != "" {
+		options.GraphDriverName = b.options.StorageDriver
+	}
+	if len(b.options.StorageOpts) > 0 {
+		options.GraphDriverOptions = b.options.StorageOpts
+	}
+	log.Debugf("Using container storage %s (run root %s, driver %s)", options.GraphRoot, options.RunRoot, options.GraphDriverName)
+
+	store, err := storage.GetStore(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open container storage: %w", err)
+	}
+	b.store = store
+	return store, nil
+}
+
+// Close releases the container storage
+func (b *buildahBackend) Close() error {
+	if b.store == nil {
+		return nil
+	}
+	if _, err := b.store.Shutdown(false); err != nil {
+		return fmt.Errorf("failed to shut down container storage: %w", err)
+	}
+	b.store = nil
+	return nil
+}
+
+// systemContext translates buildah registry options (e.g. --tls-verify=false) into a system
+// context for the image library
+func systemContext(opts []string) *types.SystemContext {
+	sc := &types.SystemContext{}
+	for _, opt := range opts {
+		key, value, _ := strings.Cut(strings.TrimLeft(opt, "-"), "=")
+		switch key {
+		case "tls-verify":
+			if value == "false" {
+				sc.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
+				sc.OCIInsecureSkipTLSVerify = true
+			}
+		case "authfile":
+			sc.AuthFilePath = value
+		case "cert-dir":
+			sc.DockerCertPath = value
+		case "creds":
+			username, password, _ := strings.Cut(value, ":")
+			sc.DockerAuthConfig = &types.DockerAuthConfig{Username: username, Password: password}
+		default:
+			log.Warnf("Ignoring unsupported registry option: %s", opt)
+		}
+	}
+	return sc
+}
+
+// openBuilder opens the working container with the given name or ID
+func (b *buildahBackend) openBuilder(container string) (*buildah.Builder, error) {
+	store, err := b.getStore()
+	if err != nil {
+		return nil, err
+	}
+	builder, err := buildah.OpenBuilder(store, container)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open container %s: %w", container, err)
+	}
+	return builder, nil
+}
+
+// ImageExists reports whether the image is in local storage
+func (b *buildahBackend) ImageExists(image string) bool {
+	store, err := b.getStore()
+	if err != nil {
+		return false
+	}
+	_, err = buildah.Pull(b.context(), image, buildah.PullOptions{
+		Store:      store,
+		PullPolicy: define.PullNever,
+	})
+	return err == nil
+}
+
+// ImageID returns the ID of an image in local storage
+func (b *buildahBackend) ImageID(image string) (string, error) {
+	store, err := b.getStore()
+	if err != nil {
+		return "", err
+	}
+	return buildah.Pull(b.context(), image, buildah.PullOptions{
+		Store:      store,
+		PullPolicy: define.PullNever,
+	})
+}
+
+// Pull pulls an image into local storage
+func (b *buildahBackend) Pull(image string, registryOpts []string) error {
+	store, err := b.getStore()
+	if err != nil {
+		return err
+	}
+	report, done := b.report()
+	defer done()
+	imageID, err := buildah.Pull(b.context(), image, buildah.PullOptions{
+		Store:         store,
+		SystemContext: systemContext(registryOpts),
+		PullPolicy:    define.PullAlways,
+		ReportWriter:  report,
+	})
+	if err != nil {
+		return err
+	}
+	log.Debugf("Pulled %s as %s", image, imageID)
+	return nil
+}
+
+// Import copies an image from an archive into storage and names it
+func (b *buildahBackend) Import(src, name string) error {
+	store, err := b.getStore()
+	if err != nil {
+		return err
+	}
+	report, done := b.report()
+	defer done()
+	imageID, err := buildah.Pull(b.context(), src, buildah.PullOptions{
+		Store:        store,
+		PullPolicy:   define.PullAlways,
+		ReportWriter: report,
+	})
+	if err != nil {
+		return err
+	}
+	if err := store.AddNames(imageID, []string{name}); err != nil {
+		return fmt.Errorf("failed to name image %s: %w", imageID, err)
+	}
+	log.Debugf("Imported %s as %s", src, imageID)
+	return nil
+}
+
+// From creates a working container from an image
+func (b *buildahBackend) From(image, name string) error {
+	store, err := b.getStore()
+	if err != nil {
+		return err
+	}
+	// Memory and CPU limits only take effect with OCI isolation, which runs commands in
+	// their own cgroup
+	buildOpts := &define.CommonBuildOptions{}
+	if b.resources.Memory != "" {
+		if buildOpts.Memory, err = utils.ParseSize(b.resources.Memory); err != nil {
+			return fmt.Errorf("invalid memory limit: %w", err)
+		}
+	}
+	if b.resources.CPUs > 0 {
+		buildOpts.CPUPeriod = 100000
+		buildOpts.CPUQuota = int64(b.resources.CPUs * 100000)
+	}
+
+	// Buildah mounts its own resolv.conf and hosts over the rootfs' while running commands
+	buildOpts.DNSServers = b.dns.Nameservers
+	buildOpts.DNSSearch = b.dns.Search
+	for host, ip := range b.dns.Hosts {
+		buildOpts.AddHost = append(buildOpts.AddHost, host+":"+ip)
+	}
+
+	builder, err := buildah.NewBuilder(b.context(), store, buildah.BuilderOptions{
+		FromImage:       image,
+		Container:       name,
+		PullPolicy:      define.PullNever,
+		SystemContext:   &types.SystemContext{},
+		Isolation:       b.isolation,
+		CommonBuildOpts: buildOpts,
+	})
+	if err != nil {
+		return err
+	}
+	// The label is removed again before committing, so it never reaches an image
+	builder.SetLabel(ContainerLabel, containerOwner())
+	if err := builder.Save(); err != nil {
+		builder.Delete()
+		return fmt.Errorf("failed to label container %s: %w", name, err)
+	}
+	return nil
+}
+
+// Mount mounts the container filesystem
+func (b *buildahBackend) Mount(container string) (string, error) {
+	builder, err := b.openBuilder(container)
+	if err != nil {
+		return "", err
+	}
+	return builder.Mount(builder.MountLabel)
+}
+
+// Unmount unmounts the container filesystem
+func (b *buildahBackend) Unmount(container string) error {
+	builder, err := b.openBuilder(container)
+	if err != nil {
+		return err
+	}
+	return builder.Unmount()
+}
+
+// Remove deletes the working container
+func (b *buildahBackend) Remove(container string) error {
+	store, err := b.getStore()
+	if err != nil {
+		return err
+	}
+	if builder, err := buildah.OpenBuilder(store, container); err == nil {
+		return builder.Delete()
+	}
+	// Not a buildah container, remove it from storage directly
+	return store.DeleteContainer(container)
+}
+
+// Run executes a command inside the container
+func (b *buildahBackend) Run(container string, command []string, opts RunOptions, stdout, stderr io.Writer) error {
+	builder, err := b.openBuilder(container)
+	if err != nil {
+		return err
+	}
+	network, env := opts.resolve(b.network, b.env)
+	runOpts := buildah.RunOptions{
+		Isolation:       b.isolation,
+		AddCapabilities: b.options.CapAdd,
+		Mounts:          b.volumes,
+		RunMounts:       b.options.Mounts,
+		Env:             env,
+		Stdout:          stdout,
+		Stderr:          stderr,
+		Quiet:           true,
+	}
+	switch network {
+	case "host":
+		runOpts.NamespaceOptions = define.NamespaceOptions{{Name: string(specs.NetworkNamespace), Host: true}}
+	case "none":
+		runOpts.ConfigureNetwork = define.NetworkDisabled
+	}
+	return builder.Run(limits.Nice(b.resources, command), runOpts)
+}
+
+// Commit creates an image in local storage from the container
+func (b *buildahBackend) Commit(container, image string) error {
+	store, err := b.getStore()
+	if err != nil {
+		return err
+	}
+	builder, err := b.openBuilder(container)
+	if err != nil {
+		return err
+	}
+	dest, err := is.Transport.ParseStoreReference(store, image)
+	if err != nil {
+		return fmt.Errorf("failed to parse image name %s: %w", image, err)
+	}
+	builder.UnsetLabel(ContainerLabel)
+	_, _, _, err = builder.Commit(b.context(), dest, buildah.CommitOptions{
+		SystemContext: &types.SystemContext{},
+	})
+	return err
+}
+
+// Push pushes a local image to a registry
+func (b *buildahBackend) Push(image, dest string, registryOpts []string) error {
+	return b.push(image, "docker://"+dest, systemContext(registryOpts))
+}
+
+// Save writes a local image to a docker-archive tarball
+func (b *buildahBackend) Save(image, path string) error {
+	return b.push(image, "docker-archive:"+path, &types.SystemContext{})
+}
+
+// push copies a local image to a transport destination
+func (b *buildahBackend) push(image, dest string, sc *types.SystemContext) error {
+	store, err := b.getStore()
+	if err != nil {
+		return err
+	}
+	ref, err := alltransports.ParseImageName(dest)
+	if err != nil {
+		return fmt.Errorf("failed to parse destination %s: %w", dest, err)
+	}
+	report, done := b.report()
+	defer done()
+	_, _, err = buildah.Push(b.context(), image, ref, buildah.PushOptions{
+		Store:         store,
+		SystemContext: sc,
+		ReportWriter:  report,
+	})
+	return err
+}
+
+// Containers returns the containers in storage created by go-image-builder, recognised by
+// their name prefix. Containers created by older versions, or not created through buildah,
+// have no owner label.
+func (b *buildahBackend) Containers() (map[string]string, error) {
+	store, err := b.getStore()
+	if err != nil {
+		return nil, err
+	}
+	all, err := store.Containers()
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make(map[string]string)
+	for _, container := range all {
+		for _, name := range container.Names {
+			if !strings.HasPrefix(name, ContainerPrefix) {
+				continue
+			}
+			containers[name] = ""
+			if builder, err := buildah.OpenBuilder(store, name); err == nil {
+				containers[name] = builder.Labels()[ContainerLabel]
+			}
+			break
+		}
+	}
+	return containers, nil
+}
+
+// Prune removes images without a name
+func (b *buildahBackend) Prune() error {
+	store, err := b.getStore()
+	if err != nil {
+		return err
+	}
+	images, err := store.Images()
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		if len(image.Names) == 0 {
+			store.DeleteImage(image.ID, true) // Ignore errors during cleanup
+		}
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"go-image-builder/internal/chroot"
+	"go-image-builder/internal/limits"
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/progress"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cliBackend implements Backend with the podman or docker command line. Neither can mount a
+// stopped container portably, so the container filesystem is exported into a directory
+// under the work directory and commands are run in it with chroot, which requires root.
+type cliBackend struct {
+	tool      string
+	workDir   string
+	resources imageconfig.Resources
+	env       []string
+	network   string
+	ctx       context.Context
+	progress  progress.Reporter
+}
+
+// SetProgress sets the reporter the output of pulls and pushes is reported to as they run
+func (c *cliBackend) SetProgress(r progress.Reporter) {
+	c.progress = r
+}
+
+// SetContext sets the context whose cancellation kills running commands
+func (c *cliBackend) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+// context returns the context commands run under
+func (c *cliBackend) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// execute runs the tool with the given arguments and returns its combined output
+func (c *cliBackend) execute(args ...string) ([]byte, error) {
+	cmdStr := c.tool + " " + strings.Join(args, " ")
+	log.Debugf("Executing: %s", cmdStr)
+	output, err := exec.CommandContext(c.context(), c.tool, args...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s command failed: %s\nOutput: %s\nError: %w", c.tool, cmdStr, string(output), err)
+	}
+	return output, nil
+}
+
+// executeReporting runs the tool like execute, reporting its output as progress while it
+// runs if there is a reporter
+func (c *cliBackend) executeReporting(args ...string) error {
+	if c.progress == nil {
+		_, err := c.execute(args...)
+		return err
+	}
+	cmdStr := c.tool + " " + strings.Join(args, " ")
+	log.Debugf("Executing: %s", cmdStr)
+	report := progress.Writer(c.progress)
+	defer report.Close()
+	var output bytes.Buffer
+	cmd := exec.CommandContext(c.context(), c.tool, args...)
+	cmd.Stdout = io.MultiWriter(&output, report)
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s command failed: %s\nOutput: %s\nError: %w", c.tool, cmdStr, output.String(), err)
+	}
+	return nil
+}
+
+// rootfs returns the directory holding the exported filesystem of a container
+func (c *cliBackend) rootfs(container string) string {
+	dir := c.workDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "containers", container)
+}
+
+// ImageExists reports whether the image is in local storage
+func (c *cliBackend) ImageExists(image string) bool {
+	_, err := c.execute("image", "inspect", image)
+	return err == nil
+}
+
+// ImageID returns the ID of an image in local storage
+func (c *cliBackend) ImageID(image string) (string, error) {
+	output, err := c.execute("image", "inspect", "--format", "{{.Id}}", image)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Pull pulls an image. Registry options are only understood by podman.
+func (c *cliBackend) Pull(image string, registryOpts []string) error {
+	args := []string{"pull"}
+	if c.tool == "podman" {
+		args = append(args, registryOpts...)
+	} else if len(registryOpts) > 0 {
+		log.Warnf("Ignoring registry options with the %s backend: %v", c.tool, registryOpts)
+	}
+	return c.executeReporting(append(args, image)...)
+}
+
+// Import loads an image from an archive and tags it. Docker can only load docker-archive
+// tarballs.
+func (c *cliBackend) Import(src, name string) error {
+	var output []byte
+	var err error
+	if c.tool == "podman" {
+		output, err = c.execute("pull", "--quiet", src)
+	} else {
+		path, ok := strings.CutPrefix(src, "docker-archive:")
+		if !ok {
+			return fmt.Errorf("the docker backend cannot load %s, use a docker-archive tarball", src)
+		}
+		output, err = c.execute("load", "--quiet", "--input", path)
+	}
+	if err != nil {
+		return err
+	}
+	image := loadedImage(string(output))
+	if image == "" {
+		return fmt.Errorf("failed to determine the image loaded from %s", src)
+	}
+	_, err = c.execute("tag", image, name)
+	return err
+}
+
+// loadedImage returns the image reported by podman pull --quiet (an image ID) or docker
+// load --quiet ("Loaded image: name" or "Loaded image ID: id")
+func loadedImage(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	last := strings.TrimSpace(lines[len(lines)-1])
+	if i := strings.LastIndex(last, ": "); i >= 0 {
+		last = last[i+2:]
+	}
+	return last
+}
+
+// From creates a container and exports its filesystem. Scratch containers are only a
+// directory since neither tool can create a container without an image.
+func (c *cliBackend) From(image, name string) error {
+	dir := c.rootfs(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create container directory: %w", err)
+	}
+	if image == "scratch" {
+		return nil
+	}
+
+	// The command is never run, but is required for images without one
+	if _, err := c.execute("create", "--name", name, "--label", ContainerLabel+"="+containerOwner(), image, "/bin/sh"); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	export := exec.CommandContext(c.context(), c.tool, "export", name)
+	extract := exec.CommandContext(c.context(), "tar", "-x", "-C", dir)
+	pipe, err := export.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create export pipe: %w", err)
+	}
+	extract.Stdin = pipe
+	var stderr bytes.Buffer
+	export.Stderr = &stderr
+	extract.Stderr = &stderr
+
+	log.Debugf("Exporting container %s to %s", name, dir)
+	if err := extract.Start(); err != nil {
+		return fmt.Errorf("failed to start tar: %w", err)
+	}
+	if err := export.Run(); err != nil {
+		extract.Wait()
+		return fmt.Errorf("failed to export container %s: %w\nOutput: %s", name, err, stderr.String())
+	}
+	if err := extract.Wait(); err != nil {
+		return fmt.Errorf("failed to extract container %s: %w\nOutput: %s", name, err, stderr.String())
+	}
+	return nil
+}
+
+// Mount returns the directory holding the container filesystem
+func (c *cliBackend) Mount(container string) (string, error) {
+	dir := c.rootfs(container)
+	if _, err := os.Stat(dir); err != nil {
+		return "", fmt.Errorf("container %s has no filesystem: %w", container, err)
+	}
+	return dir, nil
+}
+
+// Unmount is a no-op, the exported filesystem stays until the container is removed
+func (c *cliBackend) Unmount(container string) error {
+	return nil
+}
+
+// Remove deletes the container and its exported filesystem
+func (c *cliBackend) Remove(container string) error {
+	if err := os.RemoveAll(c.rootfs(container)); err != nil {
+		return fmt.Errorf("failed to remove container directory: %w", err)
+	}
+	// Scratch containers have no tool container
+	if _, err := c.execute("container", "inspect", container); err != nil {
+		return nil
+	}
+	_, err := c.execute("rm", "--force", container)
+	return err
+}
+
+// Run executes a command chrooted into the exported filesystem, with /proc, /sys and /dev
+// mounted for the duration of the command. Commands share the network of the host, unless
+// they run without one in a network namespace of their own.
+func (c *cliBackend) Run(container string, command []string, opts RunOptions, stdout, stderr io.Writer) error {
+	root := c.rootfs(container)
+	chrooted, err := chroot.Command(root, command...)
+	if err != nil {
+		return err
+	}
+	network, env := opts.resolve(c.network, c.env)
+	if network == "none" {
+		chrooted = append([]string{"unshare", "--net", "--"}, chrooted...)
+	}
+	args, err := limits.Wrap(c.resources, chrooted)
+	if err != nil {
+		return err
+	}
+	return chroot.Run(root, func() error {
+		log.Debugf("Executing: %s", strings.Join(args, " "))
+		cmd := exec.CommandContext(c.context(), args[0], args[1:]...)
+		if len(env) > 0 {
+			cmd.Env = append(os.Environ(), env...)
+		}
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		return cmd.Run()
+	})
+}
+
+// Commit imports the exported filesystem as an image
+func (c *cliBackend) Commit(container, image string) error {
+	archive := exec.CommandContext(c.context(), "tar", "-c", "-C", c.rootfs(container), ".")
+	load := exec.CommandContext(c.context(), c.tool, "import", "-", image)
+	pipe, err := archive.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create archive pipe: %w", err)
+	}
+	load.Stdin = pipe
+	var output bytes.Buffer
+	archive.Stderr = &output
+	load.Stdout = &output
+	load.Stderr = &output
+
+	if err := load.Start(); err != nil {
+		return fmt.Errorf("failed to start %s import: %w", c.tool, err)
+	}
+	if err := archive.Run(); err != nil {
+		load.Wait()
+		return fmt.Errorf("failed to archive container %s: %w\nOutput: %s", container, err, output.String())
+	}
+	if err := load.Wait(); err != nil {
+		return fmt.Errorf("failed to import container %s: %w\nOutput: %s", container, err, output.String())
+	}
+	return nil
+}
+
+// Push tags a local image with the destination reference and pushes it
+func (c *cliBackend) Push(image, dest string, registryOpts []string) error {
+	if _, err := c.execute("tag", image, dest); err != nil {
+		return err
+	}
+	args := []string{"push"}
+	if c.tool == "podman" {
+		args = append(args, registryOpts...)
+	} else if len(registryOpts) > 0 {
+		log.Warnf("Ignoring registry options with the %s backend: %v", c.tool, registryOpts)
+	}
+	return c.executeReporting(append(args, dest)...)
+}
+
+// Save writes a local image to a docker-archive tarball
+func (c *cliBackend) Save(image, path string) error {
+	args := []string{"save", "-o", path}
+	if c.tool == "podman" {
+		args = append(args, "--format", "docker-archive")
+	}
+	return c.executeReporting(append(args, image)...)
+}
+
+// Containers returns the containers labelled as created by go-image-builder
+func (c *cliBackend) Containers() (map[string]string, error) {
+	// Docker formats labels as a single string, podman as a map
+	label := fmt.Sprintf("{{.Label %q}}", ContainerLabel)
+	if c.tool == "podman" {
+		label = fmt.Sprintf("{{index .Labels %q}}", ContainerLabel)
+	}
+	output, err := c.execute("ps", "--all", "--filter", "label="+ContainerLabel, "--format", "{{.Names}}\t"+label)
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		name, owner, _ := strings.Cut(line, "\t")
+		if name = strings.TrimSpace(name); name != "" {
+			containers[name] = strings.TrimSpace(owner)
+		}
+	}
+	return containers, nil
+}
+
+// Prune removes dangling images
+func (c *cliBackend) Prune() error {
+	_, err := c.execute("image", "prune", "--force")
+	return err
+}
+
+// Close is a no-op for command line backends
+func (c *cliBackend) Close() error {
+	return nil
+}
@@ -2,68 +2,157 @@ package oci
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/progress"
 	"go-image-builder/pkg/utils"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// OCIInterface defines the interface for container image operations
+// OCIInterface defines the interface for container image operations. OCI implements it;
+// ocitest.Fake provides an in-memory implementation for tests.
 type OCIInterface interface {
 	PullParentImage() error
 	MountParent() error
 	UnmountParent() error
-	CreateContainer(name string) error
-	MountContainer(name string) error
-	UnmountContainer(name string) error
-	CommitContainer(name string) error
+	GetParentMountPoint() string
+	GetParentContainer() string
+	ParentImageID() (string, error)
+	MountImage(image string) (containerName, mountPoint string, err error)
+	CreateContainer() (string, error)
+	MountContainer(containerName string) (string, error)
+	UnmountContainer(containerName string) error
+	CommitContainer(containerName, name string) error
 	PushImage() error
-	Cleanup() error
+	SaveImage(imageName, destinationPath string) error
+	RunCommand(containerName, command string) error
+	RunCommandWithOptions(containerName, command string, opts RunOptions) error
+	RunCommandWithOutput(containerName, command string) ([]byte, error)
+	Stat(containerName, path string) error
+	CopyFromContainerWithCat(containerName, fromPath, toPath string) error
+	Cleanup(containerName string) error
+	ListBuilderContainers() ([]string, error)
+	SetLog(w io.Writer)
+	SetContext(ctx context.Context)
+	SetProgress(r progress.Reporter)
+	Close() error
+}
+
+var _ OCIInterface = (*OCI)(nil)
+
+// ContainerPrefix is the name prefix of every container created by go-image-builder
+const ContainerPrefix = "go-image-builder-"
+
+// ContainerLabel marks the containers created by go-image-builder. Its value records the
+// build that created the container, so containers of running builds are never removed.
+const ContainerLabel = "com.openchami.image-builder"
+
+// newContainerName returns a unique name for a container created by this tool
+func newContainerName() string {
+	return fmt.Sprintf("%s%d", ContainerPrefix, time.Now().UnixNano())
+}
+
+// ContainerCreated returns the creation time encoded in a container name generated by
+// this tool. The boolean is false if the name was not generated by go-image-builder.
+func ContainerCreated(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, ContainerPrefix) {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(strings.TrimPrefix(name, ContainerPrefix), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// IsDigestReference reports whether an image reference is pinned by digest, as in
+// registry/image@sha256:...
+func IsDigestReference(image string) bool {
+	return strings.Contains(image, "@sha256:")
+}
+
+// ArchiveImageName returns the local storage name an archive parent is imported under. The
+// name is derived from the parent so builds from the same archive share the import.
+func ArchiveImageName(parent string) string {
+	sum := sha256.Sum256([]byte(parent))
+	return fmt.Sprintf("localhost/%sparent-%s:latest", ContainerPrefix, hex.EncodeToString(sum[:])[:12])
 }
 
 // OCI implements container image operations
 type OCI struct {
 	config           *imageconfig.Config
 	workDir          string
+	backend          Backend
 	parentContainer  string
 	parentMountPoint string
+	log              io.Writer
 }
 
-// NewOCI creates a new OCI instance
-func NewOCI(config *imageconfig.Config, workDir string) *OCI {
+// NewOCI creates a new OCI instance using the backend selected in the configuration
+func NewOCI(config *imageconfig.Config, workDir string) (*OCI, error) {
+	backend, err := NewBackend(config.Options, workDir)
+	if err != nil {
+		return nil, err
+	}
 	return &OCI{
 		config:  config,
 		workDir: workDir,
-	}
+		backend: backend,
+	}, nil
 }
 
-// executeBuildah runs a buildah command with the given arguments, handling root/rootless execution.
-func (o *OCI) executeBuildah(args ...string) ([]byte, error) {
-	var cmd *exec.Cmd
-	var cmdStr string
+// SetLog sets a writer that receives the full output of every command run in a container
+func (o *OCI) SetLog(w io.Writer) {
+	o.log = w
+}
 
-	if os.Geteuid() == 0 {
-		cmd = exec.Command("buildah", args...)
-		cmdStr = "buildah " + strings.Join(args, " ")
-	} else {
-		// Prepend "unshare" for rootless execution
-		cmdArgs := append([]string{"buildah"}, args...)
-		cmd = exec.Command("unshare", cmdArgs...)
-		cmdStr = "unshare " + strings.Join(cmdArgs, " ")
+// run executes a command in the container and copies its output to the log
+func (o *OCI) run(containerName string, command []string, opts RunOptions, stdout, stderr io.Writer) error {
+	if o.log == nil {
+		return o.backend.Run(containerName, command, opts, stdout, stderr)
 	}
+	fmt.Fprintf(o.log, "$ [%s] %s\n", containerName, strings.Join(command, " "))
+	return o.backend.Run(containerName, command, opts, io.MultiWriter(stdout, o.log), io.MultiWriter(stderr, o.log))
+}
+
+// SetContext sets the context whose cancellation interrupts running backend operations
+func (o *OCI) SetContext(ctx context.Context) {
+	o.backend.SetContext(ctx)
+}
+
+// SetProgress sets the reporter that receives the progress of image pulls and pushes
+func (o *OCI) SetProgress(r progress.Reporter) {
+	o.backend.SetProgress(r)
+}
+
+// Close releases the resources held by the backend
+func (o *OCI) Close() error {
+	return o.backend.Close()
+}
 
-	log.Debugf("Executing: %s", cmdStr)
-	output, err := cmd.CombinedOutput()
+// removeStaleContainers removes the working containers left behind by builds that are no
+// longer running. Containers of running builds, other tools and users are never touched.
+func (o *OCI) removeStaleContainers() {
+	containers, err := o.StaleContainers(staleContainerAge)
 	if err != nil {
-		return nil, fmt.Errorf("buildah command failed: %s\nOutput: %s\nError: %w", cmdStr, string(output), err)
+		log.Debugf("Failed to list containers: %v", err)
+		return
+	}
+	for _, container := range containers {
+		log.Debugf("Cleaning up stale container: %s", container)
+		o.backend.Remove(container) // Ignore errors during cleanup
 	}
-	return output, nil
 }
 
 // PullParentImage pulls the parent image if specified
@@ -73,50 +162,59 @@ func (o *OCI) PullParentImage() error {
 		return nil
 	}
 
+	// An untrusted parent must not be imported or used, even if it is already local
+	if err := o.verifyParent(); err != nil {
+		return err
+	}
+
 	parentImage := o.config.Options.Parent
+	if archive, ok := o.config.Options.ParentArchive(); ok {
+		return o.importArchive(parentImage, archive)
+	}
+	policy := o.config.Options.ParentPullPolicy
 	log.Infof("Checking for local parent image: %s", parentImage)
 
-	// 1. Check if image exists locally using 'buildah inspect'.
-	inspectArgs := []string{"inspect", "--type=image", parentImage}
-	if _, err := o.executeBuildah(inspectArgs...); err == nil {
+	// 1. Check if image exists locally. An image pinned by digest can never be stale.
+	exists := o.backend.ImageExists(parentImage)
+	switch {
+	case exists && (policy != "always" || IsDigestReference(parentImage)):
 		log.Infof("Parent image '%s' found locally, using it.", parentImage)
-		log.Debug("Note: To force a refresh, remove the local image manually before running.")
+		if policy != "always" && !IsDigestReference(parentImage) {
+			log.Debug("Note: Set parent_pull_policy to 'always' or pin the parent by digest to pick up updates.")
+		}
 		return nil
+	case policy == "never":
+		return fmt.Errorf("parent image '%s' not found locally and parent_pull_policy is 'never'", parentImage)
+	case exists:
+		log.Infof("Refreshing parent image '%s' from registry...", parentImage)
+	default:
+		log.Infof("Parent image '%s' not found locally. Pulling from registry...", parentImage)
 	}
 
-	log.Infof("Parent image '%s' not found locally. Pulling from registry...", parentImage)
+	// Clean up containers left behind by earlier builds
+	o.removeStaleContainers()
 
-	// Clean up any existing containers first. This is good practice.
-	cleanupArgs := []string{"containers", "--format", "{{.ContainerID}}"}
-	if output, err := o.executeBuildah(cleanupArgs...); err == nil {
-		containers := strings.Split(strings.TrimSpace(string(output)), "\n")
-		for _, container := range containers {
-			if container == "" {
-				continue
-			}
-			log.Debugf("Cleaning up stale container: %s", container)
-			o.executeBuildah("rm", container) // Ignore errors during cleanup
+	// Dangling images may belong to other users of the storage, so only prune on request
+	if o.config.Options.PruneImages {
+		log.Info("Pruning dangling images")
+		if err := o.backend.Prune(); err != nil {
+			log.Warnf("Failed to prune images: %v", err)
 		}
 	}
 
-	// Clean up any dangling images to save space.
-	o.executeBuildah("prune", "-f") // Ignore errors during cleanup
-
 	// 2. If not local, pull it.
-	pullArgs := []string{"pull"}
+	var registryOpts []string
 	if o.config.Options.PublishRegistry != "" {
-		pullArgs = append(pullArgs, o.config.Options.RegistryOptsPull...)
+		registryOpts = o.config.Options.RegistryOptsPull
 	}
-	pullArgs = append(pullArgs, parentImage)
-
-	if _, err := o.executeBuildah(pullArgs...); err != nil {
-		return err // executeBuildah will provide detailed error
+	if err := o.backend.Pull(parentImage, registryOpts); err != nil {
+		return fmt.Errorf("failed to pull parent image '%s': %w", parentImage, err)
 	}
 
 	// 3. Verify the image exists locally after pull.
 	log.Debugf("Verifying parent image '%s' exists locally after pull", parentImage)
-	if _, err := o.executeBuildah(inspectArgs...); err != nil {
-		return fmt.Errorf("failed to inspect parent image '%s' after pulling: %w", parentImage, err)
+	if !o.backend.ImageExists(parentImage) {
+		return fmt.Errorf("parent image '%s' not found after pulling", parentImage)
 	}
 
 	log.Infof("Successfully pulled parent image: %s", parentImage)
@@ -127,38 +225,25 @@ func (o *OCI) PullParentImage() error {
 func (o *OCI) MountParent() error {
 	log.Infof("Mounting parent image: %s", o.config.Options.Parent)
 
-	// Clean up any existing containers first
-	cleanupArgs := []string{"containers", "--format", "{{.ContainerID}}"}
-	output, err := o.executeBuildah(cleanupArgs...)
-	if err == nil {
-		containers := strings.Split(strings.TrimSpace(string(output)), "\n")
-		for _, container := range containers {
-			if container == "" {
-				continue
-			}
-			log.Debugf("Cleaning up stale container: %s", container)
-			o.executeBuildah("rm", container) // Ignore errors during cleanup
-		}
+	parentImage := o.config.Options.Parent
+	if _, ok := o.config.Options.ParentArchive(); ok {
+		parentImage = ArchiveImageName(parentImage)
 	}
 
 	// Create a new container from the parent image
-	fromArgs := []string{"from", "--pull=never", o.config.Options.Parent}
-	output, err = o.executeBuildah(fromArgs...)
-	if err != nil {
+	containerName := newContainerName()
+	if err := o.backend.From(parentImage, containerName); err != nil {
 		return fmt.Errorf("failed to create container from parent image: %w", err)
 	}
-	containerName := strings.TrimSpace(string(output))
 	log.Debugf("Created container from parent image: %s", containerName)
 
 	// Mount the container
-	mountArgs := []string{"mount", containerName}
-	output, err = o.executeBuildah(mountArgs...)
+	mountPoint, err := o.backend.Mount(containerName)
 	if err != nil {
 		// Clean up the container if mount fails
-		o.executeBuildah("rm", containerName) // Ignore errors during cleanup
+		o.backend.Remove(containerName) // Ignore errors during cleanup
 		return fmt.Errorf("failed to mount parent image: %w", err)
 	}
-	mountPoint := strings.TrimSpace(string(output))
 	log.Debugf("Parent image mounted at: %s", mountPoint)
 
 	// Store the container name for cleanup
@@ -168,6 +253,57 @@ func (o *OCI) MountParent() error {
 	return nil
 }
 
+// MountImage creates and mounts a container from an additional image, such as one merged
+// onto the rootfs. Archives are imported, and registry images are pulled as the parent pull
+// policy allows. The container is removed with Cleanup.
+func (o *OCI) MountImage(image string) (string, string, error) {
+	localImage := image
+	if archive, ok := imageconfig.ParseArchive(image); ok {
+		localImage = ArchiveImageName(image)
+		if err := o.importArchive(image, archive); err != nil {
+			return "", "", err
+		}
+	} else if err := o.PullImage(image); err != nil {
+		return "", "", err
+	}
+
+	containerName := newContainerName()
+	if err := o.backend.From(localImage, containerName); err != nil {
+		return "", "", fmt.Errorf("failed to create container from image '%s': %w", image, err)
+	}
+	mountPoint, err := o.backend.Mount(containerName)
+	if err != nil {
+		o.backend.Remove(containerName) // Ignore errors during cleanup
+		return "", "", fmt.Errorf("failed to mount image '%s': %w", image, err)
+	}
+	log.Debugf("Image %s mounted at: %s", image, mountPoint)
+	return containerName, mountPoint, nil
+}
+
+// PullImage pulls an image that is missing from local storage, or that is stale under the
+// 'always' pull policy
+func (o *OCI) PullImage(image string) error {
+	policy := o.config.Options.ParentPullPolicy
+	exists := o.backend.ImageExists(image)
+	switch {
+	case exists && (policy != "always" || IsDigestReference(image)):
+		log.Debugf("Image '%s' found locally, using it.", image)
+		return nil
+	case !exists && policy == "never":
+		return fmt.Errorf("image '%s' not found locally and parent_pull_policy is 'never'", image)
+	}
+
+	var registryOpts []string
+	if o.config.Options.PublishRegistry != "" {
+		registryOpts = o.config.Options.RegistryOptsPull
+	}
+	log.Infof("Pulling image: %s", image)
+	if err := o.backend.Pull(image, registryOpts); err != nil {
+		return fmt.Errorf("failed to pull image '%s': %w", image, err)
+	}
+	return nil
+}
+
 // UnmountParent unmounts the parent image if it was mounted
 func (o *OCI) UnmountParent() error {
 	// If no parent specified or parent is "scratch", skip unmounting
@@ -177,8 +313,7 @@ func (o *OCI) UnmountParent() error {
 
 	log.Infof("Unmounting parent image: %s", o.config.Options.Parent)
 
-	args := []string{"umount", o.parentContainer}
-	if _, err := o.executeBuildah(args...); err != nil {
+	if err := o.backend.Unmount(o.parentContainer); err != nil {
 		return fmt.Errorf("failed to unmount parent image: %w", err)
 	}
 
@@ -187,44 +322,35 @@ func (o *OCI) UnmountParent() error {
 
 // CreateContainer creates a new container
 func (o *OCI) CreateContainer() (string, error) {
-	containerName := fmt.Sprintf("go-image-builder-%d", time.Now().Unix())
+	containerName := newContainerName()
 	log.Debugf("Creating container: %s", containerName)
 
-	args := []string{"from", "--log-level=error", "scratch"}
-
-	output, err := o.executeBuildah(args...)
-	if err != nil {
+	if err := o.backend.From("scratch", containerName); err != nil {
 		return "", fmt.Errorf("failed to create container: %w", err)
 	}
 
-	// Get the container ID from the output
-	containerID := strings.TrimSpace(string(output))
-	log.Debugf("Created container with ID: %s", containerID)
-	return containerID, nil
+	log.Debugf("Created container: %s", containerName)
+	return containerName, nil
 }
 
 // MountContainer mounts a container and returns its mount point
 func (o *OCI) MountContainer(containerName string) (string, error) {
 	log.Debugf("Mounting container: %s", containerName)
 
-	args := []string{"mount", containerName}
-	output, err := o.executeBuildah(args...)
+	mountPoint, err := o.backend.Mount(containerName)
 	if err != nil {
 		// Clean up the container if mount fails
-		o.executeBuildah("rm", containerName) // Ignore errors during cleanup
-		return "", fmt.Errorf("failed to mount parent image: %w", err)
+		o.backend.Remove(containerName) // Ignore errors during cleanup
+		return "", fmt.Errorf("failed to mount container: %w", err)
 	}
-	mountPoint := strings.TrimSpace(string(output))
-	log.Debugf("Parent image mounted at: %s", mountPoint)
+	log.Debugf("Container mounted at: %s", mountPoint)
 	return mountPoint, nil
 }
 
 // UnmountContainer unmounts the container
 func (o *OCI) UnmountContainer(containerName string) error {
 	log.Debugf("Unmounting container: %s", containerName)
-	args := []string{"umount", containerName}
-	_, err := o.executeBuildah(args...)
-	return err
+	return o.backend.Unmount(containerName)
 }
 
 // PushImage pushes the image to the registry
@@ -239,34 +365,9 @@ func (o *OCI) PushImage() error {
 	imageRef := fmt.Sprintf("%s/%s", registry, imagePath)
 	log.Debugf("Pushing to image reference: %s", imageRef)
 
-	// Build the push command
-	args := []string{"push"}
-	if len(o.config.Options.RegistryOptsPush) > 0 {
-		args = append(args, o.config.Options.RegistryOptsPush...)
-	}
-	args = append(args, o.parentContainer, imageRef)
-
-	// Cannot use the helper here because we need to set environment variables.
-	var cmd *exec.Cmd
-	var cmdStr string
-
-	if os.Geteuid() == 0 {
-		cmd = exec.Command("buildah", args...)
-		cmdStr = "buildah " + strings.Join(args, " ")
-	} else {
-		cmdArgs := append([]string{"buildah"}, args...)
-		cmd = exec.Command("unshare", cmdArgs...)
-		cmdStr = "unshare " + strings.Join(cmdArgs, " ")
-	}
-	// Auth is handled by podman/buildah config files, no need to set env var explicitly
-	// cmd.Env = append(os.Environ(),
-	// 	fmt.Sprintf("REGISTRY_AUTH_FILE=%s", o.config.Options.Authfile),
-	// )
-
-	// Execute the push command
-	log.Debugf("Executing: %s", cmdStr)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to push image: %w\nOutput: %s", err, string(output))
+	// Auth is handled by the containers auth file, no need to set it explicitly
+	if err := o.backend.Push(o.parentContainer, imageRef, o.config.Options.RegistryOptsPush); err != nil {
+		return fmt.Errorf("failed to push image: %w", err)
 	}
 
 	log.Infof("Successfully pushed image to %s", imageRef)
@@ -276,9 +377,7 @@ func (o *OCI) PushImage() error {
 // CommitContainer commits the changes to the container
 func (o *OCI) CommitContainer(containerName, name string) error {
 	log.Debugf("Committing container: %s", containerName)
-	args := []string{"commit", containerName, name}
-	_, err := o.executeBuildah(args...)
-	if err != nil {
+	if err := o.backend.Commit(containerName, name); err != nil {
 		return fmt.Errorf("failed to commit container %s: %w", containerName, err)
 	}
 	log.Debugf("Successfully committed container: %s", containerName)
@@ -298,13 +397,12 @@ func (o *OCI) Cleanup(containerName string) error {
 	log.Debugf("Cleaning up container: %s", containerName)
 
 	// First, unmount the container
-	if err := o.UnmountContainer(containerName); err != nil {
+	if err := o.backend.Unmount(containerName); err != nil {
 		log.Warnf("Failed to unmount container during cleanup (might already be unmounted): %v", err)
 	}
 
 	// Then, remove the container
-	args := []string{"rm", containerName}
-	if _, err := o.executeBuildah(args...); err != nil {
+	if err := o.backend.Remove(containerName); err != nil {
 		return fmt.Errorf("failed to remove container %s: %w", containerName, err)
 	}
 
@@ -312,11 +410,74 @@ func (o *OCI) Cleanup(containerName string) error {
 	return nil
 }
 
+// ListBuilderContainers returns the names of all containers created by go-image-builder
+func (o *OCI) ListBuilderContainers() ([]string, error) {
+	all, err := o.backend.Containers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	var containers []string
+	for name := range all {
+		if strings.HasPrefix(name, ContainerPrefix) {
+			containers = append(containers, name)
+		}
+	}
+	sort.Strings(containers)
+	return containers, nil
+}
+
+// StaleContainers returns the containers created by go-image-builder whose build has exited.
+// Containers whose build cannot be identified are only returned once they are older than
+// unownedAge.
+func (o *OCI) StaleContainers(unownedAge time.Duration) ([]string, error) {
+	all, err := o.backend.Containers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	var containers []string
+	for name, owner := range all {
+		if strings.HasPrefix(name, ContainerPrefix) && isStale(name, owner, unownedAge) {
+			containers = append(containers, name)
+		}
+	}
+	sort.Strings(containers)
+	return containers, nil
+}
+
 // GetParentMountPoint returns the mount point of the parent container
 func (o *OCI) GetParentMountPoint() string {
 	return o.parentMountPoint
 }
 
+// ParentImageID returns the ID of the parent image in local storage, the digest of its config
+func (o *OCI) ParentImageID() (string, error) {
+	parentImage := o.config.Options.Parent
+	if _, ok := o.config.Options.ParentArchive(); ok {
+		parentImage = ArchiveImageName(parentImage)
+	}
+	id, err := o.ImageID(parentImage)
+	if err != nil {
+		return "", fmt.Errorf("failed to get ID of parent image '%s': %w", o.config.Options.Parent, err)
+	}
+	return id, nil
+}
+
+// ImageExists reports whether an image is present in local storage
+func (o *OCI) ImageExists(image string) bool {
+	return o.backend.ImageExists(image)
+}
+
+// ImageID returns the ID of an image in local storage, the hex digest of its config
+func (o *OCI) ImageID(image string) (string, error) {
+	id, err := o.backend.ImageID(image)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(id, "sha256:"), nil
+}
+
 // GetParentContainer returns the name of the parent container
 func (o *OCI) GetParentContainer() string {
 	return o.parentContainer
@@ -325,12 +486,7 @@ func (o *OCI) GetParentContainer() string {
 // SaveImage saves a locally stored image to a Docker v2.2 archive tarball at the destination path.
 func (o *OCI) SaveImage(imageName, destinationPath string) error {
 	log.Debugf("Saving image '%s' to Docker archive at '%s'", imageName, destinationPath)
-	pushArgs := []string{
-		"push",
-		imageName,
-		fmt.Sprintf("docker-archive:%s", destinationPath),
-	}
-	if _, err := o.executeBuildah(pushArgs...); err != nil {
+	if err := o.backend.Save(imageName, destinationPath); err != nil {
 		return fmt.Errorf("failed to save image '%s' to archive: %w", imageName, err)
 	}
 	return nil
@@ -338,15 +494,15 @@ func (o *OCI) SaveImage(imageName, destinationPath string) error {
 
 // RunCommand executes a command inside the specified container.
 func (o *OCI) RunCommand(containerName, command string) error {
+	return o.RunCommandWithOptions(containerName, command, RunOptions{})
+}
+
+// RunCommandWithOptions executes a command inside the container with its own network or proxy.
+func (o *OCI) RunCommandWithOptions(containerName, command string, opts RunOptions) error {
 	log.Debugf("Running command '%s' in container '%s'", command, containerName)
-	args := []string{
-		"run",
-		containerName,
-		"--",
-		"sh", "-c", command,
-	}
-	if _, err := o.executeBuildah(args...); err != nil {
-		return fmt.Errorf("failed to run command '%s': %w", command, err)
+	var output bytes.Buffer
+	if err := o.run(containerName, []string{"sh", "-c", command}, opts, &output, &output); err != nil {
+		return fmt.Errorf("failed to run command '%s': %w\nOutput: %s", command, err, output.String())
 	}
 	return nil
 }
@@ -354,31 +510,24 @@ func (o *OCI) RunCommand(containerName, command string) error {
 // RunCommandWithOutput executes a command inside the container and returns its output.
 func (o *OCI) RunCommandWithOutput(containerName, command string) ([]byte, error) {
 	log.Debugf("Running command '%s' in container '%s' and capturing output", command, containerName)
-	args := []string{
-		"run",
-		containerName,
-		"--",
-		"sh", "-c", command,
-	}
-	output, err := o.executeBuildah(args...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to run command '%s' with output: %w", command, err)
+	var output bytes.Buffer
+	if err := o.run(containerName, []string{"sh", "-c", command}, RunOptions{}, &output, &output); err != nil {
+		return nil, fmt.Errorf("failed to run command '%s' with output: %w\nOutput: %s", command, err, output.String())
 	}
-	return output, nil
+	return output.Bytes(), nil
 }
 
 // Stat checks for the existence of a file or directory inside a container.
 func (o *OCI) Stat(containerName, path string) error {
 	log.Debugf("Checking for existence of '%s' in container '%s'", path, containerName)
-	args := []string{"run", containerName, "--", "stat", path}
 	// We discard the output, we only care about the exit code.
-	_, err := o.executeBuildah(args...)
-	return err
+	var output bytes.Buffer
+	return o.backend.Run(containerName, []string{"stat", path}, RunOptions{}, &output, &output)
 }
 
 // CopyFromContainerWithCat copies a file from the container to a destination path on the
 // host by running 'cat' inside the container and redirecting the output. This is
-// more reliable than 'buildah copy' for single files in some environments.
+// more reliable than copying out of the mount point for single files in some environments.
 func (o *OCI) CopyFromContainerWithCat(containerName, fromPath, toPath string) error {
 	log.Debugf("Copying from container '%s:%s' to host '%s' using 'cat'", containerName, fromPath, toPath)
 
@@ -389,28 +538,9 @@ func (o *OCI) CopyFromContainerWithCat(containerName, fromPath, toPath string) e
 	}
 	defer hostFile.Close()
 
-	// Prepare the 'buildah run' command.
-	var cmd *exec.Cmd
-	var cmdStr string
-	args := []string{"run", containerName, "--", "cat", fromPath}
-
-	if os.Geteuid() == 0 {
-		cmd = exec.Command("buildah", args...)
-		cmdStr = "buildah " + strings.Join(args, " ")
-	} else {
-		cmdArgs := append([]string{"buildah"}, args...)
-		cmd = exec.Command("unshare", cmdArgs...)
-		cmdStr = "unshare " + strings.Join(cmdArgs, " ")
-	}
-
-	cmd.Stdout = hostFile // Redirect stdout directly to the host file.
-
 	// Capture stderr for better error messages.
 	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	log.Debugf("Executing: %s", cmdStr)
-	if err := cmd.Run(); err != nil {
+	if err := o.backend.Run(containerName, []string{"cat", fromPath}, RunOptions{}, hostFile, &stderr); err != nil {
 		return fmt.Errorf("failed to run 'cat' in container for '%s': %w\nStderr: %s", fromPath, err, stderr.String())
 	}
 
@@ -0,0 +1,220 @@
+package ocitest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"go-image-builder/pkg/oci"
+	"go-image-builder/pkg/progress"
+)
+
+// Fake is an in-memory implementation of oci.OCIInterface for tests. Containers are mounted
+// at MountPoint, files inside containers are served from Files and command output from
+// Outputs. Every call is recorded in Calls.
+type Fake struct {
+	// MountPoint is returned for every mounted container
+	MountPoint string
+	// Files maps paths inside the container to their contents for Stat and
+	// CopyFromContainerWithCat
+	Files map[string][]byte
+	// Outputs maps commands to the output returned by RunCommandWithOutput
+	Outputs map[string][]byte
+	// Errors maps method names to the error they return
+	Errors map[string]error
+	// ParentID is returned by ParentImageID
+	ParentID string
+
+	mu         sync.Mutex
+	calls      []string
+	containers []string
+	nextID     int
+}
+
+var _ oci.OCIInterface = (*Fake)(nil)
+
+// NewFake returns a fake whose containers are mounted at mountPoint
+func NewFake(mountPoint string) *Fake {
+	return &Fake{
+		MountPoint: mountPoint,
+		Files:      make(map[string][]byte),
+		Outputs:    make(map[string][]byte),
+		Errors:     make(map[string]error),
+	}
+}
+
+// Calls returns the recorded calls as "Method arg1 arg2"
+func (f *Fake) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// Containers returns the containers that have been created and not cleaned up
+func (f *Fake) Containers() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.containers...)
+}
+
+// record logs a call and returns the configured error for the method
+func (f *Fake) record(method string, args ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, strings.TrimSpace(method+" "+strings.Join(args, " ")))
+	return f.Errors[method]
+}
+
+// newContainer registers a new container and returns its name
+func (f *Fake) newContainer() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	name := fmt.Sprintf("%sfake-%d", oci.ContainerPrefix, f.nextID)
+	f.containers = append(f.containers, name)
+	return name
+}
+
+func (f *Fake) PullParentImage() error { return f.record("PullParentImage") }
+
+func (f *Fake) MountParent() error {
+	if err := f.record("MountParent"); err != nil {
+		return err
+	}
+	f.newContainer()
+	return nil
+}
+
+func (f *Fake) UnmountParent() error { return f.record("UnmountParent") }
+
+func (f *Fake) GetParentMountPoint() string { return f.MountPoint }
+
+func (f *Fake) ParentImageID() (string, error) {
+	return f.ParentID, f.record("ParentImageID")
+}
+
+func (f *Fake) GetParentContainer() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.containers) == 0 {
+		return ""
+	}
+	return f.containers[0]
+}
+
+func (f *Fake) MountImage(image string) (string, string, error) {
+	if err := f.record("MountImage", image); err != nil {
+		return "", "", err
+	}
+	return f.newContainer(), f.MountPoint, nil
+}
+
+func (f *Fake) CreateContainer() (string, error) {
+	if err := f.record("CreateContainer"); err != nil {
+		return "", err
+	}
+	return f.newContainer(), nil
+}
+
+func (f *Fake) MountContainer(containerName string) (string, error) {
+	if err := f.record("MountContainer", containerName); err != nil {
+		return "", err
+	}
+	return f.MountPoint, nil
+}
+
+func (f *Fake) UnmountContainer(containerName string) error {
+	return f.record("UnmountContainer", containerName)
+}
+
+func (f *Fake) CommitContainer(containerName, name string) error {
+	return f.record("CommitContainer", containerName, name)
+}
+
+func (f *Fake) PushImage() error { return f.record("PushImage") }
+
+func (f *Fake) SaveImage(imageName, destinationPath string) error {
+	return f.record("SaveImage", imageName, destinationPath)
+}
+
+func (f *Fake) RunCommand(containerName, command string) error {
+	return f.record("RunCommand", containerName, command)
+}
+
+// RunCommandWithOptions records the network and proxy environment of the command after it,
+// as in "RunCommandWithOptions container command network=none proxy=http_proxy=..."
+func (f *Fake) RunCommandWithOptions(containerName, command string, opts oci.RunOptions) error {
+	args := []string{containerName, command}
+	if opts.Network != "" {
+		args = append(args, "network="+opts.Network)
+	}
+	if opts.Proxy != nil {
+		args = append(args, "proxy="+strings.Join(opts.Proxy.Env(), ","))
+	}
+	return f.record("RunCommandWithOptions", args...)
+}
+
+func (f *Fake) RunCommandWithOutput(containerName, command string) ([]byte, error) {
+	if err := f.record("RunCommandWithOutput", containerName, command); err != nil {
+		return nil, err
+	}
+	output, ok := f.Outputs[command]
+	if !ok {
+		return nil, fmt.Errorf("no output configured for command '%s'", command)
+	}
+	return output, nil
+}
+
+func (f *Fake) Stat(containerName, path string) error {
+	if err := f.record("Stat", containerName, path); err != nil {
+		return err
+	}
+	if _, ok := f.Files[path]; !ok {
+		return fmt.Errorf("stat %s: no such file or directory", path)
+	}
+	return nil
+}
+
+func (f *Fake) CopyFromContainerWithCat(containerName, fromPath, toPath string) error {
+	if err := f.record("CopyFromContainerWithCat", containerName, fromPath, toPath); err != nil {
+		return err
+	}
+	data, ok := f.Files[fromPath]
+	if !ok {
+		return fmt.Errorf("cat %s: no such file or directory", fromPath)
+	}
+	return os.WriteFile(toPath, data, 0644)
+}
+
+func (f *Fake) Cleanup(containerName string) error {
+	if err := f.record("Cleanup", containerName); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, name := range f.containers {
+		if name == containerName {
+			f.containers = append(f.containers[:i], f.containers[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (f *Fake) ListBuilderContainers() ([]string, error) {
+	if err := f.record("ListBuilderContainers"); err != nil {
+		return nil, err
+	}
+	return f.Containers(), nil
+}
+
+func (f *Fake) SetLog(w io.Writer) {}
+
+func (f *Fake) SetContext(ctx context.Context) {}
+
+func (f *Fake) SetProgress(r progress.Reporter) {}
+
+func (f *Fake) Close() error { return f.record("Close") }
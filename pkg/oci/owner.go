@@ -0,0 +1,77 @@
+package oci
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// staleContainerAge is how old a working container must be before it is removed when the
+// build that created it cannot be identified, for example when it predates owner labels or
+// was created on another host sharing the storage
+const staleContainerAge = 24 * time.Hour
+
+// containerOwner returns the ContainerLabel value recording the build that creates a
+// container, as host/pid/start. The process start time tells a reused PID apart from the
+// build that created the container.
+func containerOwner() string {
+	host, _ := os.Hostname()
+	start, _ := processStart(os.Getpid())
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), start)
+}
+
+// ownerRunning reports whether the build recorded in an owner label is still running. The
+// second result is false if that cannot be determined.
+func ownerRunning(owner string) (running, known bool) {
+	parts := strings.Split(owner, "/")
+	if len(parts) != 3 || parts[2] == "" {
+		return false, false
+	}
+	if host, _ := os.Hostname(); parts[0] != host {
+		return false, false
+	}
+	pid, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false, false
+	}
+	start, err := processStart(pid)
+	if os.IsNotExist(err) {
+		return false, true
+	}
+	if err != nil {
+		return false, false
+	}
+	return start == parts[2], true
+}
+
+// processStart returns the start time of a process in clock ticks since boot, as recorded in
+// /proc/<pid>/stat
+func processStart(pid int) (string, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return "", err
+	}
+	// The command name may contain spaces, so fields are counted from its closing parenthesis,
+	// which is followed by the third field
+	end := strings.LastIndexByte(string(stat), ')')
+	if end < 0 {
+		return "", fmt.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 20 {
+		return "", fmt.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+	return fields[19], nil
+}
+
+// isStale reports whether a working container was left behind by a build that is no longer
+// running. A container whose owner is unknown is stale once it is older than unownedAge.
+func isStale(name, owner string, unownedAge time.Duration) bool {
+	if running, known := ownerRunning(owner); known {
+		return !running
+	}
+	created, ok := ContainerCreated(name)
+	return ok && time.Since(created) >= unownedAge
+}
@@ -0,0 +1,36 @@
+package oci
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIsStale(t *testing.T) {
+	host, _ := os.Hostname()
+	old := fmt.Sprintf("%s%d", ContainerPrefix, time.Now().Add(-2*time.Hour).UnixNano())
+	recent := newContainerName()
+
+	tests := []struct {
+		name      string
+		container string
+		owner     string
+		want      bool
+	}{
+		{"running build", old, containerOwner(), false},
+		{"exited build", recent, fmt.Sprintf("%s/%d/1", host, os.Getpid()), true},
+		{"other host recent", recent, "elsewhere/1/1", false},
+		{"other host old", old, "elsewhere/1/1", true},
+		{"unlabelled recent", recent, "", false},
+		{"unlabelled old", old, "true", true},
+		{"foreign name", "other", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isStale(tt.container, tt.owner, time.Hour); got != tt.want {
+				t.Errorf("isStale(%s, %s) = %v, want %v", tt.container, tt.owner, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package oci
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/utils"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/transports/alltransports"
+	log "github.com/sirupsen/logrus"
+)
+
+// verifyParent checks the parent image against the configured signature policy and cosign
+// signature. The parent is verified at its source rather than in local storage, so a parent
+// referenced by tag could change between verification and pull unless it is pinned by digest.
+// VerificationError is the error of a parent image that failed verification
+type VerificationError struct {
+	Image  string
+	Method string
+	Err    error
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("parent image '%s' failed %s verification: %v", e.Image, e.Method, e.Err)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+func (o *OCI) verifyParent() error {
+	verify := o.config.Options.ParentVerify
+	parent := o.config.Options.Parent
+
+	if verify.Policy != "" {
+		source := parent
+		if _, ok := o.config.Options.ParentArchive(); !ok {
+			source = "docker://" + utils.SanitizeRegistryURL(parent)
+		}
+		log.Infof("Verifying parent image '%s' against %s", parent, verify.Policy)
+		if err := verifyPolicy(verify.Policy, source, o.config.Options.RegistryOptsPull); err != nil {
+			return &VerificationError{Image: parent, Method: "signature policy", Err: err}
+		}
+	}
+
+	if verify.Cosign() {
+		log.Infof("Verifying cosign signature of parent image '%s'", parent)
+		if err := verifyCosign(utils.SanitizeRegistryURL(parent), o.config.Options); err != nil {
+			return &VerificationError{Image: parent, Method: "cosign", Err: err}
+		}
+	}
+	return nil
+}
+
+// verifyPolicy evaluates a containers policy.json for the image at source, a transport
+// reference such as docker://registry/image:tag
+func verifyPolicy(policyPath, source string, registryOpts []string) error {
+	policy, err := signature.NewPolicyFromFile(policyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load signature policy: %w", err)
+	}
+	policyContext, err := signature.NewPolicyContext(policy)
+	if err != nil {
+		return fmt.Errorf("failed to create policy context: %w", err)
+	}
+	defer policyContext.Destroy()
+
+	ref, err := alltransports.ParseImageName(source)
+	if err != nil {
+		return fmt.Errorf("failed to parse image reference %s: %w", source, err)
+	}
+	ctx := context.Background()
+	src, err := ref.NewImageSource(ctx, systemContext(registryOpts))
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", source, err)
+	}
+	defer src.Close()
+
+	allowed, err := policyContext.IsRunningImageAllowed(ctx, image.UnparsedInstance(src, nil))
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("rejected by policy")
+	}
+	return nil
+}
+
+// verifyCosign runs cosign verify for a registry reference, either against a public key or
+// keylessly against the expected certificate identity and issuer
+func verifyCosign(ref string, options imageconfig.Options) error {
+	verify := options.ParentVerify
+	args := []string{"verify", "--output", "text"}
+	if verify.CosignKey != "" {
+		args = append(args, "--key", verify.CosignKey)
+	}
+	if verify.CosignIdentity != "" {
+		args = append(args, "--certificate-identity", verify.CosignIdentity, "--certificate-oidc-issuer", verify.CosignIssuer)
+	}
+	if slices.Contains(options.RegistryOptsPull, "--tls-verify=false") {
+		args = append(args, "--allow-insecure-registry")
+	}
+	args = append(args, ref)
+
+	log.Debugf("Executing: cosign %s", strings.Join(args, " "))
+	output, err := exec.Command("cosign", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cosign verify failed: %s: %w", strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
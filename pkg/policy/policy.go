@@ -0,0 +1,101 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"go-image-builder/pkg/imageconfig"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Query is the rego rule policies define violations with. It is a set of messages, as in
+//
+//	package imagebuilder
+//
+//	deny contains msg if {
+//		some repo in input.repos
+//		not startswith(repo.url, "https://")
+//		msg := sprintf("repository %s must use https", [repo.alias])
+//	}
+const Query = "data.imagebuilder.deny"
+
+// Evaluate checks a configuration against rego policy files or directories with the opa CLI
+// and returns the violations found. The configuration is the input document, with the field
+// names of the configuration file.
+func Evaluate(config *imageconfig.Config, policies []string) ([]string, error) {
+	input, err := Input(config)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"eval", "--format", "json", "--stdin-input"}
+	for _, p := range policies {
+		args = append(args, "--data", p)
+	}
+	args = append(args, Query)
+
+	cmd := exec.Command("opa", args...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate policies: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+	return parseDenials(output)
+}
+
+// Input returns the JSON input document of a configuration. It is converted through YAML so
+// policies see the same field names as the configuration file.
+func Input(config *imageconfig.Config) ([]byte, error) {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to convert config: %w", err)
+	}
+	input, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal policy input: %w", err)
+	}
+	return input, nil
+}
+
+// parseDenials reads the messages of the deny set from the output of opa eval. A policy that
+// does not define the rule has no result.
+func parseDenials(output []byte) ([]string, error) {
+	var result struct {
+		Result []struct {
+			Expressions []struct {
+				Value any `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse opa output: %w", err)
+	}
+
+	var denials []string
+	for _, r := range result.Result {
+		for _, e := range r.Expressions {
+			values, ok := e.Value.([]any)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a set of messages", Query)
+			}
+			for _, v := range values {
+				if msg, ok := v.(string); ok {
+					denials = append(denials, msg)
+				} else {
+					denials = append(denials, fmt.Sprint(v))
+				}
+			}
+		}
+	}
+	return denials, nil
+}
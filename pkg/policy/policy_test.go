@@ -0,0 +1,69 @@
+package policy
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+)
+
+func TestParseDenials(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    []string
+		wantErr bool
+	}{
+		{name: "undefined rule", output: `{}`},
+		{name: "no violations", output: `{"result":[{"expressions":[{"value":[],"text":"data.imagebuilder.deny"}]}]}`},
+		{
+			name:   "violations",
+			output: `{"result":[{"expressions":[{"value":["repository epel must use https","no testing packages"]}]}]}`,
+			want:   []string{"repository epel must use https", "no testing packages"},
+		},
+		{name: "not a set", output: `{"result":[{"expressions":[{"value":true}]}]}`, wantErr: true},
+		{name: "invalid output", output: `not json`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDenials([]byte(tt.output))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDenials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDenials() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInput(t *testing.T) {
+	config := &imageconfig.Config{
+		Options:      imageconfig.Options{Name: "compute"},
+		Repositories: []imageconfig.Repository{{Alias: "epel", Url: "http://example.com/epel"}},
+	}
+	data, err := Input(config)
+	if err != nil {
+		t.Fatalf("Input() error = %v", err)
+	}
+
+	var input struct {
+		Options struct {
+			Name string `json:"name"`
+		} `json:"options"`
+		Repos []struct {
+			Alias string `json:"alias"`
+			URL   string `json:"url"`
+		} `json:"repos"`
+	}
+	if err := json.Unmarshal(data, &input); err != nil {
+		t.Fatalf("failed to parse input %s: %v", data, err)
+	}
+	if input.Options.Name != "compute" {
+		t.Errorf("options.name = %q, want compute", input.Options.Name)
+	}
+	if len(input.Repos) != 1 || input.Repos[0].URL != "http://example.com/epel" {
+		t.Errorf("repos = %+v, want the epel repository", input.Repos)
+	}
+}
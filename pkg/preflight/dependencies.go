@@ -0,0 +1,171 @@
+package preflight
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"go-image-builder/pkg/imageconfig"
+)
+
+// The space a build needs depends on the image, so these are estimates for a typical OS image
+// and running below them only produces a warning
+const (
+	// minWorkDirSpace is the free space a build needs for its rootfs and layer tarballs
+	minWorkDirSpace = 10 << 30
+	// minSquashfsSpace is the additional space needed to write a squashfs image
+	minSquashfsSpace = 5 << 30
+	// minTmpSpace is the free space container storage needs to stage image pulls and pushes
+	minTmpSpace = 2 << 30
+	// minTempDirSpace is the free space the layer tarballs and parent archive need when a
+	// build is given a temporary directory apart from its work directory
+	minTempDirSpace = 5 << 30
+)
+
+// CheckDependencies verifies that the host tools a build of config needs are installed
+func CheckDependencies(config *imageconfig.Config, createSquashfs bool) []Issue {
+	tools := []string{"tar"}
+	switch config.Options.Backend {
+	case "podman", "docker":
+		tools = append(tools, config.Options.Backend, "mount", "umount")
+		// Commands without a network run in a network namespace of their own
+		offline := config.Options.Network == "none"
+		for _, cmd := range config.Cmds {
+			offline = offline || cmd.Network == "none"
+		}
+		if offline {
+			tools = append(tools, "unshare")
+		}
+	}
+	var issues []Issue
+	if config.Options.PkgManager != "" && (len(config.Packages) > 0 || len(config.PackageGroups) > 0) {
+		// The rootfs is bootstrapped with the host package manager, or from a base image
+		// without it, and then chrooted into
+		tools = append(tools, "mount", "umount")
+		scratch := config.Options.Parent == "" || config.Options.Parent == "scratch"
+		if _, err := exec.LookPath(config.Options.PkgManager); err != nil && scratch {
+			issues = append(issues, Issue{
+				Check:   config.Options.PkgManager,
+				Message: fmt.Sprintf("%s not found in PATH, the rootfs will be bootstrapped from %s", config.Options.PkgManager, config.Options.Bootstrap()),
+				Hint:    fmt.Sprintf("install %s on the build host to build the rootfs from scratch", config.Options.PkgManager),
+				Warning: true,
+			})
+		}
+	}
+	if config.Options.ParentVerify.Cosign() {
+		tools = append(tools, "cosign")
+	}
+	if createSquashfs && config.NFSRoot == nil {
+		tools = append(tools, "mksquashfs")
+	}
+	for _, overlay := range config.NodeOverlays {
+		if overlay.Format == "squashfs" {
+			tools = append(tools, "mksquashfs")
+		}
+	}
+	if config.Options.Harden.Profile != "" {
+		tools = append(tools, "oscap-chroot")
+	}
+	if config.Options.Dracut == "host" {
+		tools = append(tools, "dracut")
+	}
+	if config.Options.Scan.Scanner != "" {
+		tools = append(tools, config.Options.Scan.Scanner)
+	}
+	if config.Options.Resources.Memory != "" || config.Options.Resources.CPUs > 0 {
+		tools = append(tools, "systemd-run")
+	}
+
+	seen := make(map[string]bool)
+	for _, tool := range tools {
+		if seen[tool] {
+			continue
+		}
+		seen[tool] = true
+		if _, err := exec.LookPath(tool); err != nil {
+			issues = append(issues, Issue{
+				Check:   tool,
+				Message: fmt.Sprintf("%s not found in PATH", tool),
+				Hint:    fmt.Sprintf("install %s on the build host", tool),
+			})
+		}
+	}
+	return issues
+}
+
+// CheckDiskSpace warns when the work directory or the temporary directory used by container
+// storage has less free space than a typical build needs
+func CheckDiskSpace(workDir string, createSquashfs bool) []Issue {
+	need := uint64(minWorkDirSpace)
+	if createSquashfs {
+		need += minSquashfsSpace
+	}
+
+	tmpDir := os.Getenv("TMPDIR")
+	if tmpDir == "" {
+		tmpDir = "/var/tmp"
+	}
+
+	var issues []Issue
+	issues = append(issues, checkFreeSpace(workDir, need)...)
+	issues = append(issues, checkFreeSpace(tmpDir, minTmpSpace)...)
+	return issues
+}
+
+// CheckTempDirSpace warns when the temporary directory set with options.temp_dir has less
+// free space than the layer tarballs of a typical build need
+func CheckTempDirSpace(dir string) []Issue {
+	return checkFreeSpace(dir, minTempDirSpace)
+}
+
+// checkFreeSpace warns when dir has less than need bytes free
+func checkFreeSpace(dir string, need uint64) []Issue {
+	free, err := freeSpace(dir)
+	if err != nil {
+		return []Issue{{
+			Check:   "disk space",
+			Message: fmt.Sprintf("failed to check free space in %s: %v", dir, err),
+			Warning: true,
+		}}
+	}
+	if free < need {
+		return []Issue{{
+			Check:   "disk space",
+			Message: fmt.Sprintf("%s has %s free, a typical build needs %s", dir, formatBytes(free), formatBytes(need)),
+			Hint:    "free up space or choose another directory",
+			Warning: true,
+		}}
+	}
+	return nil
+}
+
+// freeSpace returns the space available to unprivileged users on the filesystem holding
+// dir, or its closest existing parent
+func freeSpace(dir string) (uint64, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, err := os.Stat(dir); err == nil || dir == filepath.Dir(dir) {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// formatBytes formats a size in GiB or MiB
+func formatBytes(n uint64) string {
+	if n >= 1<<30 {
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	}
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+}
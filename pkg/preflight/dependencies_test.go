@@ -0,0 +1,69 @@
+package preflight
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+)
+
+func TestCheckDependencies(t *testing.T) {
+	// Only tar and mount are installed
+	bin := t.TempDir()
+	for _, tool := range []string{"tar", "mount"} {
+		if err := os.WriteFile(filepath.Join(bin, tool), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatalf("failed to write %s: %v", tool, err)
+		}
+	}
+	t.Setenv("PATH", bin)
+
+	tests := []struct {
+		name     string
+		options  imageconfig.Options
+		packages []string
+		squashfs bool
+		want     []string
+		warnings []string
+	}{
+		{name: "buildah", options: imageconfig.Options{Backend: "buildah"}},
+		{name: "podman", options: imageconfig.Options{Backend: "podman"}, want: []string{"podman", "umount"}},
+		{name: "packages", options: imageconfig.Options{PkgManager: "dnf"}, packages: []string{"vim"}, want: []string{"umount"}, warnings: []string{"dnf"}},
+		{name: "packages on parent", options: imageconfig.Options{PkgManager: "dnf", Parent: "registry/base:9"}, packages: []string{"vim"}, want: []string{"umount"}},
+		{name: "package manager without packages", options: imageconfig.Options{PkgManager: "dnf"}},
+		{name: "podman and packages", options: imageconfig.Options{Backend: "podman", PkgManager: "dnf"}, packages: []string{"vim"}, want: []string{"podman", "umount"}, warnings: []string{"dnf"}},
+		{name: "cosign", options: imageconfig.Options{ParentVerify: imageconfig.ParentVerify{CosignKey: "cosign.pub"}}, want: []string{"cosign"}},
+		{name: "squashfs", squashfs: true, want: []string{"mksquashfs"}},
+		{name: "scan", options: imageconfig.Options{Scan: imageconfig.Scan{Scanner: "trivy"}}, want: []string{"trivy"}},
+		{name: "resources", options: imageconfig.Options{Resources: imageconfig.Resources{CPUs: 2}}, want: []string{"systemd-run"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &imageconfig.Config{Options: tt.options, Packages: tt.packages}
+			var got, warnings []string
+			for _, issue := range CheckDependencies(config, tt.squashfs) {
+				if issue.Warning {
+					warnings = append(warnings, issue.Check)
+					continue
+				}
+				got = append(got, issue.Check)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CheckDependencies() missing = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(warnings, tt.warnings) {
+				t.Errorf("CheckDependencies() warnings = %v, want %v", warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	for _, issue := range CheckDiskSpace(filepath.Join(t.TempDir(), "missing", "output"), true) {
+		if !issue.Warning {
+			t.Errorf("CheckDiskSpace() issue %q stops the build", issue.Message)
+		}
+	}
+}
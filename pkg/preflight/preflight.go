@@ -0,0 +1,44 @@
+package preflight
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Issue is a problem found by a preflight check
+type Issue struct {
+	// Check names the failed check
+	Check string
+	// Message describes the problem
+	Message string
+	// Hint tells the user how to fix it
+	Hint string
+	// Warning issues are logged but do not stop the build
+	Warning bool
+}
+
+func (i Issue) String() string {
+	if i.Hint == "" {
+		return fmt.Sprintf("%s: %s", i.Check, i.Message)
+	}
+	return fmt.Sprintf("%s: %s (%s)", i.Check, i.Message, i.Hint)
+}
+
+// Report logs the issues and returns an error listing the ones that are not warnings
+func Report(issues []Issue) error {
+	var fatal []string
+	for _, issue := range issues {
+		if issue.Warning {
+			log.Warnf("Preflight: %s", issue)
+			continue
+		}
+		log.Errorf("Preflight: %s", issue)
+		fatal = append(fatal, issue.String())
+	}
+	if len(fatal) > 0 {
+		return fmt.Errorf("preflight checks failed:\n  %s", strings.Join(fatal, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package preflight
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// minSubIDs is the smallest subordinate ID range most distribution images work with
+const minSubIDs = 65536
+
+// CheckRootless verifies that the host is set up for rootless container builds
+func CheckRootless() []Issue {
+	var issues []Issue
+
+	if issue := checkUserNamespaces(); issue != nil {
+		issues = append(issues, *issue)
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		issues = append(issues, Issue{Check: "user", Message: fmt.Sprintf("failed to look up current user: %v", err)})
+	} else {
+		for _, file := range []string{"/etc/subuid", "/etc/subgid"} {
+			if issue := checkSubIDs(file, current.Username, current.Uid); issue != nil {
+				issues = append(issues, *issue)
+			}
+		}
+	}
+
+	for _, tool := range []string{"newuidmap", "newgidmap"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			issues = append(issues, Issue{
+				Check:   tool,
+				Message: fmt.Sprintf("%s not found in PATH", tool),
+				Hint:    "install the shadow-utils (Fedora/RHEL) or uidmap (Debian/Ubuntu) package",
+			})
+		}
+	}
+
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+		issues = append(issues, Issue{
+			Check:   "cgroups",
+			Message: "cgroup v2 is not mounted, resource limits will not be applied to build containers",
+			Hint:    "boot with systemd.unified_cgroup_hierarchy=1",
+			Warning: true,
+		})
+	}
+
+	if !nativeRootlessOverlay() {
+		if _, err := exec.LookPath("fuse-overlayfs"); err != nil {
+			issues = append(issues, Issue{
+				Check:   "fuse-overlayfs",
+				Message: "fuse-overlayfs not found and the kernel does not support rootless overlay mounts, storage will fall back to the slow vfs driver",
+				Hint:    "install the fuse-overlayfs package",
+				Warning: true,
+			})
+		}
+	}
+
+	return issues
+}
+
+// checkUserNamespaces verifies that unprivileged users may create user namespaces
+func checkUserNamespaces() *Issue {
+	// Debian and older Ubuntu kernels gate user namespaces behind this sysctl
+	if data, err := os.ReadFile("/proc/sys/kernel/unprivileged_userns_clone"); err == nil {
+		if strings.TrimSpace(string(data)) == "0" {
+			return &Issue{
+				Check:   "user namespaces",
+				Message: "unprivileged user namespaces are disabled",
+				Hint:    "run 'sysctl -w kernel.unprivileged_userns_clone=1'",
+			}
+		}
+		return nil
+	}
+
+	data, err := os.ReadFile("/proc/sys/user/max_user_namespaces")
+	if err != nil {
+		return &Issue{
+			Check:   "user namespaces",
+			Message: "user namespaces are not supported by this kernel",
+			Hint:    "enable CONFIG_USER_NS in the kernel configuration",
+		}
+	}
+	if strings.TrimSpace(string(data)) == "0" {
+		return &Issue{
+			Check:   "user namespaces",
+			Message: "user namespaces are disabled",
+			Hint:    "run 'sysctl -w user.max_user_namespaces=15000'",
+		}
+	}
+	return nil
+}
+
+// checkSubIDs verifies that the user has a large enough subordinate ID range in file
+func checkSubIDs(file, username, uid string) *Issue {
+	count, err := subIDCount(file, username, uid)
+	if err != nil {
+		return &Issue{
+			Check:   file,
+			Message: fmt.Sprintf("failed to read subordinate ID ranges: %v", err),
+			Hint:    fmt.Sprintf("run 'usermod --add-subuids 100000-165535 --add-subgids 100000-165535 %s'", username),
+		}
+	}
+	if count == 0 {
+		return &Issue{
+			Check:   file,
+			Message: fmt.Sprintf("no subordinate ID range for user %s", username),
+			Hint:    fmt.Sprintf("run 'usermod --add-subuids 100000-165535 --add-subgids 100000-165535 %s'", username),
+		}
+	}
+	if count < minSubIDs {
+		return &Issue{
+			Check:   file,
+			Message: fmt.Sprintf("user %s only has %d subordinate IDs, packages owned by high UIDs may fail to install", username, count),
+			Hint:    fmt.Sprintf("allocate at least %d IDs", minSubIDs),
+			Warning: true,
+		}
+	}
+	return nil
+}
+
+// subIDCount returns the number of subordinate IDs assigned to a user in a subuid or subgid
+// file, whose entries are "name:start:count" with name being a user name or UID
+func subIDCount(file, username, uid string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	total := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 || (fields[0] != username && fields[0] != uid) {
+			continue
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid entry '%s': %w", line, err)
+		}
+		total += count
+	}
+	return total, scanner.Err()
+}
+
+// nativeRootlessOverlay reports whether the kernel supports overlay mounts in user
+// namespaces, which was added in Linux 5.13
+func nativeRootlessOverlay() bool {
+	data, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return false
+	}
+	parts := strings.SplitN(strings.TrimSpace(string(data)), ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	minor, err := strconv.Atoi(strings.TrimFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }))
+	if err != nil {
+		return false
+	}
+	return major > 5 || (major == 5 && minor >= 13)
+}
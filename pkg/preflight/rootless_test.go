@@ -0,0 +1,43 @@
+package preflight
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSubIDCount(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "subuid")
+	content := `# comment
+builder:100000:65536
+1000:300000:1000
+other:200000:65536
+`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write subuid file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		uid      string
+		want     int
+	}{
+		{name: "by name", username: "builder", uid: "2000", want: 65536},
+		{name: "by name and uid", username: "builder", uid: "1000", want: 66536},
+		{name: "by uid", username: "nobody", uid: "1000", want: 1000},
+		{name: "missing", username: "nobody", uid: "3000", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := subIDCount(file, tt.username, tt.uid)
+			if err != nil {
+				t.Fatalf("subIDCount() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("subIDCount() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package progress
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Reporter receives the progress of a build, for user interfaces to display. Calls may come
+// from several goroutines.
+type Reporter interface {
+	// Stage reports that stage n of total stages, counted from 1, started
+	Stage(name string, n, total int)
+	// Transfer reports that done of total bytes were pushed to ref. Total is 0 if unknown.
+	Transfer(ref string, done, total int64)
+	// Package reports that package n of the total packages of a transaction was installed
+	Package(name string, n, total int)
+	// Status reports what a long running operation, such as an image pull, is doing
+	Status(msg string)
+}
+
+// Log reports progress through the logger. It is the default reporter.
+type Log struct{}
+
+var _ Reporter = Log{}
+
+// Stage implements Reporter. Stages are announced by the builder, so this only logs at debug.
+func (Log) Stage(name string, n, total int) {
+	log.Debugf("Stage %d of %d: %s", n, total, name)
+}
+
+// Transfer implements Reporter, logging finished transfers only
+func (Log) Transfer(ref string, done, total int64) {
+	if total > 0 && done == total {
+		log.Infof("Pushed %.1f MiB to %s", float64(total)/(1<<20), ref)
+	}
+}
+
+// Package implements Reporter
+func (Log) Package(name string, n, total int) {
+	log.Infof("Installing %s (%d/%d)", name, n, total)
+}
+
+// Status implements Reporter
+func (Log) Status(msg string) {
+	log.Info(msg)
+}
+
+// OrLog returns r, or Log if r is nil
+func OrLog(r Reporter) Reporter {
+	if r == nil {
+		return Log{}
+	}
+	return r
+}
+
+// Writer returns a writer reporting every non-empty line written to it as a status. Close
+// the writer once done, which waits until every line was reported.
+func Writer(r Reporter) io.WriteCloser {
+	pr, pw := io.Pipe()
+	w := &writer{PipeWriter: pw, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		scanner := bufio.NewScanner(pr)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				r.Status(line)
+			}
+		}
+		// Keep draining so writers never block on a line too long to scan
+		io.Copy(io.Discard, pr)
+	}()
+	return w
+}
+
+type writer struct {
+	*io.PipeWriter
+	done chan struct{}
+}
+
+func (w *writer) Close() error {
+	err := w.PipeWriter.Close()
+	<-w.done
+	return err
+}
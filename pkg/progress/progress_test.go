@@ -0,0 +1,34 @@
+package progress
+
+import (
+	"reflect"
+	"testing"
+)
+
+// statuses records the statuses reported to it
+type statuses struct {
+	Log
+	msgs []string
+}
+
+func (s *statuses) Status(msg string) {
+	s.msgs = append(s.msgs, msg)
+}
+
+func TestWriter(t *testing.T) {
+	r := &statuses{}
+	w := Writer(r)
+	for _, chunk := range []string{"Copying blob 3f4c", "a61aafcd done\n\n", "Writing manifest\nStoring signatures"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"Copying blob 3f4ca61aafcd done", "Writing manifest", "Storing signatures"}
+	if !reflect.DeepEqual(r.msgs, want) {
+		t.Errorf("Writer() reported %q, want %q", r.msgs, want)
+	}
+}
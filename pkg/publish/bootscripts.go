@@ -0,0 +1,87 @@
+package publish
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"go-image-builder/pkg/imageconfig"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BootScriptData holds the values boot script templates can refer to. The artifact URLs are
+// those of the files in the output directory under the configured base URL.
+type BootScriptData struct {
+	ArtifactInfo
+	URL         string
+	KernelURL   string
+	InitrdURL   string
+	SquashfsURL string
+	Cmdline     string
+	Labels      map[string]string
+}
+
+// BootScripts renders the configured boot script templates into dir and returns the paths
+// of the scripts written
+func BootScripts(cfg *imageconfig.BootScripts, info ArtifactInfo, labels map[string]string, dir string) ([]string, error) {
+	data := BootScriptData{ArtifactInfo: info, Labels: labels}
+
+	url, err := renderBootTemplate("boot_scripts.url", cfg.URL, data)
+	if err != nil {
+		return nil, err
+	}
+	data.URL = strings.TrimSuffix(url, "/")
+	data.KernelURL = data.URL + "/kernel"
+	data.InitrdURL = data.URL + "/initrd.img"
+	data.SquashfsURL = data.URL + "/image.squashfs"
+
+	// The command line may refer to the artifact URLs, as in root=live:{{.SquashfsURL}}
+	if data.Cmdline, err = renderBootTemplate("boot_scripts.cmdline", cfg.Cmdline, data); err != nil {
+		return nil, err
+	}
+	data.Cmdline = appendArgs(data.Cmdline, info.BootArgs)
+
+	var written []string
+	for _, script := range cfg.Templates {
+		text, err := os.ReadFile(script.Src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read boot script template: %w", err)
+		}
+		rendered, err := renderBootTemplate(script.Src, string(text), data)
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, script.Dest)
+		log.Infof("Writing boot script %s", path)
+		if err := os.WriteFile(path, []byte(rendered), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write boot script: %w", err)
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+// renderBootTemplate executes a boot script template, failing on unknown fields and labels
+func renderBootTemplate(name, text string, data BootScriptData) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s template: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render %s template: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// appendArgs appends kernel arguments to a command line
+func appendArgs(cmdline, args string) string {
+	if args == "" {
+		return cmdline
+	}
+	return strings.TrimSpace(cmdline + " " + args)
+}
@@ -0,0 +1,82 @@
+package publish
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+)
+
+func TestBootScripts(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "boot.ipxe.tmpl")
+	ipxe := `#!ipxe
+kernel {{.KernelURL}} {{.Cmdline}}
+initrd {{.InitrdURL}}
+# {{index .Labels "com.openchami.image.os.name"}} {{.KernelVersion}}
+`
+	if err := os.WriteFile(src, []byte(ipxe), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info := ArtifactInfo{Name: "rocky", Tag: "v1", KernelVersion: "5.14.0"}
+	labels := map[string]string{"com.openchami.image.os.name": "Rocky Linux"}
+
+	tests := []struct {
+		name    string
+		cfg     imageconfig.BootScripts
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "ipxe",
+			cfg: imageconfig.BootScripts{
+				URL:       "http://boot.example.com/{{.Name}}/{{.Tag}}/",
+				Cmdline:   "root=live:{{.SquashfsURL}} console=ttyS0",
+				Templates: []imageconfig.BootScript{{Src: src, Dest: "boot.ipxe"}},
+			},
+			want: `#!ipxe
+kernel http://boot.example.com/rocky/v1/kernel root=live:http://boot.example.com/rocky/v1/image.squashfs console=ttyS0
+initrd http://boot.example.com/rocky/v1/initrd.img
+# Rocky Linux 5.14.0
+`,
+		},
+		{
+			name: "unknown field",
+			cfg: imageconfig.BootScripts{
+				URL:       "http://boot.example.com/{{.Branch}}",
+				Templates: []imageconfig.BootScript{{Src: src, Dest: "boot.ipxe"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing label",
+			cfg: imageconfig.BootScripts{
+				Cmdline:   "{{.Labels.missing}}",
+				Templates: []imageconfig.BootScript{{Src: src, Dest: "boot.ipxe"}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			written, err := BootScripts(&tt.cfg, info, labels, dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BootScripts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(written) != 1 || written[0] != filepath.Join(dir, "boot.ipxe") {
+				t.Fatalf("BootScripts() wrote %v, want [%s]", written, filepath.Join(dir, "boot.ipxe"))
+			}
+			got, err := os.ReadFile(written[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("boot.ipxe =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
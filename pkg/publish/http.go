@@ -0,0 +1,162 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"go-image-builder/pkg/imageconfig"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ArtifactInfo describes the build whose artifacts are published. URL templates refer to
+// its fields, as in "https://boot.example.com/images/{{.Name}}/{{.Tag}}".
+type ArtifactInfo struct {
+	Name          string
+	Tag           string
+	KernelVersion string
+	// BootArgs are the kernel arguments the configured live overlay or NFS root needs,
+	// appended to the generated command lines
+	BootArgs string
+}
+
+// HTTP uploads files with PUT into the directory the configured URL renders to. Missing
+// directories are created with MKCOL when the server is a WebDAV server that rejects the
+// upload with 409 Conflict.
+func HTTP(ctx context.Context, cfg *imageconfig.HTTPPublish, info ArtifactInfo, files []string) error {
+	tmpl, err := template.New("url").Option("missingkey=error").Parse(cfg.URL)
+	if err != nil {
+		return fmt.Errorf("failed to parse publish_http.url template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, info); err != nil {
+		return fmt.Errorf("failed to render publish_http.url template: %w", err)
+	}
+	base, err := url.Parse(strings.TrimSuffix(buf.String(), "/"))
+	if err != nil {
+		return fmt.Errorf("invalid publish_http.url %s: %w", buf.String(), err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return fmt.Errorf("invalid publish_http.url %s: must be an http or https URL", base)
+	}
+
+	u := &uploader{ctx: ctx, cfg: cfg, client: &http.Client{}}
+	for _, file := range files {
+		dest := *base
+		dest.Path = path.Join(base.Path, filepath.Base(file))
+		log.Infof("Uploading %s to %s", file, dest.Redacted())
+		if err := u.put(file, &dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploader sends authenticated requests to the publishing server
+type uploader struct {
+	ctx    context.Context
+	cfg    *imageconfig.HTTPPublish
+	client *http.Client
+}
+
+// put uploads a file, creating the directories above it if the server asks for them
+func (u *uploader) put(file string, dest *url.URL) error {
+	status, err := u.upload(file, dest)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusConflict {
+		if err := u.mkcol(dest); err != nil {
+			return err
+		}
+		if status, err = u.upload(file, dest); err != nil {
+			return err
+		}
+	}
+	if status < 200 || status >= 300 {
+		return fmt.Errorf("failed to upload %s to %s: server returned %d %s", file, dest.Redacted(), status, http.StatusText(status))
+	}
+	return nil
+}
+
+// upload sends a file with PUT and returns the response status
+func (u *uploader) upload(file string, dest *url.URL) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", file, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat %s: %w", file, err)
+	}
+
+	req, err := u.request(http.MethodPut, dest, f)
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return u.do(req)
+}
+
+// mkcol creates every missing directory above dest, from the top down
+func (u *uploader) mkcol(dest *url.URL) error {
+	var dirs []string
+	for dir := path.Dir(dest.Path); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+	}
+	for _, dir := range dirs {
+		col := *dest
+		col.Path = dir + "/"
+		req, err := u.request("MKCOL", &col, nil)
+		if err != nil {
+			return err
+		}
+		status, err := u.do(req)
+		if err != nil {
+			return err
+		}
+		// 405 Method Not Allowed is returned for collections that already exist
+		if status != http.StatusCreated && status != http.StatusMethodNotAllowed {
+			return fmt.Errorf("failed to create directory %s: server returned %d %s", col.Redacted(), status, http.StatusText(status))
+		}
+	}
+	return nil
+}
+
+// request creates a request carrying the configured credentials
+func (u *uploader) request(method string, dest *url.URL, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(u.ctx, method, dest.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if u.cfg.TokenEnv != "" {
+		if token := os.Getenv(u.cfg.TokenEnv); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	} else if u.cfg.Username != "" {
+		req.SetBasicAuth(u.cfg.Username, os.Getenv(u.cfg.PasswordEnv))
+	}
+	return req, nil
+}
+
+// do sends a request and returns the response status, discarding the body
+func (u *uploader) do(req *http.Request) (int, error) {
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to call %s: %w", req.URL.Redacted(), err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
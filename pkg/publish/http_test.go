@@ -0,0 +1,103 @@
+package publish
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+)
+
+// davServer is a minimal WebDAV server that rejects uploads into missing collections
+type davServer struct {
+	mu    sync.Mutex
+	dirs  map[string]bool
+	files map[string]string
+}
+
+func (d *davServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != "builder" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case "MKCOL":
+		dir := path.Clean(r.URL.Path)
+		if d.dirs[dir] {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !d.dirs[path.Dir(dir)] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		d.dirs[dir] = true
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodPut:
+		if !d.dirs[path.Dir(r.URL.Path)] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		d.files[r.URL.Path] = string(data)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	dav := &davServer{dirs: map[string]bool{"/": true, "/images": true}, files: make(map[string]string)}
+	server := httptest.NewServer(dav)
+	defer server.Close()
+
+	dir := t.TempDir()
+	var files []string
+	for _, name := range []string{"kernel", "initrd.img"} {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	t.Setenv("TEST_PUBLISH_PASSWORD", "secret")
+
+	cfg := &imageconfig.HTTPPublish{
+		URL:         server.URL + "/images/{{.Name}}/{{.Tag}}/",
+		Username:    "builder",
+		PasswordEnv: "TEST_PUBLISH_PASSWORD",
+	}
+	info := ArtifactInfo{Name: "compute", Tag: "v1"}
+	if err := HTTP(context.Background(), cfg, info, files); err != nil {
+		t.Fatalf("HTTP() error = %v", err)
+	}
+	for _, name := range []string{"kernel", "initrd.img"} {
+		if got := dav.files["/images/compute/v1/"+name]; got != name {
+			t.Errorf("uploaded %s = %q, want %q", name, got, name)
+		}
+	}
+
+	// Uploading again overwrites the files in the existing collections
+	if err := HTTP(context.Background(), cfg, info, files); err != nil {
+		t.Errorf("HTTP() into existing directories error = %v", err)
+	}
+
+	t.Setenv("TEST_PUBLISH_PASSWORD", "wrong")
+	if err := HTTP(context.Background(), cfg, info, files); err == nil {
+		t.Error("HTTP() with wrong credentials succeeded, want error")
+	}
+
+	cfg.URL = "ftp://example.com/{{.Name}}"
+	if err := HTTP(context.Background(), cfg, info, files); err == nil {
+		t.Error("HTTP() to an ftp URL succeeded, want error")
+	}
+}
@@ -0,0 +1,149 @@
+package publish
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"go-image-builder/pkg/imageconfig"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultPXETemplate is the pxelinux configuration written when no template is configured
+const defaultPXETemplate = `DEFAULT {{.Name}}
+LABEL {{.Name}}
+  KERNEL {{.Kernel}}
+  INITRD {{.Initrd}}
+  APPEND {{.Params}}
+`
+
+// PXEData holds the values pxelinux configuration templates can refer to. Kernel and Initrd
+// are relative to the TFTP root, as pxelinux requests them.
+type PXEData struct {
+	ArtifactInfo
+	Group  string
+	Kernel string
+	Initrd string
+	Params string
+}
+
+// TFTP copies the kernel and initrd into <root>/<group>/<name>/ for every node group and
+// points the pxelinux configuration of the group's MAC addresses at them. Files are replaced
+// atomically, so nodes booting during the copy get either the old or the new image.
+func TFTP(cfg *imageconfig.TFTPPublish, info ArtifactInfo, kernel, initrd string) error {
+	text := defaultPXETemplate
+	if cfg.Template != "" {
+		data, err := os.ReadFile(cfg.Template)
+		if err != nil {
+			return fmt.Errorf("failed to read pxelinux template: %w", err)
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("pxelinux").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return fmt.Errorf("failed to parse pxelinux template %s: %w", cfg.Template, err)
+	}
+
+	for _, group := range cfg.Groups {
+		dir := path.Join(group.Name, info.Name)
+		data := PXEData{
+			ArtifactInfo: info,
+			Group:        group.Name,
+			Kernel:       path.Join(dir, "vmlinuz"),
+			Initrd:       path.Join(dir, "initrd.img"),
+			Params:       appendArgs(group.Params, info.BootArgs),
+		}
+		log.Infof("Copying boot files of %s to %s", group.Name, filepath.Join(cfg.Root, dir))
+		if err := os.MkdirAll(filepath.Join(cfg.Root, dir), 0755); err != nil {
+			return fmt.Errorf("failed to create TFTP directory: %w", err)
+		}
+		if err := copyAtomic(kernel, filepath.Join(cfg.Root, data.Kernel)); err != nil {
+			return err
+		}
+		if err := copyAtomic(initrd, filepath.Join(cfg.Root, data.Initrd)); err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return fmt.Errorf("failed to render pxelinux template for group %s: %w", group.Name, err)
+		}
+		for _, mac := range group.Macs {
+			name, err := pxeConfigName(mac)
+			if err != nil {
+				return err
+			}
+			dest := filepath.Join(cfg.Root, "pxelinux.cfg", name)
+			if err := writeAtomic(dest, buf.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pxeConfigName returns the name pxelinux looks up the configuration of a MAC address by,
+// the ARP hardware type 01 followed by the address in lowercase with dashes
+func pxeConfigName(mac string) (string, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return "", fmt.Errorf("invalid MAC address %s: %w", mac, err)
+	}
+	return "01-" + strings.ReplaceAll(hw.String(), ":", "-"), nil
+}
+
+// copyAtomic copies src to dest through a temporary file renamed into place
+func copyAtomic(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+	return replaceFile(dest, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
+
+// writeAtomic writes data to dest through a temporary file renamed into place
+func writeAtomic(dest string, data []byte) error {
+	return replaceFile(dest, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// replaceFile writes a temporary file next to dest with write and renames it to dest
+func replaceFile(dest string, write func(io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directory of %s: %w", dest, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", dest, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", dest, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", dest, err)
+	}
+	// TFTP servers commonly run as an unprivileged user
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set permissions of %s: %w", dest, err)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", dest, err)
+	}
+	return nil
+}
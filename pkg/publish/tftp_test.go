@@ -0,0 +1,75 @@
+package publish
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-image-builder/pkg/imageconfig"
+)
+
+func TestTFTP(t *testing.T) {
+	dir := t.TempDir()
+	kernel := filepath.Join(dir, "kernel")
+	initrd := filepath.Join(dir, "initrd.img")
+	for path, content := range map[string]string{kernel: "kernel", initrd: "initrd"} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	template := filepath.Join(dir, "pxelinux.tmpl")
+	if err := os.WriteFile(template, []byte("{{.Group}} {{.Tag}} {{.Kernel}} {{.Initrd}} {{.Params}}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	cfg := &imageconfig.TFTPPublish{
+		Root: root,
+		Groups: []imageconfig.TFTPGroup{
+			{Name: "compute", Macs: []string{"AA:BB:CC:DD:EE:01", "aa-bb-cc-dd-ee-02"}, Params: "console=ttyS0"},
+			{Name: "login", Macs: []string{"aa:bb:cc:dd:ee:03"}},
+		},
+	}
+	info := ArtifactInfo{Name: "rocky", Tag: "v1"}
+
+	if err := TFTP(cfg, info, kernel, initrd); err != nil {
+		t.Fatalf("TFTP() error = %v", err)
+	}
+	want := map[string]string{
+		"compute/rocky/vmlinuz":             "kernel",
+		"login/rocky/initrd.img":            "initrd",
+		"pxelinux.cfg/01-aa-bb-cc-dd-ee-01": "DEFAULT rocky\nLABEL rocky\n  KERNEL compute/rocky/vmlinuz\n  INITRD compute/rocky/initrd.img\n  APPEND console=ttyS0\n",
+		"pxelinux.cfg/01-aa-bb-cc-dd-ee-02": "DEFAULT rocky\nLABEL rocky\n  KERNEL compute/rocky/vmlinuz\n  INITRD compute/rocky/initrd.img\n  APPEND console=ttyS0\n",
+		"pxelinux.cfg/01-aa-bb-cc-dd-ee-03": "DEFAULT rocky\nLABEL rocky\n  KERNEL login/rocky/vmlinuz\n  INITRD login/rocky/initrd.img\n  APPEND \n",
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Errorf("TFTP() did not write %s: %v", name, err)
+			continue
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+
+	cfg.Template = template
+	if err := TFTP(cfg, info, kernel, initrd); err != nil {
+		t.Fatalf("TFTP() with template error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(root, "pxelinux.cfg", "01-aa-bb-cc-dd-ee-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "compute v1 compute/rocky/vmlinuz compute/rocky/initrd.img console=ttyS0\n" {
+		t.Errorf("templated pxelinux config = %q", got)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(root, "pxelinux.cfg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("pxelinux.cfg has %d files, want 3 without temporary files", len(entries))
+	}
+}
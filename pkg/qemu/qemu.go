@@ -0,0 +1,204 @@
+package qemu
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultCmdline is the kernel command line used when booting a squashfs live image
+const DefaultCmdline = "console=ttyS0 root=live:/dev/vda rd.live.image rd.live.ram=0 selinux=0"
+
+// Config describes a smoke test boot of an image
+type Config struct {
+	Kernel   string
+	Initrd   string
+	Squashfs string
+	Cmdline  string
+	Memory   string
+	CPUs     int
+	// Expect is the console output that signals a successful boot
+	Expect string
+	// Timeout bounds how long to wait for Expect to appear
+	Timeout time.Duration
+	// CheckScript is run on the host once the expected output is seen
+	CheckScript string
+	// SSHPort is the host port forwarded to port 22 of the guest
+	SSHPort int
+	// SerialLog is the path the console output is written to
+	SerialLog string
+	Binary    string
+}
+
+// Result holds the outcome of a smoke test
+type Result struct {
+	Booted   bool
+	Passed   bool
+	Duration time.Duration
+}
+
+// args builds the qemu command line for the configuration
+func (c *Config) args() []string {
+	args := []string{
+		"-machine", "accel=kvm:tcg",
+		"-cpu", "max",
+		"-m", c.Memory,
+		"-smp", strconv.Itoa(c.CPUs),
+		"-nographic",
+		"-no-reboot",
+		"-serial", "stdio",
+		"-monitor", "none",
+		"-kernel", c.Kernel,
+		"-initrd", c.Initrd,
+		"-append", c.Cmdline,
+	}
+
+	if c.Squashfs != "" {
+		args = append(args, "-drive", fmt.Sprintf("file=%s,format=raw,if=virtio,readonly=on", c.Squashfs))
+	}
+
+	netdev := "user,id=net0"
+	if c.SSHPort > 0 {
+		netdev += fmt.Sprintf(",hostfwd=tcp:127.0.0.1:%d-:22", c.SSHPort)
+	}
+	args = append(args, "-netdev", netdev, "-device", "virtio-net-pci,netdev=net0")
+	return args
+}
+
+// Run boots the image, waits for the expected console output and runs the optional check script
+func Run(ctx context.Context, c Config) (*Result, error) {
+	if c.Binary == "" {
+		c.Binary = "qemu-kvm"
+		if _, err := exec.LookPath(c.Binary); err != nil {
+			c.Binary = "qemu-system-x86_64"
+		}
+	}
+	if c.Cmdline == "" {
+		c.Cmdline = DefaultCmdline
+	}
+	if c.Memory == "" {
+		c.Memory = "2048"
+	}
+	if c.CPUs <= 0 {
+		c.CPUs = 2
+	}
+
+	// The default command line boots the squashfs attached as /dev/vda
+	if c.Squashfs == "" && c.Cmdline == DefaultCmdline {
+		return nil, fmt.Errorf("a squashfs is required to boot with the default kernel command line")
+	}
+
+	for _, path := range []string{c.Kernel, c.Initrd, c.Squashfs} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("boot artifact %s is not accessible: %w", path, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.Binary, c.args()...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	cmd.Stderr = cmd.Stdout
+
+	var serial io.Writer = io.Discard
+	if c.SerialLog != "" {
+		logFile, err := os.Create(c.SerialLog)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create serial log: %w", err)
+		}
+		defer logFile.Close()
+		serial = logFile
+	}
+
+	log.Debugf("Executing: %s %s", c.Binary, strings.Join(c.args(), " "))
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", c.Binary, err)
+	}
+
+	// Scan the console output for the expected string, then wait for qemu to exit once the
+	// console is closed
+	booted := make(chan struct{})
+	exited := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		found := false
+		for scanner.Scan() {
+			line := scanner.Text()
+			fmt.Fprintln(serial, line)
+			log.Debugf("[console] %s", line)
+			if !found && strings.Contains(line, c.Expect) {
+				found = true
+				close(booted)
+			}
+		}
+		exited <- cmd.Wait()
+	}()
+	defer func() {
+		cmd.Process.Kill()
+		<-exited
+	}()
+
+	result := &Result{}
+	select {
+	case <-booted:
+	case err := <-exited:
+		// Put the result back for the deferred wait
+		exited <- err
+		select {
+		case <-booted:
+			// The expected output was seen right before qemu exited
+		default:
+			result.Duration = time.Since(start)
+			if ctx.Err() != nil {
+				log.Errorf("Timed out after %s waiting for '%s' on the console", c.Timeout, c.Expect)
+				return result, nil
+			}
+			if err == nil {
+				err = fmt.Errorf("exit status 0")
+			}
+			return result, fmt.Errorf("%s exited before the image booted: %w", c.Binary, err)
+		}
+	case <-ctx.Done():
+		result.Duration = time.Since(start)
+		log.Errorf("Timed out after %s waiting for '%s' on the console", c.Timeout, c.Expect)
+		return result, nil
+	}
+	result.Booted = true
+	log.Infof("Image booted in %s", time.Since(start).Round(time.Second))
+
+	if c.CheckScript != "" {
+		log.Infof("Running check script: %s", c.CheckScript)
+		check := exec.CommandContext(ctx, c.CheckScript)
+		check.Env = append(os.Environ(),
+			fmt.Sprintf("QEMU_SSH_PORT=%d", c.SSHPort),
+			fmt.Sprintf("QEMU_SERIAL_LOG=%s", c.SerialLog),
+		)
+		output, err := check.CombinedOutput()
+		if err != nil {
+			log.Errorf("Check script failed: %v\nOutput: %s", err, string(output))
+			result.Duration = time.Since(start)
+			return result, nil
+		}
+		log.Debugf("Check script output: %s", string(output))
+	}
+
+	result.Passed = true
+	result.Duration = time.Since(start)
+	return result, nil
+}
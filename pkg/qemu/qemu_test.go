@@ -0,0 +1,74 @@
+package qemu
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeQemu writes a script standing in for qemu and returns a config booting with it
+func fakeQemu(t *testing.T, script string) Config {
+	t.Helper()
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "qemu")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"vmlinuz", "initrd.img", "image.squashfs"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return Config{
+		Binary:   binary,
+		Kernel:   filepath.Join(dir, "vmlinuz"),
+		Initrd:   filepath.Join(dir, "initrd.img"),
+		Squashfs: filepath.Join(dir, "image.squashfs"),
+		Expect:   "login:",
+		Timeout:  10 * time.Second,
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		script     string
+		wantBooted bool
+		wantErr    bool
+	}{
+		{name: "boots", script: "echo 'localhost login:'\nexec sleep 10\n", wantBooted: true},
+		{name: "boots and exits", script: "echo 'localhost login:'\n", wantBooted: true},
+		{name: "fails early", script: "echo 'could not open kernel' >&2\nexit 1\n", wantErr: true},
+		{name: "exits early", script: "echo 'reboot: Power down'\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			result, err := Run(context.Background(), fakeQemu(t, tt.script))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if time.Since(start) > 5*time.Second {
+				t.Errorf("Run() took %s, want it to return once qemu exits", time.Since(start))
+			}
+			if err == nil && result.Booted != tt.wantBooted {
+				t.Errorf("Run() booted = %v, want %v", result.Booted, tt.wantBooted)
+			}
+		})
+	}
+}
+
+func TestRunWithoutSquashfs(t *testing.T) {
+	c := fakeQemu(t, "echo 'localhost login:'\n")
+	c.Squashfs = ""
+	if _, err := Run(context.Background(), c); err == nil {
+		t.Error("Run() without a squashfs and the default command line succeeded, want error")
+	}
+
+	c.Cmdline = "console=ttyS0 root=/dev/nfs"
+	if _, err := Run(context.Background(), c); err != nil {
+		t.Errorf("Run() with a custom command line error = %v", err)
+	}
+}
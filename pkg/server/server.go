@@ -0,0 +1,794 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-image-builder/internal/redact"
+	"go-image-builder/pkg/builder"
+	"go-image-builder/pkg/imageconfig"
+	"go-image-builder/pkg/progress"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Build states
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// maxConfigSize limits the size of a submitted configuration
+const maxConfigSize = 1 << 20
+
+// Build tracks a build submitted to the server. Stage is the stage a running build is in and
+// Progress the percentage of its stages done.
+type Build struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	Stage      string    `json:"stage,omitempty"`
+	Progress   int       `json:"progress"`
+	Error      string    `json:"error,omitempty"`
+	Submitted  time.Time `json:"submitted"`
+	Started    time.Time `json:"started,omitempty"`
+	Finished   time.Time `json:"finished,omitempty"`
+	Artifacts  []string  `json:"artifacts,omitempty"`
+	config     *imageconfig.Config
+	workDir    string
+	squashfs   bool
+	initrd     bool
+	logs       []string
+	logUpdated chan struct{}
+}
+
+// serverLog marks the server's own log entries, which are not part of any build log
+var serverLog = log.WithField("component", "server")
+
+// Server is an HTTP service that accepts image configurations and builds them. Builds run one
+// at a time because the builder logs through the global logger and shares buildah storage.
+type Server struct {
+	workDir            string
+	queue              chan *Build
+	token              string
+	filesDir           string
+	allowNotifications bool
+
+	mu     sync.Mutex
+	builds map[string]*Build
+}
+
+// NewServer creates a server that builds into subdirectories of workDir
+func NewServer(workDir string, queueSize int) (*Server, error) {
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %w", err)
+	}
+
+	s := &Server{
+		workDir: workDir,
+		queue:   make(chan *Build, queueSize),
+		builds:  make(map[string]*Build),
+	}
+	return s, nil
+}
+
+// SetToken sets the bearer token every request must carry. No token is required if it is empty.
+func (s *Server) SetToken(token string) {
+	s.token = token
+}
+
+// SetFilesDir sets the directory that local files referenced by submitted configurations,
+// such as copyfiles sources, must be in. Configurations referencing local files are rejected
+// if it is empty.
+func (s *Server) SetFilesDir(dir string) {
+	s.filesDir = dir
+}
+
+// SetAllowNotifications sets whether submitted configurations may send notifications, which
+// the server posts to the URLs they name
+func (s *Server) SetAllowNotifications(allow bool) {
+	s.allowNotifications = allow
+}
+
+// Handler returns the HTTP handler serving the REST API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /builds", s.handleSubmit)
+	mux.HandleFunc("GET /builds", s.handleListBuilds)
+	mux.HandleFunc("GET /builds/{id}", s.handleGetBuild)
+	mux.HandleFunc("GET /builds/{id}/logs", s.handleLogs)
+	mux.HandleFunc("GET /builds/{id}/artifacts/{name}", s.handleDownload)
+	mux.HandleFunc("GET /artifacts", s.handleListArtifacts)
+	if s.token == "" {
+		return mux
+	}
+	return s.authenticate(mux)
+}
+
+// authenticate rejects requests without the server token
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Run processes queued builds until stop is closed
+func (s *Server) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case b := <-s.queue:
+			s.runBuild(b)
+		}
+	}
+}
+
+// runBuild executes a single build and records its outcome
+func (s *Server) runBuild(b *Build) {
+	s.mu.Lock()
+	b.Status = StatusRunning
+	b.Started = time.Now()
+	s.mu.Unlock()
+
+	hook := &buildLog{s: s, b: b}
+	log.AddHook(hook)
+	err := s.build(b)
+	removeHook(hook)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b.Finished = time.Now()
+	if err != nil {
+		b.Status = StatusFailed
+		b.Error = err.Error()
+		b.appendLog(fmt.Sprintf("Build failed: %v", err))
+	} else {
+		b.Status = StatusSucceeded
+		b.Progress = 100
+		b.Artifacts = listArtifacts(b.workDir)
+	}
+	b.Stage = ""
+	close(b.logUpdated)
+}
+
+// build runs the builder for a submitted configuration
+func (s *Server) build(b *Build) error {
+	if err := os.MkdirAll(b.workDir, 0755); err != nil {
+		return fmt.Errorf("failed to create build directory: %w", err)
+	}
+
+	bldr, err := builder.New(b.config,
+		builder.WithWorkDir(b.workDir),
+		builder.WithArtifacts(b.squashfs, b.initrd),
+		builder.WithProgress(&buildProgress{s: s, b: b}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create builder: %w", err)
+	}
+	if _, err := bldr.Build(context.Background()); err != nil {
+		return fmt.Errorf("failed to build image: %w", err)
+	}
+	return nil
+}
+
+// buildLog is a log.Hook capturing the log of a build while it runs. The builder logs through
+// the global logger, so the hook is only installed for the duration of the build.
+type buildLog struct {
+	s *Server
+	b *Build
+}
+
+// Levels implements log.Hook
+func (h *buildLog) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire implements log.Hook, appending the log entry to the build unless the server logged it
+func (h *buildLog) Fire(entry *log.Entry) error {
+	if _, ok := entry.Data["component"]; ok {
+		return nil
+	}
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	h.b.appendLog(fmt.Sprintf("%s [%s] %s", entry.Time.Format(time.RFC3339), entry.Level, redact.String(entry.Message)))
+	return nil
+}
+
+// buildProgress records the stage of a build in its status. Everything else is logged, and
+// so ends up in the build log.
+type buildProgress struct {
+	progress.Log
+	s *Server
+	b *Build
+}
+
+// Stage implements progress.Reporter
+func (p *buildProgress) Stage(name string, n, total int) {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+	p.b.Stage = name
+	p.b.Progress = (n - 1) * 100 / total
+}
+
+// removeHook uninstalls a hook from the global logger
+func removeHook(hook log.Hook) {
+	hooks := make(log.LevelHooks)
+	for level, levelHooks := range log.StandardLogger().ReplaceHooks(make(log.LevelHooks)) {
+		for _, h := range levelHooks {
+			if h != hook {
+				hooks[level] = append(hooks[level], h)
+			}
+		}
+	}
+	log.StandardLogger().ReplaceHooks(hooks)
+}
+
+// appendLog records a log line and wakes any log streams. The caller must hold the server lock.
+func (b *Build) appendLog(line string) {
+	b.logs = append(b.logs, line)
+	close(b.logUpdated)
+	b.logUpdated = make(chan struct{})
+}
+
+// handleSubmit accepts a YAML configuration and queues a build for it
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxConfigSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("failed to read request body: %w", err))
+		return
+	}
+
+	config, err := imageconfig.ParseConfig(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// Every variant is checked and built on its own, with its own files and parent
+	configs, err := config.Expand()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("failed to expand variants: %w", err))
+		return
+	}
+	for _, config := range configs {
+		if err := s.checkLocalFiles(config); err != nil {
+			writeError(w, http.StatusBadRequest, variantError(config, err))
+			return
+		}
+		if err := s.checkOptions(config); err != nil {
+			writeError(w, http.StatusBadRequest, variantError(config, err))
+			return
+		}
+	}
+
+	squashfs, err := boolParam(r, "squashfs", false)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	initrd, err := boolParam(r, "initrd", true)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	builds := make([]*Build, 0, len(configs))
+	for _, config := range configs {
+		id, err := newBuildID()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		builds = append(builds, &Build{
+			ID:         id,
+			Name:       config.Options.Name,
+			Status:     StatusQueued,
+			Submitted:  time.Now(),
+			config:     config,
+			workDir:    filepath.Join(s.workDir, id),
+			squashfs:   squashfs,
+			initrd:     initrd,
+			logUpdated: make(chan struct{}),
+		})
+	}
+
+	// The builds of all variants are queued, or none. Only submissions add to the queue,
+	// and they hold the lock, so the free space cannot shrink before they are queued.
+	s.mu.Lock()
+	if cap(s.queue)-len(s.queue) < len(builds) {
+		s.mu.Unlock()
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("build queue is full"))
+		return
+	}
+	snapshots := make([]Build, 0, len(builds))
+	for _, b := range builds {
+		s.queue <- b
+		s.builds[b.ID] = b
+		snapshots = append(snapshots, *b)
+	}
+	s.mu.Unlock()
+
+	for _, b := range builds {
+		serverLog.Infof("Queued build %s for image %s", b.ID, b.Name)
+	}
+	// Configurations with variants are answered with the build of every variant
+	if len(config.Variants) > 0 {
+		writeJSON(w, http.StatusAccepted, snapshots)
+		return
+	}
+	w.Header().Set("Location", "/builds/"+snapshots[0].ID)
+	writeJSON(w, http.StatusAccepted, snapshots[0])
+}
+
+// variantError prefixes an error with the variant of the configuration it is about, if any
+func variantError(config *imageconfig.Config, err error) error {
+	if config.Variant == nil {
+		return err
+	}
+	return fmt.Errorf("variant %s: %w", config.Variant.Name, err)
+}
+
+// handleListBuilds returns all builds, most recent first
+func (s *Server) handleListBuilds(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	builds := make([]Build, 0, len(s.builds))
+	for _, b := range s.builds {
+		builds = append(builds, *b)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].Submitted.After(builds[j].Submitted)
+	})
+	writeJSON(w, http.StatusOK, builds)
+}
+
+// handleGetBuild returns the status of a single build
+func (s *Server) handleGetBuild(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	b, ok := s.builds[r.PathValue("id")]
+	var snapshot Build
+	if ok {
+		snapshot = *b
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("build not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// handleLogs streams the log of a build as server-sent events until the build finishes
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	b, ok := s.builds[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("build not found"))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	sent := 0
+	for {
+		s.mu.Lock()
+		lines := b.logs[sent:]
+		updated := b.logUpdated
+		done := b.Status == StatusSucceeded || b.Status == StatusFailed
+		status := b.Status
+		s.mu.Unlock()
+
+		for _, line := range lines {
+			writeEvent(w, "log", line)
+		}
+		sent += len(lines)
+
+		if done {
+			writeEvent(w, "status", status)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-updated:
+		}
+	}
+}
+
+// writeEvent writes a server-sent event, with a data line for every line of data
+func writeEvent(w io.Writer, event, data string) {
+	fmt.Fprintf(w, "event: %s\n", event)
+	data = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(data)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+// artifactEntry describes the artifacts of a completed build
+type artifactEntry struct {
+	Build     string   `json:"build"`
+	Name      string   `json:"name"`
+	Finished  string   `json:"finished"`
+	Artifacts []string `json:"artifacts"`
+}
+
+// handleListArtifacts returns the artifacts of all successful builds
+func (s *Server) handleListArtifacts(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	entries := []artifactEntry{}
+	for _, b := range s.builds {
+		if b.Status != StatusSucceeded {
+			continue
+		}
+		entries = append(entries, artifactEntry{
+			Build:     b.ID,
+			Name:      b.Name,
+			Finished:  b.Finished.Format(time.RFC3339),
+			Artifacts: b.Artifacts,
+		})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Finished > entries[j].Finished
+	})
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handleDownload serves an artifact of a successful build
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	b, ok := s.builds[r.PathValue("id")]
+	var artifacts []string
+	var workDir string
+	if ok {
+		artifacts = b.Artifacts
+		workDir = b.workDir
+	}
+	s.mu.Unlock()
+
+	name := r.PathValue("name")
+	for _, artifact := range artifacts {
+		if artifact == name {
+			http.ServeFile(w, r, filepath.Join(workDir, artifact))
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("artifact not found"))
+}
+
+// listArtifacts returns the regular files left in a build directory
+func listArtifacts(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var artifacts []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			artifacts = append(artifacts, entry.Name())
+		}
+	}
+	return artifacts
+}
+
+// boolParam reads an optional boolean query parameter
+func boolParam(r *http.Request, key string, def bool) (bool, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	return b, nil
+}
+
+// newBuildID returns a random identifier for a build
+func newBuildID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate build id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// copyOpts are the cp options a submitted copyfiles entry may use. Options naming further
+// files, a target directory or dereferencing links could reach outside the files directory.
+var copyOpts = map[string]bool{
+	"-r": true, "-R": true, "--recursive": true,
+	"-P": true, "--no-dereference": true,
+	"-n": true, "--no-clobber": true,
+	"-u": true, "--update": true,
+	"-p": true, "-d": true,
+	"-x": true, "--one-file-system": true,
+}
+
+// checkLocalFiles restricts the local files a submitted configuration reads to the files
+// directory. Copyfiles sources, CA certificates, archive images and verification keys are
+// resolved against it, so relative paths are relative to the files directory.
+func (s *Server) checkLocalFiles(config *imageconfig.Config) error {
+	if err := s.checkCopyFiles("copyfiles", config.CopyFiles); err != nil {
+		return err
+	}
+	for i, cert := range config.CACerts {
+		path, err := s.localFile(cert)
+		if err != nil {
+			return fmt.Errorf("ca_certs[%d]: %w", i, err)
+		}
+		config.CACerts[i] = path
+	}
+
+	if config.KernelModules != nil {
+		for i, src := range config.KernelModules.Sources {
+			path, err := s.localFile(src)
+			if err != nil {
+				return fmt.Errorf("kernel_modules.sources[%d]: %w", i, err)
+			}
+			config.KernelModules.Sources[i] = path
+		}
+	}
+	if config.GPU != nil && config.GPU.Runfile != "" {
+		path, err := s.localFile(config.GPU.Runfile)
+		if err != nil {
+			return fmt.Errorf("gpu.runfile: %w", err)
+		}
+		config.GPU.Runfile = path
+	}
+	for i, test := range config.Tests {
+		if test.Goss == "" {
+			continue
+		}
+		path, err := s.localFile(test.Goss)
+		if err != nil {
+			return fmt.Errorf("tests[%d].goss: %w", i, err)
+		}
+		config.Tests[i].Goss = path
+	}
+
+	if config.BootScripts != nil {
+		for i, script := range config.BootScripts.Templates {
+			path, err := s.localFile(script.Src)
+			if err != nil {
+				return fmt.Errorf("boot_scripts.templates[%d].src: %w", i, err)
+			}
+			config.BootScripts.Templates[i].Src = path
+		}
+	}
+
+	// Generators run on the server, so only programs from the files directory are allowed
+	for i, generator := range config.ArtifactGenerators {
+		path, err := s.localFile(generator.Command)
+		if err != nil {
+			return fmt.Errorf("artifact_generators[%d].command: %w", i, err)
+		}
+		config.ArtifactGenerators[i].Command = path
+	}
+
+	var err error
+	if config.Options.Parent, err = s.localArchive(config.Options.Parent); err != nil {
+		return fmt.Errorf("options.parent: %w", err)
+	}
+	if config.Options.BootstrapImage, err = s.localArchive(config.Options.BootstrapImage); err != nil {
+		return fmt.Errorf("options.bootstrap_image: %w", err)
+	}
+	if stage := config.BuildStage; stage != nil {
+		if err := s.checkCopyFiles("build_stage.copyfiles", stage.CopyFiles); err != nil {
+			return err
+		}
+		if stage.Parent, err = s.localArchive(stage.Parent); err != nil {
+			return fmt.Errorf("build_stage.parent: %w", err)
+		}
+	}
+	if config.Options.Harden.Tailoring != "" {
+		if config.Options.Harden.Tailoring, err = s.localFile(config.Options.Harden.Tailoring); err != nil {
+			return fmt.Errorf("options.harden.tailoring: %w", err)
+		}
+	}
+	if seed := config.Seed; seed != nil {
+		for _, f := range []struct {
+			name string
+			path *string
+		}{
+			{"user_data", &seed.UserData},
+			{"meta_data", &seed.MetaData},
+			{"network_config", &seed.NetworkConfig},
+			{"ignition", &seed.Ignition},
+		} {
+			if *f.path == "" {
+				continue
+			}
+			if *f.path, err = s.localFile(*f.path); err != nil {
+				return fmt.Errorf("seed.%s: %w", f.name, err)
+			}
+		}
+	}
+	for i, overlay := range config.NodeOverlays {
+		if err := s.checkCopyFiles(fmt.Sprintf("node_overlays[%d].files", i), overlay.Files); err != nil {
+			return err
+		}
+	}
+	for i, merge := range config.MergeImages {
+		if config.MergeImages[i].Image, err = s.localArchive(merge.Image); err != nil {
+			return fmt.Errorf("merge_images[%d].image: %w", i, err)
+		}
+	}
+
+	verify := &config.Options.ParentVerify
+	if verify.Policy != "" {
+		if verify.Policy, err = s.localFile(verify.Policy); err != nil {
+			return fmt.Errorf("options.parent_verify.policy: %w", err)
+		}
+	}
+	// Keys may also be KMS or other provider URIs, which cosign resolves itself
+	if verify.CosignKey != "" && !strings.Contains(verify.CosignKey, "://") {
+		if verify.CosignKey, err = s.localFile(verify.CosignKey); err != nil {
+			return fmt.Errorf("options.parent_verify.cosign_key: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkOptions rejects the options of a submitted configuration that reach outside its build
+// on the server, such as host paths it would write to or mount into the build container
+func (s *Server) checkOptions(config *imageconfig.Config) error {
+	// Submitted configurations are not read from a git checkout
+	if strings.Contains(config.Options.PublishTags, ".GitSHA") {
+		return fmt.Errorf("options.publish_tags: GitSHA is not available for submitted configurations")
+	}
+	// Submitted configurations must not write to arbitrary paths on the server
+	if config.PublishTFTP != nil {
+		return fmt.Errorf("publish_tftp is not available for submitted configurations")
+	}
+	// Its credentials come from the server's environment, and would go to any URL
+	if config.PublishHTTP != nil {
+		return fmt.Errorf("publish_http is not available for submitted configurations")
+	}
+	// The boot script service token comes from the server's environment, ACCESS_TOKEN
+	// without token_env, and would go to any URL
+	if config.BSS != nil {
+		return fmt.Errorf("bss is not available for submitted configurations")
+	}
+	if len(config.Notifications) > 0 && !s.allowNotifications {
+		return fmt.Errorf("notifications are not available for submitted configurations")
+	}
+	// Header values from the environment would send the server's secrets to any URL
+	for i, n := range config.Notifications {
+		if len(n.HeadersEnv) > 0 {
+			return fmt.Errorf("notifications[%d].headers_env is not available for submitted configurations", i)
+		}
+	}
+	// Builds keep their temporary files in their own work directory
+	if config.Options.TempDir != "" {
+		return fmt.Errorf("options.temp_dir is not available for submitted configurations")
+	}
+	// Only the isolation of the buildah options stays within the build: the others add
+	// capabilities or host paths to its commands, or move container storage on the host
+	buildah := config.Options.Buildah
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"cap_add", len(buildah.CapAdd) > 0},
+		{"root", buildah.Root != ""},
+		{"runroot", buildah.RunRoot != ""},
+		{"storage_driver", buildah.StorageDriver != ""},
+		{"storage_opts", len(buildah.StorageOpts) > 0},
+		{"volumes", len(buildah.Volumes) > 0},
+		{"mounts", len(buildah.Mounts) > 0},
+	} {
+		if option.set {
+			return fmt.Errorf("options.buildah.%s is not available for submitted configurations", option.name)
+		}
+	}
+	return nil
+}
+
+// checkCopyFiles restricts the sources of copied files to the files directory and their
+// options to those that cannot copy other files
+func (s *Server) checkCopyFiles(field string, files []imageconfig.CopyFile) error {
+	for i, cf := range files {
+		path, err := s.localFile(cf.Src)
+		if err != nil {
+			return fmt.Errorf("%s[%d].src: %w", field, i, err)
+		}
+		files[i].Src = path
+		for _, opt := range cf.Opts {
+			if !copyOpts[opt] && !strings.HasPrefix(opt, "--preserve=") && !strings.HasPrefix(opt, "--no-preserve=") {
+				return fmt.Errorf("%s[%d].opts: option '%s' is not allowed", field, i, opt)
+			}
+		}
+	}
+	return nil
+}
+
+// localArchive restricts an oci: or docker-archive: image reference to the files directory.
+// Other references are returned unchanged.
+func (s *Server) localArchive(image string) (string, error) {
+	archive, ok := imageconfig.ParseArchive(image)
+	if !ok {
+		return image, nil
+	}
+	path, err := s.localFile(archive.Path)
+	if err != nil {
+		return "", err
+	}
+	if archive.Ref != "" {
+		return archive.Transport + ":" + path + ":" + archive.Ref, nil
+	}
+	return archive.Transport + ":" + path, nil
+}
+
+// localFile resolves a path against the files directory, following links, and fails if the
+// result is outside it
+func (s *Server) localFile(path string) (string, error) {
+	if s.filesDir == "" {
+		return "", fmt.Errorf("local files are not allowed, the server has no files directory")
+	}
+	root, err := filepath.EvalSymlinks(s.filesDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve files directory: %w", err)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%s is outside the files directory", path)
+	}
+	return resolved, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		serverLog.Debugf("Failed to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const testConfig = `options:
+  layer_type: base
+  name: test
+  pkg_manager: dnf
+`
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	s, err := NewServer(t.TempDir(), 4)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	return s
+}
+
+func TestAuthentication(t *testing.T) {
+	s := newTestServer(t)
+	s.SetToken("secret")
+	handler := s.Handler()
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{name: "no token", want: http.StatusUnauthorized},
+		{name: "wrong token", header: "Bearer wrong", want: http.StatusUnauthorized},
+		{name: "basic auth", header: "Basic secret", want: http.StatusUnauthorized},
+		{name: "valid token", header: "Bearer secret", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/builds", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("GET /builds status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubmitLocalFiles(t *testing.T) {
+	filesDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(filesDir, "motd"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(outside, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(filesDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		filesDir string
+		config   string
+		want     int
+	}{
+		{name: "no local files", config: testConfig, want: http.StatusAccepted},
+		{name: "no files directory", config: testConfig + "copyfiles:\n  - src: motd\n    dest: /etc/motd\n", want: http.StatusBadRequest},
+		{name: "relative source", filesDir: filesDir, config: testConfig + "copyfiles:\n  - src: motd\n    dest: /etc/motd\n", want: http.StatusAccepted},
+		{name: "absolute source outside", filesDir: filesDir, config: testConfig + "copyfiles:\n  - src: " + outside + "\n    dest: /etc/motd\n", want: http.StatusBadRequest},
+		{name: "relative source outside", filesDir: filesDir, config: testConfig + "copyfiles:\n  - src: ../../etc/passwd\n    dest: /etc/motd\n", want: http.StatusBadRequest},
+		{name: "link outside", filesDir: filesDir, config: testConfig + "copyfiles:\n  - src: link\n    dest: /etc/motd\n", want: http.StatusBadRequest},
+		{name: "extra source option", filesDir: filesDir, config: testConfig + "copyfiles:\n  - src: motd\n    dest: /etc/motd\n    opts: [\"/etc/shadow\"]\n", want: http.StatusBadRequest},
+		{name: "target directory option", filesDir: filesDir, config: testConfig + "copyfiles:\n  - src: motd\n    dest: /etc/motd\n    opts: [\"--target-directory=/etc\"]\n", want: http.StatusBadRequest},
+		{name: "tftp publishing", config: testConfig + "publish_tftp:\n  root: /srv/tftp\n  groups:\n    - name: compute\n      macs: [\"aa:bb:cc:dd:ee:ff\"]\n", want: http.StatusBadRequest},
+		{name: "goss file outside", filesDir: filesDir, config: testConfig + "tests:\n  - goss: " + outside + "\n", want: http.StatusBadRequest},
+		{name: "build stage source outside", filesDir: filesDir, config: testConfig + "build_stage:\n  copyfiles:\n    - src: " + outside + "\n      dest: /src\n  artifacts: [/opt/slurm]\n", want: http.StatusBadRequest},
+		{name: "generator outside", filesDir: filesDir, config: testConfig + "artifact_generators:\n  - name: vnfs\n    command: /usr/bin/true\n", want: http.StatusBadRequest},
+		{name: "archive parent outside", filesDir: filesDir, config: strings.Replace(testConfig, "options:\n", "options:\n  parent: docker-archive:"+outside+"\n", 1), want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.SetFilesDir(tt.filesDir)
+			req := httptest.NewRequest(http.MethodPost, "/builds", strings.NewReader(tt.config))
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("POST /builds status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestLogStream(t *testing.T) {
+	s := newTestServer(t)
+	b := &Build{ID: "build", Status: StatusRunning, logUpdated: make(chan struct{})}
+	s.builds[b.ID] = b
+
+	hook := &buildLog{s: s, b: b}
+	log.AddHook(hook)
+	log.Info("first line\nsecond line")
+	serverLog.Info("server message")
+	removeHook(hook)
+	log.Info("after the build")
+
+	b.Status = StatusSucceeded
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/builds/build/logs", nil))
+
+	body := rec.Body.String()
+	if strings.Contains(body, "server message") || strings.Contains(body, "after the build") {
+		t.Errorf("log stream contains entries from outside the build:\n%s", body)
+	}
+	if !strings.Contains(body, "data: second line\n") {
+		t.Errorf("log stream does not have a data line for every line of an entry:\n%s", body)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if line != "" && !strings.HasPrefix(line, "event: ") && !strings.HasPrefix(line, "data: ") {
+			t.Errorf("log stream has invalid line %q", line)
+		}
+	}
+	if !strings.HasSuffix(body, "event: status\ndata: succeeded\n\n") {
+		t.Errorf("log stream does not end with the build status:\n%s", body)
+	}
+}
+
+func TestSubmitRestrictedOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{name: "http publishing", config: testConfig + "publish_http:\n  url: https://attacker.example.com/\n  token_env: AWS_SECRET_ACCESS_KEY\n"},
+		{name: "bss registration", config: strings.Replace(testConfig, "options:\n", "options:\n  publish_registry: registry.example.com\n", 1) + "bss:\n  url: https://attacker.example.com/\n  hosts: [x1000c0s0b0n0]\n  kernel_url: http://boot/vmlinuz\n"},
+		{name: "temporary directory", config: strings.Replace(testConfig, "options:\n", "options:\n  temp_dir: /etc/cron.d\n", 1)},
+		{name: "notification", config: testConfig + "notifications:\n  - type: webhook\n    url: https://hooks.example.com/\n"},
+		{name: "buildah volume", config: strings.Replace(testConfig, "options:\n", "options:\n  buildah:\n    volumes: [\"/:/host\"]\n", 1)},
+		{name: "buildah capability", config: strings.Replace(testConfig, "options:\n", "options:\n  buildah:\n    cap_add: [CAP_SYS_ADMIN]\n", 1)},
+		{name: "buildah storage", config: strings.Replace(testConfig, "options:\n", "options:\n  buildah:\n    root: /etc\n", 1)},
+		{name: "buildah mount", config: strings.Replace(testConfig, "options:\n", "options:\n  buildah:\n    mounts: [\"type=bind,source=/var/run/docker.sock,target=/run/docker.sock\"]\n", 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/builds", strings.NewReader(tt.config)))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("POST /builds status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), "not available for submitted configurations") {
+				t.Errorf("POST /builds error = %s, want the option rejected", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSubmitNotifications(t *testing.T) {
+	s := newTestServer(t)
+	s.SetAllowNotifications(true)
+	tests := []struct {
+		name   string
+		config string
+		want   int
+	}{
+		{name: "headers", config: testConfig + "notifications:\n  - type: webhook\n    url: https://hooks.example.com/\n    headers:\n      X-Build: compute\n", want: http.StatusAccepted},
+		{name: "headers from environment", config: testConfig + "notifications:\n  - type: webhook\n    url: https://hooks.example.com/\n    headers_env:\n      X-Key: AWS_SECRET_ACCESS_KEY\n", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/builds", strings.NewReader(tt.config)))
+			if rec.Code != tt.want {
+				t.Errorf("POST /builds status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSubmitVariants(t *testing.T) {
+	filesDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(filesDir, "motd"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := `options:
+  layer_type: base
+  name: 'compute-{{.Variant}}'
+  pkg_manager: dnf
+variants:
+  - name: cpu
+  - name: gpu
+    packages: [cuda]
+    copyfiles:
+      - src: %s
+        dest: /etc/motd
+`
+
+	s := newTestServer(t)
+	s.SetFilesDir(filesDir)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/builds", strings.NewReader(fmt.Sprintf(config, "motd"))))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("POST /builds status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body.String())
+	}
+	var builds []Build
+	if err := json.Unmarshal(rec.Body.Bytes(), &builds); err != nil {
+		t.Fatalf("POST /builds returned %s: %v", rec.Body.String(), err)
+	}
+	if len(builds) != 2 || builds[0].Name != "compute-cpu" || builds[1].Name != "compute-gpu" {
+		t.Errorf("POST /builds queued %+v, want a build of every variant", builds)
+	}
+	if gpu := s.builds[builds[1].ID].config; len(gpu.Packages) != 1 || gpu.CopyFiles[0].Src != filepath.Join(filesDir, "motd") {
+		t.Errorf("gpu variant config = %+v, want its packages and resolved files", gpu)
+	}
+
+	// Variant files are restricted to the files directory like any other
+	outside := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(outside, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/builds", strings.NewReader(fmt.Sprintf(config, outside))))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "variant gpu: copyfiles[0].src") {
+		t.Errorf("POST /builds status = %d, want %d for a variant file outside: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+	}
+}
+
+func TestSubmitGitSHA(t *testing.T) {
+	s := newTestServer(t)
+	config := strings.Replace(testConfig, "options:\n", "options:\n  publish_tags: 'build-{{.GitSHA}}'\n", 1)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/builds", strings.NewReader(config)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /builds status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits maps size suffixes to their multipliers. Suffixes are binary, as with docker and
+// podman's --memory.
+var sizeUnits = map[string]int64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+// ParseSize parses a size such as "512M", "4G" or "4GiB" into bytes
+func ParseSize(s string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "ib"), "b")
+	i := strings.IndexFunc(value, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	number, unit := value, ""
+	if i >= 0 {
+		number, unit = value[:i], value[i:]
+	}
+
+	multiplier, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in '%s'", s)
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
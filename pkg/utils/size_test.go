@@ -0,0 +1,33 @@
+package utils
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "1024", want: 1024},
+		{input: "512M", want: 512 << 20},
+		{input: "4G", want: 4 << 30},
+		{input: "4GiB", want: 4 << 30},
+		{input: "1.5g", want: 3 << 29},
+		{input: "100b", want: 100},
+		{input: "4X", wantErr: true},
+		{input: "", wantErr: true},
+		{input: "-1G", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}